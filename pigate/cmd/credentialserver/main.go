@@ -12,6 +12,7 @@ import (
 	"pigate/pkg/config"
 	"pigate/pkg/credentialparser"
 	"pigate/pkg/messenger"
+	"pigate/pkg/version"
 )
 
 const application = "credentialserver"
@@ -41,6 +42,8 @@ func (p *program) run() {
 		"Path to the configuration file")
 	flag.Parse()
 
+	log.Printf("Starting credentialserver %s", version.Get())
+
 	// 2) Load configuration for credentialserver
 	cfg := config.LoadConfig(configFilePath, application+"-config").(*config.CredentialServerConfig)
 
@@ -98,6 +101,12 @@ func (p *program) Stop(s service.Service) error {
 }
 
 func main() {
+	// Handled before the log file is opened so it prints to the console.
+	if len(os.Args) > 1 && (os.Args[1] == "-version" || os.Args[1] == "--version") {
+		fmt.Println(application, version.Get())
+		return
+	}
+
 	logFile, err := os.OpenFile("C:\\ProgramData\\CredentialServer\\credentialserver.log",
 		os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
@@ -2,30 +2,80 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"pigate/pkg/config"
+	"pigate/pkg/control"
 	"pigate/pkg/database"
 	"pigate/pkg/gate"
+	"pigate/pkg/gpio"
 	"pigate/pkg/messenger"
+	"pigate/pkg/metrics"
+	"pigate/pkg/serial"
+	"pigate/pkg/version"
 )
 
 const application string = "gatecontroller"
 
+const heartbeatInterval = time.Minute
+
 func main() {
 	// 1) Parse command-line flags for config path
 	var configFilePath string
+	var showVersion, releasePins bool
 	flag.StringVar(&configFilePath, "c", "/workspace/pigate/pkg/config",
 		"Path to the configuration file")
+	flag.BoolVar(&showVersion, "version", false, "Print the version and exit")
+	flag.BoolVar(&releasePins, "release-pins", false,
+		"Drive the relay and LED to their inactive level and exit; run after the service stops")
 	flag.Parse()
+	if showVersion {
+		fmt.Println(application, version.Get())
+		return
+	}
+	startedAt := time.Now()
 
 	// 2) Load configuration for gatecontroller
 	cfg := config.LoadConfig(configFilePath, application+"-config").(*config.GateControllerConfig)
+	relayCfg, ledCfg, readerCfg, err := outputConfigs(cfg)
+	if err != nil {
+		log.Fatalf("Invalid GPIO configuration: %v", err)
+	}
+	operator, err := relayConfig(cfg.Relays, relayCfg)
+	if err != nil {
+		log.Fatalf("Invalid relay configuration: %v", err)
+	}
+	if err := checkPins(cfg, relayCfg, ledCfg, readerCfg, operator); err != nil {
+		log.Fatalf("Invalid GPIO configuration: %v", err)
+	}
+	if releasePins {
+		if err := releaseOutputs(cfg, relayCfg, ledCfg, readerCfg, operator); err != nil {
+			log.Fatalf("Failed to release gate pins: %v", err)
+		}
+		return
+	}
+
+	if cfg.Device_ID == "" {
+		cfg.Device_ID, _ = os.Hostname()
+	}
+	log.Printf("Starting gatecontroller %s", version.Get())
+	log.Printf("Loaded gatecontroller configuration for device %s at location %s", cfg.Device_ID, cfg.Location_ID)
 
-	log.Printf("Loaded gatecontroller configuration for location %s", cfg.Location_ID)
+	// The Update Agent reads these checks after an update and rolls back if
+	// they do not all pass. A failed check exits, so they are only marked on
+	// success.
+	health := control.NewHealth(control.StartupChecks...)
+	health.Pass(control.CheckConfig)
 
 	// 3) Initialize repository
 	gm, err := database.NewSqliteGateManager(cfg.LocalDBPath)
@@ -33,35 +83,193 @@ func main() {
 		log.Fatalf("Failed to open database at %s: %v", cfg.LocalDBPath, err)
 	}
 	defer gm.Close()
+	health.Pass(control.CheckSQLite)
+
+	// Metrics are served on localhost only; scrape them through an SSH tunnel
+	// or a local agent.
+	registry := metrics.NewRegistry()
+	gateMetrics := metrics.NewGateMetrics(registry)
+	syncMetrics := metrics.NewSyncMetrics(registry)
+	if cfg.MetricsPort > 0 {
+		go serveMetrics(cfg.MetricsPort, registry)
+	}
 
 	// 4) Create GateController
 	gateCtrl := gate.NewGateController(gm, cfg.GateOpenDuration)
-	// Initialize the Raspberry Pi GPIO pin
-	ledPinNumber := 27
-	gateCtrl.InitPinControl(cfg.RelayPin, ledPinNumber)
-	defer gateCtrl.Close()
+	gateCtrl.SetMetrics(gateMetrics)
+	gateCtrl.SetCardPINTimeout(time.Duration(cfg.CardPINTimeout) * time.Second)
+	gateCtrl.SetDuressLastDigit(cfg.DuressLastDigit)
+	if err := gateCtrl.SetThrottle(throttleConfig(cfg.Throttle)); err != nil {
+		log.Fatalf("Invalid throttle config: %v", err)
+	}
+	apb, err := antiPassbackConfig(cfg)
+	if err == nil {
+		err = gateCtrl.SetAntiPassback(apb)
+	}
+	if err != nil {
+		log.Fatalf("Invalid anti-passback config: %v", err)
+	}
+	// Initialize the Raspberry Pi GPIO pins. From here on, exits go through
+	// fatalf so the relay is released to its inactive level first.
+	drivers := newGPIODrivers()
+	fatalf := func(format string, args ...interface{}) {
+		drivers.close()
+		log.Fatalf(format, args...)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			drivers.close()
+			panic(r)
+		}
+	}()
+	relayDriver, err := drivers.open(cfg.GPIODriver, cfg.GPIOChip)
+	if err != nil {
+		fatalf("Failed to open gpio driver: %v", err)
+	}
+	if err := gateCtrl.InitPinControl(relayDriver, relayCfg, ledCfg); err != nil {
+		fatalf("Failed to configure gate pins: %v", err)
+	}
+	if err := gateCtrl.InitRelays(relayDriver, operator); err != nil {
+		fatalf("Failed to configure gate relays: %v", err)
+	}
+	log.Printf("Driving the gate operator in %s mode", operator.Mode)
+	feedback, err := feedbackPatterns(cfg.Feedback)
+	if err != nil {
+		fatalf("Invalid feedback config: %v", err)
+	}
+	var indicators []gate.Indicator
+	readerLights, err := openReaderLights(relayDriver, readerCfg)
+	if err != nil {
+		fatalf("Failed to configure reader pins: %v", err)
+	}
+	if readerLights != nil {
+		readerLights.Start()
+		indicators = append(indicators, readerLights)
+	}
 
-	// 5) Start the keypad listener (non-blocking)
-	keypadReader := gate.NewKeypadReader()
-	err = keypadReader.Start(func(code string) {
-		if err := gateCtrl.Open(code, time.Now()); err != nil {
-			log.Printf("Failed to open gate for credential %s: %v", code, err)
+	// 4b) Follow the gate through its limit switches and safety input, if wired
+	var gateSensors *gate.GateSensors
+	if sc := sensorConfig(cfg.Sensors); sc.Wired() {
+		gateCtrl.SetSensors(sc)
+		gateSensors = gate.NewGateSensors(relayDriver, sc)
+		if err := gateSensors.Start(gateCtrl.UpdateSensors); err != nil {
+			fatalf("Failed to start gate sensors: %v", err)
 		}
-	})
+	}
+
+	// 4c) Open for the request-to-exit button and vehicle loop, if wired
+	gateCtrl.SetSuppressExitInLockdown(cfg.Exit.SuppressInLockdown)
+	var exitInputs *gate.ExitInputs
+	if ec := exitConfig(cfg.Exit); ec.Wired() {
+		exitInputs = gate.NewExitInputs(relayDriver, ec)
+		err := exitInputs.Start(func(source string) {
+			if err := gateCtrl.Exit(source, time.Now()); err != nil {
+				log.Printf("Exit at %s failed: %v", source, err)
+			}
+		})
+		if err != nil {
+			fatalf("Failed to start exit inputs: %v", err)
+		}
+	}
+
+	if cfg.ControlSocket != "" {
+		go serveControl(cfg.ControlSocket, gateCtrl, health)
+	}
+
+	// 5) Start the keypad listener (non-blocking)
+	keypadDriverName, keypadChip := cfg.Keypad.GPIODriver, cfg.Keypad.GPIOChip
+	if keypadDriverName == "" {
+		keypadDriverName = cfg.GPIODriver
+	}
+	if keypadChip == "" {
+		keypadChip = cfg.GPIOChip
+	}
+	keypadDriver, err := drivers.open(keypadDriverName, keypadChip)
+	if err != nil {
+		fatalf("Failed to open gpio driver: %v", err)
+	}
+	kc, err := keypadConfig(cfg.Keypad)
 	if err != nil {
-		log.Fatalf("Failed to start keypad reader: %v", err)
+		fatalf("Invalid keypad config: %v", err)
 	}
+	// Failed codes are throttled per reader, so each gets its own handlers.
+	openCode := func(source string) func(string) {
+		return func(code string) {
+			if err := gateCtrl.OpenFrom(source, code, time.Now()); err != nil {
+				log.Printf("Failed to open gate for credential %s: %v", code, err)
+			}
+		}
+	}
+	openCard := func(source string) func(gate.Card) {
+		return func(card gate.Card) {
+			if err := gateCtrl.OpenCardFrom(source, card, time.Now()); err != nil {
+				log.Printf("Failed to open gate for card %s: %v", card.Code(), err)
+			}
+		}
+	}
+	keypadReader := gate.NewKeypadReader(keypadDriver, kc)
+	keypadReader.SetMetrics(gateMetrics)
+	keypadReader.SetCardHandler(openCard(gate.SourceWiegand))
+	if err := keypadReader.Start(openCode(gate.SourceWiegand)); err != nil {
+		fatalf("Failed to start keypad reader: %v", err)
+	}
+	var exitKeypad *gate.KeypadReader
+	if cfg.Keypad.ExitD0Pin != 0 || cfg.Keypad.ExitD1Pin != 0 {
+		ekc := kc
+		ekc.D0Pin, ekc.D1Pin = cfg.Keypad.ExitD0Pin, cfg.Keypad.ExitD1Pin
+		exitKeypad = gate.NewKeypadReader(keypadDriver, ekc)
+		exitKeypad.SetMetrics(gateMetrics)
+		exitKeypad.SetCardHandler(openCard(gate.SourceWiegandExit))
+		if err := exitKeypad.Start(openCode(gate.SourceWiegandExit)); err != nil {
+			fatalf("Failed to start exit keypad reader: %v", err)
+		}
+	}
+	health.Pass(control.CheckKeypad)
+
+	// 5b) Start the OSDP reader, if one is configured
+	var osdpReader *gate.OSDPReader
+	if cfg.OSDP.Device != "" {
+		oc, err := osdpConfig(cfg.OSDP, kc)
+		if err != nil {
+			fatalf("Invalid OSDP config: %v", err)
+		}
+		port, err := serial.Open(serial.Config{Device: cfg.OSDP.Device, Baud: cfg.OSDP.Baud})
+		if err != nil {
+			fatalf("Failed to open OSDP serial port: %v", err)
+		}
+		osdpReader = gate.NewOSDPReader(port, oc)
+		osdpReader.SetMetrics(gateMetrics)
+		osdpReader.SetCardHandler(openCard(gate.SourceOSDP))
+		if err := osdpReader.Start(openCode(gate.SourceOSDP)); err != nil {
+			port.Close()
+			fatalf("Failed to start OSDP reader: %v", err)
+		}
+		indicators = append(indicators, osdpReader)
+	}
+	gateCtrl.SetFeedback(feedback, indicators...)
 
 	// 6) Set up MQTT client
-	client := messenger.NewMQTTClientWithCredentials(cfg.MQTT.Broker, application, cfg.Location_ID, cfg.MQTT.Username, cfg.MQTT.Password)
+	client := messenger.NewDeviceMQTTClient(cfg.MQTT.Broker, application, cfg.Location_ID, cfg.MQTT.Username, cfg.MQTT.Password)
+	client.SetMetrics(metrics.NewMessengerMetrics(registry))
 	if err := client.Connect(); err != nil {
-		log.Fatalf("Failed to connect to MQTT broker (%s): %v", cfg.MQTT.Broker, err)
+		fatalf("Failed to connect to MQTT broker (%s): %v", cfg.MQTT.Broker, err)
 	}
 	defer client.Disconnect()
+	health.Pass(control.CheckMQTT)
 	gateCtrl.SetStatusNotifier(client)
+	gateCtrl.SetAccessNotifier(client)
+	gateCtrl.SetAlertNotifier(client)
+	gateCtrl.SetDuressNotifier(client)
+	gateCtrl.SetPositionNotifier(client)
 	if err := client.NotifyGateClosed(); err != nil {
 		log.Printf("Failed to publish initial gate status: %v", err)
 	}
+	if position := gateCtrl.Position(); position != gate.PositionUnknown {
+		if err := client.NotifyGatePosition(position.String()); err != nil {
+			log.Printf("Failed to publish initial gate position: %v", err)
+		}
+	}
+	go sendHeartbeats(client, cfg.Device_ID, startedAt)
 
 	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 		cfg.DB.Host, cfg.DB.Port, cfg.DB.User, cfg.DB.Password, cfg.DB.Name)
@@ -69,12 +277,11 @@ func main() {
 	// 7) Sync credentials and access times on start
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	if err := database.SyncCredentials(ctx, gm, connStr); err != nil {
+	syncErr := database.SyncAll(ctx, gm, connStr, syncMetrics)
+	if syncErr != nil {
 		log.Println("Initial sync failed. Will retry later.")
 	}
-	if err := database.SyncAccessTimes(ctx, gm, connStr); err != nil {
-		log.Println("Initial access time sync failed. Will retry later.")
-	}
+	reportSync(client, syncErr)
 
 	// 8) Periodic sync every 24 hours
 	go func() {
@@ -84,24 +291,417 @@ func main() {
 			<-ticker.C
 			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 			// sync credentials and access times and log errors or successes
-			if err := database.SyncCredentials(ctx, gm, connStr); err != nil {
-				log.Printf("Periodic credential sync failed: %v", err)
-			} else {
-				log.Println("Periodic credential sync succeeded.")
-			}
-			if err := database.SyncAccessTimes(ctx, gm, connStr); err != nil {
-				log.Printf("Periodic access time sync failed: %v", err)
+			syncErr := database.SyncAll(ctx, gm, connStr, syncMetrics)
+			if syncErr != nil {
+				log.Printf("Periodic sync failed: %v", syncErr)
 			} else {
-				log.Println("Periodic access time sync succeeded.")
+				log.Println("Periodic sync succeeded.")
 			}
+			reportSync(client, syncErr)
 			cancel()
 		}
 	}()
 
 	// 9) Subscribe to updates via MQTT
-	client.SubscribeCredentialStatus(database.HandleUpdateNotification(gm, connStr))
+	client.SubscribeCredentialStatus(database.HandleUpdateNotification(gm, connStr, syncMetrics, func(err error) {
+		reportSync(client, err)
+	}))
 	client.SubscribePigateCommand(gateCtrl.CommandHandler())
 
-	// Keep main go routine running (non-busy)
-	select {}
+	// Run until stopped, then release the pins with the relay inactive.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	sig := <-stop
+	log.Printf("Received %v, shutting down", sig)
+	keypadReader.Stop()
+	if exitKeypad != nil {
+		exitKeypad.Stop()
+	}
+	if osdpReader != nil {
+		osdpReader.Stop()
+	}
+	if readerLights != nil {
+		readerLights.Stop()
+	}
+	if gateSensors != nil {
+		gateSensors.Stop()
+	}
+	if exitInputs != nil {
+		exitInputs.Stop()
+	}
+	drivers.close()
+}
+
+const (
+	defaultGPIODriver = "gpiocdev"
+	ledPinNumber      = 27
+)
+
+// outputConfigs returns the relay and LED lines described by cfg, and the
+// reader's green LED, red LED and beeper lines; those not wired have pin 0.
+func outputConfigs(cfg *config.GateControllerConfig) (relay, led gpio.OutputConfig, reader [3]gpio.OutputConfig, err error) {
+	drive, err := gpio.ParseDrive(cfg.GPIODrive)
+	if err != nil {
+		return relay, led, reader, err
+	}
+	relay = gpio.OutputConfig{Pin: cfg.RelayPin, ActiveLow: cfg.RelayActiveLow, Drive: drive}
+	led = gpio.OutputConfig{Pin: ledPinNumber, ActiveLow: cfg.LEDActiveLow, Drive: drive}
+	fc := cfg.Feedback
+	for i, pin := range []int{fc.GreenPin, fc.RedPin, fc.BeeperPin} {
+		reader[i] = gpio.OutputConfig{Pin: pin, ActiveLow: fc.ActiveLow, Drive: drive}
+	}
+	return relay, led, reader, nil
+}
+
+// relayConfig converts the operator settings for pkg/gate. The further
+// relays are driven like the open relay.
+func relayConfig(cfg config.RelayConfig, open gpio.OutputConfig) (gate.RelayConfig, error) {
+	mode, err := gate.ParseRelayMode(cfg.Mode)
+	if err != nil {
+		return gate.RelayConfig{}, err
+	}
+	out := func(pin int) gpio.OutputConfig {
+		return gpio.OutputConfig{Pin: pin, ActiveLow: open.ActiveLow, Drive: open.Drive}
+	}
+	rc := gate.RelayConfig{
+		Mode:       mode,
+		PulseWidth: time.Duration(cfg.PulseMs) * time.Millisecond,
+		Close:      out(cfg.ClosePin),
+		Stop:       out(cfg.StopPin),
+		HoldOpen:   out(cfg.HoldOpenPin),
+	}
+	return rc, rc.Validate()
+}
+
+// checkPins reports a line wired to two functions. The keypads only clash
+// with the relay's lines when they share its chip.
+func checkPins(cfg *config.GateControllerConfig, relay, led gpio.OutputConfig, reader [3]gpio.OutputConfig, operator gate.RelayConfig) error {
+	type line struct {
+		name string
+		pin  int
+	}
+	relayLines := []line{
+		{"GATE_CONTROL_PIN", relay.Pin},
+		{"the status LED", led.Pin},
+		{"GATE_CLOSE_PIN", operator.Close.Pin},
+		{"GATE_STOP_PIN", operator.Stop.Pin},
+		{"GATE_HOLD_OPEN_PIN", operator.HoldOpen.Pin},
+		{"READER_GREEN_PIN", reader[0].Pin},
+		{"READER_RED_PIN", reader[1].Pin},
+		{"READER_BEEPER_PIN", reader[2].Pin},
+		{"GATE_OPEN_LIMIT_PIN", cfg.Sensors.OpenLimitPin},
+		{"GATE_CLOSED_LIMIT_PIN", cfg.Sensors.ClosedLimitPin},
+		{"GATE_SAFETY_PIN", cfg.Sensors.SafetyPin},
+		{"EXIT_REX_PIN", cfg.Exit.REXPin},
+		{"EXIT_LOOP_PIN", cfg.Exit.LoopPin},
+	}
+	keypadLines := []line{
+		{"KEYPAD_D0_PIN", cfg.Keypad.D0Pin},
+		{"KEYPAD_D1_PIN", cfg.Keypad.D1Pin},
+		{"KEYPAD_EXIT_D0_PIN", cfg.Keypad.ExitD0Pin},
+		{"KEYPAD_EXIT_D1_PIN", cfg.Keypad.ExitD1Pin},
+	}
+	used := make(map[int]string)
+	claim := func(lines []line) error {
+		for _, l := range lines {
+			if l.pin == 0 {
+				continue
+			}
+			if other, ok := used[l.pin]; ok {
+				return fmt.Errorf("pin %d is wired to both %s and %s", l.pin, other, l.name)
+			}
+			used[l.pin] = l.name
+		}
+		return nil
+	}
+	if err := claim(relayLines); err != nil {
+		return err
+	}
+	if cfg.Keypad.GPIOChip != "" && cfg.Keypad.GPIOChip != cfg.GPIOChip {
+		used = make(map[int]string)
+	}
+	return claim(keypadLines)
+}
+
+// releaseOutputs requests the relay, LED and reader lines at their inactive
+// level and releases them. The kernel keeps a line's last value when a
+// process dies, so the service manager runs this after the gate controller
+// stops for any reason, including a crash.
+func releaseOutputs(cfg *config.GateControllerConfig, relay, led gpio.OutputConfig, reader [3]gpio.OutputConfig, operator gate.RelayConfig) error {
+	drivers := newGPIODrivers()
+	driver, err := drivers.open(cfg.GPIODriver, cfg.GPIOChip)
+	if err != nil {
+		return err
+	}
+	outs := []gpio.OutputConfig{relay, led}
+	for _, out := range append(reader[:], operator.Close, operator.Stop, operator.HoldOpen) {
+		if out.Pin != 0 {
+			outs = append(outs, out)
+		}
+	}
+	for _, out := range outs {
+		if _, err := driver.Output(out); err != nil {
+			drivers.close()
+			return err
+		}
+	}
+	log.Printf("Released relay pin %d, LED pin %d and %d further relay and reader lines", relay.Pin, led.Pin, len(outs)-2)
+	return drivers.close()
+}
+
+// openReaderLights drives the wired reader LED and beeper lines, or returns
+// nil when none are wired.
+func openReaderLights(driver gpio.Driver, reader [3]gpio.OutputConfig) (*gate.GPIOIndicator, error) {
+	var pins [3]gpio.OutputPin
+	wired := false
+	for i, out := range reader {
+		if out.Pin == 0 {
+			continue
+		}
+		pin, err := driver.Output(out)
+		if err != nil {
+			return nil, fmt.Errorf("reader pin %d: %w", out.Pin, err)
+		}
+		pins[i], wired = pin, true
+	}
+	if !wired {
+		return nil, nil
+	}
+	return gate.NewGPIOIndicator(pins[0], pins[1], pins[2]), nil
+}
+
+// feedbackPatterns parses the configured patterns over the defaults.
+func feedbackPatterns(cfg config.FeedbackConfig) (gate.Feedback, error) {
+	fb := gate.DefaultFeedback()
+	for _, f := range []struct {
+		key     string
+		value   string
+		pattern *gate.Pattern
+	}{
+		{"FEEDBACK_GRANTED", cfg.Granted, &fb.Granted},
+		{"FEEDBACK_DENIED", cfg.Denied, &fb.Denied},
+		{"FEEDBACK_LOCKED_OPEN", cfg.LockedOpen, &fb.LockedOpen},
+		{"FEEDBACK_IDLE", cfg.Idle, &fb.Idle},
+	} {
+		if f.value == "" {
+			continue
+		}
+		p, err := gate.ParsePattern(f.value)
+		if err != nil {
+			return fb, fmt.Errorf("%s: %w", f.key, err)
+		}
+		*f.pattern = p
+	}
+	return fb, nil
+}
+
+// keypadConfig converts the keypad settings for pkg/gate.
+func keypadConfig(cfg config.KeypadConfig) (gate.KeypadConfig, error) {
+	kc := gate.KeypadConfig{
+		D0Pin:       cfg.D0Pin,
+		D1Pin:       cfg.D1Pin,
+		FrameBits:   cfg.FrameBits,
+		CodeLength:  cfg.CodeLength,
+		KeyTimeout:  time.Duration(cfg.KeyTimeoutMs) * time.Millisecond,
+		CodeTimeout: time.Duration(cfg.CodeTimeoutMs) * time.Millisecond,
+	}
+	for _, name := range cfg.CardFormats {
+		format, ok := gate.LookupCardFormat(name)
+		if !ok {
+			return kc, fmt.Errorf("unknown card format %q", name)
+		}
+		kc.CardFormats = append(kc.CardFormats, format)
+	}
+	return kc, nil
+}
+
+// osdpConfig converts the OSDP settings for pkg/gate. Codes and cards use the
+// keypad's settings; the standard card formats are read when the keypad has
+// none configured.
+func osdpConfig(cfg config.OSDPConfig, kc gate.KeypadConfig) (gate.OSDPConfig, error) {
+	oc := gate.DefaultOSDPConfig()
+	oc.Address = cfg.Address
+	oc.PollInterval = time.Duration(cfg.PollIntervalMs) * time.Millisecond
+	oc.CodeLength = kc.CodeLength
+	oc.CodeTimeout = kc.CodeTimeout
+	if len(kc.CardFormats) > 0 {
+		oc.CardFormats = kc.CardFormats
+	}
+	if cfg.SCBK != "" {
+		scbk, err := hex.DecodeString(cfg.SCBK)
+		if err != nil {
+			return oc, fmt.Errorf("secure channel key is not hex: %w", err)
+		}
+		oc.SCBK = scbk
+	}
+	return oc, oc.Validate()
+}
+
+// throttleConfig converts the throttling settings for pkg/gate.
+func throttleConfig(cfg config.ThrottleConfig) gate.ThrottleConfig {
+	return gate.ThrottleConfig{
+		MaxFailures: cfg.MaxFailures,
+		Window:      time.Duration(cfg.WindowSeconds) * time.Second,
+		Lockout:     time.Duration(cfg.LockoutSeconds) * time.Second,
+		MaxLockout:  time.Duration(cfg.MaxLockoutSeconds) * time.Second,
+	}
+}
+
+// sensorConfig converts the gate sensor settings for pkg/gate.
+func sensorConfig(cfg config.GateSensorConfig) gate.SensorConfig {
+	return gate.SensorConfig{
+		OpenPin:       cfg.OpenLimitPin,
+		ClosedPin:     cfg.ClosedLimitPin,
+		SafetyPin:     cfg.SafetyPin,
+		ActiveLow:     cfg.ActiveLow,
+		TravelTimeout: time.Duration(cfg.TravelTimeoutSeconds) * time.Second,
+		Debounce:      time.Duration(cfg.DebounceMs) * time.Millisecond,
+	}
+}
+
+// antiPassbackConfig converts the anti-passback settings for pkg/gate. The
+// exit keypad, and the OSDP reader when its direction is exit, are the exit
+// readers.
+func antiPassbackConfig(cfg *config.GateControllerConfig) (gate.AntiPassbackConfig, error) {
+	mode, err := gate.ParseAntiPassbackMode(cfg.AntiPassback.Mode)
+	if err != nil {
+		return gate.AntiPassbackConfig{}, err
+	}
+	apb := gate.AntiPassbackConfig{
+		Mode:  mode,
+		Reset: time.Duration(cfg.AntiPassback.ResetMinutes) * time.Minute,
+	}
+	if cfg.Keypad.ExitD0Pin != 0 || cfg.Keypad.ExitD1Pin != 0 {
+		apb.ExitSources = append(apb.ExitSources, gate.SourceWiegandExit)
+	}
+	switch cfg.OSDP.Direction {
+	case "", "entry":
+	case "exit":
+		if cfg.OSDP.Device != "" {
+			apb.ExitSources = append(apb.ExitSources, gate.SourceOSDP)
+		}
+	default:
+		return apb, fmt.Errorf("unknown OSDP reader direction %q", cfg.OSDP.Direction)
+	}
+	return apb, apb.Validate()
+}
+
+// exitConfig converts the exit input settings for pkg/gate.
+func exitConfig(cfg config.ExitConfig) gate.ExitConfig {
+	return gate.ExitConfig{
+		REXPin:    cfg.REXPin,
+		LoopPin:   cfg.LoopPin,
+		ActiveLow: cfg.ActiveLow,
+		Debounce:  time.Duration(cfg.DebounceMs) * time.Millisecond,
+	}
+}
+
+// gpioDrivers opens each gpio driver and chip once, so the relay and keypad
+// can share one when they are configured the same.
+type gpioDrivers struct {
+	mu      sync.Mutex
+	drivers map[gpioDriverKey]gpio.Driver
+}
+
+type gpioDriverKey struct {
+	name, chip string
+}
+
+func newGPIODrivers() *gpioDrivers {
+	return &gpioDrivers{drivers: make(map[gpioDriverKey]gpio.Driver)}
+}
+
+// open returns the driver called name on chip, or the default driver when
+// name is empty.
+func (d *gpioDrivers) open(name, chip string) (gpio.Driver, error) {
+	if name == "" {
+		name = defaultGPIODriver
+	}
+	key := gpioDriverKey{name: name, chip: chip}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if driver, ok := d.drivers[key]; ok {
+		return driver, nil
+	}
+	driver, err := gpio.Open(name, gpio.Options{Chip: chip})
+	if err != nil {
+		return nil, err
+	}
+	d.drivers[key] = driver
+	return driver, nil
+}
+
+// close drives every output inactive and releases the drivers. It is safe to
+// call more than once.
+func (d *gpioDrivers) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var errs []error
+	for key, driver := range d.drivers {
+		if err := driver.Close(); err != nil {
+			log.Printf("Failed to close gpio driver %s: %v", key.name, err)
+			errs = append(errs, err)
+		}
+	}
+	d.drivers = make(map[gpioDriverKey]gpio.Driver)
+	return errors.Join(errs...)
+}
+
+// serveMetrics exposes registry on 127.0.0.1:port at /metrics.
+func serveMetrics(port int, registry *metrics.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", registry.Handler())
+	server := &http.Server{
+		Addr:              fmt.Sprintf("127.0.0.1:%d", port),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	log.Printf("Metrics listening on %s", server.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Metrics server failed: %v", err)
+	}
+}
+
+// serveControl runs the local control API used by the Update Agent.
+func serveControl(socketPath string, gateCtrl *gate.GateController, health *control.Health) {
+	ln, err := control.Listen(socketPath)
+	if err != nil {
+		log.Printf("Failed to open control socket %s: %v", socketPath, err)
+		return
+	}
+	server := &http.Server{
+		Handler:           control.NewHandler(gateCtrl, health),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	log.Printf("Control API listening on %s", socketPath)
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Control server failed: %v", err)
+	}
+}
+
+// sendHeartbeats reports the running version to the Control Plane every
+// heartbeatInterval.
+func sendHeartbeats(client *messenger.MQTTClient, deviceID string, startedAt time.Time) {
+	info := version.Get()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		// Failures are logged by the messenger; the next tick retries.
+		_ = client.NotifyHeartbeat(messenger.Heartbeat{
+			DeviceID:  deviceID,
+			Version:   info.Version,
+			Commit:    info.Commit,
+			BuildTime: info.BuildTime,
+			StartedAt: startedAt,
+			At:        time.Now(),
+		})
+		<-ticker.C
+	}
+}
+
+// reportSync tells the Control Plane whether the last credential sync worked.
+func reportSync(client *messenger.MQTTClient, syncErr error) {
+	if err := client.NotifyCredentialSync(syncErr); err != nil {
+		log.Printf("Failed to report credential sync: %v", err)
+	}
 }
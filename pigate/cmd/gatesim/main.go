@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"pigate/pkg/config"
+	"pigate/pkg/database"
+	"pigate/pkg/gate"
+	"pigate/pkg/gpio"
+	"pigate/pkg/messenger"
+	"pigate/pkg/version"
+)
+
+const application string = "gatesim"
+
+const (
+	heartbeatInterval   = time.Minute
+	defaultHTTPAddr     = "127.0.0.1:8090"
+	defaultTravelTime   = 8 * time.Second
+	defaultOpenDuration = 10
+	defaultRelayPin     = 22
+	ledPin              = 27
+)
+
+func main() {
+	var configFilePath string
+	var showVersion, interactive bool
+	flag.StringVar(&configFilePath, "c", "/workspace/pigate/pkg/config",
+		"Path to the configuration file")
+	flag.BoolVar(&interactive, "i", true, "Read keypad codes from stdin, one per line")
+	flag.BoolVar(&showVersion, "version", false, "Print the version and exit")
+	flag.Parse()
+	if showVersion {
+		fmt.Println(application, version.Get())
+		return
+	}
+	startedAt := time.Now()
+
+	cfg := config.LoadConfig(configFilePath, application+"-config").(*config.GateSimConfig)
+	if cfg.Device_ID == "" {
+		host, _ := os.Hostname()
+		cfg.Device_ID = application + "-" + host
+	}
+	if cfg.HTTPAddr == "" {
+		cfg.HTTPAddr = defaultHTTPAddr
+	}
+	if cfg.GateOpenDuration <= 0 {
+		cfg.GateOpenDuration = defaultOpenDuration
+	}
+	if cfg.RelayPin == 0 {
+		cfg.RelayPin = defaultRelayPin
+	}
+	if cfg.LocalDBPath == "" {
+		cfg.LocalDBPath = "./gatesim.sqlite"
+	}
+	travel := defaultTravelTime
+	if cfg.GateTravelSeconds > 0 {
+		travel = time.Duration(cfg.GateTravelSeconds) * time.Second
+	}
+	log.Printf("Starting gatesim %s as device %s at location %s", version.Get(), cfg.Device_ID, cfg.Location_ID)
+
+	gm, err := database.NewSqliteGateManager(cfg.LocalDBPath)
+	if err != nil {
+		log.Fatalf("Failed to open database at %s: %v", cfg.LocalDBPath, err)
+	}
+	defer gm.Close()
+
+	// The gate controller drives the sim gpio driver, which the simulator
+	// watches; the simulator also receives status and access notifications.
+	// Everything else is the real gate controller.
+	pins := gpio.NewFake(nil)
+	sim := newSimulator(pins, cfg.Location_ID, cfg.Device_ID, travel, cfg.RelayPin, ledPin)
+	pins.Watch(sim.observe)
+	gateCtrl := gate.NewGateController(gm, cfg.GateOpenDuration)
+	if err := gateCtrl.InitPinControl(pins, gpio.OutputConfig{Pin: cfg.RelayPin}, gpio.OutputConfig{Pin: ledPin}); err != nil {
+		log.Fatalf("Failed to configure gate pins: %v", err)
+	}
+	gateCtrl.SetStatusNotifier(sim)
+	gateCtrl.SetAccessNotifier(sim)
+	gateCtrl.SetPositionNotifier(sim)
+	gateCtrl.SetAlertNotifier(sim)
+	defer gateCtrl.Close()
+
+	// The simulated gate has both limit switches; a GATE_TRAVEL_SECONDS
+	// beyond GATE_TRAVEL_TIMEOUT_SECONDS shows the travel faults.
+	sensorCfg := gate.DefaultSensorConfig()
+	sensorCfg.OpenPin, sensorCfg.ClosedPin = openLimitPin, closedLimitPin
+	if cfg.Sensors.TravelTimeoutSeconds > 0 {
+		sensorCfg.TravelTimeout = time.Duration(cfg.Sensors.TravelTimeoutSeconds) * time.Second
+	}
+	gateCtrl.SetSensors(sensorCfg)
+	sensors := gate.NewGateSensors(pins, sensorCfg)
+	if err := sensors.Start(gateCtrl.UpdateSensors); err != nil {
+		log.Fatalf("Failed to start gate sensors: %v", err)
+	}
+	defer sensors.Stop()
+	sim.syncLimits()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go sim.runSensor(stop)
+
+	if cfg.MQTT.Broker != "" {
+		client := messenger.NewDeviceMQTTClient(cfg.MQTT.Broker, cfg.Device_ID, cfg.Location_ID, cfg.MQTT.Username, cfg.MQTT.Password)
+		if err := client.Connect(); err != nil {
+			log.Fatalf("Failed to connect to MQTT broker (%s): %v", cfg.MQTT.Broker, err)
+		}
+		defer client.Disconnect()
+		sim.client = client
+		if err := client.NotifyGateClosed(); err != nil {
+			log.Printf("Failed to publish initial gate status: %v", err)
+		}
+		if err := client.NotifyGatePosition(gateCtrl.Position().String()); err != nil {
+			log.Printf("Failed to publish initial gate position: %v", err)
+		}
+		go sendHeartbeats(client, cfg.Device_ID, startedAt)
+		client.SubscribePigateCommand(gateCtrl.CommandHandler())
+	} else {
+		log.Println("MQTT_BROKER is not set; running without MQTT")
+	}
+
+	if cfg.DB.Host != "" {
+		connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+			cfg.DB.Host, cfg.DB.Port, cfg.DB.User, cfg.DB.Password, cfg.DB.Name)
+		report := func(syncErr error) {
+			if sim.client == nil {
+				return
+			}
+			if err := sim.client.NotifyCredentialSync(syncErr); err != nil {
+				log.Printf("Failed to report credential sync: %v", err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		syncErr := database.SyncAll(ctx, gm, connStr, nil)
+		cancel()
+		if syncErr != nil {
+			log.Printf("Initial sync failed: %v", syncErr)
+		}
+		report(syncErr)
+		if sim.client != nil {
+			sim.client.SubscribeCredentialStatus(database.HandleUpdateNotification(gm, connStr, nil, report))
+		}
+	} else {
+		log.Printf("DB_HOST is not set; using credentials already in %s", cfg.LocalDBPath)
+	}
+
+	enter := func(code string) {
+		if err := gateCtrl.Open(code, time.Now()); err != nil {
+			log.Printf("Failed to open gate for credential %s: %v", code, err)
+		}
+	}
+	if interactive {
+		go readKeypad(os.Stdin, enter)
+	}
+
+	server := &http.Server{
+		Addr:              cfg.HTTPAddr,
+		Handler:           newHandler(sim, gateCtrl, enter),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	log.Printf("Virtual keypad on http://%s", cfg.HTTPAddr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("HTTP server failed: %v", err)
+	}
+}
+
+// readKeypad enters each line typed on stdin as a keypad code.
+func readKeypad(f *os.File, enter func(code string)) {
+	fmt.Println("Type a keypad code and press Enter.")
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if code := strings.TrimSpace(scanner.Text()); code != "" {
+			enter(code)
+		}
+	}
+}
+
+// sendHeartbeats reports the simulator as a Device so it appears on the status
+// page like a real gate controller.
+func sendHeartbeats(client *messenger.MQTTClient, deviceID string, startedAt time.Time) {
+	info := version.Get()
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		_ = client.NotifyHeartbeat(messenger.Heartbeat{
+			DeviceID:  deviceID,
+			Version:   info.Version,
+			Commit:    info.Commit,
+			BuildTime: info.BuildTime,
+			StartedAt: startedAt,
+			At:        time.Now(),
+		})
+		<-ticker.C
+	}
+}
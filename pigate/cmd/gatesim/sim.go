@@ -0,0 +1,267 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"pigate/pkg/gpio"
+	"pigate/pkg/messenger"
+)
+
+const (
+	sensorInterval = 50 * time.Millisecond
+	maxEntries     = 20
+	openLimitPin   = 5 // limit switches the simulator drives on the sim gpio driver
+	closedLimitPin = 6
+)
+
+// Gate motion reported by the simulated position sensor.
+const (
+	motionClosed  = "closed"
+	motionOpening = "opening"
+	motionOpen    = "open"
+	motionClosing = "closing"
+)
+
+// entry is one keypad attempt shown on the virtual keypad.
+type entry struct {
+	Code     string    `json:"code"`
+	Result   string    `json:"result"`
+	Reason   string    `json:"reason,omitempty"`
+	Username string    `json:"username,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// snapshot is the simulator state served at /api/state.
+type snapshot struct {
+	Location  string  `json:"location"`
+	Device    string  `json:"device"`
+	Status    string  `json:"status"`
+	Relay     bool    `json:"relay"`
+	LED       bool    `json:"led"`
+	Position  float64 `json:"position"` // 0 closed, 100 fully open
+	Motion    string  `json:"motion"`
+	MQTT      bool    `json:"mqtt"`
+	Entries   []entry `json:"entries"`
+	UpdatedAt string  `json:"updated_at"`
+}
+
+// simulator holds the virtual hardware around a real GateController: the
+// relay and LED it drives through the sim gpio driver, and a position sensor
+// that follows the relay over the configured travel time and works the
+// gate's limit switches. It also stands in for the controller's notifiers so
+// it can show them, forwarding to MQTT when connected.
+type simulator struct {
+	mu       sync.Mutex
+	location string
+	device   string
+	travel   time.Duration
+	relayPin int
+	ledPin   int
+	relay    bool
+	led      bool
+	position float64 // 0..1
+	motion   string
+	status   string
+	entries  []entry
+	pins     *gpio.Fake
+	limits   [2]bool // open and closed limit switches made
+
+	client *messenger.MQTTClient // nil when MQTT is not configured
+}
+
+func newSimulator(pins *gpio.Fake, location, device string, travel time.Duration, relayPin, ledPin int) *simulator {
+	return &simulator{
+		pins:     pins,
+		location: location,
+		device:   device,
+		travel:   travel,
+		relayPin: relayPin,
+		ledPin:   ledPin,
+		motion:   motionClosed,
+		status:   messenger.StatusClosed,
+	}
+}
+
+// observe follows the relay and LED outputs of the sim gpio driver.
+func (s *simulator) observe(t gpio.Transition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch t.Pin {
+	case s.relayPin:
+		s.relay = t.High
+		log.Printf("Relay %s", level(t.High))
+	case s.ledPin:
+		s.led = t.High
+		log.Printf("LED %s", level(t.High))
+	}
+}
+
+func level(high bool) string {
+	if high {
+		return "on"
+	}
+	return "off"
+}
+
+// runSensor moves the gate towards open while the relay is energised and
+// towards closed otherwise, taking s.travel for a full stroke.
+func (s *simulator) runSensor(stop <-chan struct{}) {
+	ticker := time.NewTicker(sensorInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.step(now.Sub(last))
+			s.syncLimits()
+			last = now
+		}
+	}
+}
+
+func (s *simulator) step(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delta := 1.0
+	if s.travel > 0 {
+		delta = float64(elapsed) / float64(s.travel)
+	}
+	motion := s.motion
+	switch {
+	case s.relay && s.position < 1:
+		s.position = min(1, s.position+delta)
+		motion = motionOpening
+		if s.position == 1 {
+			motion = motionOpen
+		}
+	case !s.relay && s.position > 0:
+		s.position = max(0, s.position-delta)
+		motion = motionClosing
+		if s.position == 0 {
+			motion = motionClosed
+		}
+	}
+	if motion != s.motion {
+		log.Printf("Gate sensor: %s", motion)
+		s.motion = motion
+	}
+}
+
+// syncLimits makes the limit switch inputs match the gate position. They are
+// wired active low, like switches to ground, so a made switch pulls its
+// input low. The edges are injected without s.mu held: the gate controller
+// may drive the relay in response, which s.observe follows.
+func (s *simulator) syncLimits() {
+	s.mu.Lock()
+	want := [2]bool{s.position == 1, s.position == 0}
+	changed := s.limits
+	s.limits = want
+	s.mu.Unlock()
+
+	for i, pin := range []int{openLimitPin, closedLimitPin} {
+		if changed[i] == want[i] {
+			continue
+		}
+		edge := gpio.RisingEdge
+		if want[i] {
+			edge = gpio.FallingEdge
+		}
+		if err := s.pins.Inject(pin, edge); err != nil {
+			log.Printf("Failed to set limit switch %d: %v", pin, err)
+		}
+	}
+}
+
+func (s *simulator) snapshot() snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]entry, len(s.entries))
+	copy(entries, s.entries)
+	return snapshot{
+		Location:  s.location,
+		Device:    s.device,
+		Status:    s.status,
+		Relay:     s.relay,
+		LED:       s.led,
+		Position:  s.position * 100,
+		Motion:    s.motion,
+		MQTT:      s.client != nil && s.client.IsConnected(),
+		Entries:   entries,
+		UpdatedAt: time.Now().Format(time.RFC3339Nano),
+	}
+}
+
+func (s *simulator) setStatus(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// NotifyGateOpen implements gate.StatusNotifier.
+func (s *simulator) NotifyGateOpen() error {
+	s.setStatus(messenger.StatusOpened)
+	if s.client == nil {
+		return nil
+	}
+	return s.client.NotifyGateOpen()
+}
+
+// NotifyGateLockedOpen implements gate.StatusNotifier.
+func (s *simulator) NotifyGateLockedOpen() error {
+	s.setStatus(messenger.StatusLockedOpen)
+	if s.client == nil {
+		return nil
+	}
+	return s.client.NotifyGateLockedOpen()
+}
+
+// NotifyGateClosed implements gate.StatusNotifier.
+func (s *simulator) NotifyGateClosed() error {
+	s.setStatus(messenger.StatusClosed)
+	if s.client == nil {
+		return nil
+	}
+	return s.client.NotifyGateClosed()
+}
+
+// NotifyGatePosition implements gate.PositionNotifier.
+func (s *simulator) NotifyGatePosition(position string) error {
+	if s.client == nil {
+		return nil
+	}
+	return s.client.NotifyGatePosition(position)
+}
+
+// NotifyAlert implements gate.AlertNotifier.
+func (s *simulator) NotifyAlert(event messenger.AlertEvent) error {
+	log.Printf("Alert: %s", event.Type)
+	if s.client == nil {
+		return nil
+	}
+	return s.client.NotifyAlert(event)
+}
+
+// NotifyAccess implements gate.AccessNotifier.
+func (s *simulator) NotifyAccess(event messenger.AccessEvent) error {
+	log.Printf("Keypad %s: %s %s", event.Code, event.Result, event.Reason)
+	s.mu.Lock()
+	s.entries = append([]entry{{
+		Code:     event.Code,
+		Result:   event.Result,
+		Reason:   event.Reason,
+		Username: event.Username,
+		At:       event.At,
+	}}, s.entries...)
+	if len(s.entries) > maxEntries {
+		s.entries = s.entries[:maxEntries]
+	}
+	s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	return s.client.NotifyAccess(event)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"pigate/pkg/gate"
+	"pigate/pkg/messenger"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+// newHandler serves the virtual keypad page and its API:
+//
+//	GET  /api/state          relay, LED, sensor and recent keypad entries
+//	POST /api/keypad         {"code": "12345"} as if typed on the keypad
+//	POST /api/command/{cmd}  open, close or hold_open, as if sent over MQTT
+func newHandler(sim *simulator, gateCtrl *gate.GateController, enter func(code string)) http.Handler {
+	static, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		panic(err)
+	}
+	commands := gateCtrl.CommandHandler()
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServer(http.FS(static)))
+	mux.HandleFunc("GET /api/state", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, sim.snapshot())
+	})
+	mux.HandleFunc("POST /api/keypad", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Code) == "" {
+			writeError(w, http.StatusBadRequest, "code is required")
+			return
+		}
+		enter(strings.TrimSpace(req.Code))
+		writeJSON(w, http.StatusOK, sim.snapshot())
+	})
+	mux.HandleFunc("POST /api/command/{command}", func(w http.ResponseWriter, r *http.Request) {
+		command := r.PathValue("command")
+		switch command {
+		case messenger.CommandOpenMessage, messenger.CommandCloseMessage, messenger.CommandHoldOpenMessage:
+		default:
+			writeError(w, http.StatusBadRequest, "unknown command "+command)
+			return
+		}
+		commands("gatesim", command)
+		writeJSON(w, http.StatusOK, sim.snapshot())
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pigate/pkg/database"
+)
+
+type credentialView struct {
+	Code        string `json:"code"`
+	Username    string `json:"username"`
+	AccessGroup int    `json:"access_group"`
+	LockedOut   bool   `json:"locked_out"`
+	AutoUpdate  bool   `json:"auto_update"`
+	OpenMode    string `json:"open_mode"`
+	Type        string `json:"type"`
+	PINRequired bool   `json:"pin_required"`
+	Duress      bool   `json:"duress"` // a duress code is enrolled; the code itself is not shown
+}
+
+func newCredentialView(cred database.Credential) credentialView {
+	return credentialView{
+		Code:        cred.Code,
+		Username:    cred.Username,
+		AccessGroup: cred.AccessGroup,
+		LockedOut:   cred.LockedOut,
+		AutoUpdate:  cred.AutoUpdate,
+		OpenMode:    string(cred.OpenMode),
+		Type:        string(cred.Type),
+		PINRequired: cred.RequiresPIN(),
+		Duress:      cred.DuressCode != "",
+	}
+}
+
+func (c *cli) credentials(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("credentials needs a subcommand: %w", errUsage)
+	}
+	switch args[0] {
+	case "list":
+		return c.listCredentials(ctx, args[1:])
+	case "add":
+		return c.addCredential(ctx, args[1:])
+	case "lockout":
+		return c.setLockedOut(ctx, args[1:], true)
+	case "unlock":
+		return c.setLockedOut(ctx, args[1:], false)
+	case "delete":
+		return c.deleteCredential(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown credentials subcommand %q: %w", args[0], errUsage)
+	}
+}
+
+func (c *cli) listCredentials(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("credentials list", flag.ContinueOnError)
+	group := fs.Int("group", -1, "Only list this access group")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	store, err := c.accessManager(ctx)
+	if err != nil {
+		return err
+	}
+	creds, err := store.GetCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].Code < creds[j].Code })
+
+	views := []credentialView{}
+	for _, cred := range creds {
+		if *group < 0 || cred.AccessGroup == *group {
+			views = append(views, newCredentialView(cred))
+		}
+	}
+	return c.write(views, func(w io.Writer) {
+		fmt.Fprintln(w, "CODE\tTYPE\tPIN REQUIRED\tDURESS\tUSERNAME\tGROUP\tLOCKED OUT\tAUTO UPDATE\tOPEN MODE")
+		for _, v := range views {
+			fmt.Fprintf(w, "%s\t%s\t%t\t%t\t%s\t%d\t%t\t%t\t%s\n", v.Code, v.Type, v.PINRequired, v.Duress, v.Username, v.AccessGroup, v.LockedOut, v.AutoUpdate, v.OpenMode)
+		}
+	})
+}
+
+// addCredential adds or replaces a manually managed credential. Credentials
+// added here have auto_update unset, so the credential server leaves them
+// alone.
+func (c *cli) addCredential(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("credentials add", flag.ContinueOnError)
+	code := fs.String("code", "", "Keypad code")
+	card := fs.String("card", "", "Card or fob as FACILITY:NUMBER, instead of -code")
+	pin := fs.String("pin", "", "PIN that must be entered after the -card")
+	duress := fs.String("duress", "", "Duress code, entered instead of the -code or -pin, that opens and raises a silent alarm")
+	name := fs.String("name", "", "Username")
+	group := fs.Int("group", 0, "Access group")
+	lockOpen := fs.Bool("lock-open", false, "Lock the gate open instead of a timed open")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if (*code == "") == (*card == "") || *name == "" {
+		return fmt.Errorf("credentials add needs -name and one of -code or -card: %w", errUsage)
+	}
+	if *pin != "" && (*card == "" || strings.Trim(*pin, "0123456789") != "") {
+		return fmt.Errorf("-pin must be digits and goes with -card: %w", errUsage)
+	}
+	if *duress != "" && (strings.Trim(*duress, "0123456789") != "" || *duress == *code || *duress == *pin || (*card != "" && *pin == "")) {
+		return fmt.Errorf("-duress must be digits, differ from the code or PIN, and needs -pin with -card: %w", errUsage)
+	}
+	cred := database.Credential{
+		Code:        *code,
+		Username:    *name,
+		AccessGroup: *group,
+		OpenMode:    database.RegularOpen,
+		Type:        database.CredentialPIN,
+		DuressCode:  *duress,
+	}
+	if *card != "" {
+		cardCode, err := parseCard(*card)
+		if err != nil {
+			return err
+		}
+		cred.Code, cred.Type, cred.PIN = cardCode, database.CredentialCard, *pin
+	}
+	if *lockOpen {
+		cred.OpenMode = database.LockOpen
+	}
+
+	store, err := c.accessManager(ctx)
+	if err != nil {
+		return err
+	}
+	if err := store.PutCredential(ctx, cred); err != nil {
+		return err
+	}
+	return c.write(newCredentialView(cred), func(w io.Writer) {
+		fmt.Fprintf(w, "Added %s for %s. Run resync to push it to the gates.\n", cred.Code, cred.Username)
+	})
+}
+
+func (c *cli) setLockedOut(ctx context.Context, args []string, lockedOut bool) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected one credential code: %w", errUsage)
+	}
+	store, err := c.accessManager(ctx)
+	if err != nil {
+		return err
+	}
+	cred, err := store.GetCredential(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if cred == nil {
+		return fmt.Errorf("credential %s not found", args[0])
+	}
+	cred.LockedOut = lockedOut
+	if err := store.PutCredential(ctx, *cred); err != nil {
+		return err
+	}
+	state := "locked out"
+	if !lockedOut {
+		state = "unlocked"
+	}
+	return c.write(newCredentialView(*cred), func(w io.Writer) {
+		fmt.Fprintf(w, "Credential %s %s. Run resync to push it to the gates.\n", cred.Code, state)
+		if cred.AutoUpdate {
+			fmt.Fprintln(w, "It comes from the credential feed, so the next feed import may overwrite this.")
+		}
+	})
+}
+
+func (c *cli) deleteCredential(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected one credential code: %w", errUsage)
+	}
+	store, err := c.accessManager(ctx)
+	if err != nil {
+		return err
+	}
+	if err := store.DeleteCredential(ctx, args[0]); err != nil {
+		return err
+	}
+	return c.write(map[string]string{"deleted": args[0]}, func(w io.Writer) {
+		fmt.Fprintf(w, "Deleted %s. Run resync to push it to the gates.\n", args[0])
+	})
+}
+
+type accessGroupView struct {
+	AccessGroup  int    `json:"access_group"`
+	StartTime    string `json:"start_time"`
+	EndTime      string `json:"end_time"`
+	StartWeekday string `json:"start_weekday"`
+	EndWeekday   string `json:"end_weekday"`
+}
+
+func newAccessGroupView(at database.AccessTime) accessGroupView {
+	return accessGroupView{
+		AccessGroup:  at.AccessGroup,
+		StartTime:    at.StartTime.Format("15:04"),
+		EndTime:      at.EndTime.Format("15:04"),
+		StartWeekday: at.StartWeekday.String(),
+		EndWeekday:   at.EndWeekday.String(),
+	}
+}
+
+func (c *cli) groups(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("groups needs a subcommand: %w", errUsage)
+	}
+	switch args[0] {
+	case "list":
+		return c.listGroups(ctx)
+	case "set":
+		return c.setGroup(ctx, args[1:])
+	case "delete":
+		return c.deleteGroup(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown groups subcommand %q: %w", args[0], errUsage)
+	}
+}
+
+func (c *cli) listGroups(ctx context.Context) error {
+	store, err := c.accessManager(ctx)
+	if err != nil {
+		return err
+	}
+	times, err := store.GetAccessTimes(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].AccessGroup < times[j].AccessGroup })
+
+	views := []accessGroupView{}
+	for _, at := range times {
+		views = append(views, newAccessGroupView(at))
+	}
+	return c.write(views, func(w io.Writer) {
+		fmt.Fprintln(w, "GROUP\tDAYS\tHOURS")
+		for _, v := range views {
+			fmt.Fprintf(w, "%d\t%s-%s\t%s-%s\n", v.AccessGroup, v.StartWeekday, v.EndWeekday, v.StartTime, v.EndTime)
+		}
+	})
+}
+
+func (c *cli) setGroup(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("groups set", flag.ContinueOnError)
+	group := fs.Int("group", -1, "Access group")
+	start := fs.String("start", "", "Start of the access window, HH:MM")
+	end := fs.String("end", "", "End of the access window, HH:MM")
+	from := fs.String("from", "sun", "First weekday of the access window")
+	to := fs.String("to", "sat", "Last weekday of the access window")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *group < 0 || *start == "" || *end == "" {
+		return fmt.Errorf("groups set needs -group, -start and -end: %w", errUsage)
+	}
+
+	at := database.AccessTime{AccessGroup: *group}
+	var err error
+	if at.StartTime, err = parseTimeOfDay(*start); err != nil {
+		return err
+	}
+	if at.EndTime, err = parseTimeOfDay(*end); err != nil {
+		return err
+	}
+	if at.StartWeekday, err = parseWeekday(*from); err != nil {
+		return err
+	}
+	if at.EndWeekday, err = parseWeekday(*to); err != nil {
+		return err
+	}
+
+	store, err := c.accessManager(ctx)
+	if err != nil {
+		return err
+	}
+	if err := store.PutAccessTime(ctx, at); err != nil {
+		return err
+	}
+	view := newAccessGroupView(at)
+	return c.write(view, func(w io.Writer) {
+		fmt.Fprintf(w, "Group %d allowed %s-%s, %s-%s. Run resync to push it to the gates.\n",
+			view.AccessGroup, view.StartWeekday, view.EndWeekday, view.StartTime, view.EndTime)
+	})
+}
+
+func (c *cli) deleteGroup(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected one access group: %w", errUsage)
+	}
+	var group int
+	if _, err := fmt.Sscan(args[0], &group); err != nil {
+		return fmt.Errorf("invalid access group %q", args[0])
+	}
+	store, err := c.accessManager(ctx)
+	if err != nil {
+		return err
+	}
+	if err := store.DeleteAccessTime(ctx, group); err != nil {
+		return err
+	}
+	return c.write(map[string]int{"deleted": group}, func(w io.Writer) {
+		fmt.Fprintf(w, "Deleted group %d. Run resync to push it to the gates.\n", group)
+	})
+}
+
+// parseTimeOfDay parses HH:MM into the zero-date form access times use.
+func parseTimeOfDay(value string) (time.Time, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, want HH:MM", value)
+	}
+	return time.Date(0, 1, 1, t.Hour(), t.Minute(), 0, 0, time.UTC), nil
+}
+
+func parseWeekday(value string) (time.Weekday, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if len(value) >= 3 {
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if strings.HasPrefix(strings.ToLower(day.String()), value) {
+				return day, nil
+			}
+		}
+	}
+	return 0, errors.New("invalid weekday " + value + ", want e.g. mon or monday")
+}
+
+// parseCard reads a card as printed on the fob, FACILITY:NUMBER, and returns
+// the code it is enrolled under.
+func parseCard(s string) (string, error) {
+	facility, number, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", fmt.Errorf("card %q must be FACILITY:NUMBER: %w", s, errUsage)
+	}
+	f, err := strconv.ParseUint(facility, 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("card facility code %q: %w", facility, err)
+	}
+	n, err := strconv.ParseUint(number, 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("card number %q: %w", number, err)
+	}
+	return database.CardCode(uint32(f), uint32(n)), nil
+}
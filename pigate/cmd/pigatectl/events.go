@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type eventView struct {
+	ID         int64     `json:"id"`
+	LocationID string    `json:"location_id"`
+	EventType  string    `json:"event_type"`
+	Payload    string    `json:"payload"`
+	Operator   string    `json:"operator,omitempty"`
+	Code       string    `json:"code,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// events queries the history the status server records in
+// pigate_status_events, newest first.
+func (c *cli) events(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	location := fs.String("location", "", "Only this Location")
+	types := fs.String("type", "", "Comma-separated event types, e.g. gate_access,gate_command")
+	since := fs.Duration("since", 24*time.Hour, "How far back to look")
+	code := fs.String("code", "", "Only events for this keypad code")
+	limit := fs.Int("limit", 50, "Maximum number of events")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *limit <= 0 {
+		return fmt.Errorf("-limit must be positive: %w", errUsage)
+	}
+
+	db, err := sql.Open("postgres", c.connString())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var where []string
+	var queryArgs []interface{}
+	arg := func(value interface{}) string {
+		queryArgs = append(queryArgs, value)
+		return fmt.Sprintf("$%d", len(queryArgs))
+	}
+	where = append(where, "created_at >= "+arg(time.Now().Add(-*since)))
+	if *location != "" {
+		where = append(where, "location_id = "+arg(*location))
+	}
+	if *types != "" {
+		var placeholders []string
+		for _, eventType := range strings.Split(*types, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				placeholders = append(placeholders, arg(eventType))
+			}
+		}
+		where = append(where, "event_type IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if *code != "" {
+		where = append(where, "code = "+arg(*code))
+	}
+	query := `SELECT id, location_id, event_type, payload, operator, code, created_at
+		FROM pigate_status_events
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY created_at DESC, id DESC
+		LIMIT ` + arg(*limit)
+
+	rows, err := db.QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return fmt.Errorf("query events: %w", err)
+	}
+	defer rows.Close()
+
+	views := []eventView{}
+	for rows.Next() {
+		var event eventView
+		var operator, code sql.NullString
+		if err := rows.Scan(&event.ID, &event.LocationID, &event.EventType, &event.Payload, &operator, &code, &event.CreatedAt); err != nil {
+			return err
+		}
+		event.Operator = operator.String
+		event.Code = code.String
+		views = append(views, event)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return c.write(views, func(w io.Writer) {
+		fmt.Fprintln(w, "TIME\tLOCATION\tEVENT\tPAYLOAD\tOPERATOR\tCODE")
+		for _, e := range views {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				e.CreatedAt.Local().Format(time.DateTime), e.LocationID, e.EventType, e.Payload, e.Operator, e.Code)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"pigate/pkg/messenger"
+)
+
+type commandResult struct {
+	Location string `json:"location"`
+	Command  string `json:"command"`
+}
+
+// command sends open, close, hold_open, lockdown or end_lockdown to one
+// Location.
+func (c *cli) command(ctx context.Context, command string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	location := fs.String("location", "", "Location to command (default LOCATION_ID)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	locations, err := c.locations(*location, false)
+	if err != nil {
+		return err
+	}
+	client, err := c.mqtt()
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	loc := client.ForLocation(locations[0])
+	switch command {
+	case "open":
+		err = loc.CommandOpen()
+	case "close":
+		err = loc.CommandClose()
+	case "hold_open":
+		err = loc.CommandLockOpen()
+	case "lockdown":
+		err = loc.CommandLockdown()
+	case "end_lockdown":
+		err = loc.CommandEndLockdown()
+	}
+	if err != nil {
+		return err
+	}
+	result := commandResult{Location: locations[0], Command: command}
+	return c.write(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Sent %s to %s\n", command, locations[0])
+	})
+}
+
+// resync tells the gate controllers at each Location to pull credentials and
+// access times from Postgres, the same notification the credential server
+// sends after importing a file.
+func (c *cli) resync(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("resync", flag.ContinueOnError)
+	location := fs.String("location", "", "Location to resync (default every configured Location)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	locations, err := c.locations(*location, true)
+	if err != nil {
+		return err
+	}
+	client, err := c.mqtt()
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	for _, id := range locations {
+		if err := client.ForLocation(id).NotifyNewCredentials(); err != nil {
+			return fmt.Errorf("resync %s: %w", id, err)
+		}
+	}
+	return c.write(map[string][]string{"resynced": locations}, func(w io.Writer) {
+		fmt.Fprintf(w, "Asked %s to resync credentials\n", strings.Join(locations, ", "))
+	})
+}
+
+// tailEvent is one live message printed by tail.
+type tailEvent struct {
+	At       time.Time `json:"at"`
+	Location string    `json:"location"`
+	Type     string    `json:"type"`
+	Payload  string    `json:"payload"`
+}
+
+// tail prints gate status, access, presence and credential sync messages as
+// they arrive, until interrupted.
+func (c *cli) tail(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	location := fs.String("location", "", "Location to follow (default every configured Location)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	locations, err := c.locations(*location, true)
+	if err != nil {
+		return err
+	}
+	client, err := c.mqtt()
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	var mu sync.Mutex
+	handler := func(locationID, eventType string) func(topic, payload string) {
+		return func(topic, payload string) {
+			mu.Lock()
+			defer mu.Unlock()
+			c.printTail(tailEvent{At: time.Now(), Location: locationID, Type: eventType, Payload: payload})
+		}
+	}
+	for _, id := range locations {
+		loc := client.ForLocation(id)
+		subscriptions := []error{
+			loc.SubscribePigateStatus(handler(id, "gate_status")),
+			loc.SubscribePigatePosition(handler(id, "gate_position")),
+			loc.SubscribePigateAccess(handler(id, "gate_access")),
+			loc.SubscribePigateAlert(handler(id, "gate_alert")),
+			loc.SubscribePigateDuress(handler(id, "duress")),
+			loc.SubscribePigatePresence(handler(id, "device_presence")),
+			loc.SubscribeCredentialSync(handler(id, "credential_sync")),
+		}
+		for _, err := range subscriptions {
+			if err != nil {
+				return err
+			}
+		}
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// printTail writes one event as a JSON line or a table row. Rows are printed
+// directly, since a live tail cannot wait to align columns.
+func (c *cli) printTail(event tailEvent) {
+	if c.json {
+		_ = json.NewEncoder(c.stdout).Encode(event)
+		return
+	}
+	detail := event.Payload
+	switch event.Type {
+	case "gate_access":
+		var access messenger.AccessEvent
+		if json.Unmarshal([]byte(event.Payload), &access) == nil {
+			detail = fmt.Sprintf("%s code=%s user=%q", access.Result, access.Code, access.Username)
+			if access.Reason != "" {
+				detail += " reason=" + access.Reason
+			}
+		}
+	case "duress":
+		var duress messenger.DuressEvent
+		if json.Unmarshal([]byte(event.Payload), &duress) == nil {
+			detail = fmt.Sprintf("DURESS code=%s user=%q source=%s", duress.Code, duress.Username, duress.Source)
+		}
+	case "gate_alert":
+		var alert messenger.AlertEvent
+		if json.Unmarshal([]byte(event.Payload), &alert) == nil {
+			detail = alert.Type
+			if alert.Until != nil {
+				detail += fmt.Sprintf(" source=%s failures=%d until=%s", alert.Source, alert.Failures, alert.Until.Local().Format(time.TimeOnly))
+			}
+		}
+	}
+	fmt.Fprintf(c.stdout, "%s  %-30s  %-16s  %s\n", event.At.Format(time.TimeOnly), event.Location, event.Type, detail)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	_ "github.com/lib/pq"
+
+	"pigate/pkg/config"
+	"pigate/pkg/database"
+	"pigate/pkg/messenger"
+	"pigate/pkg/version"
+)
+
+const application = "pigatectl"
+
+const usageText = `Usage: pigatectl [-c config dir] [-o table|json] <command> [flags]
+
+Credentials:
+  credentials list [-group N]
+  credentials add (-code CODE | -card FACILITY:NUMBER [-pin PIN]) -name NAME [-duress CODE] [-group N] [-lock-open]
+  credentials lockout CODE
+  credentials unlock CODE
+  credentials delete CODE
+
+Access groups:
+  groups list
+  groups set -group N -start HH:MM -end HH:MM [-from mon] [-to sun]
+  groups delete N
+
+Gate:
+  open|close|hold_open [-location ID]
+  lockdown|end_lockdown [-location ID]
+  tail [-location ID]               print live gate status, access and alerts
+  resync [-location ID]             tell gate controllers to pull credentials
+
+History:
+  events [-location ID] [-type T[,T]] [-code CODE] [-since 24h] [-limit 50]
+`
+
+var errUsage = errors.New("invalid usage")
+
+// cli runs one pigatectl command against the Control Plane.
+type cli struct {
+	cfg    *config.PigateCtlConfig
+	json   bool
+	stdout io.Writer
+}
+
+func main() {
+	var configFilePath, output string
+	var showVersion bool
+	flag.StringVar(&configFilePath, "c", "/workspace/pigate/pkg/config",
+		"Path to the configuration file")
+	flag.StringVar(&output, "o", "table", "Output format: table or json")
+	flag.BoolVar(&showVersion, "version", false, "Print the version and exit")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usageText) }
+	flag.Parse()
+	if showVersion {
+		fmt.Println(application, version.Get())
+		return
+	}
+	if flag.NArg() == 0 || (output != "table" && output != "json") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.LoadConfig(configFilePath, application+"-config").(*config.PigateCtlConfig)
+	c := &cli{cfg: cfg, json: output == "json", stdout: os.Stdout}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := c.run(ctx, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "pigatectl: %v\n", err)
+		if errors.Is(err, errUsage) {
+			flag.Usage()
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+func (c *cli) run(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "credentials":
+		return c.credentials(ctx, args[1:])
+	case "groups":
+		return c.groups(ctx, args[1:])
+	case "open", "close", "hold_open", "lockdown", "end_lockdown":
+		return c.command(ctx, args[0], args[1:])
+	case "tail":
+		return c.tail(ctx, args[1:])
+	case "resync":
+		return c.resync(ctx, args[1:])
+	case "events":
+		return c.events(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q: %w", args[0], errUsage)
+	}
+}
+
+// write prints v as JSON, or as a table drawn by table.
+func (c *cli) write(v interface{}, table func(w io.Writer)) error {
+	if c.json {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// accessStore is the part of the Postgres access manager pigatectl uses.
+type accessStore interface {
+	database.AccessManager
+	GetAccessTimes(ctx context.Context) ([]database.AccessTime, error)
+}
+
+func (c *cli) accessManager(ctx context.Context) (accessStore, error) {
+	manager, err := database.NewPostgresAccessManager(ctx, c.connString())
+	if err != nil {
+		return nil, fmt.Errorf("connect to Postgres: %w", err)
+	}
+	return manager, nil
+}
+
+func (c *cli) connString() string {
+	db := c.cfg.DB
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		db.Host, db.Port, db.User, db.Password, db.Name)
+}
+
+// mqtt connects to the broker. Client IDs include the host and PID so several
+// pigatectl runs do not take over each other's session.
+func (c *cli) mqtt() (*messenger.MQTTClient, error) {
+	if c.cfg.MQTT.Broker == "" {
+		return nil, errors.New("MQTT_BROKER is not configured")
+	}
+	host, _ := os.Hostname()
+	clientID := fmt.Sprintf("%s-%s-%d", application, host, os.Getpid())
+	client := messenger.NewMQTTClientWithCredentials(c.cfg.MQTT.Broker, clientID, c.cfg.Location_ID, c.cfg.MQTT.Username, c.cfg.MQTT.Password)
+	if err := client.Connect(); err != nil {
+		return nil, fmt.Errorf("connect to MQTT broker (%s): %w", c.cfg.MQTT.Broker, err)
+	}
+	return client, nil
+}
+
+// locations returns the Location given with -location, or every configured
+// Location when all is set and the primary one otherwise.
+func (c *cli) locations(flagValue string, all bool) ([]string, error) {
+	if flagValue = strings.TrimSpace(flagValue); flagValue != "" {
+		return []string{flagValue}, nil
+	}
+	ids := []string{c.cfg.Location_ID}
+	if all {
+		ids = append(ids, c.cfg.Location_IDs...)
+	}
+	var locations []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			locations = append(locations, id)
+		}
+	}
+	if len(locations) == 0 {
+		return nil, errors.New("no location given and LOCATION_ID is not configured")
+	}
+	return locations, nil
+}
+
+// parseFlags parses a subcommand's flags, reporting errors as usage errors.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s: %v: %w", fs.Name(), err, errUsage)
+	}
+	return nil
+}
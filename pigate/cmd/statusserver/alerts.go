@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"pigate/pkg/alerting"
+	"pigate/pkg/config"
+	"pigate/pkg/messenger"
+)
+
+const alertEvaluateInterval = 30 * time.Second
+
+// duressRule names the alert for a duress code. No rule evaluates it: each
+// duress event is notified as it arrives and never resolves.
+const duressRule = "duress"
+
+// newAlertEngine builds the alert rules and notifiers enabled in cfg.
+func newAlertEngine(cfg config.AlertConfig) *alerting.Engine {
+	var rules []alerting.Rule
+	if cfg.GateOpenMinutes > 0 {
+		rules = append(rules, alerting.GateHeldOpen{Limit: time.Duration(cfg.GateOpenMinutes) * time.Minute})
+	}
+	if cfg.BusinessHoursStart != "" && cfg.BusinessHoursEnd != "" {
+		start, startErr := alerting.ParseTimeOfDay(cfg.BusinessHoursStart)
+		end, endErr := alerting.ParseTimeOfDay(cfg.BusinessHoursEnd)
+		if startErr != nil || endErr != nil {
+			log.Printf("Ignoring business hours %q-%q: must be HH:MM", cfg.BusinessHoursStart, cfg.BusinessHoursEnd)
+		} else {
+			rules = append(rules, alerting.LockedOpenAfterHours{Start: start, End: end, Location: time.Local})
+		}
+	}
+	if cfg.DeviceOfflineMinutes > 0 {
+		rules = append(rules, alerting.DeviceOfflineRule{Grace: time.Duration(cfg.DeviceOfflineMinutes) * time.Minute})
+	}
+	if cfg.SyncStaleHours > 0 {
+		rules = append(rules, alerting.CredentialSyncStale{MaxAge: time.Duration(cfg.SyncStaleHours) * time.Hour})
+	}
+	if cfg.DeniedThreshold > 0 && cfg.DeniedWindowMinutes > 0 {
+		rules = append(rules, alerting.RepeatedDenials{
+			Threshold: cfg.DeniedThreshold,
+			Window:    time.Duration(cfg.DeniedWindowMinutes) * time.Minute,
+		})
+	}
+
+	if cfg.GateFault {
+		rules = append(rules, alerting.GatePositionFault{})
+	}
+
+	var notifiers []alerting.Notifier
+	if cfg.WebhookURL != "" {
+		notifiers = append(notifiers, alerting.NewWebhookNotifier(cfg.WebhookURL))
+	}
+	if cfg.SMTP.Addr != "" && len(cfg.SMTP.To) > 0 {
+		notifiers = append(notifiers, &alerting.SMTPNotifier{
+			Addr:     cfg.SMTP.Addr,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.From,
+			To:       cfg.SMTP.To,
+		})
+	}
+
+	log.Printf("Alerting enabled with %d rules and %d notifiers", len(rules), len(notifiers))
+	return alerting.NewEngine(rules, notifiers)
+}
+
+// recordAlert stores each alert transition in the Location's history and pushes
+// it to open pages.
+func (a *app) recordAlert(n alerting.Notification) {
+	loc, ok := a.locations.get(n.Alert.LocationID)
+	if !ok {
+		return
+	}
+	payload, err := json.Marshal(n)
+	if err != nil {
+		log.Printf("Failed to encode alert: %v", err)
+		return
+	}
+	now := time.Now()
+	if err := a.store.recordEvent(context.Background(), n.Alert.LocationID, eventAlert, "", string(payload), "", "", now); err != nil {
+		log.Printf("Failed to persist alert: %v", err)
+	}
+	a.publishActivity(loc, eventAlert, "", string(payload), "", "", now)
+}
+
+// notifyDuress records a duress event as a critical alert and sends it to every
+// notifier straight away, without waiting for the next evaluation.
+func (a *app) notifyDuress(loc *gateLocation, event messenger.DuressEvent) {
+	at := event.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	n := alerting.Notification{
+		Status: alerting.StatusFiring,
+		Alert: alerting.Alert{
+			Rule:       duressRule,
+			LocationID: loc.state.locationID,
+			Severity:   alerting.SeverityCritical,
+			Summary:    fmt.Sprintf("Duress code entered at %s for %s (%s) on the %s reader", loc.state.locationID, event.Username, event.Code, event.Source),
+			StartedAt:  at,
+		},
+	}
+	log.Printf("Alert %s: %s", n.Status, n.Alert.Summary)
+	a.recordAlert(n)
+	go a.alerts.Dispatch(context.Background(), n)
+}
+
+func (a *app) observe(loc *gateLocation, kind alerting.ObservationKind, value, code string, at time.Time) {
+	a.alerts.Observe(alerting.Observation{
+		LocationID: loc.state.locationID,
+		Kind:       kind,
+		Value:      value,
+		Code:       code,
+		At:         at,
+	})
+}
+
+type alertsResponse struct {
+	Alerts []alerting.Alert `json:"alerts"`
+}
+
+func (a *app) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, alertsResponse{Alerts: a.alerts.Active()})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"pigate/pkg/database"
+	"pigate/pkg/messenger"
+)
+
+const rolloutCheckInterval = 30 * time.Second
+
+type deviceView struct {
+	DeviceID       string     `json:"device_id"`
+	LocationID     string     `json:"location_id"`
+	CurrentVersion string     `json:"current_version"`
+	DesiredVersion string     `json:"desired_version"`
+	FailedVersion  string     `json:"failed_version,omitempty"`
+	UpdateStatus   string     `json:"update_status"`
+	UpdateError    string     `json:"update_error,omitempty"`
+	LastSeen       *time.Time `json:"last_seen,omitempty"`
+	Drift          bool       `json:"drift"`
+}
+
+type rolloutView struct {
+	LocationID   string    `json:"location_id"`
+	Version      string    `json:"version"`
+	CanaryDevice string    `json:"canary_device"`
+	Status       string    `json:"status"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type devicesResponse struct {
+	Devices  []deviceView  `json:"devices"`
+	Rollouts []rolloutView `json:"rollouts"`
+}
+
+func newDeviceView(d database.Device) deviceView {
+	return deviceView{
+		DeviceID:       d.DeviceID,
+		LocationID:     d.LocationID,
+		CurrentVersion: d.CurrentVersion,
+		DesiredVersion: d.DesiredVersion,
+		FailedVersion:  d.FailedVersion,
+		UpdateStatus:   d.UpdateStatus,
+		UpdateError:    d.UpdateError,
+		LastSeen:       d.LastSeen,
+		Drift:          d.Drifted(),
+	}
+}
+
+func newRolloutView(r database.Rollout) rolloutView {
+	return rolloutView{
+		LocationID:   r.LocationID,
+		Version:      r.Version,
+		CanaryDevice: r.CanaryDeviceID,
+		Status:       r.Status,
+		UpdatedAt:    r.UpdatedAt,
+	}
+}
+
+var errNoDeviceRegistry = errors.New("Postgres client is not configured")
+
+func (a *app) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if a.devices == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoDeviceRegistry.Error())
+		return
+	}
+	locationID := strings.TrimSpace(r.URL.Query().Get("location"))
+	devices, err := a.devices.ListDevices(r.Context(), locationID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rollouts, err := a.devices.ListRollouts(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := devicesResponse{Devices: make([]deviceView, 0, len(devices)), Rollouts: []rolloutView{}}
+	for _, d := range devices {
+		resp.Devices = append(resp.Devices, newDeviceView(d))
+	}
+	for _, ro := range rollouts {
+		if locationID == "" || ro.LocationID == locationID {
+			resp.Rollouts = append(resp.Rollouts, newRolloutView(ro))
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type desiredVersionRequest struct {
+	Version  string `json:"version"`
+	Operator string `json:"operator,omitempty"`
+}
+
+// handleDesiredVersion sets one Device's Desired Version directly, bypassing
+// any Location rollout.
+func (a *app) handleDesiredVersion(w http.ResponseWriter, r *http.Request) {
+	if a.devices == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoDeviceRegistry.Error())
+		return
+	}
+	var req desiredVersionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid desired version request")
+		return
+	}
+	version := strings.TrimSpace(req.Version)
+	deviceID := r.PathValue("id")
+
+	device, err := a.devices.GetDevice(r.Context(), deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown device %q", deviceID))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := a.devices.SetDesiredVersion(r.Context(), deviceID, version); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	device.DesiredVersion = version
+
+	payload, _ := json.Marshal(map[string]string{"device_id": deviceID, "version": version})
+	a.recordVersionEvent(r.Context(), device.LocationID, eventDesiredVersion, string(payload), requestOperator(r, req.Operator))
+	writeJSON(w, http.StatusOK, newDeviceView(*device))
+}
+
+type rolloutRequest struct {
+	Location     string `json:"location"`
+	Version      string `json:"version"`
+	CanaryDevice string `json:"canary_device,omitempty"`
+	Operator     string `json:"operator,omitempty"`
+}
+
+// handleRollout starts a staged rollout of a version to a Location. Without a
+// canary_device the first Device at the Location is the canary.
+func (a *app) handleRollout(w http.ResponseWriter, r *http.Request) {
+	if a.devices == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoDeviceRegistry.Error())
+		return
+	}
+	var req rolloutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid rollout request")
+		return
+	}
+	version := strings.TrimSpace(req.Version)
+	if version == "" {
+		writeError(w, http.StatusBadRequest, "version is required")
+		return
+	}
+	loc, err := a.locationFor(req.Location)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	locationID := loc.state.locationID
+
+	canary := strings.TrimSpace(req.CanaryDevice)
+	if canary == "" {
+		devices, err := a.devices.ListDevices(r.Context(), locationID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(devices) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("location %q has no registered devices", locationID))
+			return
+		}
+		canary = devices[0].DeviceID
+	}
+
+	err = a.devices.StartRollout(r.Context(), locationID, version, canary)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("device %q is not at location %q", canary, locationID))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rollout := database.Rollout{LocationID: locationID, Version: version, CanaryDeviceID: canary, Status: database.RolloutCanary, UpdatedAt: time.Now()}
+	a.recordRollout(r.Context(), rollout, requestOperator(r, req.Operator))
+	writeJSON(w, http.StatusOK, newRolloutView(rollout))
+}
+
+// watchRollouts promotes or fails canary rollouts as canaries report back.
+func (a *app) watchRollouts(ctx context.Context) {
+	if a.devices == nil {
+		return
+	}
+	ticker := time.NewTicker(rolloutCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := a.devices.AdvanceRollouts(ctx)
+			if err != nil {
+				log.Printf("Failed to advance rollouts: %v", err)
+			}
+			for _, rollout := range changed {
+				log.Printf("Rollout of %s to %s %s", rollout.Version, rollout.LocationID, rollout.Status)
+				a.recordRollout(ctx, rollout, "")
+			}
+		}
+	}
+}
+
+// recordHeartbeat persists the version a Device reports running. Heartbeats
+// are frequent, so they update the Device record but not the history.
+func (a *app) recordHeartbeat(loc *gateLocation, heartbeat messenger.Heartbeat) {
+	if a.devices == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := a.devices.RecordHeartbeat(ctx, heartbeat.DeviceID, loc.state.locationID, heartbeat.Version); err != nil {
+		log.Printf("Failed to persist heartbeat from %s: %v", heartbeat.DeviceID, err)
+	}
+}
+
+func (a *app) recordRollout(ctx context.Context, rollout database.Rollout, operator string) {
+	payload, err := json.Marshal(newRolloutView(rollout))
+	if err != nil {
+		log.Printf("Failed to encode rollout: %v", err)
+		return
+	}
+	a.recordVersionEvent(ctx, rollout.LocationID, eventRollout, string(payload), operator)
+}
+
+// recordVersionEvent stores a version change in the Location's history and
+// pushes it to open pages.
+func (a *app) recordVersionEvent(ctx context.Context, locationID, eventType, payload, operator string) {
+	now := time.Now()
+	if err := a.store.recordEvent(ctx, locationID, eventType, "", payload, operator, "", now); err != nil {
+		log.Printf("Failed to persist %s event: %v", eventType, err)
+	}
+	if loc, ok := a.locations.get(locationID); ok {
+		a.publishActivity(loc, eventType, "", payload, operator, "", now)
+	}
+}
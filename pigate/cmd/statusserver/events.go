@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event types stored in pigate_status_events.
+const (
+	eventGateStatus       = "gate_status"
+	eventGatePosition     = "gate_position"
+	eventCredentialStatus = "credential_status"
+	eventGateCommand      = "gate_command"
+	eventGateAccess       = "gate_access"
+	eventGateAlert        = "gate_alert"
+	eventDevicePresence   = "device_presence"
+	eventCredentialSync   = "credential_sync"
+	eventAlert            = "alert"
+	eventDesiredVersion   = "desired_version"
+	eventRollout          = "rollout"
+)
+
+const (
+	defaultEventLimit = 50
+	maxEventLimit     = 500
+)
+
+type statusEvent struct {
+	ID         int64     `json:"id"`
+	LocationID string    `json:"location_id"`
+	EventType  string    `json:"event_type"`
+	Topic      string    `json:"topic"`
+	Payload    string    `json:"payload"`
+	Operator   string    `json:"operator,omitempty"`
+	Code       string    `json:"code,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type eventPage struct {
+	Events     []statusEvent `json:"events"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// eventFilter narrows an event history query. Zero values match everything.
+type eventFilter struct {
+	locationID string
+	eventTypes []string
+	since      *time.Time
+	until      *time.Time
+	operator   string
+	code       string
+	limit      int
+	cursor     *eventCursor
+}
+
+// eventCursor points at the last event of the previous page. Events are
+// ordered newest first by (created_at, id), so the next page starts strictly
+// below this pair.
+type eventCursor struct {
+	createdAt time.Time
+	id        int64
+}
+
+func (c eventCursor) encode() string {
+	raw := fmt.Sprintf("%d:%d", c.createdAt.UnixNano(), c.id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeEventCursor(value string) (*eventCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	i, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &eventCursor{createdAt: time.Unix(0, n), id: i}, nil
+}
+
+func parseEventFilter(r *http.Request) (eventFilter, error) {
+	q := r.URL.Query()
+	filter := eventFilter{
+		locationID: strings.TrimSpace(q.Get("location")),
+		operator:   strings.TrimSpace(q.Get("operator")),
+		code:       strings.TrimSpace(q.Get("code")),
+		limit:      defaultEventLimit,
+	}
+
+	for _, value := range q["type"] {
+		for _, eventType := range strings.Split(value, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				filter.eventTypes = append(filter.eventTypes, eventType)
+			}
+		}
+	}
+
+	if value := q.Get("since"); value != "" {
+		since, err := parseEventTime(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid since: %w", err)
+		}
+		filter.since = &since
+	}
+	if value := q.Get("until"); value != "" {
+		until, err := parseEventTime(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid until: %w", err)
+		}
+		filter.until = &until
+	}
+	if filter.since != nil && filter.until != nil && filter.until.Before(*filter.since) {
+		return filter, errors.New("until must not be before since")
+	}
+
+	if value := q.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return filter, errors.New("limit must be a positive number")
+		}
+		if limit > maxEventLimit {
+			limit = maxEventLimit
+		}
+		filter.limit = limit
+	}
+
+	if value := q.Get("cursor"); value != "" {
+		cursor, err := decodeEventCursor(value)
+		if err != nil {
+			return filter, err
+		}
+		filter.cursor = cursor
+	}
+	return filter, nil
+}
+
+// parseEventTime accepts RFC 3339 timestamps as well as the zone-less
+// values produced by <input type="datetime-local">, read in server time.
+func parseEventTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a RFC 3339 time", value)
+}
+
+func (s *statusStore) listEvents(parent context.Context, filter eventFilter) (eventPage, error) {
+	page := eventPage{Events: []statusEvent{}}
+	if s.db == nil {
+		return page, errors.New("Postgres client is not configured")
+	}
+	ctx, cancel := context.WithTimeout(parent, 5*time.Second)
+	defer cancel()
+
+	var where []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.locationID != "" {
+		where = append(where, "location_id = "+arg(filter.locationID))
+	}
+	if len(filter.eventTypes) > 0 {
+		placeholders := make([]string, len(filter.eventTypes))
+		for i, eventType := range filter.eventTypes {
+			placeholders[i] = arg(eventType)
+		}
+		where = append(where, "event_type IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if filter.since != nil {
+		where = append(where, "created_at >= "+arg(*filter.since))
+	}
+	if filter.until != nil {
+		where = append(where, "created_at < "+arg(*filter.until))
+	}
+	if filter.operator != "" {
+		// Exact match ignoring case; % and _ are not wildcards.
+		where = append(where, "lower(operator) = lower("+arg(filter.operator)+")")
+	}
+	if filter.code != "" {
+		where = append(where, "code = "+arg(filter.code))
+	}
+	if filter.cursor != nil {
+		where = append(where, fmt.Sprintf("(created_at, id) < (%s, %s)", arg(filter.cursor.createdAt), arg(filter.cursor.id)))
+	}
+
+	query := `SELECT id, location_id, event_type, topic, payload, operator, code, created_at
+		FROM pigate_status_events`
+	if len(where) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(where, " AND ")
+	}
+	// Fetch one extra row to learn whether another page exists.
+	query += "\n\t\tORDER BY created_at DESC, id DESC\n\t\tLIMIT " + arg(filter.limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event statusEvent
+		var operator, code sql.NullString
+		if err := rows.Scan(&event.ID, &event.LocationID, &event.EventType, &event.Topic, &event.Payload, &operator, &code, &event.CreatedAt); err != nil {
+			return page, err
+		}
+		event.Operator = operator.String
+		event.Code = code.String
+		page.Events = append(page.Events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(page.Events) > filter.limit {
+		page.Events = page.Events[:filter.limit]
+		last := page.Events[len(page.Events)-1]
+		page.NextCursor = eventCursor{createdAt: last.CreatedAt, id: last.ID}.encode()
+	}
+	return page, nil
+}
+
+func (a *app) handleEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := a.store.listEvents(r.Context(), filter)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"pigate/pkg/messenger"
+)
+
+const locationRefreshInterval = time.Minute
+
+// gateLocation is one Location followed by this status server: its in-memory
+// state and a messenger addressing its topics over the shared connection.
+type gateLocation struct {
+	state *statusState
+	mqtt  *messenger.MQTTClient
+}
+
+// locationSet holds every followed Location in the order it was added.
+type locationSet struct {
+	mu    sync.RWMutex
+	byID  map[string]*gateLocation
+	order []string
+}
+
+func newLocationSet() *locationSet {
+	return &locationSet{byID: make(map[string]*gateLocation)}
+}
+
+func (l *locationSet) get(locationID string) (*gateLocation, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	loc, ok := l.byID[locationID]
+	return loc, ok
+}
+
+func (l *locationSet) all() []*gateLocation {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	locs := make([]*gateLocation, 0, len(l.order))
+	for _, id := range l.order {
+		locs = append(locs, l.byID[id])
+	}
+	return locs
+}
+
+// add stores loc unless its Location is already present.
+func (l *locationSet) add(loc *gateLocation) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	id := loc.state.locationID
+	if _, ok := l.byID[id]; ok {
+		return false
+	}
+	l.byID[id] = loc
+	l.order = append(l.order, id)
+	return true
+}
+
+type locationRecord struct {
+	locationID string
+	name       string
+}
+
+// listLocations returns the enabled rows of pigate_locations.
+func (s *statusStore) listLocations(parent context.Context) ([]locationRecord, error) {
+	if s.db == nil {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(parent, 3*time.Second)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT location_id, name
+		FROM pigate_locations
+		WHERE enabled
+		ORDER BY location_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []locationRecord
+	for rows.Next() {
+		var record locationRecord
+		if err := rows.Scan(&record.locationID, &record.name); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// configuredLocations merges LOCATION_ID and LOCATION_IDS, dropping blanks
+// and duplicates while keeping the configured order.
+func configuredLocations(primary string, extra []string) []string {
+	seen := make(map[string]struct{})
+	var ids []string
+	for _, id := range append([]string{primary}, extra...) {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if _, dup := seen[id]; dup {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// addLocation starts following locationID: it restores the persisted latest
+// status and subscribes to the Location's topics. Known Locations only have
+// their display name updated.
+func (a *app) addLocation(ctx context.Context, locationID, name string) {
+	if loc, ok := a.locations.get(locationID); ok {
+		loc.state.setName(name)
+		return
+	}
+
+	state := newStatusState(locationID)
+	state.setName(name)
+	if err := a.store.loadLatest(ctx, state); err != nil {
+		log.Printf("Status latest load failed for %s: %v", locationID, err)
+	}
+	loc := &gateLocation{
+		state: state,
+		mqtt:  a.mqtt.ForLocation(locationID),
+	}
+	if !a.locations.add(loc) {
+		return
+	}
+	log.Printf("Following location %s", locationID)
+	a.alerts.Track(locationID, time.Now())
+	a.subscribeToStatus(loc)
+	a.broadcastStatus(loc)
+}
+
+// refreshLocations follows Locations added to pigate_locations since start-up.
+func (a *app) refreshLocations(ctx context.Context) {
+	records, err := a.store.listLocations(ctx)
+	if err != nil {
+		log.Printf("Failed to load locations: %v", err)
+		return
+	}
+	for _, record := range records {
+		a.addLocation(ctx, record.locationID, record.name)
+	}
+}
+
+func (a *app) watchLocations(ctx context.Context) {
+	ticker := time.NewTicker(locationRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.refreshLocations(ctx)
+		}
+	}
+}
+
+var errLocationRequired = errors.New("location is required when more than one location is configured")
+
+// locationFor resolves a Location from a request. An empty ID selects the only
+// followed Location.
+func (a *app) locationFor(locationID string) (*gateLocation, error) {
+	locationID = strings.TrimSpace(locationID)
+	if locationID == "" {
+		locs := a.locations.all()
+		switch len(locs) {
+		case 0:
+			return nil, errors.New("no locations are configured")
+		case 1:
+			return locs[0], nil
+		default:
+			return nil, errLocationRequired
+		}
+	}
+	loc, ok := a.locations.get(locationID)
+	if !ok {
+		return nil, fmt.Errorf("unknown location %q", locationID)
+	}
+	return loc, nil
+}
+
+type locationsResponse struct {
+	Locations []statusSnapshot `json:"locations"`
+}
+
+func (a *app) handleLocations(w http.ResponseWriter, r *http.Request) {
+	locs := a.locations.all()
+	resp := locationsResponse{Locations: make([]statusSnapshot, 0, len(locs))}
+	for _, loc := range locs {
+		resp.Locations = append(resp.Locations, a.currentSnapshot(loc))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
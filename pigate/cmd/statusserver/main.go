@@ -17,8 +17,11 @@ import (
 
 	_ "github.com/lib/pq"
 
+	"pigate/pkg/alerting"
 	"pigate/pkg/config"
+	"pigate/pkg/database"
 	"pigate/pkg/messenger"
+	"pigate/pkg/version"
 )
 
 const application = "statusserver"
@@ -29,22 +32,32 @@ var staticFiles embed.FS
 type statusState struct {
 	mu                 sync.RWMutex
 	locationID         string
+	name               string
 	gateStatus         string
 	gateStatusAt       *time.Time
+	gatePosition       string
+	gatePositionAt     *time.Time
 	credentialStatus   string
 	credentialStatusAt *time.Time
 	lastCommand        string
 	lastCommandAt      *time.Time
+	devicePresence     string
+	devicePresenceAt   *time.Time
 }
 
 type statusSnapshot struct {
 	LocationID         string     `json:"location_id"`
+	LocationName       string     `json:"location_name,omitempty"`
 	GateStatus         string     `json:"gate_status"`
 	GateStatusAt       *time.Time `json:"gate_status_at,omitempty"`
+	GatePosition       string     `json:"gate_position,omitempty"` // empty for gates without limit switches
+	GatePositionAt     *time.Time `json:"gate_position_at,omitempty"`
 	CredentialStatus   string     `json:"credential_status"`
 	CredentialStatusAt *time.Time `json:"credential_status_at,omitempty"`
 	LastCommand        string     `json:"last_command,omitempty"`
 	LastCommandAt      *time.Time `json:"last_command_at,omitempty"`
+	DevicePresence     string     `json:"device_presence"`
+	DevicePresenceAt   *time.Time `json:"device_presence_at,omitempty"`
 	MQTTConnected      bool       `json:"mqtt_connected"`
 	DBConnected        bool       `json:"db_connected"`
 	DBError            string     `json:"db_error,omitempty"`
@@ -61,31 +74,41 @@ type statusStore struct {
 }
 
 type app struct {
-	state *statusState
-	store *statusStore
-	mqtt  *messenger.MQTTClient
+	locations *locationSet
+	store     *statusStore
+	mqtt      *messenger.MQTTClient // shared connection; see gateLocation.mqtt for per-location topics
+	hub       *streamHub
+	health    *healthMonitor
+	alerts    *alerting.Engine
+	metrics   *serverMetrics
+	devices   *database.DeviceRegistry // nil without Postgres
 }
 
 func main() {
 	var configFilePath string
+	var showVersion bool
 	flag.StringVar(&configFilePath, "c", "/workspace/pigate/pkg/config", "Path to the configuration file")
+	flag.BoolVar(&showVersion, "version", false, "Print the version and exit")
 	flag.Parse()
+	if showVersion {
+		fmt.Println(application, version.Get())
+		return
+	}
+	log.Printf("Starting statusserver %s", version.Get())
 
 	cfg := config.LoadConfig(configFilePath, application+"-config").(*config.StatusServerConfig)
 	if cfg.HTTPAddr == "" {
 		cfg.HTTPAddr = "127.0.0.1:8090"
 	}
 
-	state := newStatusState(cfg.Location_ID)
 	store := newStatusStore(connString(cfg.DB))
 	if err := store.initSchema(context.Background()); err != nil {
 		log.Printf("Status schema initialization failed: %v", err)
 	}
-	if err := store.loadLatest(context.Background(), state); err != nil {
-		log.Printf("Status latest load failed: %v", err)
-	}
 	defer store.Close()
+	devices := newDeviceRegistry(store)
 
+	serverMetrics := newServerMetrics()
 	client := messenger.NewMQTTClientWithCredentials(
 		cfg.MQTT.Broker,
 		application,
@@ -93,16 +116,30 @@ func main() {
 		cfg.MQTT.Username,
 		cfg.MQTT.Password,
 	)
+	client.SetMetrics(serverMetrics.messenger)
 	if err := client.Connect(); err != nil {
 		log.Printf("Failed to connect to MQTT broker (%s): %v", cfg.MQTT.Broker, err)
 	}
 
 	serverApp := &app{
-		state: state,
-		store: store,
-		mqtt:  client,
-	}
-	serverApp.subscribeToStatus()
+		locations: newLocationSet(),
+		store:     store,
+		mqtt:      client,
+		hub:       newStreamHub(),
+		health:    &healthMonitor{},
+		alerts:    newAlertEngine(cfg.Alerts),
+		metrics:   serverMetrics,
+		devices:   devices,
+	}
+	serverMetrics.registerGauges(serverApp)
+	for _, locationID := range configuredLocations(cfg.Location_ID, cfg.Location_IDs) {
+		serverApp.addLocation(context.Background(), locationID, "")
+	}
+	serverApp.refreshLocations(context.Background())
+	go serverApp.watchLocations(context.Background())
+	go serverApp.monitorHealth(context.Background())
+	go serverApp.alerts.Run(context.Background(), alertEvaluateInterval, serverApp.recordAlert)
+	go serverApp.watchRollouts(context.Background())
 
 	static, err := fs.Sub(staticFiles, "static")
 	if err != nil {
@@ -110,9 +147,17 @@ func main() {
 	}
 
 	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/locations", serverApp.handleLocations)
 	mux.HandleFunc("GET /api/status", serverApp.handleStatus)
 	mux.HandleFunc("POST /api/command", serverApp.handleCommand)
+	mux.HandleFunc("GET /api/events", serverApp.handleEvents)
+	mux.HandleFunc("GET /api/stream", serverApp.handleStream)
+	mux.HandleFunc("GET /api/alerts", serverApp.handleAlerts)
+	mux.HandleFunc("GET /api/devices", serverApp.handleDevices)
+	mux.HandleFunc("POST /api/devices/{id}/desired-version", serverApp.handleDesiredVersion)
+	mux.HandleFunc("POST /api/rollouts", serverApp.handleRollout)
 	mux.HandleFunc("GET /healthz", serverApp.handleHealth)
+	mux.HandleFunc("GET /metrics", serverApp.handleMetrics)
 	mux.Handle("/", http.FileServer(http.FS(static)))
 
 	server := &http.Server{
@@ -132,6 +177,15 @@ func newStatusState(locationID string) *statusState {
 		locationID:       locationID,
 		gateStatus:       "unknown",
 		credentialStatus: "unknown",
+		devicePresence:   "unknown",
+	}
+}
+
+func (s *statusState) setName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name != "" {
+		s.name = name
 	}
 }
 
@@ -142,6 +196,13 @@ func (s *statusState) setGateStatus(status string, at time.Time) {
 	s.gateStatusAt = &at
 }
 
+func (s *statusState) setGatePosition(position string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gatePosition = position
+	s.gatePositionAt = &at
+}
+
 func (s *statusState) setCredentialStatus(status string, at time.Time) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -149,6 +210,13 @@ func (s *statusState) setCredentialStatus(status string, at time.Time) {
 	s.credentialStatusAt = &at
 }
 
+func (s *statusState) setDevicePresence(presence string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devicePresence = presence
+	s.devicePresenceAt = &at
+}
+
 func (s *statusState) setCommand(command string, at time.Time) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -163,12 +231,18 @@ func (s *statusState) applyLatest(latest persistedLatest) {
 		s.gateStatus = latest.gateStatus
 	}
 	s.gateStatusAt = latest.gateStatusAt
+	s.gatePosition = latest.gatePosition
+	s.gatePositionAt = latest.gatePositionAt
 	if latest.credentialStatus != "" {
 		s.credentialStatus = latest.credentialStatus
 	}
 	s.credentialStatusAt = latest.credentialStatusAt
 	s.lastCommand = latest.lastCommand
 	s.lastCommandAt = latest.lastCommandAt
+	if latest.devicePresence != "" {
+		s.devicePresence = latest.devicePresence
+	}
+	s.devicePresenceAt = latest.devicePresenceAt
 }
 
 func (s *statusState) snapshot(mqttConnected bool, health dbHealth) statusSnapshot {
@@ -177,12 +251,17 @@ func (s *statusState) snapshot(mqttConnected bool, health dbHealth) statusSnapsh
 
 	return statusSnapshot{
 		LocationID:         s.locationID,
+		LocationName:       s.name,
 		GateStatus:         s.gateStatus,
 		GateStatusAt:       s.gateStatusAt,
+		GatePosition:       s.gatePosition,
+		GatePositionAt:     s.gatePositionAt,
 		CredentialStatus:   s.credentialStatus,
 		CredentialStatusAt: s.credentialStatusAt,
 		LastCommand:        s.lastCommand,
 		LastCommandAt:      s.lastCommandAt,
+		DevicePresence:     s.devicePresence,
+		DevicePresenceAt:   s.devicePresenceAt,
 		MQTTConnected:      mqttConnected,
 		DBConnected:        health.connected,
 		DBError:            health.err,
@@ -208,6 +287,50 @@ func (s *statusStore) Close() {
 	}
 }
 
+// statusSchema creates the status tables, then adds the columns later
+// releases introduced to tables an older status server created. Every ALTER
+// follows its table's CREATE so that a fresh database gets through it.
+var statusSchema = []string{
+	`CREATE TABLE IF NOT EXISTS pigate_status_events (
+		id BIGSERIAL PRIMARY KEY,
+		location_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		topic TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`,
+	`CREATE INDEX IF NOT EXISTS pigate_status_events_location_created_idx
+		ON pigate_status_events (location_id, created_at DESC);`,
+	`ALTER TABLE pigate_status_events ADD COLUMN IF NOT EXISTS operator TEXT;`,
+	`ALTER TABLE pigate_status_events ADD COLUMN IF NOT EXISTS code TEXT;`,
+	`CREATE INDEX IF NOT EXISTS pigate_status_events_created_id_idx
+		ON pigate_status_events (created_at DESC, id DESC);`,
+	`CREATE TABLE IF NOT EXISTS pigate_locations (
+		location_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`,
+	`CREATE TABLE IF NOT EXISTS pigate_status_latest (
+		location_id TEXT PRIMARY KEY,
+		gate_status TEXT NOT NULL DEFAULT 'unknown',
+		gate_status_at TIMESTAMPTZ,
+		gate_position TEXT,
+		gate_position_at TIMESTAMPTZ,
+		credential_status TEXT NOT NULL DEFAULT 'unknown',
+		credential_status_at TIMESTAMPTZ,
+		last_command TEXT,
+		last_command_at TIMESTAMPTZ,
+		device_presence TEXT NOT NULL DEFAULT 'unknown',
+		device_presence_at TIMESTAMPTZ,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`,
+	`ALTER TABLE pigate_status_latest ADD COLUMN IF NOT EXISTS device_presence TEXT NOT NULL DEFAULT 'unknown';`,
+	`ALTER TABLE pigate_status_latest ADD COLUMN IF NOT EXISTS device_presence_at TIMESTAMPTZ;`,
+	`ALTER TABLE pigate_status_latest ADD COLUMN IF NOT EXISTS gate_position TEXT;`,
+	`ALTER TABLE pigate_status_latest ADD COLUMN IF NOT EXISTS gate_position_at TIMESTAMPTZ;`,
+}
+
 func (s *statusStore) initSchema(parent context.Context) error {
 	if s.db == nil {
 		return errors.New("Postgres client is not configured")
@@ -215,30 +338,7 @@ func (s *statusStore) initSchema(parent context.Context) error {
 	ctx, cancel := context.WithTimeout(parent, 5*time.Second)
 	defer cancel()
 
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS pigate_status_events (
-			id BIGSERIAL PRIMARY KEY,
-			location_id TEXT NOT NULL,
-			event_type TEXT NOT NULL,
-			topic TEXT NOT NULL,
-			payload TEXT NOT NULL,
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-		);`,
-		`CREATE INDEX IF NOT EXISTS pigate_status_events_location_created_idx
-			ON pigate_status_events (location_id, created_at DESC);`,
-		`CREATE TABLE IF NOT EXISTS pigate_status_latest (
-			location_id TEXT PRIMARY KEY,
-			gate_status TEXT NOT NULL DEFAULT 'unknown',
-			gate_status_at TIMESTAMPTZ,
-			credential_status TEXT NOT NULL DEFAULT 'unknown',
-			credential_status_at TIMESTAMPTZ,
-			last_command TEXT,
-			last_command_at TIMESTAMPTZ,
-			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-		);`,
-	}
-
-	for _, query := range queries {
+	for _, query := range statusSchema {
 		if _, err := s.db.ExecContext(ctx, query); err != nil {
 			return err
 		}
@@ -246,6 +346,21 @@ func (s *statusStore) initSchema(parent context.Context) error {
 	return nil
 }
 
+// newDeviceRegistry shares the status store's connection with the Device
+// registry used for Desired Version management.
+func newDeviceRegistry(s *statusStore) *database.DeviceRegistry {
+	if s.db == nil {
+		return nil
+	}
+	registry := database.NewDeviceRegistry(s.db)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := registry.InitSchema(ctx); err != nil {
+		log.Printf("Device schema initialization failed: %v", err)
+	}
+	return registry
+}
+
 func (s *statusStore) health(parent context.Context) dbHealth {
 	if s.db == nil {
 		return dbHealth{connected: false, err: "Postgres client is not configured"}
@@ -261,10 +376,14 @@ func (s *statusStore) health(parent context.Context) dbHealth {
 type persistedLatest struct {
 	gateStatus         string
 	gateStatusAt       *time.Time
+	gatePosition       string
+	gatePositionAt     *time.Time
 	credentialStatus   string
 	credentialStatusAt *time.Time
 	lastCommand        string
 	lastCommandAt      *time.Time
+	devicePresence     string
+	devicePresenceAt   *time.Time
 }
 
 func (s *statusStore) loadLatest(parent context.Context, state *statusState) error {
@@ -279,8 +398,12 @@ func (s *statusStore) loadLatest(parent context.Context, state *statusState) err
 	var credentialStatusAt sql.NullTime
 	var lastCommand sql.NullString
 	var lastCommandAt sql.NullTime
+	var devicePresenceAt sql.NullTime
+	var gatePosition sql.NullString
+	var gatePositionAt sql.NullTime
 	err := s.db.QueryRowContext(ctx, `
-		SELECT gate_status, gate_status_at, credential_status, credential_status_at, last_command, last_command_at
+		SELECT gate_status, gate_status_at, credential_status, credential_status_at, last_command, last_command_at,
+			device_presence, device_presence_at, gate_position, gate_position_at
 		FROM pigate_status_latest
 		WHERE location_id = $1
 	`, state.locationID).Scan(
@@ -290,6 +413,10 @@ func (s *statusStore) loadLatest(parent context.Context, state *statusState) err
 		&credentialStatusAt,
 		&lastCommand,
 		&lastCommandAt,
+		&latest.devicePresence,
+		&devicePresenceAt,
+		&gatePosition,
+		&gatePositionAt,
 	)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil
@@ -309,41 +436,68 @@ func (s *statusStore) loadLatest(parent context.Context, state *statusState) err
 	if lastCommandAt.Valid {
 		latest.lastCommandAt = &lastCommandAt.Time
 	}
+	if devicePresenceAt.Valid {
+		latest.devicePresenceAt = &devicePresenceAt.Time
+	}
+	if gatePosition.Valid {
+		latest.gatePosition = gatePosition.String
+	}
+	if gatePositionAt.Valid {
+		latest.gatePositionAt = &gatePositionAt.Time
+	}
 	state.applyLatest(latest)
 	return nil
 }
 
 func (s *statusStore) recordGateStatus(parent context.Context, locationID, topic, payload string, at time.Time) error {
-	if err := s.recordEvent(parent, locationID, "gate_status", topic, payload, at); err != nil {
+	if err := s.recordEvent(parent, locationID, eventGateStatus, topic, payload, "", "", at); err != nil {
 		return err
 	}
 	return s.upsertGateStatus(parent, locationID, payload, at)
 }
 
+func (s *statusStore) recordGatePosition(parent context.Context, locationID, topic, payload string, at time.Time) error {
+	if err := s.recordEvent(parent, locationID, eventGatePosition, topic, payload, "", "", at); err != nil {
+		return err
+	}
+	return s.upsertGatePosition(parent, locationID, payload, at)
+}
+
 func (s *statusStore) recordCredentialStatus(parent context.Context, locationID, topic, payload string, at time.Time) error {
-	if err := s.recordEvent(parent, locationID, "credential_status", topic, payload, at); err != nil {
+	if err := s.recordEvent(parent, locationID, eventCredentialStatus, topic, payload, "", "", at); err != nil {
 		return err
 	}
 	return s.upsertCredentialStatus(parent, locationID, payload, at)
 }
 
-func (s *statusStore) recordCommand(parent context.Context, locationID, topic, payload string, at time.Time) error {
-	if err := s.recordEvent(parent, locationID, "gate_command", topic, payload, at); err != nil {
+func (s *statusStore) recordCommand(parent context.Context, locationID, topic, payload, operator string, at time.Time) error {
+	if err := s.recordEvent(parent, locationID, eventGateCommand, topic, payload, operator, "", at); err != nil {
 		return err
 	}
 	return s.upsertCommand(parent, locationID, payload, at)
 }
 
-func (s *statusStore) recordEvent(parent context.Context, locationID, eventType, topic, payload string, at time.Time) error {
+func (s *statusStore) recordDevicePresence(parent context.Context, locationID, topic, payload string, at time.Time) error {
+	if err := s.recordEvent(parent, locationID, eventDevicePresence, topic, payload, "", "", at); err != nil {
+		return err
+	}
+	return s.upsertDevicePresence(parent, locationID, payload, at)
+}
+
+func (s *statusStore) recordAccess(parent context.Context, locationID, topic, payload string, event messenger.AccessEvent, at time.Time) error {
+	return s.recordEvent(parent, locationID, eventGateAccess, topic, payload, event.Username, event.Code, at)
+}
+
+func (s *statusStore) recordEvent(parent context.Context, locationID, eventType, topic, payload, operator, code string, at time.Time) error {
 	if s.db == nil {
 		return nil
 	}
 	ctx, cancel := context.WithTimeout(parent, 3*time.Second)
 	defer cancel()
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO pigate_status_events (location_id, event_type, topic, payload, created_at)
-		VALUES ($1, $2, $3, $4, $5)
-	`, locationID, eventType, topic, payload, at)
+		INSERT INTO pigate_status_events (location_id, event_type, topic, payload, operator, code, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7)
+	`, locationID, eventType, topic, payload, operator, code, at)
 	return err
 }
 
@@ -364,6 +518,23 @@ func (s *statusStore) upsertGateStatus(parent context.Context, locationID, statu
 	return err
 }
 
+func (s *statusStore) upsertGatePosition(parent context.Context, locationID, position string, at time.Time) error {
+	if s.db == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(parent, 3*time.Second)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO pigate_status_latest (location_id, gate_position, gate_position_at, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (location_id) DO UPDATE SET
+			gate_position = EXCLUDED.gate_position,
+			gate_position_at = EXCLUDED.gate_position_at,
+			updated_at = NOW()
+	`, locationID, position, at)
+	return err
+}
+
 func (s *statusStore) upsertCredentialStatus(parent context.Context, locationID, status string, at time.Time) error {
 	if s.db == nil {
 		return nil
@@ -398,39 +569,152 @@ func (s *statusStore) upsertCommand(parent context.Context, locationID, command
 	return err
 }
 
-func (a *app) subscribeToStatus() {
-	if err := a.mqtt.SubscribePigateStatus(func(topic, status string) {
+func (s *statusStore) upsertDevicePresence(parent context.Context, locationID, presence string, at time.Time) error {
+	if s.db == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(parent, 3*time.Second)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO pigate_status_latest (location_id, device_presence, device_presence_at, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (location_id) DO UPDATE SET
+			device_presence = EXCLUDED.device_presence,
+			device_presence_at = EXCLUDED.device_presence_at,
+			updated_at = NOW()
+	`, locationID, presence, at)
+	return err
+}
+
+func (a *app) subscribeToStatus(loc *gateLocation) {
+	if err := loc.mqtt.SubscribePigateStatus(func(topic, status string) {
 		now := time.Now()
-		a.state.setGateStatus(status, now)
-		if err := a.store.recordGateStatus(context.Background(), a.state.locationID, topic, status, now); err != nil {
+		loc.state.setGateStatus(status, now)
+		if err := a.store.recordGateStatus(context.Background(), loc.state.locationID, topic, status, now); err != nil {
 			log.Printf("Failed to persist gate status: %v", err)
 		}
+		a.publishActivity(loc, eventGateStatus, topic, status, "", "", now)
+		a.observe(loc, alerting.ObserveGateStatus, status, "", now)
 	}); err != nil {
 		log.Printf("Failed to subscribe to gate status: %v", err)
 	}
 
-	if err := a.mqtt.SubscribeCredentialStatus(func(topic, status string) {
+	if err := loc.mqtt.SubscribePigatePosition(func(topic, position string) {
 		now := time.Now()
-		a.state.setCredentialStatus(status, now)
-		if err := a.store.recordCredentialStatus(context.Background(), a.state.locationID, topic, status, now); err != nil {
+		loc.state.setGatePosition(position, now)
+		if err := a.store.recordGatePosition(context.Background(), loc.state.locationID, topic, position, now); err != nil {
+			log.Printf("Failed to persist gate position: %v", err)
+		}
+		a.publishActivity(loc, eventGatePosition, topic, position, "", "", now)
+		a.observe(loc, alerting.ObserveGatePosition, position, "", now)
+	}); err != nil {
+		log.Printf("Failed to subscribe to gate position: %v", err)
+	}
+
+	if err := loc.mqtt.SubscribeCredentialStatus(func(topic, status string) {
+		now := time.Now()
+		loc.state.setCredentialStatus(status, now)
+		if err := a.store.recordCredentialStatus(context.Background(), loc.state.locationID, topic, status, now); err != nil {
 			log.Printf("Failed to persist credential status: %v", err)
 		}
+		a.publishActivity(loc, eventCredentialStatus, topic, status, "", "", now)
 	}); err != nil {
 		log.Printf("Failed to subscribe to credential status: %v", err)
 	}
+
+	if err := loc.mqtt.SubscribePigateAccess(func(topic, payload string) {
+		now := time.Now()
+		var event messenger.AccessEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			log.Printf("Ignoring malformed access event on %s: %v", topic, err)
+			return
+		}
+		if err := a.store.recordAccess(context.Background(), loc.state.locationID, topic, payload, event, now); err != nil {
+			log.Printf("Failed to persist access event: %v", err)
+		}
+		a.publishActivity(loc, eventGateAccess, topic, payload, event.Username, event.Code, now)
+		a.observe(loc, alerting.ObserveAccess, event.Result, event.Code, now)
+		a.metrics.access.Inc(loc.state.locationID, event.Result, event.Reason)
+	}); err != nil {
+		log.Printf("Failed to subscribe to gate access events: %v", err)
+	}
+
+	if err := loc.mqtt.SubscribePigateAlert(func(topic, payload string) {
+		now := time.Now()
+		if err := a.store.recordEvent(context.Background(), loc.state.locationID, eventGateAlert, topic, payload, "", "", now); err != nil {
+			log.Printf("Failed to persist gate alert: %v", err)
+		}
+		a.publishActivity(loc, eventGateAlert, topic, payload, "", "", now)
+	}); err != nil {
+		log.Printf("Failed to subscribe to gate alerts: %v", err)
+	}
+
+	if err := loc.mqtt.SubscribePigateDuress(func(topic, payload string) {
+		var event messenger.DuressEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			log.Printf("Ignoring malformed duress event on %s: %v", topic, err)
+			return
+		}
+		a.notifyDuress(loc, event)
+	}); err != nil {
+		log.Printf("Failed to subscribe to duress events: %v", err)
+	}
+
+	if err := loc.mqtt.SubscribePigatePresence(func(topic, presence string) {
+		now := time.Now()
+		loc.state.setDevicePresence(presence, now)
+		if err := a.store.recordDevicePresence(context.Background(), loc.state.locationID, topic, presence, now); err != nil {
+			log.Printf("Failed to persist device presence: %v", err)
+		}
+		a.publishActivity(loc, eventDevicePresence, topic, presence, "", "", now)
+		a.observe(loc, alerting.ObservePresence, presence, "", now)
+	}); err != nil {
+		log.Printf("Failed to subscribe to device presence: %v", err)
+	}
+
+	if err := loc.mqtt.SubscribeCredentialSync(func(topic, result string) {
+		now := time.Now()
+		if err := a.store.recordEvent(context.Background(), loc.state.locationID, eventCredentialSync, topic, result, "", "", now); err != nil {
+			log.Printf("Failed to persist credential sync result: %v", err)
+		}
+		a.publishActivity(loc, eventCredentialSync, topic, result, "", "", now)
+		a.observe(loc, alerting.ObserveCredentialSync, result, "", now)
+		a.metrics.syncs.Inc(loc.state.locationID, result)
+	}); err != nil {
+		log.Printf("Failed to subscribe to credential sync results: %v", err)
+	}
+
+	if err := loc.mqtt.SubscribePigateHeartbeat(func(topic, payload string) {
+		var heartbeat messenger.Heartbeat
+		if err := json.Unmarshal([]byte(payload), &heartbeat); err != nil || heartbeat.DeviceID == "" {
+			log.Printf("Ignoring malformed heartbeat on %s", topic)
+			return
+		}
+		a.recordHeartbeat(loc, heartbeat)
+	}); err != nil {
+		log.Printf("Failed to subscribe to device heartbeats: %v", err)
+	}
 }
 
 func (a *app) handleStatus(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, a.state.snapshot(a.mqtt.IsConnected(), a.store.health(r.Context())))
+	loc, err := a.locationFor(r.URL.Query().Get("location"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, a.currentSnapshot(loc))
 }
 
 type commandRequest struct {
-	Command string `json:"command"`
+	Location string `json:"location,omitempty"`
+	Command  string `json:"command"`
+	Operator string `json:"operator,omitempty"`
 }
 
 type commandResponse struct {
-	OK      bool   `json:"ok"`
-	Command string `json:"command"`
+	OK       bool   `json:"ok"`
+	Location string `json:"location"`
+	Command  string `json:"command"`
 }
 
 func (a *app) handleCommand(w http.ResponseWriter, r *http.Request) {
@@ -440,6 +724,12 @@ func (a *app) handleCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	loc, err := a.locationFor(req.Location)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	command, mqttCommand, err := normalizeCommand(req.Command)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
@@ -448,11 +738,11 @@ func (a *app) handleCommand(w http.ResponseWriter, r *http.Request) {
 
 	switch command {
 	case "open":
-		err = a.mqtt.CommandOpen()
+		err = loc.mqtt.CommandOpen()
 	case "lock_open":
-		err = a.mqtt.CommandLockOpen()
+		err = loc.mqtt.CommandLockOpen()
 	case "close":
-		err = a.mqtt.CommandClose()
+		err = loc.mqtt.CommandClose()
 	default:
 		err = fmt.Errorf("unsupported command %q", command)
 	}
@@ -461,19 +751,26 @@ func (a *app) handleCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.metrics.commands.Inc(loc.state.locationID, commandSourceWeb, mqttCommand)
 	now := time.Now()
-	a.state.setCommand(command, now)
-	topic := fmt.Sprintf(messenger.TopicPigateCommand, a.state.locationID)
-	if err := a.store.recordCommand(r.Context(), a.state.locationID, topic, mqttCommand, now); err != nil {
+	loc.state.setCommand(command, now)
+	topic := fmt.Sprintf(messenger.TopicPigateCommand, loc.state.locationID)
+	operator := requestOperator(r, req.Operator)
+	if err := a.store.recordCommand(r.Context(), loc.state.locationID, topic, mqttCommand, operator, now); err != nil {
 		log.Printf("Failed to persist command: %v", err)
 	}
+	a.publishActivity(loc, eventGateCommand, topic, mqttCommand, operator, "", now)
+
+	writeJSON(w, http.StatusOK, commandResponse{OK: true, Location: loc.state.locationID, Command: command})
+}
 
-	writeJSON(w, http.StatusOK, commandResponse{OK: true, Command: command})
+type healthResponse struct {
+	Status string `json:"status"`
+	version.Info
 }
 
 func (a *app) handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte("ok\n"))
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok", Info: version.Get()})
 }
 
 func normalizeCommand(command string) (uiCommand string, mqttCommand string, err error) {
@@ -489,6 +786,16 @@ func normalizeCommand(command string) (uiCommand string, mqttCommand string, err
 	}
 }
 
+// requestOperator identifies who issued a command. The page has no login, so
+// the Tailscale identity header wins when the page sits behind `tailscale
+// serve`, otherwise the name typed into the page is used.
+func requestOperator(r *http.Request, fallback string) string {
+	if login := strings.TrimSpace(r.Header.Get("Tailscale-User-Login")); login != "" {
+		return login
+	}
+	return strings.TrimSpace(fallback)
+}
+
 func writeJSON(w http.ResponseWriter, status int, body interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
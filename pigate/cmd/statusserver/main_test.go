@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var (
+	schemaTable  = regexp.MustCompile(`(?:CREATE TABLE IF NOT EXISTS|ALTER TABLE|\sON)\s+(\w+)`)
+	schemaCreate = regexp.MustCompile(`^\s*CREATE TABLE`)
+)
+
+func TestStatusSchemaCreatesTablesFirst(t *testing.T) {
+	created := make(map[string]bool)
+	for i, query := range statusSchema {
+		m := schemaTable.FindStringSubmatch(query)
+		if m == nil {
+			t.Fatalf("statement %d names no table: %s", i, query)
+		}
+		if schemaCreate.MatchString(query) {
+			created[m[1]] = true
+			continue
+		}
+		if !created[m[1]] {
+			t.Errorf("statement %d uses %s before it is created", i, m[1])
+		}
+	}
+}
+
+// TestInitSchemaOnEmptyDatabase runs against the Postgres server in
+// PIGATE_TEST_POSTGRES, in a schema of its own that it drops afterwards.
+func TestInitSchemaOnEmptyDatabase(t *testing.T) {
+	dsn := os.Getenv("PIGATE_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("PIGATE_TEST_POSTGRES is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+	// One connection, so that the search path holds for every statement.
+	db.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	schema := fmt.Sprintf("pigate_test_%d", time.Now().UnixNano())
+	if _, err := db.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	defer db.ExecContext(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+	if _, err := db.ExecContext(ctx, "SET search_path TO "+schema); err != nil {
+		t.Fatalf("set search path: %v", err)
+	}
+
+	store := &statusStore{db: db}
+	for i := 0; i < 2; i++ {
+		if err := store.initSchema(ctx); err != nil {
+			t.Fatalf("initSchema() run %d error = %v", i+1, err)
+		}
+	}
+	for table, column := range map[string]string{
+		"pigate_status_events": "code",
+		"pigate_locations":     "enabled",
+		"pigate_status_latest": "device_presence_at",
+	} {
+		var n int
+		err := db.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM information_schema.columns
+			WHERE table_schema = $1 AND table_name = $2 AND column_name = $3`,
+			schema, table, column).Scan(&n)
+		if err != nil || n != 1 {
+			t.Errorf("column %s.%s: found %d (%v), want 1", table, column, n, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+
+	"pigate/pkg/messenger"
+	"pigate/pkg/metrics"
+)
+
+// commandSourceWeb labels commands sent from the status page.
+const commandSourceWeb = "web"
+
+// gateStates are the values reported by pigate_location_gate_state.
+var gateStates = []string{messenger.StatusOpened, messenger.StatusLockedOpen, messenger.StatusClosed, "unknown"}
+
+// serverMetrics is what the status server sees of every followed Location.
+type serverMetrics struct {
+	registry     *metrics.Registry
+	messenger    *metrics.MessengerMetrics
+	access       *metrics.Counter
+	commands     *metrics.Counter
+	syncs        *metrics.Counter
+	gateState    *metrics.Gauge
+	deviceOnline *metrics.Gauge
+}
+
+func newServerMetrics() *serverMetrics {
+	registry := metrics.NewRegistry()
+	return &serverMetrics{
+		registry:     registry,
+		messenger:    metrics.NewMessengerMetrics(registry),
+		access:       registry.Counter("pigate_location_access_total", "Access events received, by Location, result and denial reason.", "location", "result", "reason"),
+		commands:     registry.Counter("pigate_location_commands_total", "Gate commands published, by Location, source and command.", "location", "source", "command"),
+		syncs:        registry.Counter("pigate_location_credential_syncs_total", "Credential sync results reported by Devices, by Location and result.", "location", "result"),
+		gateState:    registry.Gauge("pigate_location_gate_state", "1 for the last reported gate state of a Location, 0 for the others.", "location", "state"),
+		deviceOnline: registry.Gauge("pigate_location_device_online", "1 when the Location's Device is online.", "location"),
+	}
+}
+
+// registerGauges adds the gauges read from app state on every scrape.
+func (m *serverMetrics) registerGauges(a *app) {
+	m.registry.GaugeFunc("pigate_alerts_firing", "Alerts currently firing across all Locations.", func() float64 {
+		return float64(len(a.alerts.Active()))
+	})
+	m.registry.GaugeFunc("pigate_db_up", "1 when the last Postgres health check succeeded.", func() float64 {
+		return boolValue(a.health.get().connected)
+	})
+	m.registry.GaugeFunc("pigate_mqtt_up", "1 when connected to the MQTT broker.", func() float64 {
+		return boolValue(a.mqtt.IsConnected())
+	})
+}
+
+// handleMetrics refreshes the per-Location gauges and serves the registry.
+func (a *app) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	for _, loc := range a.locations.all() {
+		snapshot := a.currentSnapshot(loc)
+		for _, state := range gateStates {
+			a.metrics.gateState.Set(boolValue(snapshot.GateStatus == state), loc.state.locationID, state)
+		}
+		a.metrics.deviceOnline.Set(boolValue(snapshot.DevicePresence == messenger.PresenceOnline), loc.state.locationID)
+	}
+	a.metrics.registry.Handler().ServeHTTP(w, r)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
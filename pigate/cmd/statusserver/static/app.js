@@ -1,13 +1,27 @@
 const state = {
   pending: false,
+  historyCursor: "",
+  pollTimer: null,
+  live: false,
+  locationId: new URLSearchParams(window.location.search).get("location") || "",
+  locations: new Map(),
+  devices: [],
+  rollouts: [],
 };
 
 const els = {
+  title: document.querySelector("#title"),
   location: document.querySelector("#location"),
+  overview: document.querySelector("#overview"),
+  locationGrid: document.querySelector("#locationGrid"),
+  detail: document.querySelector("#detail"),
+  backLink: document.querySelector("#backLink"),
+  deviceBadge: document.querySelector("#deviceBadge"),
   mqttBadge: document.querySelector("#mqttBadge"),
   dbBadge: document.querySelector("#dbBadge"),
   gateState: document.querySelector("#gateState"),
   gateTime: document.querySelector("#gateTime"),
+  gatePosition: document.querySelector("#gatePosition"),
   credentialStatus: document.querySelector("#credentialStatus"),
   credentialTime: document.querySelector("#credentialTime"),
   lastCommand: document.querySelector("#lastCommand"),
@@ -15,6 +29,15 @@ const els = {
   serverTime: document.querySelector("#serverTime"),
   notice: document.querySelector("#notice"),
   buttons: Array.from(document.querySelectorAll("[data-command]")),
+  operator: document.querySelector("#operator"),
+  historyFilters: document.querySelector("#historyFilters"),
+  historyRows: document.querySelector("#historyRows"),
+  historyMore: document.querySelector("#historyMore"),
+  driftBadge: document.querySelector("#driftBadge"),
+  rolloutForm: document.querySelector("#rolloutForm"),
+  canaryDevice: document.querySelector("#canaryDevice"),
+  rolloutStatus: document.querySelector("#rolloutStatus"),
+  deviceRows: document.querySelector("#deviceRows"),
 };
 
 const statusLabels = {
@@ -24,6 +47,14 @@ const statusLabels = {
   unknown: "Unknown",
 };
 
+const positionLabels = {
+  opening: "Opening",
+  open: "Open",
+  closing: "Closing",
+  closed: "Closed",
+  fault: "Fault",
+};
+
 const commandLabels = {
   open: "Open",
   lock_open: "Lock Open",
@@ -31,6 +62,26 @@ const commandLabels = {
   close: "Close",
 };
 
+const eventLabels = {
+  gate_status: "Gate status",
+  gate_position: "Gate position",
+  gate_command: "Command",
+  gate_access: "Keypad access",
+  gate_alert: "Gate alert",
+  credential_status: "Credentials",
+  device_presence: "Device",
+  credential_sync: "Credential sync",
+  alert: "Alert",
+  desired_version: "Desired version",
+  rollout: "Rollout",
+};
+
+const rolloutLabels = {
+  canary: "Installing on canary",
+  promoted: "Promoted to all devices",
+  failed: "Failed on canary",
+};
+
 function labelFor(value, labels) {
   return labels[value] || titleCase(value || "unknown");
 }
@@ -72,21 +123,40 @@ function setPending(pending) {
   });
 }
 
-function renderStatus(data) {
-  els.location.textContent = data.location_id || "Unknown location";
+function locationLabel(data) {
+  return data.location_name || data.location_id || "Unknown location";
+}
+
+function isOverview() {
+  return !state.locationId && state.locations.size > 1;
+}
+
+function renderServices(data) {
+  const presence = data.device_presence || "unknown";
+  setBadge(els.deviceBadge, presence === "online" ? "Device Online" : `Device ${labelFor(presence, {})}`, presence === "online");
   setBadge(els.mqttBadge, data.mqtt_connected ? "MQTT Online" : "MQTT Offline", data.mqtt_connected);
   setBadge(els.dbBadge, data.db_connected ? "Postgres Online" : "Postgres Offline", data.db_connected);
+}
+
+function renderStatus(data) {
+  els.location.textContent = locationLabel(data);
+  renderServices(data);
 
   const gateStatus = data.gate_status || "unknown";
   els.gateState.textContent = labelFor(gateStatus, statusLabels);
   els.gateTime.textContent = data.gate_status_at ? `Updated ${formatTime(data.gate_status_at)}` : "No status yet";
+  // Only gates with limit switches report where they are.
+  els.gatePosition.hidden = !data.gate_position;
+  els.gatePosition.textContent = data.gate_position ? `Position: ${labelFor(data.gate_position, positionLabels)}` : "";
+  els.gatePosition.classList.toggle("fault", data.gate_position === "fault");
 
   els.credentialStatus.textContent = labelFor(data.credential_status, {});
   els.credentialTime.textContent = data.credential_status_at ? `Updated ${formatTime(data.credential_status_at)}` : "No update yet";
 
   els.lastCommand.textContent = data.last_command ? labelFor(data.last_command, commandLabels) : "None";
   els.lastCommandTime.textContent = data.last_command_at ? `Sent ${formatTime(data.last_command_at)}` : "No command yet";
-  els.serverTime.textContent = data.server_time ? `Refreshed ${formatTime(data.server_time)}` : "Waiting for status";
+  const source = state.live ? "Live" : "Refreshed";
+  els.serverTime.textContent = data.server_time ? `${source} ${formatTime(data.server_time)}` : "Waiting for status";
 
   document.body.classList.remove("gate-open", "gate-locked-open", "gate-closed", "gate-unknown");
   if (gateStatus === "opened") document.body.classList.add("gate-open");
@@ -95,13 +165,84 @@ function renderStatus(data) {
   else document.body.classList.add("gate-unknown");
 }
 
+function overviewCard(data) {
+  const gateStatus = data.gate_status || "unknown";
+  const presence = data.device_presence || "unknown";
+  const card = document.createElement("a");
+  card.className = `panel location-card gate-${gateStatus.replaceAll("_", "-")}`;
+  card.href = `?location=${encodeURIComponent(data.location_id)}`;
+
+  const label = document.createElement("div");
+  label.className = "panel-label";
+  label.textContent = locationLabel(data);
+
+  const gate = document.createElement("div");
+  gate.className = "metric location-gate";
+  gate.textContent = labelFor(gateStatus, statusLabels);
+
+  const device = document.createElement("span");
+  device.className = `badge ${presence === "online" ? "ok" : "bad"}`;
+  device.textContent = presence === "online" ? "Device Online" : `Device ${labelFor(presence, {})}`;
+
+  const time = document.createElement("div");
+  time.className = "timestamp";
+  time.textContent = data.gate_status_at ? `Updated ${formatTime(data.gate_status_at)}` : "No status yet";
+
+  card.append(label, gate, time, device);
+  if (data.gate_position === "fault") {
+    const fault = document.createElement("span");
+    fault.className = "badge bad";
+    fault.textContent = "Gate fault";
+    card.append(fault);
+  }
+  const drifted = state.devices.filter((d) => d.location_id === data.location_id && d.drift).length;
+  if (drifted) {
+    const drift = document.createElement("span");
+    drift.className = "badge bad";
+    drift.textContent = `${drifted} behind desired version`;
+    card.append(drift);
+  }
+  return card;
+}
+
+function renderOverview() {
+  const locations = Array.from(state.locations.values());
+  els.title.textContent = "Locations";
+  els.location.textContent = `${locations.length} locations`;
+  if (locations.length) renderServices(locations[0]);
+  els.locationGrid.replaceChildren(...locations.map(overviewCard));
+  const times = locations.map((data) => data.server_time).filter(Boolean).sort();
+  const source = state.live ? "Live" : "Refreshed";
+  els.serverTime.textContent = times.length ? `${source} ${formatTime(times[times.length - 1])}` : "Waiting for status";
+}
+
+function render() {
+  const overview = isOverview();
+  els.overview.hidden = !overview;
+  els.detail.hidden = overview;
+  els.backLink.hidden = overview || state.locations.size < 2;
+  if (overview) {
+    renderOverview();
+    return;
+  }
+  els.title.textContent = "Status";
+  const data = state.locationId ? state.locations.get(state.locationId) : state.locations.values().next().value;
+  if (data) renderStatus(data);
+  else if (state.locationId) setNotice(`Unknown location ${state.locationId}`, true);
+}
+
+function applySnapshot(data) {
+  state.locations.set(data.location_id, data);
+  if (!state.pending) setNotice(data.db_error ? data.db_error : "");
+}
+
 async function refreshStatus() {
   try {
-    const response = await fetch("/api/status", { cache: "no-store" });
+    const response = await fetch("/api/locations", { cache: "no-store" });
     if (!response.ok) throw new Error("Status request failed");
-    const data = await response.json();
-    renderStatus(data);
-    if (!state.pending) setNotice(data.db_error ? data.db_error : "");
+    const body = await response.json();
+    body.locations.forEach(applySnapshot);
+    render();
   } catch (error) {
     setNotice(error.message, true);
   }
@@ -114,12 +255,15 @@ async function sendCommand(command) {
     const response = await fetch("/api/command", {
       method: "POST",
       headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({ command }),
+      body: JSON.stringify({ location: state.locationId, command, operator: els.operator.value.trim() }),
     });
     const body = await response.json().catch(() => ({}));
     if (!response.ok) throw new Error(body.error || "Command failed");
     setNotice(`${labelFor(body.command, commandLabels)} sent`);
-    await refreshStatus();
+    if (!state.live) {
+      await refreshStatus();
+      await loadHistory();
+    }
   } catch (error) {
     setNotice(error.message, true);
   } finally {
@@ -127,9 +271,339 @@ async function sendCommand(command) {
   }
 }
 
+function eventDetail(event) {
+  switch (event.event_type) {
+    case "gate_status":
+      return labelFor(event.payload, statusLabels);
+    case "gate_position":
+      return labelFor(event.payload, positionLabels);
+    case "gate_command":
+      return labelFor(event.payload, commandLabels);
+    case "gate_access": {
+      let access = {};
+      try {
+        access = JSON.parse(event.payload);
+      } catch (error) {
+        return event.payload;
+      }
+      if (access.result === "granted") return "Granted";
+      return access.reason ? `Denied (${labelFor(access.reason, {})})` : "Denied";
+    }
+    case "gate_alert": {
+      let alert = {};
+      try {
+        alert = JSON.parse(event.payload);
+      } catch (error) {
+        return event.payload;
+      }
+      if (alert.type === "keypad_lockout") {
+        return `${alert.source} locked out until ${formatTime(alert.until)} after ${alert.failures} failed codes`;
+      }
+      if (alert.type === "gate_forced_open") return "Gate forced open";
+      if (alert.type === "gate_failed_to_open") return "Gate did not open";
+      if (alert.type === "gate_failed_to_close") return "Gate did not close";
+      if (alert.type === "gate_sensor_fault") return "Both limit switches made";
+      return labelFor(alert.type, {});
+    }
+    case "credential_sync":
+      return event.payload === "synced" ? "Synced" : "Sync failed";
+    case "desired_version": {
+      let change = {};
+      try {
+        change = JSON.parse(event.payload);
+      } catch (error) {
+        return event.payload;
+      }
+      return change.version ? `${change.device_id} → ${change.version}` : `${change.device_id} cleared`;
+    }
+    case "rollout": {
+      let rollout = {};
+      try {
+        rollout = JSON.parse(event.payload);
+      } catch (error) {
+        return event.payload;
+      }
+      return `${rollout.version}: ${labelFor(rollout.status, rolloutLabels)} (${rollout.canary_device})`;
+    }
+    case "alert": {
+      let alert = {};
+      try {
+        alert = JSON.parse(event.payload);
+      } catch (error) {
+        return event.payload;
+      }
+      const summary = alert.alert ? alert.alert.summary : "";
+      return alert.status === "resolved" ? `Resolved: ${summary}` : summary;
+    }
+    default:
+      return labelFor(event.payload, {});
+  }
+}
+
+function deviceRow(device) {
+  const row = document.createElement("tr");
+  const location = state.locations.get(device.location_id);
+  const lastSeen = device.last_seen ? formatTime(device.last_seen) : "Never";
+  let update = labelFor(device.update_status || "unknown", {});
+  if (device.failed_version) update += ` (rolled back ${device.failed_version})`;
+  [
+    device.device_id,
+    location ? locationLabel(location) : device.location_id,
+    device.current_version || "Unknown",
+    null,
+    update,
+    lastSeen,
+  ].forEach((text, index) => {
+    const cell = document.createElement("td");
+    if (index === 3) {
+      cell.appendChild(desiredVersionField(device));
+    } else {
+      cell.textContent = text;
+    }
+    if (index === 2 && device.drift) cell.classList.add("drift");
+    if (index === 4 && device.update_error) {
+      cell.title = device.update_error;
+      cell.classList.add("denied");
+    }
+    row.appendChild(cell);
+  });
+  return row;
+}
+
+function desiredVersionField(device) {
+  const form = document.createElement("form");
+  form.className = "desired-version";
+  const input = document.createElement("input");
+  input.value = device.desired_version || "";
+  input.placeholder = "None";
+  input.setAttribute("aria-label", `Desired version for ${device.device_id}`);
+  const button = document.createElement("button");
+  button.className = "filter-button";
+  button.type = "submit";
+  button.textContent = "Set";
+  form.append(input, button);
+  form.addEventListener("submit", (event) => {
+    event.preventDefault();
+    setDesiredVersion(device.device_id, input.value.trim());
+  });
+  return form;
+}
+
+function renderDevices() {
+  const devices = state.locationId ? state.devices.filter((d) => d.location_id === state.locationId) : state.devices;
+  els.deviceRows.replaceChildren(...devices.map(deviceRow));
+  if (!devices.length) {
+    els.deviceRows.innerHTML = '<tr><td colspan="6" class="empty">No devices have checked in</td></tr>';
+  }
+
+  const drifted = devices.filter((d) => d.drift).length;
+  els.driftBadge.hidden = !devices.length;
+  setBadge(els.driftBadge, drifted ? `${drifted} behind desired version` : "All devices current", !drifted);
+
+  els.rolloutForm.hidden = isOverview() || !devices.length;
+  const selected = els.canaryDevice.value;
+  els.canaryDevice.replaceChildren(
+    ...devices.map((d) => {
+      const option = document.createElement("option");
+      option.value = d.device_id;
+      option.textContent = d.device_id;
+      return option;
+    }),
+  );
+  if (devices.some((d) => d.device_id === selected)) els.canaryDevice.value = selected;
+
+  const locationId = state.locationId || (devices[0] && devices[0].location_id);
+  const rollout = state.rollouts.find((r) => r.location_id === locationId);
+  els.rolloutStatus.hidden = isOverview() || !rollout;
+  if (rollout) {
+    els.rolloutStatus.textContent = `Rollout ${rollout.version}: ${labelFor(rollout.status, rolloutLabels)} (canary ${rollout.canary_device}, ${formatTime(rollout.updated_at)})`;
+  }
+}
+
+async function loadDevices() {
+  const params = new URLSearchParams();
+  if (state.locationId) params.set("location", state.locationId);
+  try {
+    const response = await fetch(`/api/devices?${params}`, { cache: "no-store" });
+    const body = await response.json().catch(() => ({}));
+    if (!response.ok) throw new Error(body.error || "Device request failed");
+    state.devices = body.devices;
+    state.rollouts = body.rollouts;
+    // Leave the table alone while a desired version is being typed.
+    if (!els.deviceRows.contains(document.activeElement)) renderDevices();
+    if (isOverview()) renderOverview();
+  } catch (error) {
+    els.deviceRows.innerHTML = '<tr><td colspan="6" class="empty"></td></tr>';
+    els.deviceRows.querySelector(".empty").textContent = error.message;
+  }
+}
+
+async function setDesiredVersion(deviceId, version) {
+  try {
+    const response = await fetch(`/api/devices/${encodeURIComponent(deviceId)}/desired-version`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ version, operator: els.operator.value.trim() }),
+    });
+    const body = await response.json().catch(() => ({}));
+    if (!response.ok) throw new Error(body.error || "Update failed");
+    setNotice(version ? `${deviceId} will update to ${version}` : `Cleared desired version for ${deviceId}`);
+    await loadDevices();
+  } catch (error) {
+    setNotice(error.message, true);
+  }
+}
+
+async function startRollout(version, canaryDevice) {
+  try {
+    const response = await fetch("/api/rollouts", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({
+        location: state.locationId,
+        version,
+        canary_device: canaryDevice,
+        operator: els.operator.value.trim(),
+      }),
+    });
+    const body = await response.json().catch(() => ({}));
+    if (!response.ok) throw new Error(body.error || "Rollout failed");
+    setNotice(`Rolling out ${body.version} to ${body.canary_device} first`);
+    els.rolloutForm.reset();
+    await loadDevices();
+  } catch (error) {
+    setNotice(error.message, true);
+  }
+}
+
+function historyQuery() {
+  const params = new URLSearchParams();
+  if (state.locationId) params.set("location", state.locationId);
+  const form = new FormData(els.historyFilters);
+  for (const [key, value] of form.entries()) {
+    const text = String(value).trim();
+    if (!text) continue;
+    if (key === "since" || key === "until") {
+      params.set(key, new Date(text).toISOString());
+    } else {
+      params.set(key, text);
+    }
+  }
+  return params;
+}
+
+function historyRow(event) {
+  const row = document.createElement("tr");
+  const detail = eventDetail(event);
+  const location = state.locations.get(event.location_id);
+  [
+    formatTime(event.created_at),
+    location ? locationLabel(location) : event.location_id,
+    labelFor(event.event_type, eventLabels),
+    detail,
+    event.operator || "",
+    event.code || "",
+  ].forEach((text, index) => {
+    const cell = document.createElement("td");
+    cell.textContent = text;
+    if (index === 3 && detail.startsWith("Denied")) cell.classList.add("denied");
+    row.appendChild(cell);
+  });
+  return row;
+}
+
+async function loadHistory(append = false) {
+  const params = historyQuery();
+  if (append && state.historyCursor) params.set("cursor", state.historyCursor);
+  try {
+    const response = await fetch(`/api/events?${params}`, { cache: "no-store" });
+    const body = await response.json().catch(() => ({}));
+    if (!response.ok) throw new Error(body.error || "History request failed");
+    if (!append) els.historyRows.replaceChildren();
+    body.events.forEach((event) => els.historyRows.appendChild(historyRow(event)));
+    if (!els.historyRows.children.length) {
+      els.historyRows.innerHTML = '<tr><td colspan="6" class="empty">No matching events</td></tr>';
+    }
+    state.historyCursor = body.next_cursor || "";
+    els.historyMore.hidden = !state.historyCursor;
+  } catch (error) {
+    setNotice(error.message, true);
+  }
+}
+
+function historyFiltered() {
+  return Array.from(new FormData(els.historyFilters).values()).some((value) => String(value).trim());
+}
+
+function prependActivity(event) {
+  if (historyFiltered()) return;
+  if (state.locationId && event.location_id !== state.locationId) return;
+  const empty = els.historyRows.querySelector(".empty");
+  if (empty) empty.closest("tr").remove();
+  els.historyRows.prepend(historyRow(event));
+}
+
+function startPolling() {
+  if (state.pollTimer) return;
+  refreshStatus();
+  state.pollTimer = setInterval(refreshStatus, 3000);
+}
+
+function stopPolling() {
+  clearInterval(state.pollTimer);
+  state.pollTimer = null;
+}
+
+// connectStream receives pushed updates from /api/stream. While the stream is
+// down, EventSource keeps reconnecting and the page polls /api/status instead.
+function connectStream() {
+  if (!window.EventSource) {
+    startPolling();
+    return;
+  }
+  const source = new EventSource("/api/stream");
+  source.addEventListener("open", () => {
+    state.live = true;
+    stopPolling();
+  });
+  source.addEventListener("error", () => {
+    state.live = false;
+    startPolling();
+  });
+  source.addEventListener("status", (message) => {
+    applySnapshot(JSON.parse(message.data));
+    render();
+  });
+  source.addEventListener("activity", (message) => {
+    const event = JSON.parse(message.data);
+    prependActivity(event);
+    if (event.event_type === "desired_version" || event.event_type === "rollout") loadDevices();
+  });
+}
+
 els.buttons.forEach((button) => {
   button.addEventListener("click", () => sendCommand(button.dataset.command));
 });
 
-refreshStatus();
-setInterval(refreshStatus, 3000);
+els.operator.value = localStorage.getItem("pigate.operator") || "";
+els.operator.addEventListener("change", () => {
+  localStorage.setItem("pigate.operator", els.operator.value.trim());
+});
+
+els.historyFilters.addEventListener("submit", (event) => {
+  event.preventDefault();
+  loadHistory();
+});
+els.historyMore.addEventListener("click", () => loadHistory(true));
+
+els.rolloutForm.addEventListener("submit", (event) => {
+  event.preventDefault();
+  const form = new FormData(els.rolloutForm);
+  startRollout(String(form.get("version")).trim(), String(form.get("canary_device")));
+});
+
+startPolling();
+loadHistory();
+loadDevices();
+setInterval(loadDevices, 30000);
+connectStream();
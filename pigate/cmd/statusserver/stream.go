@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	streamBuffer       = 32
+	streamKeepalive    = 20 * time.Second
+	healthPollInterval = 10 * time.Second
+)
+
+// Server-Sent Event names sent on /api/stream.
+const (
+	streamEventStatus   = "status"   // full statusSnapshot of one location
+	streamEventActivity = "activity" // one statusEvent, as stored in history
+)
+
+type streamMessage struct {
+	event string
+	data  []byte
+}
+
+// streamHub fans state changes out to every connected browser.
+type streamHub struct {
+	mu          sync.Mutex
+	subscribers map[chan streamMessage]struct{}
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{subscribers: make(map[chan streamMessage]struct{})}
+}
+
+func (h *streamHub) subscribe() chan streamMessage {
+	ch := make(chan streamMessage, streamBuffer)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *streamHub) unsubscribe(ch chan streamMessage) {
+	h.mu.Lock()
+	delete(h.subscribers, ch)
+	h.mu.Unlock()
+}
+
+// broadcast never blocks; a subscriber that falls behind misses messages and
+// catches up on the next status snapshot.
+func (h *streamHub) broadcast(event string, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Failed to encode %s stream event: %v", event, err)
+		return
+	}
+	msg := streamMessage{event: event, data: data}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// healthMonitor pings Postgres on a fixed interval so page loads and open
+// streams share one health check instead of each running their own.
+type healthMonitor struct {
+	mu     sync.RWMutex
+	health dbHealth
+}
+
+func (m *healthMonitor) get() dbHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.health
+}
+
+func (m *healthMonitor) set(health dbHealth) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := m.health != health
+	m.health = health
+	return changed
+}
+
+// monitorHealth refreshes the cached database health and pushes a status
+// update when it or the MQTT connection changes.
+func (a *app) monitorHealth(ctx context.Context) {
+	mqttConnected := a.mqtt.IsConnected()
+	a.health.set(a.store.health(ctx))
+
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed := a.health.set(a.store.health(ctx))
+			if connected := a.mqtt.IsConnected(); connected != mqttConnected {
+				mqttConnected = connected
+				changed = true
+			}
+			if changed {
+				for _, loc := range a.locations.all() {
+					a.broadcastStatus(loc)
+				}
+			}
+		}
+	}
+}
+
+func (a *app) currentSnapshot(loc *gateLocation) statusSnapshot {
+	return loc.state.snapshot(a.mqtt.IsConnected(), a.health.get())
+}
+
+func (a *app) broadcastStatus(loc *gateLocation) {
+	a.hub.broadcast(streamEventStatus, a.currentSnapshot(loc))
+}
+
+// publishActivity pushes a newly recorded event and the resulting status to
+// connected browsers.
+func (a *app) publishActivity(loc *gateLocation, eventType, topic, payload, operator, code string, at time.Time) {
+	a.hub.broadcast(streamEventActivity, statusEvent{
+		LocationID: loc.state.locationID,
+		EventType:  eventType,
+		Topic:      topic,
+		Payload:    payload,
+		Operator:   operator,
+		Code:       code,
+		CreatedAt:  at,
+	})
+	a.broadcastStatus(loc)
+}
+
+func (a *app) handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	ch := a.hub.subscribe()
+	defer a.hub.unsubscribe(ch)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, loc := range a.locations.all() {
+		initial, err := json.Marshal(a.currentSnapshot(loc))
+		if err != nil {
+			log.Printf("Failed to encode status snapshot: %v", err)
+			return
+		}
+		if err := writeStreamMessage(w, streamMessage{event: streamEventStatus, data: initial}); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-ch:
+			if err := writeStreamMessage(w, msg); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeStreamMessage(w http.ResponseWriter, msg streamMessage) error {
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.event, msg.data)
+	return err
+}
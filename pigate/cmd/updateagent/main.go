@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"pigate/pkg/config"
+	"pigate/pkg/control"
+	"pigate/pkg/database"
+	"pigate/pkg/update"
+	"pigate/pkg/version"
+)
+
+const application string = "updateagent"
+
+// updateLeaseTTL bounds how long the gate stays closed for a swap and restart.
+const updateLeaseTTL = 2 * time.Minute
+
+func main() {
+	var configFilePath string
+	var once bool
+	flag.StringVar(&configFilePath, "c", "/workspace/pigate/pkg/config",
+		"Path to the configuration file")
+	var showVersion bool
+	flag.BoolVar(&once, "once", false, "Check for an update once and exit")
+	flag.BoolVar(&showVersion, "version", false, "Print the version and exit")
+	flag.Parse()
+	if showVersion {
+		fmt.Println(application, version.Get())
+		return
+	}
+	log.Printf("Starting updateagent %s", version.Get())
+
+	cfg := config.LoadConfig(configFilePath, application+"-config").(*config.UpdateAgentConfig)
+	if cfg.Device_ID == "" {
+		log.Fatal("DEVICE_ID is required")
+	}
+	publicKey, err := update.ParsePublicKey(cfg.ReleasePublicKey)
+	if err != nil {
+		log.Fatalf("Invalid RELEASE_PUBLIC_KEY: %v", err)
+	}
+	interval := time.Duration(cfg.CheckIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	log.Printf("Loaded updateagent configuration for device %s at location %s", cfg.Device_ID, cfg.Location_ID)
+
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		cfg.DB.Host, cfg.DB.Port, cfg.DB.User, cfg.DB.Password, cfg.DB.Name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	registry, err := database.NewPostgresDeviceRegistry(ctx, connStr)
+	cancel()
+	if err != nil {
+		log.Fatalf("Failed to open device registry: %v", err)
+	}
+	defer registry.Close()
+
+	ctl := control.NewClient(cfg.ControlSocket)
+
+	agent := &update.Agent{
+		DeviceID:   cfg.Device_ID,
+		LocationID: cfg.Location_ID,
+		Store:      registry,
+		Feed:       update.NewFeed(cfg.ReleaseFeedURL, cfg.ReleaseToken),
+		AssetName:  cfg.ReleaseAsset,
+		PublicKey:  publicKey,
+		BinaryPath: cfg.BinaryPath,
+		Restarter:  update.SystemdRestarter{Unit: cfg.ServiceName},
+		EnterSafeState: func(ctx context.Context) (func(), error) {
+			return acquireLease(ctx, ctl)
+		},
+		CheckHealth: func(ctx context.Context) error {
+			return checkHealth(ctx, ctl)
+		},
+		HealthWindow: time.Duration(cfg.HealthWindowSeconds) * time.Second,
+	}
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+		if err := agent.Check(ctx); err != nil {
+			log.Printf("Update check failed: %v", err)
+		}
+		cancel()
+		if once {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+// checkHealth asks the restarted gatecontroller whether its startup checks
+// passed. An unreachable socket counts as unhealthy, since a binary that
+// fails to start never opens it.
+func checkHealth(ctx context.Context, ctl *control.Client) error {
+	health, err := ctl.Health(ctx)
+	if err != nil {
+		return err
+	}
+	if health.Healthy {
+		return nil
+	}
+	var failing []string
+	for _, check := range control.StartupChecks {
+		if result := health.Checks[check]; result != "ok" {
+			failing = append(failing, check+": "+result)
+		}
+	}
+	return fmt.Errorf("startup checks not passed: %s", strings.Join(failing, ", "))
+}
+
+// acquireLease asks the gatecontroller to hold the gate closed while the
+// binary is swapped. The lease ends with the restart, or when release is
+// called after a failed swap.
+func acquireLease(ctx context.Context, ctl *control.Client) (func(), error) {
+	lease, err := ctl.AcquireUpdateLease(ctx, updateLeaseTTL)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Holding update lease %s until %s", lease.ID, lease.ExpiresAt.Format(time.RFC3339))
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := ctl.ReleaseUpdateLease(ctx, lease.ID); err != nil {
+			log.Printf("Failed to release update lease: %v", err)
+		}
+	}, nil
+}
@@ -0,0 +1,430 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ObservationKind says which stream an Observation came from.
+type ObservationKind string
+
+const (
+	ObserveGateStatus     ObservationKind = "gate_status"     // Value: opened, locked_open, closed
+	ObserveGatePosition   ObservationKind = "gate_position"   // Value: opening, open, closing, closed, fault
+	ObservePresence       ObservationKind = "presence"        // Value: online, offline
+	ObserveCredentialSync ObservationKind = "credential_sync" // Value: synced, sync_failed
+	ObserveAccess         ObservationKind = "access"          // Value: granted, denied; Code set
+)
+
+// Values understood by the built-in rules.
+const (
+	GateOpened     = "opened"
+	GateLockedOpen = "locked_open"
+	GateFault      = "fault"
+	DeviceOffline  = "offline"
+	SyncSucceeded  = "synced"
+	AccessDenied   = "denied"
+)
+
+// Observation is one status or event message seen for a Location.
+type Observation struct {
+	LocationID string
+	Kind       ObservationKind
+	Value      string
+	Code       string
+	At         time.Time
+}
+
+type Severity string
+
+const (
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Alert is a condition that currently holds for a Location.
+type Alert struct {
+	Rule       string    `json:"rule"`
+	LocationID string    `json:"location_id"`
+	Severity   Severity  `json:"severity"`
+	Summary    string    `json:"summary"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+func (a Alert) key() string {
+	return a.Rule + "\x00" + a.LocationID
+}
+
+type NotificationStatus string
+
+const (
+	StatusFiring   NotificationStatus = "firing"
+	StatusResolved NotificationStatus = "resolved"
+)
+
+// Notification is sent once when an Alert starts and once when it resolves.
+type Notification struct {
+	Status     NotificationStatus `json:"status"`
+	Alert      Alert              `json:"alert"`
+	ResolvedAt *time.Time         `json:"resolved_at,omitempty"`
+}
+
+// Notifier delivers notifications to people.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, n Notification) error
+}
+
+// LocationState is what the engine knows about one Location. Rules read it to
+// decide whether their condition holds.
+type LocationState struct {
+	LocationID     string
+	GateStatus     string
+	GateStatusAt   time.Time
+	GatePosition   string // empty for gates without limit switches
+	GatePositionAt time.Time
+	Presence       string
+	PresenceAt     time.Time
+	LastSyncResult string
+	LastSyncAt     time.Time
+	LastSyncOKAt   time.Time
+	FirstSeenAt    time.Time
+	Denials        []Observation // denied access attempts, oldest first
+}
+
+// Rule evaluates one condition for one Location. It returns the alert summary
+// and true while the condition holds.
+type Rule interface {
+	Name() string
+	Severity() Severity
+	Evaluate(state *LocationState, now time.Time) (string, bool)
+}
+
+// Engine tracks Location state, evaluates rules and notifies on changes.
+// Alerts are deduplicated per rule and Location: a firing alert is sent once
+// and a resolved notification follows when the condition clears.
+type Engine struct {
+	rules     []Rule
+	notifiers []Notifier
+
+	mu        sync.Mutex
+	locations map[string]*LocationState
+	active    map[string]Alert
+	pruneAge  time.Duration
+
+	wake chan struct{}
+}
+
+func NewEngine(rules []Rule, notifiers []Notifier) *Engine {
+	e := &Engine{
+		rules:     rules,
+		notifiers: notifiers,
+		locations: make(map[string]*LocationState),
+		active:    make(map[string]Alert),
+		wake:      make(chan struct{}, 1),
+	}
+	for _, rule := range rules {
+		if w, ok := rule.(interface{ window() time.Duration }); ok && w.window() > e.pruneAge {
+			e.pruneAge = w.window()
+		}
+	}
+	return e
+}
+
+// Observe records an observation. Evaluation happens on the Run goroutine, so
+// Observe is cheap enough to call from MQTT handlers.
+func (e *Engine) Observe(o Observation) {
+	e.mu.Lock()
+	state := e.location(o.LocationID, o.At)
+	switch o.Kind {
+	case ObserveGateStatus:
+		if state.GateStatus != o.Value {
+			state.GateStatus = o.Value
+			state.GateStatusAt = o.At
+		}
+	case ObserveGatePosition:
+		if state.GatePosition != o.Value {
+			state.GatePosition = o.Value
+			state.GatePositionAt = o.At
+		}
+	case ObservePresence:
+		if state.Presence != o.Value {
+			state.Presence = o.Value
+			state.PresenceAt = o.At
+		}
+	case ObserveCredentialSync:
+		state.LastSyncResult = o.Value
+		state.LastSyncAt = o.At
+		if o.Value == SyncSucceeded {
+			state.LastSyncOKAt = o.At
+		}
+	case ObserveAccess:
+		if o.Value == AccessDenied {
+			state.Denials = append(state.Denials, o)
+		}
+	}
+	e.mu.Unlock()
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Track makes the engine evaluate a Location before anything has been
+// observed for it, so silence from a Device can raise alerts.
+func (e *Engine) Track(locationID string, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.location(locationID, now)
+}
+
+func (e *Engine) location(locationID string, at time.Time) *LocationState {
+	state, ok := e.locations[locationID]
+	if !ok {
+		state = &LocationState{LocationID: locationID, FirstSeenAt: at}
+		e.locations[locationID] = state
+	}
+	return state
+}
+
+// Evaluate runs every rule against every Location and returns the
+// notifications for alerts that started or resolved.
+func (e *Engine) Evaluate(now time.Time) []Notification {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var notifications []Notification
+	for _, state := range e.locations {
+		state.pruneDenials(now, e.pruneAge)
+		for _, rule := range e.rules {
+			summary, firing := rule.Evaluate(state, now)
+			alert := Alert{
+				Rule:       rule.Name(),
+				LocationID: state.LocationID,
+				Severity:   rule.Severity(),
+				Summary:    summary,
+				StartedAt:  now,
+			}
+			existing, active := e.active[alert.key()]
+			switch {
+			case firing && !active:
+				e.active[alert.key()] = alert
+				notifications = append(notifications, Notification{Status: StatusFiring, Alert: alert})
+			case !firing && active:
+				delete(e.active, alert.key())
+				resolvedAt := now
+				notifications = append(notifications, Notification{Status: StatusResolved, Alert: existing, ResolvedAt: &resolvedAt})
+			}
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].Alert.key() < notifications[j].Alert.key()
+	})
+	return notifications
+}
+
+// Active returns the alerts that are currently firing.
+func (e *Engine) Active() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	alerts := make([]Alert, 0, len(e.active))
+	for _, alert := range e.active {
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].StartedAt.Before(alerts[j].StartedAt) })
+	return alerts
+}
+
+// Dispatch sends n to every notifier, logging failures.
+func (e *Engine) Dispatch(ctx context.Context, n Notification) {
+	for _, notifier := range e.notifiers {
+		nctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+		if err := notifier.Notify(nctx, n); err != nil {
+			log.Printf("Alert notifier %s failed for %s/%s: %v", notifier.Name(), n.Alert.Rule, n.Alert.LocationID, err)
+		}
+		cancel()
+	}
+}
+
+// Run evaluates rules every interval and after each observation until ctx is
+// done. onNotify, if set, is called for each notification before dispatch.
+func (e *Engine) Run(ctx context.Context, interval time.Duration, onNotify func(Notification)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-e.wake:
+		}
+		for _, n := range e.Evaluate(time.Now()) {
+			log.Printf("Alert %s: %s", n.Status, n.Alert.Summary)
+			if onNotify != nil {
+				onNotify(n)
+			}
+			e.Dispatch(ctx, n)
+		}
+	}
+}
+
+func (s *LocationState) pruneDenials(now time.Time, maxAge time.Duration) {
+	cutoff := now.Add(-maxAge)
+	i := 0
+	for i < len(s.Denials) && s.Denials[i].At.Before(cutoff) {
+		i++
+	}
+	s.Denials = s.Denials[i:]
+}
+
+// GateHeldOpen fires when the gate has reported "opened" for longer than Limit.
+type GateHeldOpen struct {
+	Limit time.Duration
+}
+
+func (r GateHeldOpen) Name() string       { return "gate_held_open" }
+func (r GateHeldOpen) Severity() Severity { return SeverityWarning }
+
+func (r GateHeldOpen) Evaluate(s *LocationState, now time.Time) (string, bool) {
+	if s.GateStatus != GateOpened || now.Sub(s.GateStatusAt) < r.Limit {
+		return "", false
+	}
+	return fmt.Sprintf("Gate at %s has been open for %s", s.LocationID, now.Sub(s.GateStatusAt).Round(time.Minute)), true
+}
+
+// GatePositionFault fires while the gate reports a fault: forced open, not
+// reaching open or closed in time, or limit switches that disagree.
+type GatePositionFault struct{}
+
+func (r GatePositionFault) Name() string       { return "gate_fault" }
+func (r GatePositionFault) Severity() Severity { return SeverityCritical }
+
+func (r GatePositionFault) Evaluate(s *LocationState, now time.Time) (string, bool) {
+	if s.GatePosition != GateFault {
+		return "", false
+	}
+	return fmt.Sprintf("Gate at %s has reported a fault since %s", s.LocationID, s.GatePositionAt.Format(time.RFC3339)), true
+}
+
+// LockedOpenAfterHours fires while the gate is locked open outside business
+// hours. Start and End are times of day; End before Start spans midnight.
+type LockedOpenAfterHours struct {
+	Start    time.Duration // offset from midnight
+	End      time.Duration
+	Location *time.Location
+}
+
+func (r LockedOpenAfterHours) Name() string       { return "locked_open_after_hours" }
+func (r LockedOpenAfterHours) Severity() Severity { return SeverityWarning }
+
+func (r LockedOpenAfterHours) Evaluate(s *LocationState, now time.Time) (string, bool) {
+	if s.GateStatus != GateLockedOpen || r.withinBusinessHours(now) {
+		return "", false
+	}
+	return fmt.Sprintf("Gate at %s is locked open outside business hours", s.LocationID), true
+}
+
+func (r LockedOpenAfterHours) withinBusinessHours(now time.Time) bool {
+	if r.Location != nil {
+		now = now.In(r.Location)
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	if r.Start <= r.End {
+		return offset >= r.Start && offset < r.End
+	}
+	return offset >= r.Start || offset < r.End
+}
+
+// ParseTimeOfDay parses "15:04" into an offset from midnight.
+func ParseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("time of day %q must be HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// DeviceOfflineRule fires when the Device has been offline for longer than
+// Grace, or has never reported within Grace of the engine tracking it.
+type DeviceOfflineRule struct {
+	Grace time.Duration
+}
+
+func (r DeviceOfflineRule) Name() string       { return "device_offline" }
+func (r DeviceOfflineRule) Severity() Severity { return SeverityCritical }
+
+func (r DeviceOfflineRule) Evaluate(s *LocationState, now time.Time) (string, bool) {
+	switch s.Presence {
+	case DeviceOffline:
+		if now.Sub(s.PresenceAt) >= r.Grace {
+			return fmt.Sprintf("Device at %s has been offline since %s", s.LocationID, s.PresenceAt.Format(time.RFC3339)), true
+		}
+	case "":
+		if now.Sub(s.FirstSeenAt) >= r.Grace {
+			return fmt.Sprintf("Device at %s has not reported since %s", s.LocationID, s.FirstSeenAt.Format(time.RFC3339)), true
+		}
+	}
+	return "", false
+}
+
+// CredentialSyncStale fires when the last credential sync failed or the last
+// successful sync is older than MaxAge.
+type CredentialSyncStale struct {
+	MaxAge time.Duration
+}
+
+func (r CredentialSyncStale) Name() string       { return "credential_sync_stale" }
+func (r CredentialSyncStale) Severity() Severity { return SeverityWarning }
+
+func (r CredentialSyncStale) Evaluate(s *LocationState, now time.Time) (string, bool) {
+	if s.LastSyncResult != "" && s.LastSyncResult != SyncSucceeded {
+		return fmt.Sprintf("Credential sync at %s failed at %s", s.LocationID, s.LastSyncAt.Format(time.RFC3339)), true
+	}
+	last := s.LastSyncOKAt
+	if last.IsZero() {
+		last = s.FirstSeenAt
+	}
+	if now.Sub(last) >= r.MaxAge {
+		return fmt.Sprintf("Credentials at %s have not synced since %s", s.LocationID, last.Format(time.RFC3339)), true
+	}
+	return "", false
+}
+
+// RepeatedDenials fires when at least Threshold denied attempts happen within
+// Window at one Location.
+type RepeatedDenials struct {
+	Threshold int
+	Window    time.Duration
+}
+
+func (r RepeatedDenials) Name() string          { return "repeated_denials" }
+func (r RepeatedDenials) Severity() Severity    { return SeverityWarning }
+func (r RepeatedDenials) window() time.Duration { return r.Window }
+
+func (r RepeatedDenials) Evaluate(s *LocationState, now time.Time) (string, bool) {
+	cutoff := now.Add(-r.Window)
+	var codes []string
+	seen := make(map[string]struct{})
+	count := 0
+	for _, denial := range s.Denials {
+		if denial.At.Before(cutoff) {
+			continue
+		}
+		count++
+		if _, dup := seen[denial.Code]; !dup && denial.Code != "" {
+			seen[denial.Code] = struct{}{}
+			codes = append(codes, denial.Code)
+		}
+	}
+	if count < r.Threshold {
+		return "", false
+	}
+	return fmt.Sprintf("%d denied codes at %s in the last %s (%s)", count, s.LocationID, r.Window, strings.Join(codes, ", ")), true
+}
@@ -0,0 +1,262 @@
+package alerting_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"pigate/pkg/alerting"
+)
+
+var start = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestGateHeldOpenFiresOnceAndResolves(t *testing.T) {
+	engine := alerting.NewEngine([]alerting.Rule{alerting.GateHeldOpen{Limit: 10 * time.Minute}}, nil)
+	engine.Observe(alerting.Observation{LocationID: "loc", Kind: alerting.ObserveGateStatus, Value: "opened", At: start})
+
+	if got := engine.Evaluate(start.Add(5 * time.Minute)); len(got) != 0 {
+		t.Fatalf("Evaluate() before limit = %v, want none", got)
+	}
+
+	got := engine.Evaluate(start.Add(11 * time.Minute))
+	if len(got) != 1 || got[0].Status != alerting.StatusFiring || got[0].Alert.Rule != "gate_held_open" {
+		t.Fatalf("Evaluate() after limit = %+v, want one firing gate_held_open", got)
+	}
+
+	// Still open: deduplicated.
+	if got := engine.Evaluate(start.Add(30 * time.Minute)); len(got) != 0 {
+		t.Fatalf("Evaluate() while firing = %v, want none", got)
+	}
+	if active := engine.Active(); len(active) != 1 {
+		t.Fatalf("Active() = %v, want one alert", active)
+	}
+
+	engine.Observe(alerting.Observation{LocationID: "loc", Kind: alerting.ObserveGateStatus, Value: "closed", At: start.Add(31 * time.Minute)})
+	got = engine.Evaluate(start.Add(31 * time.Minute))
+	if len(got) != 1 || got[0].Status != alerting.StatusResolved || got[0].ResolvedAt == nil {
+		t.Fatalf("Evaluate() after close = %+v, want one resolved", got)
+	}
+	if active := engine.Active(); len(active) != 0 {
+		t.Fatalf("Active() after resolve = %v, want none", active)
+	}
+}
+
+func TestLockedOpenAfterHours(t *testing.T) {
+	open, _ := alerting.ParseTimeOfDay("07:00")
+	closeAt, _ := alerting.ParseTimeOfDay("19:00")
+	rule := alerting.LockedOpenAfterHours{Start: open, End: closeAt, Location: time.UTC}
+	state := &alerting.LocationState{LocationID: "loc", GateStatus: "locked_open"}
+
+	if _, firing := rule.Evaluate(state, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)); firing {
+		t.Error("locked open at noon should not fire")
+	}
+	if _, firing := rule.Evaluate(state, time.Date(2024, 6, 1, 22, 0, 0, 0, time.UTC)); !firing {
+		t.Error("locked open at 22:00 should fire")
+	}
+	state.GateStatus = "closed"
+	if _, firing := rule.Evaluate(state, time.Date(2024, 6, 1, 22, 0, 0, 0, time.UTC)); firing {
+		t.Error("closed gate should not fire")
+	}
+}
+
+func TestGatePositionFault(t *testing.T) {
+	engine := alerting.NewEngine([]alerting.Rule{alerting.GatePositionFault{}}, nil)
+	engine.Observe(alerting.Observation{LocationID: "loc", Kind: alerting.ObserveGatePosition, Value: "closing", At: start})
+	if got := engine.Evaluate(start); len(got) != 0 {
+		t.Fatalf("Evaluate() while closing = %v, want none", got)
+	}
+
+	engine.Observe(alerting.Observation{LocationID: "loc", Kind: alerting.ObserveGatePosition, Value: "fault", At: start.Add(time.Minute)})
+	got := engine.Evaluate(start.Add(time.Minute))
+	if len(got) != 1 || got[0].Status != alerting.StatusFiring || got[0].Alert.Severity != alerting.SeverityCritical {
+		t.Fatalf("Evaluate() on fault = %+v, want one critical firing", got)
+	}
+
+	engine.Observe(alerting.Observation{LocationID: "loc", Kind: alerting.ObserveGatePosition, Value: "closed", At: start.Add(2 * time.Minute)})
+	got = engine.Evaluate(start.Add(2 * time.Minute))
+	if len(got) != 1 || got[0].Status != alerting.StatusResolved {
+		t.Fatalf("Evaluate() once closed = %+v, want resolved", got)
+	}
+}
+
+func TestDeviceOffline(t *testing.T) {
+	engine := alerting.NewEngine([]alerting.Rule{alerting.DeviceOfflineRule{Grace: 5 * time.Minute}}, nil)
+	engine.Track("silent", start)
+	engine.Observe(alerting.Observation{LocationID: "loc", Kind: alerting.ObservePresence, Value: "online", At: start})
+	engine.Observe(alerting.Observation{LocationID: "loc", Kind: alerting.ObservePresence, Value: "offline", At: start.Add(time.Minute)})
+
+	got := engine.Evaluate(start.Add(7 * time.Minute))
+	if len(got) != 2 {
+		t.Fatalf("Evaluate() = %+v, want offline and never-reported alerts", got)
+	}
+
+	engine.Observe(alerting.Observation{LocationID: "loc", Kind: alerting.ObservePresence, Value: "online", At: start.Add(8 * time.Minute)})
+	got = engine.Evaluate(start.Add(8 * time.Minute))
+	if len(got) != 1 || got[0].Status != alerting.StatusResolved || got[0].Alert.LocationID != "loc" {
+		t.Fatalf("Evaluate() after reconnect = %+v, want loc resolved", got)
+	}
+}
+
+func TestCredentialSyncStale(t *testing.T) {
+	rule := alerting.CredentialSyncStale{MaxAge: 26 * time.Hour}
+	state := &alerting.LocationState{LocationID: "loc", FirstSeenAt: start, LastSyncResult: "synced", LastSyncOKAt: start}
+
+	if _, firing := rule.Evaluate(state, start.Add(time.Hour)); firing {
+		t.Error("fresh sync should not fire")
+	}
+	if _, firing := rule.Evaluate(state, start.Add(27*time.Hour)); !firing {
+		t.Error("stale sync should fire")
+	}
+	state.LastSyncResult = "sync_failed"
+	state.LastSyncAt = start.Add(2 * time.Hour)
+	if _, firing := rule.Evaluate(state, start.Add(2*time.Hour)); !firing {
+		t.Error("failed sync should fire")
+	}
+}
+
+func TestRepeatedDenials(t *testing.T) {
+	engine := alerting.NewEngine([]alerting.Rule{alerting.RepeatedDenials{Threshold: 3, Window: 5 * time.Minute}}, nil)
+	for i, code := range []string{"11111", "22222", "11111"} {
+		engine.Observe(alerting.Observation{
+			LocationID: "loc",
+			Kind:       alerting.ObserveAccess,
+			Value:      "denied",
+			Code:       code,
+			At:         start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	engine.Observe(alerting.Observation{LocationID: "loc", Kind: alerting.ObserveAccess, Value: "granted", Code: "33333", At: start})
+
+	got := engine.Evaluate(start.Add(3 * time.Minute))
+	if len(got) != 1 || got[0].Status != alerting.StatusFiring {
+		t.Fatalf("Evaluate() = %+v, want one firing", got)
+	}
+	if !strings.Contains(got[0].Alert.Summary, "11111, 22222") {
+		t.Errorf("summary %q should list the denied codes", got[0].Alert.Summary)
+	}
+
+	got = engine.Evaluate(start.Add(10 * time.Minute))
+	if len(got) != 1 || got[0].Status != alerting.StatusResolved {
+		t.Fatalf("Evaluate() after window = %+v, want resolved", got)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	received := make(chan alerting.Notification, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n alerting.Notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("decode webhook body: %v", err)
+		}
+		received <- n
+	}))
+	defer server.Close()
+
+	notifier := alerting.NewWebhookNotifier(server.URL)
+	n := alerting.Notification{Status: alerting.StatusFiring, Alert: alerting.Alert{Rule: "gate_held_open", LocationID: "loc", Summary: "open"}}
+	if err := notifier.Notify(context.Background(), n); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	got := <-received
+	if got.Alert.Rule != "gate_held_open" || got.Status != alerting.StatusFiring {
+		t.Errorf("webhook received %+v", got)
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, messages := startSMTPSink(t)
+	notifier := &alerting.SMTPNotifier{
+		Addr: addr,
+		From: "pigate@example.com",
+		To:   []string{"ops@example.com"},
+	}
+	resolvedAt := start.Add(time.Hour)
+	n := alerting.Notification{
+		Status:     alerting.StatusResolved,
+		Alert:      alerting.Alert{Rule: "device_offline", LocationID: "loc", Summary: "Device at loc has been offline", StartedAt: start},
+		ResolvedAt: &resolvedAt,
+	}
+	if err := notifier.Notify(context.Background(), n); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	select {
+	case msg := <-messages:
+		if !strings.Contains(msg, "Subject: [PiGate] RESOLVED: Device at loc has been offline") {
+			t.Errorf("message missing subject:\n%s", msg)
+		}
+		if !strings.Contains(msg, "Resolved: ") {
+			t.Errorf("message missing resolved time:\n%s", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP sink received no message")
+	}
+}
+
+// startSMTPSink runs a minimal SMTP server that accepts one message at a time
+// and sends each DATA section on the returned channel.
+func startSMTPSink(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 sink ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 sink")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			messages <- data.String()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// WebhookNotifier POSTs each notification as JSON to URL.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *WebhookNotifier) Name() string { return "webhook" }
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("encode notification: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// SMTPNotifier emails each notification. Username is optional; without it
+// the message is sent unauthenticated, which suits a local relay or sink.
+type SMTPNotifier struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTPNotifier) Name() string { return "smtp" }
+
+func (s *SMTPNotifier) Notify(ctx context.Context, n Notification) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return fmt.Errorf("smtp address %q: %w", s.Addr, err)
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.Addr, auth, s.From, s.To, s.message(n))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *SMTPNotifier) message(n Notification) []byte {
+	subject := fmt.Sprintf("[PiGate] %s: %s", strings.ToUpper(string(n.Status)), n.Alert.Summary)
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\r\n\r\n", n.Alert.Summary)
+	fmt.Fprintf(&body, "Location: %s\r\n", n.Alert.LocationID)
+	fmt.Fprintf(&body, "Rule: %s\r\n", n.Alert.Rule)
+	fmt.Fprintf(&body, "Severity: %s\r\n", n.Alert.Severity)
+	fmt.Fprintf(&body, "Started: %s\r\n", n.Alert.StartedAt.Format(time.RFC1123Z))
+	if n.ResolvedAt != nil {
+		fmt.Fprintf(&body, "Resolved: %s\r\n", n.ResolvedAt.Format(time.RFC1123Z))
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(body.String())
+	return msg.Bytes()
+}
@@ -34,19 +34,182 @@ type GateControllerConfig struct {
 	MQTT             MQTTConfig
 	MQTTBroker       string
 	Location_ID      string
+	Device_ID        string // reported in heartbeats; defaults to the hostname
 	Remote_DB_Table  string
 	GateOpenDuration int
+	CardPINTimeout   int  // seconds a card that requires a PIN waits for it
+	DuressLastDigit  bool // a PIN with its last digit incremented is a duress code
 	RelayPin         int
+	GPIODriver       string // gpio driver for the relay and LED; defaults to gpiocdev
+	GPIOChip         string // GPIO character device; defaults to gpiochip0
+	GPIODrive        string // push_pull, open_drain or open_source
+	RelayActiveLow   bool
+	LEDActiveLow     bool
+	Relays           RelayConfig
+	Keypad           KeypadConfig
+	OSDP             OSDPConfig
+	Feedback         FeedbackConfig
+	Throttle         ThrottleConfig
+	Sensors          GateSensorConfig
+	Exit             ExitConfig
+	AntiPassback     AntiPassbackConfig
 	LocalDBPath      string
+	MetricsPort      int    // serves /metrics on 127.0.0.1; 0 disables
+	ControlSocket    string // Unix socket for the local control API; empty disables
 	DB               DBConfig
 }
 
+// KeypadConfig describes the Wiegand keypad wiring and code format.
+type KeypadConfig struct {
+	GPIODriver    string // defaults to the relay's driver
+	GPIOChip      string // defaults to the relay's chip
+	D0Pin         int
+	D1Pin         int
+	FrameBits     int // 4, or 8 for key plus complement
+	CodeLength    int // 0: variable length, ended with #
+	KeyTimeoutMs  int
+	CodeTimeoutMs int
+	CardFormats   []string // card formats read on the same bus, e.g. H10301
+	ExitD0Pin     int      // a second keypad at the exit, on the same settings; 0 when not wired
+	ExitD1Pin     int
+}
+
+// OSDPConfig describes an OSDP reader on an RS-485 serial line. Codes and
+// cards it reports use the keypad's code length, timeout and card formats.
+type OSDPConfig struct {
+	Device         string // serial device, e.g. /dev/ttyUSB0; empty disables
+	Baud           int
+	Address        int
+	SCBK           string // hex secure channel key; empty for a plain channel
+	PollIntervalMs int
+	Direction      string // entry or exit
+}
+
+// FeedbackConfig describes the reader's LED and beeper lines and the
+// patterns shown on them and on an OSDP reader. Patterns are parsed with
+// gate.ParsePattern; empty ones keep the defaults.
+type FeedbackConfig struct {
+	GreenPin   int // BCM pins on the relay's chip; 0 when not wired
+	RedPin     int
+	BeeperPin  int
+	ActiveLow  bool
+	Granted    string
+	Denied     string
+	LockedOpen string
+	Idle       string
+}
+
+// RelayConfig describes how the relays drive the gate operator: hold, pulse
+// or three_button. The further relays share the open relay's chip and active
+// level; pins of 0 are not wired.
+type RelayConfig struct {
+	Mode        string
+	PulseMs     int
+	ClosePin    int // three-button operators' close input
+	StopPin     int
+	HoldOpenPin int // made while the gate is locked open
+}
+
+// ThrottleConfig limits failed codes per keypad or reader before it is
+// locked out. Each lockout in a row doubles, up to MaxLockoutSeconds.
+type ThrottleConfig struct {
+	MaxFailures       int // 0 disables throttling
+	WindowSeconds     int
+	LockoutSeconds    int
+	MaxLockoutSeconds int
+}
+
+// GateSensorConfig describes the gate's limit switches and safety input, on
+// the relay's chip. Pins of 0 are not wired.
+type GateSensorConfig struct {
+	OpenLimitPin         int
+	ClosedLimitPin       int
+	SafetyPin            int // safety loop or photo-eye
+	ActiveLow            bool
+	TravelTimeoutSeconds int // longest a full open or close may take
+	DebounceMs           int
+}
+
+// ExitConfig describes the request-to-exit button and free-exit vehicle loop,
+// on the relay's chip. Pins of 0 are not wired.
+type ExitConfig struct {
+	REXPin             int
+	LoopPin            int
+	ActiveLow          bool
+	DebounceMs         int
+	SuppressInLockdown bool // exits open nothing while the facility is in lockdown
+}
+
+// AntiPassbackConfig enables anti-passback between the entry and exit
+// readers: off, soft (log only) or hard (deny).
+type AntiPassbackConfig struct {
+	Mode         string
+	ResetMinutes int // a credential seen longer ago may enter again; 0 never resets
+}
+
+// GateSimConfig configures the gate simulator. It takes the gate controller
+// settings plus the simulator's own.
+type GateSimConfig struct {
+	GateControllerConfig
+	HTTPAddr          string // virtual keypad and gate view
+	GateTravelSeconds int    // time for the simulated gate to open or close fully
+}
+
 type StatusServerConfig struct {
-	MQTT        MQTTConfig
-	MQTTBroker  string
-	Location_ID string
-	HTTPAddr    string
-	DB          DBConfig
+	MQTT         MQTTConfig
+	MQTTBroker   string
+	Location_ID  string
+	Location_IDs []string // additional locations shown on the overview
+	HTTPAddr     string
+	DB           DBConfig
+	Alerts       AlertConfig
+}
+
+// UpdateAgentConfig configures the Update Agent on a Device.
+type UpdateAgentConfig struct {
+	Location_ID          string
+	Device_ID            string
+	DB                   DBConfig
+	ReleaseFeedURL       string // e.g. https://api.github.com/repos/owner/pigate
+	ReleaseToken         string
+	ReleaseAsset         string // e.g. gatecontroller-linux-arm64
+	ReleasePublicKey     string // base64 Ed25519 key that signs checksums.txt
+	BinaryPath           string
+	ServiceName          string
+	ControlSocket        string // gatecontroller control socket used for the update lease
+	CheckIntervalMinutes int
+	HealthWindowSeconds  int // how long a new gatecontroller has to pass its startup checks
+}
+
+// PigateCtlConfig configures the pigatectl admin tool.
+type PigateCtlConfig struct {
+	MQTT         MQTTConfig
+	Location_ID  string   // default Location for gate commands, tail and resync
+	Location_IDs []string // additional Locations resync and tail cover by default
+	DB           DBConfig
+}
+
+// AlertConfig configures the status server alert rules. A zero threshold
+// disables its rule.
+type AlertConfig struct {
+	GateOpenMinutes      int
+	BusinessHoursStart   string // "07:00"; locked open outside these hours alerts
+	BusinessHoursEnd     string
+	DeviceOfflineMinutes int
+	SyncStaleHours       int
+	DeniedThreshold      int
+	DeniedWindowMinutes  int
+	GateFault            bool // alert while a gate reports a position fault
+	WebhookURL           string
+	SMTP                 SMTPConfig
+}
+
+type SMTPConfig struct {
+	Addr     string // host:port; empty disables email
+	Username string
+	Password string
+	From     string
+	To       []string
 }
 
 func LoadConfig(configPath, component string) interface{} {
@@ -91,7 +254,7 @@ func LoadConfig(configPath, component string) interface{} {
 				Password: dbPassword,
 			},
 		}
-	case "gatecontroller-config":
+	case "gatecontroller-config", "gatesim-config":
 		// Get the env var name from config, then get its value from the environment
 		DB_PASSWORD_ENV := v.GetString("DB_PASSWORD_ENV")
 		dbPassword := ""
@@ -103,13 +266,115 @@ func LoadConfig(configPath, component string) interface{} {
 		if MQTT_PASSWORD_ENV != "" {
 			mqttPassword = os.Getenv(MQTT_PASSWORD_ENV)
 		}
-		return &GateControllerConfig{
+		OSDP_SCBK_ENV := v.GetString("OSDP_SCBK_ENV")
+		osdpSCBK := ""
+		if OSDP_SCBK_ENV != "" {
+			osdpSCBK = os.Getenv(OSDP_SCBK_ENV)
+		}
+		// The keypad the gate controller was first built for.
+		v.SetDefault("KEYPAD_D0_PIN", 17)
+		v.SetDefault("KEYPAD_D1_PIN", 18)
+		v.SetDefault("KEYPAD_FRAME_BITS", 4)
+		v.SetDefault("KEYPAD_CODE_LENGTH", 5)
+		v.SetDefault("KEYPAD_KEY_TIMEOUT_MS", 100)
+		v.SetDefault("KEYPAD_CODE_TIMEOUT_MS", 3000)
+		v.SetDefault("CARD_PIN_TIMEOUT_SECONDS", 10)
+		v.SetDefault("OSDP_BAUD", 9600)
+		v.SetDefault("OSDP_POLL_INTERVAL_MS", 200)
+		v.SetDefault("READER_ACTIVE_LOW", true) // Wiegand reader inputs are pulled up
+		v.SetDefault("RELAY_MODE", "hold")
+		v.SetDefault("RELAY_PULSE_MS", 500)
+		v.SetDefault("THROTTLE_MAX_FAILURES", 5)
+		v.SetDefault("THROTTLE_WINDOW_SECONDS", 60)
+		v.SetDefault("THROTTLE_LOCKOUT_SECONDS", 30)
+		v.SetDefault("THROTTLE_MAX_LOCKOUT_SECONDS", 3600)
+		v.SetDefault("GATE_SENSOR_ACTIVE_LOW", true) // switches to ground on pulled-up inputs
+		v.SetDefault("GATE_TRAVEL_TIMEOUT_SECONDS", 30)
+		v.SetDefault("GATE_SENSOR_DEBOUNCE_MS", 50)
+		v.SetDefault("EXIT_ACTIVE_LOW", true)
+		v.SetDefault("OSDP_DIRECTION", "entry")
+		v.SetDefault("ANTI_PASSBACK_MODE", "off")
+		v.SetDefault("EXIT_DEBOUNCE_MS", 50)
+		gc := &GateControllerConfig{
 			MQTTBroker:       v.GetString("MQTT_BROKER"),
 			Location_ID:      v.GetString("LOCATION_ID"),
+			Device_ID:        v.GetString("DEVICE_ID"),
 			GateOpenDuration: v.GetInt("GATE_OPEN_DURATION"),
+			CardPINTimeout:   v.GetInt("CARD_PIN_TIMEOUT_SECONDS"),
+			DuressLastDigit:  v.GetBool("DURESS_LAST_DIGIT"),
 			RelayPin:         v.GetInt("GATE_CONTROL_PIN"),
-			LocalDBPath:      v.GetString("DATABASE_PATH"),
-			Remote_DB_Table:  v.GetString("REMOTE_DB_TABLE"),
+			GPIODriver:       v.GetString("GPIO_DRIVER"),
+			GPIOChip:         v.GetString("GPIO_CHIP"),
+			GPIODrive:        v.GetString("GPIO_DRIVE"),
+			RelayActiveLow:   v.GetBool("RELAY_ACTIVE_LOW"),
+			LEDActiveLow:     v.GetBool("LED_ACTIVE_LOW"),
+			Relays: RelayConfig{
+				Mode:        v.GetString("RELAY_MODE"),
+				PulseMs:     v.GetInt("RELAY_PULSE_MS"),
+				ClosePin:    v.GetInt("GATE_CLOSE_PIN"),
+				StopPin:     v.GetInt("GATE_STOP_PIN"),
+				HoldOpenPin: v.GetInt("GATE_HOLD_OPEN_PIN"),
+			},
+			Keypad: KeypadConfig{
+				GPIODriver:    v.GetString("KEYPAD_GPIO_DRIVER"),
+				GPIOChip:      v.GetString("KEYPAD_GPIO_CHIP"),
+				D0Pin:         v.GetInt("KEYPAD_D0_PIN"),
+				D1Pin:         v.GetInt("KEYPAD_D1_PIN"),
+				FrameBits:     v.GetInt("KEYPAD_FRAME_BITS"),
+				CodeLength:    v.GetInt("KEYPAD_CODE_LENGTH"),
+				KeyTimeoutMs:  v.GetInt("KEYPAD_KEY_TIMEOUT_MS"),
+				CodeTimeoutMs: v.GetInt("KEYPAD_CODE_TIMEOUT_MS"),
+				CardFormats:   v.GetStringSlice("KEYPAD_CARD_FORMATS"),
+				ExitD0Pin:     v.GetInt("KEYPAD_EXIT_D0_PIN"),
+				ExitD1Pin:     v.GetInt("KEYPAD_EXIT_D1_PIN"),
+			},
+			OSDP: OSDPConfig{
+				Device:         v.GetString("OSDP_DEVICE"),
+				Baud:           v.GetInt("OSDP_BAUD"),
+				Address:        v.GetInt("OSDP_ADDRESS"),
+				SCBK:           osdpSCBK,
+				PollIntervalMs: v.GetInt("OSDP_POLL_INTERVAL_MS"),
+				Direction:      v.GetString("OSDP_DIRECTION"),
+			},
+			Feedback: FeedbackConfig{
+				GreenPin:   v.GetInt("READER_GREEN_PIN"),
+				RedPin:     v.GetInt("READER_RED_PIN"),
+				BeeperPin:  v.GetInt("READER_BEEPER_PIN"),
+				ActiveLow:  v.GetBool("READER_ACTIVE_LOW"),
+				Granted:    v.GetString("FEEDBACK_GRANTED"),
+				Denied:     v.GetString("FEEDBACK_DENIED"),
+				LockedOpen: v.GetString("FEEDBACK_LOCKED_OPEN"),
+				Idle:       v.GetString("FEEDBACK_IDLE"),
+			},
+			Throttle: ThrottleConfig{
+				MaxFailures:       v.GetInt("THROTTLE_MAX_FAILURES"),
+				WindowSeconds:     v.GetInt("THROTTLE_WINDOW_SECONDS"),
+				LockoutSeconds:    v.GetInt("THROTTLE_LOCKOUT_SECONDS"),
+				MaxLockoutSeconds: v.GetInt("THROTTLE_MAX_LOCKOUT_SECONDS"),
+			},
+			Sensors: GateSensorConfig{
+				OpenLimitPin:         v.GetInt("GATE_OPEN_LIMIT_PIN"),
+				ClosedLimitPin:       v.GetInt("GATE_CLOSED_LIMIT_PIN"),
+				SafetyPin:            v.GetInt("GATE_SAFETY_PIN"),
+				ActiveLow:            v.GetBool("GATE_SENSOR_ACTIVE_LOW"),
+				TravelTimeoutSeconds: v.GetInt("GATE_TRAVEL_TIMEOUT_SECONDS"),
+				DebounceMs:           v.GetInt("GATE_SENSOR_DEBOUNCE_MS"),
+			},
+			Exit: ExitConfig{
+				REXPin:             v.GetInt("EXIT_REX_PIN"),
+				LoopPin:            v.GetInt("EXIT_LOOP_PIN"),
+				ActiveLow:          v.GetBool("EXIT_ACTIVE_LOW"),
+				DebounceMs:         v.GetInt("EXIT_DEBOUNCE_MS"),
+				SuppressInLockdown: v.GetBool("EXIT_SUPPRESS_IN_LOCKDOWN"),
+			},
+			AntiPassback: AntiPassbackConfig{
+				Mode:         v.GetString("ANTI_PASSBACK_MODE"),
+				ResetMinutes: v.GetInt("ANTI_PASSBACK_RESET_MINUTES"),
+			},
+			LocalDBPath:     v.GetString("DATABASE_PATH"),
+			MetricsPort:     v.GetInt("METRICS_PORT"),
+			ControlSocket:   v.GetString("CONTROL_SOCKET"),
+			Remote_DB_Table: v.GetString("REMOTE_DB_TABLE"),
 			MQTT: MQTTConfig{
 				Broker:   v.GetString("MQTT_BROKER"),
 				Username: v.GetString("MQTT_USERNAME"),
@@ -123,6 +388,14 @@ func LoadConfig(configPath, component string) interface{} {
 				Password: dbPassword,
 			},
 		}
+		if component == "gatesim-config" {
+			return &GateSimConfig{
+				GateControllerConfig: *gc,
+				HTTPAddr:             v.GetString("HTTP_ADDR"),
+				GateTravelSeconds:    v.GetInt("GATE_TRAVEL_SECONDS"),
+			}
+		}
+		return gc
 	case "statusserver-config":
 		DB_PASSWORD_ENV := v.GetString("DB_PASSWORD_ENV")
 		dbPassword := ""
@@ -134,10 +407,92 @@ func LoadConfig(configPath, component string) interface{} {
 		if MQTT_PASSWORD_ENV != "" {
 			mqttPassword = os.Getenv(MQTT_PASSWORD_ENV)
 		}
+		SMTP_PASSWORD_ENV := v.GetString("SMTP_PASSWORD_ENV")
+		smtpPassword := ""
+		if SMTP_PASSWORD_ENV != "" {
+			smtpPassword = os.Getenv(SMTP_PASSWORD_ENV)
+		}
 		return &StatusServerConfig{
-			MQTTBroker:  v.GetString("MQTT_BROKER"),
-			Location_ID: v.GetString("LOCATION_ID"),
-			HTTPAddr:    v.GetString("HTTP_ADDR"),
+			MQTTBroker:   v.GetString("MQTT_BROKER"),
+			Location_ID:  v.GetString("LOCATION_ID"),
+			Location_IDs: v.GetStringSlice("LOCATION_IDS"),
+			HTTPAddr:     v.GetString("HTTP_ADDR"),
+			Alerts: AlertConfig{
+				GateOpenMinutes:      v.GetInt("ALERT_GATE_OPEN_MINUTES"),
+				BusinessHoursStart:   v.GetString("ALERT_BUSINESS_HOURS_START"),
+				BusinessHoursEnd:     v.GetString("ALERT_BUSINESS_HOURS_END"),
+				DeviceOfflineMinutes: v.GetInt("ALERT_DEVICE_OFFLINE_MINUTES"),
+				SyncStaleHours:       v.GetInt("ALERT_SYNC_STALE_HOURS"),
+				DeniedThreshold:      v.GetInt("ALERT_DENIED_THRESHOLD"),
+				DeniedWindowMinutes:  v.GetInt("ALERT_DENIED_WINDOW_MINUTES"),
+				GateFault:            v.GetBool("ALERT_GATE_FAULT"),
+				WebhookURL:           v.GetString("ALERT_WEBHOOK_URL"),
+				SMTP: SMTPConfig{
+					Addr:     v.GetString("SMTP_ADDR"),
+					Username: v.GetString("SMTP_USERNAME"),
+					Password: smtpPassword,
+					From:     v.GetString("SMTP_FROM"),
+					To:       v.GetStringSlice("SMTP_TO"),
+				},
+			},
+			MQTT: MQTTConfig{
+				Broker:   v.GetString("MQTT_BROKER"),
+				Username: v.GetString("MQTT_USERNAME"),
+				Password: mqttPassword,
+			},
+			DB: DBConfig{
+				Host:     v.GetString("DB_HOST"),
+				Port:     v.GetInt("DB_PORT"),
+				Name:     v.GetString("DB_NAME"),
+				User:     v.GetString("DB_USER"),
+				Password: dbPassword,
+			},
+		}
+	case "updateagent-config":
+		DB_PASSWORD_ENV := v.GetString("DB_PASSWORD_ENV")
+		dbPassword := ""
+		if DB_PASSWORD_ENV != "" {
+			dbPassword = os.Getenv(DB_PASSWORD_ENV)
+		}
+		RELEASE_TOKEN_ENV := v.GetString("RELEASE_TOKEN_ENV")
+		releaseToken := ""
+		if RELEASE_TOKEN_ENV != "" {
+			releaseToken = os.Getenv(RELEASE_TOKEN_ENV)
+		}
+		return &UpdateAgentConfig{
+			Location_ID:          v.GetString("LOCATION_ID"),
+			Device_ID:            v.GetString("DEVICE_ID"),
+			ReleaseFeedURL:       v.GetString("RELEASE_FEED_URL"),
+			ReleaseToken:         releaseToken,
+			ReleaseAsset:         v.GetString("RELEASE_ASSET"),
+			ReleasePublicKey:     v.GetString("RELEASE_PUBLIC_KEY"),
+			BinaryPath:           v.GetString("BINARY_PATH"),
+			ServiceName:          v.GetString("SERVICE_NAME"),
+			ControlSocket:        v.GetString("CONTROL_SOCKET"),
+			CheckIntervalMinutes: v.GetInt("CHECK_INTERVAL_MINUTES"),
+			HealthWindowSeconds:  v.GetInt("HEALTH_WINDOW_SECONDS"),
+			DB: DBConfig{
+				Host:     v.GetString("DB_HOST"),
+				Port:     v.GetInt("DB_PORT"),
+				Name:     v.GetString("DB_NAME"),
+				User:     v.GetString("DB_USER"),
+				Password: dbPassword,
+			},
+		}
+	case "pigatectl-config":
+		DB_PASSWORD_ENV := v.GetString("DB_PASSWORD_ENV")
+		dbPassword := ""
+		if DB_PASSWORD_ENV != "" {
+			dbPassword = os.Getenv(DB_PASSWORD_ENV)
+		}
+		MQTT_PASSWORD_ENV := v.GetString("MQTT_PASSWORD_ENV")
+		mqttPassword := ""
+		if MQTT_PASSWORD_ENV != "" {
+			mqttPassword = os.Getenv(MQTT_PASSWORD_ENV)
+		}
+		return &PigateCtlConfig{
+			Location_ID:  v.GetString("LOCATION_ID"),
+			Location_IDs: v.GetStringSlice("LOCATION_IDS"),
 			MQTT: MQTTConfig{
 				Broker:   v.GetString("MQTT_BROKER"),
 				Username: v.GetString("MQTT_USERNAME"),
@@ -0,0 +1,107 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"pigate/pkg/gate"
+)
+
+// Client calls the control API over its Unix socket.
+type Client struct {
+	http *http.Client
+}
+
+func NewClient(socketPath string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &Client{http: &http.Client{Transport: transport, Timeout: 10 * time.Second}}
+}
+
+// State returns the gate state and any update lease.
+func (c *Client) State(ctx context.Context) (StateResponse, error) {
+	var resp StateResponse
+	err := c.do(ctx, http.MethodGet, "/state", nil, &resp)
+	return resp, err
+}
+
+// Health returns the gatecontroller's startup checks. Unlike other calls it
+// does not treat 503 as an error, so callers can see which checks are pending.
+func (c *Client) Health(ctx context.Context) (HealthResponse, error) {
+	var health HealthResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://gatecontroller/health", nil)
+	if err != nil {
+		return health, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return health, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return health, fmt.Errorf("GET /health: %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	return health, err
+}
+
+// AcquireUpdateLease asks the gatecontroller to hold the gate closed for ttl.
+func (c *Client) AcquireUpdateLease(ctx context.Context, ttl time.Duration) (gate.UpdateLease, error) {
+	var lease gate.UpdateLease
+	err := c.do(ctx, http.MethodPost, "/update-lease", LeaseRequest{TTLSeconds: int(ttl.Seconds())}, &lease)
+	return lease, err
+}
+
+func (c *Client) RenewUpdateLease(ctx context.Context, id string, ttl time.Duration) (gate.UpdateLease, error) {
+	var lease gate.UpdateLease
+	err := c.do(ctx, http.MethodPost, "/update-lease/"+url.PathEscape(id)+"/renew", LeaseRequest{TTLSeconds: int(ttl.Seconds())}, &lease)
+	return lease, err
+}
+
+func (c *Client) ReleaseUpdateLease(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/update-lease/"+url.PathEscape(id), nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	// The host is ignored; the transport always dials the socket.
+	req, err := http.NewRequestWithContext(ctx, method, "http://gatecontroller"+path, reader)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr errorResponse
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s %s: %s", method, path, apiErr.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
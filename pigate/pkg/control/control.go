@@ -0,0 +1,150 @@
+// Package control serves the gatecontroller's local control API over a Unix
+// socket. Only processes on the Device that can open the socket, such as the
+// Update Agent, can reach it.
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pigate/pkg/gate"
+)
+
+// Gate is the part of gate.GateController the control API exposes.
+type Gate interface {
+	State() gate.GateState
+	SafeToUpdate() bool
+	UpdateLease() (gate.UpdateLease, bool)
+	AcquireUpdateLease(ttl time.Duration) (gate.UpdateLease, error)
+	RenewUpdateLease(id string, ttl time.Duration) (gate.UpdateLease, error)
+	ReleaseUpdateLease(id string) error
+}
+
+var _ Gate = (*gate.GateController)(nil)
+
+// StateResponse is returned by GET /state.
+type StateResponse struct {
+	State       string            `json:"state"`
+	SafeUpdate  bool              `json:"safe_update"` // closed, idle and no lease held
+	UpdateLease *gate.UpdateLease `json:"update_lease,omitempty"`
+}
+
+// LeaseRequest is the body of POST /update-lease and its renewal.
+type LeaseRequest struct {
+	TTLSeconds int `json:"ttl_seconds"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHandler returns the control API:
+//
+//	GET    /state                    current state and lease
+//	POST   /update-lease             acquire a lease
+//	POST   /update-lease/{id}/renew  extend a lease
+//	DELETE /update-lease/{id}        release a lease
+//	GET    /health                   startup checks; 503 until all pass
+func NewHandler(g Gate, health *Health) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		resp := health.Report()
+		status := http.StatusOK
+		if !resp.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, resp)
+	})
+	mux.HandleFunc("GET /state", func(w http.ResponseWriter, r *http.Request) {
+		state := g.State()
+		resp := StateResponse{State: state.String()}
+		if lease, held := g.UpdateLease(); held {
+			resp.UpdateLease = &lease
+		}
+		resp.SafeUpdate = g.SafeToUpdate() && resp.UpdateLease == nil
+		writeJSON(w, http.StatusOK, resp)
+	})
+	mux.HandleFunc("POST /update-lease", func(w http.ResponseWriter, r *http.Request) {
+		ttl, ok := readTTL(w, r)
+		if !ok {
+			return
+		}
+		lease, err := g.AcquireUpdateLease(ttl)
+		writeLease(w, lease, err)
+	})
+	mux.HandleFunc("POST /update-lease/{id}/renew", func(w http.ResponseWriter, r *http.Request) {
+		ttl, ok := readTTL(w, r)
+		if !ok {
+			return
+		}
+		lease, err := g.RenewUpdateLease(r.PathValue("id"), ttl)
+		writeLease(w, lease, err)
+	})
+	mux.HandleFunc("DELETE /update-lease/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if err := g.ReleaseUpdateLease(r.PathValue("id")); err != nil {
+			writeJSON(w, statusFor(err), errorResponse{Error: err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// Listen creates the control socket at path, replacing a stale socket left by
+// an earlier run. The socket is only accessible to the owner and group.
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func readTTL(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	var req LeaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid lease request"})
+		return 0, false
+	}
+	return time.Duration(req.TTLSeconds) * time.Second, true
+}
+
+func writeLease(w http.ResponseWriter, lease gate.UpdateLease, err error) {
+	if err != nil {
+		writeJSON(w, statusFor(err), errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, lease)
+}
+
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, gate.ErrNotSafeToUpdate), errors.Is(err, gate.ErrLeaseHeld):
+		return http.StatusConflict
+	case errors.Is(err, gate.ErrLeaseNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
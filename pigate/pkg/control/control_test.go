@@ -0,0 +1,147 @@
+package control_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pigate/pkg/control"
+	"pigate/pkg/gate"
+)
+
+type fakeGate struct {
+	state gate.GateState
+	lease *gate.UpdateLease
+}
+
+func (f *fakeGate) State() gate.GateState { return f.state }
+
+func (f *fakeGate) SafeToUpdate() bool { return f.state == gate.Closed }
+
+func (f *fakeGate) UpdateLease() (gate.UpdateLease, bool) {
+	if f.lease == nil {
+		return gate.UpdateLease{}, false
+	}
+	return *f.lease, true
+}
+
+func (f *fakeGate) AcquireUpdateLease(ttl time.Duration) (gate.UpdateLease, error) {
+	if f.state != gate.Closed {
+		return gate.UpdateLease{}, gate.ErrNotSafeToUpdate
+	}
+	if f.lease != nil {
+		return gate.UpdateLease{}, gate.ErrLeaseHeld
+	}
+	f.lease = &gate.UpdateLease{ID: "lease-1", ExpiresAt: time.Now().Add(ttl)}
+	return *f.lease, nil
+}
+
+func (f *fakeGate) RenewUpdateLease(id string, ttl time.Duration) (gate.UpdateLease, error) {
+	if f.lease == nil || f.lease.ID != id {
+		return gate.UpdateLease{}, gate.ErrLeaseNotFound
+	}
+	f.lease.ExpiresAt = time.Now().Add(ttl)
+	return *f.lease, nil
+}
+
+func (f *fakeGate) ReleaseUpdateLease(id string) error {
+	if f.lease == nil || f.lease.ID != id {
+		return gate.ErrLeaseNotFound
+	}
+	f.lease = nil
+	return nil
+}
+
+func serve(t *testing.T, g control.Gate, health *control.Health) *control.Client {
+	t.Helper()
+	// Unix socket paths are limited to ~100 bytes, so avoid t.TempDir().
+	dir, err := os.MkdirTemp("", "pigate-ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "gatecontroller.sock")
+
+	ln, err := control.Listen(path)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	server := &http.Server{Handler: control.NewHandler(g, health)}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return control.NewClient(path)
+}
+
+func TestLeaseLifecycle(t *testing.T) {
+	g := &fakeGate{state: gate.Closed}
+	client := serve(t, g, control.NewHealth())
+	ctx := context.Background()
+
+	state, err := client.State(ctx)
+	if err != nil {
+		t.Fatalf("State() error = %v", err)
+	}
+	if state.State != "closed" || !state.SafeUpdate || state.UpdateLease != nil {
+		t.Errorf("State() = %+v, want closed and safe", state)
+	}
+
+	lease, err := client.AcquireUpdateLease(ctx, 2*time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireUpdateLease() error = %v", err)
+	}
+	if _, err := client.AcquireUpdateLease(ctx, time.Minute); err == nil || !strings.Contains(err.Error(), "another update lease") {
+		t.Errorf("second AcquireUpdateLease() error = %v", err)
+	}
+	state, _ = client.State(ctx)
+	if state.SafeUpdate || state.UpdateLease == nil || state.UpdateLease.ID != lease.ID {
+		t.Errorf("State() during lease = %+v", state)
+	}
+
+	if _, err := client.RenewUpdateLease(ctx, lease.ID, time.Minute); err != nil {
+		t.Errorf("RenewUpdateLease() error = %v", err)
+	}
+	if err := client.ReleaseUpdateLease(ctx, lease.ID); err != nil {
+		t.Fatalf("ReleaseUpdateLease() error = %v", err)
+	}
+	if err := client.ReleaseUpdateLease(ctx, lease.ID); err == nil {
+		t.Error("releasing twice should fail")
+	}
+}
+
+func TestLeaseRefusedWhileOpen(t *testing.T) {
+	client := serve(t, &fakeGate{state: gate.LockedOpen}, control.NewHealth())
+
+	state, err := client.State(context.Background())
+	if err != nil || state.State != "locked_open" || state.SafeUpdate {
+		t.Errorf("State() = %+v, %v", state, err)
+	}
+	if _, err := client.AcquireUpdateLease(context.Background(), time.Minute); err == nil {
+		t.Error("AcquireUpdateLease() succeeded while locked open")
+	}
+}
+
+func TestHealthReportsStartupChecks(t *testing.T) {
+	health := control.NewHealth(control.StartupChecks...)
+	client := serve(t, &fakeGate{state: gate.Closed}, health)
+	ctx := context.Background()
+
+	health.Pass(control.CheckConfig)
+	health.Pass(control.CheckSQLite)
+	resp, err := client.Health(ctx)
+	if err != nil {
+		t.Fatalf("Health() error = %v", err)
+	}
+	if resp.Healthy || resp.Checks[control.CheckSQLite] != "ok" || resp.Checks[control.CheckMQTT] != "pending" {
+		t.Errorf("Health() = %+v, want sqlite ok and mqtt pending", resp)
+	}
+
+	health.Pass(control.CheckKeypad)
+	health.Pass(control.CheckMQTT)
+	if resp, err := client.Health(ctx); err != nil || !resp.Healthy {
+		t.Errorf("Health() = %+v, %v; want healthy", resp, err)
+	}
+}
@@ -0,0 +1,60 @@
+package control
+
+import (
+	"sync"
+)
+
+// Startup checks the gatecontroller must pass before an update is kept.
+const (
+	CheckConfig = "config"
+	CheckSQLite = "sqlite"
+	CheckMQTT   = "mqtt"
+	CheckKeypad = "keypad"
+)
+
+// StartupChecks lists every check a healthy gatecontroller passes.
+var StartupChecks = []string{CheckConfig, CheckSQLite, CheckMQTT, CheckKeypad}
+
+// HealthResponse is returned by GET /health.
+type HealthResponse struct {
+	Healthy bool              `json:"healthy"`
+	Checks  map[string]string `json:"checks"` // check -> "ok" or "pending"
+}
+
+// Health records the gatecontroller's startup checks as they complete. A
+// failed check stops the gatecontroller, so checks are only ever passed.
+type Health struct {
+	mu     sync.Mutex
+	checks map[string]string
+}
+
+// NewHealth returns a Health with every check pending.
+func NewHealth(checks ...string) *Health {
+	h := &Health{checks: make(map[string]string, len(checks))}
+	for _, check := range checks {
+		h.checks[check] = "pending"
+	}
+	return h
+}
+
+// Pass marks check as passed.
+func (h *Health) Pass(check string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[check] = "ok"
+}
+
+// Report returns a snapshot of the checks. The gatecontroller is healthy once
+// every check has passed.
+func (h *Health) Report() HealthResponse {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	resp := HealthResponse{Healthy: true, Checks: make(map[string]string, len(h.checks))}
+	for check, result := range h.checks {
+		resp.Checks[check] = result
+		if result != "ok" {
+			resp.Healthy = false
+		}
+	}
+	return resp
+}
@@ -83,6 +83,7 @@ func ParseCredentialFile(filePath string) ([]database.Credential, error) {
 			LockedOut:   lockedOut,
 			AutoUpdate:  true, // this record comes from the external feed
 			OpenMode:    "regular_open",
+			Type:        database.CredentialPIN,
 		}
 		entries = append(entries, entry)
 	}
@@ -7,6 +7,8 @@ import (
 type GateManager interface {
 	AccessManager
 	AccessLogger
+	LockoutStore
+	PresenceStore
 	// Close closes the underlying database connection.
 	Close() error
 }
@@ -16,6 +18,9 @@ type AccessManager interface {
 	PutCredential(ctx context.Context, cred Credential) error
 	PutCredentials(ctx context.Context, creds []Credential) error
 	GetCredential(ctx context.Context, code string) (*Credential, error)
+	// GetCredentialByDuressCode returns the PIN credential whose duress code
+	// is code.
+	GetCredentialByDuressCode(ctx context.Context, code string) (*Credential, error)
 	GetCredentials(ctx context.Context) ([]Credential, error)
 	DeleteCredential(ctx context.Context, code string) error
 	DeleteCredentials(ctx context.Context, codes []string) error
@@ -31,3 +36,18 @@ type AccessLogger interface {
 	PutGateLog(ctx context.Context, log GateLog) error
 	GetGateLogs(ctx context.Context) ([]GateLog, error)
 }
+
+// LockoutStore keeps keypad lockouts across restarts of the gate controller.
+type LockoutStore interface {
+	PutLockout(ctx context.Context, lockout Lockout) error
+	GetLockouts(ctx context.Context) ([]Lockout, error)
+	DeleteLockout(ctx context.Context, source string) error
+}
+
+// PresenceStore keeps each credential's anti-passback presence across
+// restarts of the gate controller.
+type PresenceStore interface {
+	PutPresence(ctx context.Context, presence Presence) error
+	// GetPresence returns the presence of code, or nil when it has none.
+	GetPresence(ctx context.Context, code string) (*Presence, error)
+}
@@ -0,0 +1,380 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Update statuses reported by the Update Agent.
+const (
+	UpdateStatusIdle        = "idle"
+	UpdateStatusWaiting     = "waiting_safe_state"
+	UpdateStatusDownloading = "downloading"
+	UpdateStatusInstalled   = "installed"
+	UpdateStatusFailed      = "failed"
+	UpdateStatusRolledBack  = "rolled_back"
+)
+
+// Rollout statuses. A Location rollout installs on its canary Device first and
+// reaches the rest of the Location only once the canary reports it installed.
+const (
+	RolloutCanary   = "canary"
+	RolloutPromoted = "promoted"
+	RolloutFailed   = "failed"
+)
+
+// Device is a Device record in the Control Plane Store.
+type Device struct {
+	DeviceID       string
+	LocationID     string
+	DesiredVersion string
+	CurrentVersion string
+	UpdateStatus   string
+	UpdateError    string
+	FailedVersion  string // last version rolled back after failing health checks
+	LastSeen       *time.Time
+}
+
+// Drifted reports whether the Device is not running its Desired Version.
+func (d Device) Drifted() bool {
+	return d.DesiredVersion != "" && d.DesiredVersion != d.CurrentVersion
+}
+
+// Rollout is the staged rollout of one version to a Location.
+type Rollout struct {
+	LocationID     string
+	Version        string
+	CanaryDeviceID string
+	Status         string
+	UpdatedAt      time.Time
+}
+
+// outcome returns the status the rollout moves to given its canary's latest
+// report, or "" while the canary is still updating.
+func (r Rollout) outcome(canary Device) string {
+	switch {
+	case canary.FailedVersion == r.Version:
+		return RolloutFailed
+	case canary.CurrentVersion == r.Version && canary.UpdateStatus == UpdateStatusInstalled:
+		return RolloutPromoted
+	default:
+		return ""
+	}
+}
+
+// DeviceRegistry reads and writes Device records in the Control Plane Store.
+type DeviceRegistry struct {
+	db *sql.DB
+}
+
+// NewDeviceRegistry uses an already open Control Plane Store connection.
+func NewDeviceRegistry(db *sql.DB) *DeviceRegistry {
+	return &DeviceRegistry{db: db}
+}
+
+func NewPostgresDeviceRegistry(ctx context.Context, connStr string) (*DeviceRegistry, error) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	registry := &DeviceRegistry{db: db}
+	if err := registry.InitSchema(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return registry, nil
+}
+
+func (r *DeviceRegistry) InitSchema(ctx context.Context) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS pigate_devices (
+			device_id TEXT PRIMARY KEY,
+			location_id TEXT NOT NULL DEFAULT '',
+			desired_version TEXT NOT NULL DEFAULT '',
+			current_version TEXT NOT NULL DEFAULT '',
+			update_status TEXT NOT NULL DEFAULT '',
+			update_error TEXT NOT NULL DEFAULT '',
+			last_seen TIMESTAMPTZ,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`ALTER TABLE pigate_devices ADD COLUMN IF NOT EXISTS failed_version TEXT NOT NULL DEFAULT ''`,
+		`CREATE TABLE IF NOT EXISTS pigate_rollouts (
+			location_id TEXT PRIMARY KEY,
+			version TEXT NOT NULL,
+			canary_device_id TEXT NOT NULL,
+			status TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+	}
+	for _, q := range queries {
+		if _, err := r.db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *DeviceRegistry) Close() error {
+	return r.db.Close()
+}
+
+// GetDevice returns the Device record, or sql.ErrNoRows if it is not
+// registered.
+func (r *DeviceRegistry) GetDevice(ctx context.Context, deviceID string) (*Device, error) {
+	d, err := scanDevice(r.db.QueryRowContext(ctx, `
+		SELECT `+deviceColumns+`
+		FROM pigate_devices
+		WHERE device_id = $1`, deviceID))
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ListDevices returns the Devices at locationID, or every Device when
+// locationID is empty.
+func (r *DeviceRegistry) ListDevices(ctx context.Context, locationID string) ([]Device, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+deviceColumns+`
+		FROM pigate_devices
+		WHERE $1 = '' OR location_id = $1
+		ORDER BY location_id, device_id`, locationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []Device
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+const deviceColumns = `device_id, location_id, desired_version, current_version, update_status, update_error, failed_version, last_seen`
+
+func scanDevice(row interface{ Scan(...any) error }) (Device, error) {
+	var d Device
+	var lastSeen sql.NullTime
+	err := row.Scan(&d.DeviceID, &d.LocationID, &d.DesiredVersion, &d.CurrentVersion, &d.UpdateStatus, &d.UpdateError, &d.FailedVersion, &lastSeen)
+	if lastSeen.Valid {
+		d.LastSeen = &lastSeen.Time
+	}
+	return d, err
+}
+
+// RegisterDevice returns the Device record, creating it on first contact. An
+// empty DesiredVersion means no update is requested.
+func (r *DeviceRegistry) RegisterDevice(ctx context.Context, deviceID, locationID string) (*Device, error) {
+	if _, err := r.db.ExecContext(ctx, `
+		INSERT INTO pigate_devices (device_id, location_id)
+		VALUES ($1, $2)
+		ON CONFLICT (device_id) DO NOTHING`, deviceID, locationID); err != nil {
+		return nil, err
+	}
+	return r.GetDevice(ctx, deviceID)
+}
+
+// ReportVersion records the Device's Current Version and update progress.
+func (r *DeviceRegistry) ReportVersion(ctx context.Context, deviceID, currentVersion, updateStatus, updateError string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE pigate_devices
+		SET current_version = $2,
+			update_status = $3,
+			update_error = $4,
+			last_seen = now(),
+			updated_at = now()
+		WHERE device_id = $1`, deviceID, currentVersion, updateStatus, updateError)
+	return err
+}
+
+// RecordHeartbeat stores the version a Device reported running, registering
+// the Device on first contact.
+func (r *DeviceRegistry) RecordHeartbeat(ctx context.Context, deviceID, locationID, version string) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO pigate_devices (device_id, location_id, current_version, last_seen)
+		VALUES ($1, $2, $3, now())
+		ON CONFLICT (device_id) DO UPDATE SET
+			location_id = EXCLUDED.location_id,
+			current_version = EXCLUDED.current_version,
+			last_seen = now(),
+			updated_at = now()`, deviceID, locationID, version)
+	return err
+}
+
+// ReportFailedVersion records that failedVersion was rolled back, so the
+// Update Agent does not install it again until the Desired Version changes
+// or failed_version is cleared.
+func (r *DeviceRegistry) ReportFailedVersion(ctx context.Context, deviceID, currentVersion, failedVersion, reason string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE pigate_devices
+		SET current_version = $2,
+			failed_version = $3,
+			update_status = $4,
+			update_error = $5,
+			last_seen = now(),
+			updated_at = now()
+		WHERE device_id = $1`, deviceID, currentVersion, failedVersion, UpdateStatusRolledBack, reason)
+	return err
+}
+
+// SetDesiredVersion sets one Device's Desired Version. It returns
+// sql.ErrNoRows if the Device has never checked in.
+func (r *DeviceRegistry) SetDesiredVersion(ctx context.Context, deviceID, version string) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE pigate_devices
+		SET desired_version = $2,
+			updated_at = now()
+		WHERE device_id = $1`, deviceID, version)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+// StartRollout begins a staged rollout of version to locationID by setting it
+// as the Desired Version of canaryDeviceID only. AdvanceRollouts sets it on
+// the rest of the Location once the canary has installed it. Starting a new
+// rollout replaces any earlier one for the Location.
+func (r *DeviceRegistry) StartRollout(ctx context.Context, locationID, version, canaryDeviceID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE pigate_devices
+		SET desired_version = $3,
+			updated_at = now()
+		WHERE device_id = $1 AND location_id = $2`, canaryDeviceID, locationID, version)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO pigate_rollouts (location_id, version, canary_device_id, status)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (location_id) DO UPDATE SET
+			version = EXCLUDED.version,
+			canary_device_id = EXCLUDED.canary_device_id,
+			status = EXCLUDED.status,
+			created_at = now(),
+			updated_at = now()`, locationID, version, canaryDeviceID, RolloutCanary); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListRollouts returns the latest rollout of each Location.
+func (r *DeviceRegistry) ListRollouts(ctx context.Context) ([]Rollout, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT location_id, version, canary_device_id, status, updated_at
+		FROM pigate_rollouts
+		ORDER BY location_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rollouts []Rollout
+	for rows.Next() {
+		var ro Rollout
+		if err := rows.Scan(&ro.LocationID, &ro.Version, &ro.CanaryDeviceID, &ro.Status, &ro.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, ro)
+	}
+	return rollouts, rows.Err()
+}
+
+// AdvanceRollouts promotes canary rollouts whose canary installed the version
+// to every Device at the Location, and marks rollouts whose canary rolled the
+// version back as failed. It returns the rollouts that changed status.
+func (r *DeviceRegistry) AdvanceRollouts(ctx context.Context) ([]Rollout, error) {
+	rollouts, err := r.ListRollouts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var changed []Rollout
+	for _, ro := range rollouts {
+		if ro.Status != RolloutCanary {
+			continue
+		}
+		canary, err := r.GetDevice(ctx, ro.CanaryDeviceID)
+		if err != nil {
+			return changed, err
+		}
+		next := ro.outcome(*canary)
+		if next == "" {
+			continue
+		}
+		finished, err := r.finishRollout(ctx, ro, next)
+		if err != nil {
+			return changed, err
+		}
+		if !finished {
+			continue
+		}
+		ro.Status = next
+		changed = append(changed, ro)
+	}
+	return changed, nil
+}
+
+// finishRollout moves ro to status, promoting its version when status is
+// RolloutPromoted. It reports false if a newer rollout replaced ro.
+func (r *DeviceRegistry) finishRollout(ctx context.Context, ro Rollout, status string) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Only finish the rollout that was read; a newer one may have replaced it.
+	res, err := tx.ExecContext(ctx, `
+		UPDATE pigate_rollouts
+		SET status = $3,
+			updated_at = now()
+		WHERE location_id = $1 AND version = $2 AND status = $4`,
+		ro.LocationID, ro.Version, status, RolloutCanary)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if status == RolloutPromoted {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE pigate_devices
+			SET desired_version = $2,
+				updated_at = now()
+			WHERE location_id = $1`, ro.LocationID, ro.Version); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+func requireRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package database
+
+import "testing"
+
+func TestRolloutOutcome(t *testing.T) {
+	ro := Rollout{LocationID: "loc", Version: "v1.2.0", CanaryDeviceID: "pi-1", Status: RolloutCanary}
+	tests := []struct {
+		name   string
+		canary Device
+		want   string
+	}{
+		{"still downloading", Device{CurrentVersion: "v1.1.0", UpdateStatus: UpdateStatusDownloading}, ""},
+		{"waiting for safe state", Device{CurrentVersion: "v1.1.0", UpdateStatus: UpdateStatusWaiting}, ""},
+		{"installed", Device{CurrentVersion: "v1.2.0", UpdateStatus: UpdateStatusInstalled}, RolloutPromoted},
+		{"rolled back", Device{CurrentVersion: "v1.1.0", UpdateStatus: UpdateStatusRolledBack, FailedVersion: "v1.2.0"}, RolloutFailed},
+		{"older failure", Device{CurrentVersion: "v1.1.0", UpdateStatus: UpdateStatusIdle, FailedVersion: "v1.0.9"}, ""},
+	}
+	for _, tt := range tests {
+		if got := ro.outcome(tt.canary); got != tt.want {
+			t.Errorf("%s: outcome() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDeviceDrifted(t *testing.T) {
+	if (Device{CurrentVersion: "v1.0.0"}).Drifted() {
+		t.Error("Device without a Desired Version reported drift")
+	}
+	if (Device{DesiredVersion: "v1.0.0", CurrentVersion: "v1.0.0"}).Drifted() {
+		t.Error("Device on its Desired Version reported drift")
+	}
+	if !(Device{DesiredVersion: "v1.1.0", CurrentVersion: "v1.0.0"}).Drifted() {
+		t.Error("Device behind its Desired Version not reported as drifted")
+	}
+}
@@ -55,6 +55,9 @@ func (r *dynamoAccessManager) PutCredential(ctx context.Context, cred Credential
 			"LockedOut":   &types.AttributeValueMemberBOOL{Value: cred.LockedOut},
 			"AutoUpdate":  &types.AttributeValueMemberBOOL{Value: cred.AutoUpdate},
 			"OpenMode":    &types.AttributeValueMemberS{Value: string(cred.OpenMode)},
+			"Type":        &types.AttributeValueMemberS{Value: string(cred.Type.orPIN())},
+			"PIN":         &types.AttributeValueMemberS{Value: cred.PIN},
+			"DuressCode":  &types.AttributeValueMemberS{Value: cred.DuressCode},
 		},
 	})
 	return err
@@ -73,6 +76,9 @@ func (r *dynamoAccessManager) PutCredentials(ctx context.Context, creds []Creden
 			"LockedOut":   &types.AttributeValueMemberBOOL{Value: cred.LockedOut},
 			"AutoUpdate":  &types.AttributeValueMemberBOOL{Value: cred.AutoUpdate},
 			"OpenMode":    &types.AttributeValueMemberS{Value: string(cred.OpenMode)},
+			"Type":        &types.AttributeValueMemberS{Value: string(cred.Type.orPIN())},
+			"PIN":         &types.AttributeValueMemberS{Value: cred.PIN},
+			"DuressCode":  &types.AttributeValueMemberS{Value: cred.DuressCode},
 		}
 
 		writeRequests = append(writeRequests, types.WriteRequest{
@@ -125,9 +131,42 @@ func (r *dynamoAccessManager) GetCredential(ctx context.Context, code string) (*
 		LockedOut:   out.Item["LockedOut"].(*types.AttributeValueMemberBOOL).Value,
 		AutoUpdate:  out.Item["AutoUpdate"].(*types.AttributeValueMemberBOOL).Value,
 		OpenMode:    OpenMode(out.Item["OpenMode"].(*types.AttributeValueMemberS).Value),
+		Type:        dynamoCredentialType(out.Item),
+		PIN:         dynamoString(out.Item, "PIN"),
+		DuressCode:  dynamoString(out.Item, "DuressCode"),
 	}, nil
 }
 
+// GetCredentialByDuressCode scans for the PIN credential with the given duress
+// code. The table is keyed by Code only, so this reads every page.
+func (r *dynamoAccessManager) GetCredentialByDuressCode(ctx context.Context, code string) (*Credential, error) {
+	if code == "" {
+		return nil, nil
+	}
+	input := &dynamodb.ScanInput{
+		TableName:                aws.String(r.tableName),
+		FilterExpression:         aws.String("DuressCode = :code AND #type = :pin"),
+		ExpressionAttributeNames: map[string]string{"#type": "Type"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":code": &types.AttributeValueMemberS{Value: code},
+			":pin":  &types.AttributeValueMemberS{Value: string(CredentialPIN)},
+		},
+	}
+	for {
+		result, err := r.client.Scan(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Items) > 0 {
+			return r.GetCredential(ctx, result.Items[0]["Code"].(*types.AttributeValueMemberS).Value)
+		}
+		if result.LastEvaluatedKey == nil {
+			return nil, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
 // GetCredentials retrieves all credentials from the DynamoDB table.
 func (r *dynamoAccessManager) GetCredentials(ctx context.Context) ([]Credential, error) {
 	var credentials []Credential
@@ -153,6 +192,9 @@ func (r *dynamoAccessManager) GetCredentials(ctx context.Context) ([]Credential,
 			LockedOut:   item["LockedOut"].(*types.AttributeValueMemberBOOL).Value,
 			AutoUpdate:  item["AutoUpdate"].(*types.AttributeValueMemberBOOL).Value,
 			OpenMode:    OpenMode(item["OpenMode"].(*types.AttributeValueMemberS).Value),
+			Type:        dynamoCredentialType(item),
+			PIN:         dynamoString(item, "PIN"),
+			DuressCode:  dynamoString(item, "DuressCode"),
 		}
 		credentials = append(credentials, cred)
 	}
@@ -160,6 +202,20 @@ func (r *dynamoAccessManager) GetCredentials(ctx context.Context) ([]Credential,
 	return credentials, nil
 }
 
+// dynamoCredentialType reads the Type attribute, which items written before
+// card support do not have.
+func dynamoCredentialType(item map[string]types.AttributeValue) CredentialType {
+	return CredentialType(dynamoString(item, "Type")).orPIN()
+}
+
+// dynamoString reads an optional string attribute.
+func dynamoString(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
 // DeleteCredential deletes a credential by its code
 func (r *dynamoAccessManager) DeleteCredential(ctx context.Context, code string) error {
 	_, err := r.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -19,13 +20,49 @@ const (
 	LockOpen    OpenMode = "lock_open"    // “lock open” mode
 )
 
+// CredentialType tells keypad PINs apart from scanned cards and fobs.
+type CredentialType string
+
+const (
+	CredentialPIN  CredentialType = "pin"
+	CredentialCard CredentialType = "card"
+)
+
+// orPIN returns t, or CredentialPIN for credentials written before cards
+// were supported.
+func (t CredentialType) orPIN() CredentialType {
+	if t == "" {
+		return CredentialPIN
+	}
+	return t
+}
+
+// CardCode is the code a card is enrolled under: its facility code and card
+// number, e.g. "12:34567". The colon keeps it apart from keypad PINs.
+func CardCode(facility, number uint32) string {
+	return fmt.Sprintf("%d:%d", facility, number)
+}
+
 type Credential struct {
 	Code        string // Primary key
 	Username    string
 	AccessGroup int
 	LockedOut   bool
-	AutoUpdate  bool     // “true” = this record comes from the external feed
-	OpenMode    OpenMode // "regular_open" or "lock_open"
+	AutoUpdate  bool           // “true” = this record comes from the external feed
+	OpenMode    OpenMode       // "regular_open" or "lock_open"
+	Type        CredentialType // "pin" or "card"; empty means pin
+	PIN         string         // for cards: PIN that must follow the card; empty for card only
+	DuressCode  string         // opens like Code (for cards, like PIN) but raises a duress alarm; empty for none
+}
+
+// IsCard reports whether the credential is a card rather than a PIN.
+func (c Credential) IsCard() bool {
+	return c.Type == CredentialCard
+}
+
+// RequiresPIN reports whether the card is only accepted together with its PIN.
+func (c Credential) RequiresPIN() bool {
+	return c.IsCard() && c.PIN != ""
 }
 
 type AccessTime struct {
@@ -36,8 +73,25 @@ type AccessTime struct {
 	EndWeekday   time.Weekday
 }
 
+// Lockout is a keypad or reader that ignores codes until Until after too many
+// failed ones. Level counts lockouts in a row; each doubles the next one.
+type Lockout struct {
+	Source string // Primary key
+	Until  time.Time
+	Level  int
+}
+
+// Presence is where anti-passback last saw a credential: inside after it
+// opened an entry reader, outside after an exit reader.
+type Presence struct {
+	Code   string // Primary key
+	Inside bool
+	At     time.Time
+}
+
 type GateLog struct {
 	Code   string // Primary key
 	Time   time.Time
 	Status GateStatus
+	Source string // keypad, reader or exit input; empty in logs from before it was kept
 }
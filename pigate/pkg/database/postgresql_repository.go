@@ -20,8 +20,14 @@ func (r *postgresAccessManager) InitSchema(ctx context.Context) error {
             access_group INTEGER NOT NULL,
             locked_out BOOLEAN NOT NULL,
             auto_update BOOLEAN NOT NULL,
-			open_mode TEXT NOT NULL CHECK (open_mode IN ('regular_open', 'lock_open'))
+			open_mode TEXT NOT NULL CHECK (open_mode IN ('regular_open', 'lock_open')),
+			credential_type TEXT NOT NULL DEFAULT 'pin' CHECK (credential_type IN ('pin', 'card')),
+			pin TEXT NOT NULL DEFAULT '',
+			duress_code TEXT NOT NULL DEFAULT ''
         );`,
+		`ALTER TABLE credentials ADD COLUMN IF NOT EXISTS credential_type TEXT NOT NULL DEFAULT 'pin' CHECK (credential_type IN ('pin', 'card'));`,
+		`ALTER TABLE credentials ADD COLUMN IF NOT EXISTS pin TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE credentials ADD COLUMN IF NOT EXISTS duress_code TEXT NOT NULL DEFAULT '';`,
 		`CREATE TABLE IF NOT EXISTS access_times (
             access_group INTEGER PRIMARY KEY,
             start_time TIME NOT NULL,
@@ -56,15 +62,18 @@ func NewPostgresAccessManager(ctx context.Context, connStr string) (*postgresAcc
 // PutCredential inserts or updates a credential
 func (r *postgresAccessManager) PutCredential(ctx context.Context, cred Credential) error {
 	query := `
-        INSERT INTO credentials (code, username, access_group, locked_out, auto_update, open_mode)
-        VALUES ($1, $2, $3, $4, $5, $6)
+        INSERT INTO credentials (code, username, access_group, locked_out, auto_update, open_mode, credential_type, pin, duress_code)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
         ON CONFLICT (code) DO UPDATE SET
             username = EXCLUDED.username,
             access_group = EXCLUDED.access_group,
             locked_out = EXCLUDED.locked_out,
             auto_update = EXCLUDED.auto_update,
-			open_mode = EXCLUDED.open_mode`
-	_, err := r.db.ExecContext(ctx, query, cred.Code, cred.Username, cred.AccessGroup, cred.LockedOut, cred.AutoUpdate, cred.OpenMode)
+			open_mode = EXCLUDED.open_mode,
+			credential_type = EXCLUDED.credential_type,
+			pin = EXCLUDED.pin,
+			duress_code = EXCLUDED.duress_code`
+	_, err := r.db.ExecContext(ctx, query, cred.Code, cred.Username, cred.AccessGroup, cred.LockedOut, cred.AutoUpdate, cred.OpenMode, cred.Type.orPIN(), cred.PIN, cred.DuressCode)
 	return err
 }
 
@@ -85,10 +94,25 @@ func (r *postgresAccessManager) PutCredentials(ctx context.Context, creds []Cred
 
 // GetCredential retrieves a credential by code
 func (r *postgresAccessManager) GetCredential(ctx context.Context, code string) (*Credential, error) {
-	query := `SELECT code, username, access_group, locked_out, auto_update, open_mode FROM credentials WHERE code = $1`
+	query := `SELECT code, username, access_group, locked_out, auto_update, open_mode, credential_type, pin, duress_code FROM credentials WHERE code = $1`
 	row := r.db.QueryRowContext(ctx, query, code)
 	var cred Credential
-	err := row.Scan(&cred.Code, &cred.Username, &cred.AccessGroup, &cred.LockedOut, &cred.AutoUpdate, &cred.OpenMode)
+	err := row.Scan(&cred.Code, &cred.Username, &cred.AccessGroup, &cred.LockedOut, &cred.AutoUpdate, &cred.OpenMode, &cred.Type, &cred.PIN, &cred.DuressCode)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// GetCredentialByDuressCode retrieves the PIN credential with the given duress code
+func (r *postgresAccessManager) GetCredentialByDuressCode(ctx context.Context, code string) (*Credential, error) {
+	query := `SELECT code, username, access_group, locked_out, auto_update, open_mode, credential_type, pin, duress_code FROM credentials WHERE duress_code = $1 AND duress_code <> '' AND credential_type = 'pin'`
+	row := r.db.QueryRowContext(ctx, query, code)
+	var cred Credential
+	err := row.Scan(&cred.Code, &cred.Username, &cred.AccessGroup, &cred.LockedOut, &cred.AutoUpdate, &cred.OpenMode, &cred.Type, &cred.PIN, &cred.DuressCode)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -100,7 +124,7 @@ func (r *postgresAccessManager) GetCredential(ctx context.Context, code string)
 
 // GetCredentials retrieves all credentials
 func (r *postgresAccessManager) GetCredentials(ctx context.Context) ([]Credential, error) {
-	query := `SELECT code, username, access_group, locked_out, auto_update, open_mode FROM credentials`
+	query := `SELECT code, username, access_group, locked_out, auto_update, open_mode, credential_type, pin, duress_code FROM credentials`
 	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
@@ -109,7 +133,7 @@ func (r *postgresAccessManager) GetCredentials(ctx context.Context) ([]Credentia
 	var creds []Credential
 	for rows.Next() {
 		var cred Credential
-		if err := rows.Scan(&cred.Code, &cred.Username, &cred.AccessGroup, &cred.LockedOut, &cred.AutoUpdate, &cred.OpenMode); err != nil {
+		if err := rows.Scan(&cred.Code, &cred.Username, &cred.AccessGroup, &cred.LockedOut, &cred.AutoUpdate, &cred.OpenMode, &cred.Type, &cred.PIN, &cred.DuressCode); err != nil {
 			return nil, err
 		}
 		creds = append(creds, cred)
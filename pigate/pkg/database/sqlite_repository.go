@@ -16,10 +16,13 @@ type sqliteGateManager struct {
 	DB *sql.DB
 	AccessManager
 	AccessLogger
+	LockoutStore
+	PresenceStore
 }
 
 // NewRepository opens the database at dbPath, creates the required tables,
-// and initializes both the AccessManager and AccessLogger.
+// and initializes the AccessManager, AccessLogger, LockoutStore and
+// PresenceStore.
 func NewSqliteGateManager(dbPath string) (GateManager, error) {
 	// Open the SQLite database
 	db, err := sql.Open("sqlite3", dbPath)
@@ -41,10 +44,26 @@ func NewSqliteGateManager(dbPath string) (GateManager, error) {
 		return nil, err
 	}
 
+	// Create the LockoutStore (which creates its tables)
+	lockouts, err := NewSQLiteLockoutStore(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Create the PresenceStore (which creates its tables)
+	presence, err := NewSQLitePresenceStore(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return &sqliteGateManager{
 		DB:            db,
 		AccessManager: accessMgr,
 		AccessLogger:  accessLogger,
+		LockoutStore:  lockouts,
+		PresenceStore: presence,
 	}, nil
 }
 
@@ -80,7 +99,10 @@ func (r *sqlitAccessManager) createTables() error {
 			access_group INTEGER NOT NULL,
 			locked_out BOOLEAN NOT NULL,
 			auto_update BOOLEAN NOT NULL DEFAULT 0,
-			open_mode TEXT NOT NULL CHECK (open_mode IN ('regular_open', 'lock_open'))
+			open_mode TEXT NOT NULL CHECK (open_mode IN ('regular_open', 'lock_open')),
+			credential_type TEXT NOT NULL DEFAULT 'pin' CHECK (credential_type IN ('pin', 'card')),
+			pin TEXT NOT NULL DEFAULT '',
+			duress_code TEXT NOT NULL DEFAULT ''
 		);`,
 
 		`CREATE TABLE IF NOT EXISTS access_times (
@@ -97,20 +119,47 @@ func (r *sqlitAccessManager) createTables() error {
 			return err
 		}
 	}
+
+	// Columns added since, for databases created before them.
+	columns := []struct{ name, definition string }{
+		{"credential_type", `TEXT NOT NULL DEFAULT 'pin' CHECK (credential_type IN ('pin', 'card'))`},
+		{"pin", `TEXT NOT NULL DEFAULT ''`},
+		{"duress_code", `TEXT NOT NULL DEFAULT ''`},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(r.db, "credentials", c.name, c.definition); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// addColumnIfMissing adds column to table unless it is already there; SQLite
+// has no ADD COLUMN IF NOT EXISTS.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	var exists bool
+	err := db.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
 func (r *sqlitAccessManager) PutCredential(ctx context.Context, cred Credential) error {
 	query := `
-		INSERT INTO credentials (code, username, access_group, locked_out, auto_update, open_mode)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO credentials (code, username, access_group, locked_out, auto_update, open_mode, credential_type, pin, duress_code)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(code) DO UPDATE SET
 			username = excluded.username,
 			access_group = excluded.access_group,
 			locked_out = excluded.locked_out,
 			auto_update = excluded.auto_update,
-			open_mode = excluded.open_mode`
-	_, err := r.db.ExecContext(ctx, query, cred.Code, cred.Username, cred.AccessGroup, cred.LockedOut, cred.AutoUpdate, cred.OpenMode)
+			open_mode = excluded.open_mode,
+			credential_type = excluded.credential_type,
+			pin = excluded.pin,
+			duress_code = excluded.duress_code`
+	_, err := r.db.ExecContext(ctx, query, cred.Code, cred.Username, cred.AccessGroup, cred.LockedOut, cred.AutoUpdate, cred.OpenMode, cred.Type.orPIN(), cred.PIN, cred.DuressCode)
 	return err
 }
 
@@ -123,21 +172,24 @@ func (r *sqlitAccessManager) PutCredentials(ctx context.Context, creds []Credent
 	defer tx.Rollback()
 
 	stmt, err := tx.Prepare(`
-		INSERT INTO credentials (code, username, access_group, locked_out, auto_update, open_mode)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO credentials (code, username, access_group, locked_out, auto_update, open_mode, credential_type, pin, duress_code)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(code) DO UPDATE SET
 			username = excluded.username,
 			access_group = excluded.access_group,
 			locked_out = excluded.locked_out,
 			auto_update = excluded.auto_update,
-			open_mode = excluded.open_mode`)
+			open_mode = excluded.open_mode,
+			credential_type = excluded.credential_type,
+			pin = excluded.pin,
+			duress_code = excluded.duress_code`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	for _, cred := range creds {
-		_, err := stmt.Exec(cred.Code, cred.Username, cred.AccessGroup, cred.LockedOut, cred.AutoUpdate, cred.OpenMode)
+		_, err := stmt.Exec(cred.Code, cred.Username, cred.AccessGroup, cred.LockedOut, cred.AutoUpdate, cred.OpenMode, cred.Type.orPIN(), cred.PIN, cred.DuressCode)
 		if err != nil {
 			return err
 		}
@@ -150,9 +202,19 @@ func (r *sqlitAccessManager) PutCredentials(ctx context.Context, creds []Credent
 }
 
 func (r *sqlitAccessManager) GetCredential(ctx context.Context, code string) (*Credential, error) {
-	query := `SELECT code, username, access_group, locked_out, auto_update, open_mode FROM credentials WHERE code = ?`
+	query := `SELECT code, username, access_group, locked_out, auto_update, open_mode, credential_type, pin, duress_code FROM credentials WHERE code = ?`
 	var c Credential
-	err := r.db.QueryRow(query, code).Scan(&c.Code, &c.Username, &c.AccessGroup, &c.LockedOut, &c.AutoUpdate, &c.OpenMode)
+	err := r.db.QueryRow(query, code).Scan(&c.Code, &c.Username, &c.AccessGroup, &c.LockedOut, &c.AutoUpdate, &c.OpenMode, &c.Type, &c.PIN, &c.DuressCode)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *sqlitAccessManager) GetCredentialByDuressCode(ctx context.Context, code string) (*Credential, error) {
+	query := `SELECT code, username, access_group, locked_out, auto_update, open_mode, credential_type, pin, duress_code FROM credentials WHERE duress_code = ? AND duress_code != '' AND credential_type = 'pin'`
+	var c Credential
+	err := r.db.QueryRow(query, code).Scan(&c.Code, &c.Username, &c.AccessGroup, &c.LockedOut, &c.AutoUpdate, &c.OpenMode, &c.Type, &c.PIN, &c.DuressCode)
 	if err != nil {
 		return nil, err
 	}
@@ -160,7 +222,7 @@ func (r *sqlitAccessManager) GetCredential(ctx context.Context, code string) (*C
 }
 
 func (r *sqlitAccessManager) GetCredentials(ctx context.Context) ([]Credential, error) {
-	query := `SELECT code, username, access_group, locked_out, auto_update, open_mode FROM credentials`
+	query := `SELECT code, username, access_group, locked_out, auto_update, open_mode, credential_type, pin, duress_code FROM credentials`
 	rows, err := r.db.Query(query)
 	if err != nil {
 		return nil, err
@@ -170,7 +232,7 @@ func (r *sqlitAccessManager) GetCredentials(ctx context.Context) ([]Credential,
 	var credentials []Credential
 	for rows.Next() {
 		var cred Credential
-		err := rows.Scan(&cred.Code, &cred.Username, &cred.AccessGroup, &cred.LockedOut, &cred.AutoUpdate, &cred.OpenMode)
+		err := rows.Scan(&cred.Code, &cred.Username, &cred.AccessGroup, &cred.LockedOut, &cred.AutoUpdate, &cred.OpenMode, &cred.Type, &cred.PIN, &cred.DuressCode)
 		if err != nil {
 			return nil, err
 		}
@@ -310,7 +372,8 @@ func (r *sqliteAccessLogger) createTables() error {
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			code TEXT NOT NULL,
 			time INTEGER NOT NULL, -- Unix timestamp for search support
-			status TEXT NOT NULL
+			status TEXT NOT NULL,
+			source TEXT NOT NULL DEFAULT ''
 		);`,
 	}
 	for _, query := range queries {
@@ -318,17 +381,17 @@ func (r *sqliteAccessLogger) createTables() error {
 			return err
 		}
 	}
-	return nil
+	return addColumnIfMissing(r.db, "gate_request_log", "source", `TEXT NOT NULL DEFAULT ''`)
 }
 
 func (r *sqliteAccessLogger) PutGateLog(ctx context.Context, logEntry GateLog) error {
-	query := `INSERT INTO gate_request_log (code, time, status) VALUES (?, ?, ?)`
-	_, err := r.db.Exec(query, logEntry.Code, logEntry.Time.Unix(), logEntry.Status)
+	query := `INSERT INTO gate_request_log (code, time, status, source) VALUES (?, ?, ?, ?)`
+	_, err := r.db.Exec(query, logEntry.Code, logEntry.Time.Unix(), logEntry.Status, logEntry.Source)
 	return err
 }
 
 func (r *sqliteAccessLogger) GetGateLogs(ctx context.Context) ([]GateLog, error) {
-	query := `SELECT code, time, status FROM gate_request_log`
+	query := `SELECT code, time, status, source FROM gate_request_log`
 	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
@@ -337,11 +400,11 @@ func (r *sqliteAccessLogger) GetGateLogs(ctx context.Context) ([]GateLog, error)
 
 	var logs []GateLog
 	for rows.Next() {
-		var code string
+		var code, source string
 		var ts int64
 		var status GateStatus
 
-		if err := rows.Scan(&code, &ts, &status); err != nil {
+		if err := rows.Scan(&code, &ts, &status, &source); err != nil {
 			return nil, err
 		}
 
@@ -349,7 +412,123 @@ func (r *sqliteAccessLogger) GetGateLogs(ctx context.Context) ([]GateLog, error)
 			Code:   code,
 			Time:   time.Unix(ts, 0).UTC(), // TODO: ensure this is in UTC
 			Status: status,
+			Source: source,
 		})
 	}
 	return logs, nil
 }
+
+// -------------------------------------------------------------------
+// LockoutStore
+// -------------------------------------------------------------------
+
+// Ensure sqliteLockoutStore implements LockoutStore
+var _ LockoutStore = (*sqliteLockoutStore)(nil)
+
+type sqliteLockoutStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteLockoutStore(db *sql.DB) (LockoutStore, error) {
+	store := &sqliteLockoutStore{db: db}
+	if err := store.createTables(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (r *sqliteLockoutStore) createTables() error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS keypad_lockouts (
+		source TEXT PRIMARY KEY,
+		until INTEGER NOT NULL, -- Unix timestamp
+		level INTEGER NOT NULL
+	);`)
+	return err
+}
+
+func (r *sqliteLockoutStore) PutLockout(ctx context.Context, lockout Lockout) error {
+	query := `
+		INSERT INTO keypad_lockouts (source, until, level) VALUES (?, ?, ?)
+		ON CONFLICT(source) DO UPDATE SET
+			until = excluded.until,
+			level = excluded.level`
+	_, err := r.db.ExecContext(ctx, query, lockout.Source, lockout.Until.Unix(), lockout.Level)
+	return err
+}
+
+func (r *sqliteLockoutStore) GetLockouts(ctx context.Context) ([]Lockout, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT source, until, level FROM keypad_lockouts`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lockouts []Lockout
+	for rows.Next() {
+		var l Lockout
+		var until int64
+		if err := rows.Scan(&l.Source, &until, &l.Level); err != nil {
+			return nil, err
+		}
+		l.Until = time.Unix(until, 0).UTC()
+		lockouts = append(lockouts, l)
+	}
+	return lockouts, rows.Err()
+}
+
+func (r *sqliteLockoutStore) DeleteLockout(ctx context.Context, source string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM keypad_lockouts WHERE source = ?`, source)
+	return err
+}
+
+// -------------------------------------------------------------------
+// PresenceStore
+// -------------------------------------------------------------------
+
+// Ensure sqlitePresenceStore implements PresenceStore
+var _ PresenceStore = (*sqlitePresenceStore)(nil)
+
+type sqlitePresenceStore struct {
+	db *sql.DB
+}
+
+func NewSQLitePresenceStore(db *sql.DB) (PresenceStore, error) {
+	store := &sqlitePresenceStore{db: db}
+	if err := store.createTables(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (r *sqlitePresenceStore) createTables() error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS credential_presence (
+		code TEXT PRIMARY KEY,
+		inside BOOLEAN NOT NULL,
+		at INTEGER NOT NULL -- Unix timestamp
+	);`)
+	return err
+}
+
+func (r *sqlitePresenceStore) PutPresence(ctx context.Context, presence Presence) error {
+	query := `
+		INSERT INTO credential_presence (code, inside, at) VALUES (?, ?, ?)
+		ON CONFLICT(code) DO UPDATE SET
+			inside = excluded.inside,
+			at = excluded.at`
+	_, err := r.db.ExecContext(ctx, query, presence.Code, presence.Inside, presence.At.Unix())
+	return err
+}
+
+func (r *sqlitePresenceStore) GetPresence(ctx context.Context, code string) (*Presence, error) {
+	p := Presence{Code: code}
+	var at int64
+	err := r.db.QueryRowContext(ctx, `SELECT inside, at FROM credential_presence WHERE code = ?`, code).Scan(&p.Inside, &at)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.At = time.Unix(at, 0).UTC()
+	return &p, nil
+}
@@ -4,6 +4,8 @@ package database_test
 
 import (
 	"context"
+	"database/sql"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -40,6 +42,7 @@ func TestGateManager(t *testing.T) {
 		LockedOut:   false,
 		AutoUpdate:  false,
 		OpenMode:    database.RegularOpen, // Test with RegularOpen
+		Type:        database.CredentialPIN,
 	}
 
 	// PutCredential
@@ -126,6 +129,7 @@ func TestGateManager(t *testing.T) {
 		Code:   code,
 		Time:   time.Now().UTC(),
 		Status: database.StatusGranted,
+		Source: "wiegand",
 	}
 
 	// PutGateLog
@@ -141,11 +145,164 @@ func TestGateManager(t *testing.T) {
 	if len(logs) != 1 {
 		t.Fatalf("GetGateLogs returned %d logs; want 1", len(logs))
 	}
-	if logs[0].Code != logEntry.Code || logs[0].Status != logEntry.Status {
-		t.Errorf("GetGateLogs[0] = %+v; want Code=%s Status=%s", logs[0], logEntry.Code, logEntry.Status)
+	if logs[0].Code != logEntry.Code || logs[0].Status != logEntry.Status || logs[0].Source != logEntry.Source {
+		t.Errorf("GetGateLogs[0] = %+v; want Code=%s Status=%s Source=%s", logs[0], logEntry.Code, logEntry.Status, logEntry.Source)
 	}
 	if logs[0].Time.Unix() != logEntry.Time.Unix() {
 		t.Errorf("Log Time = %d; want %d", logs[0].Time.Unix(), logEntry.Time.Unix())
 	}
 
 }
+
+func TestSQLiteCardCredentials(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	db, err := sql.Open("sqlite3", "file:cards?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// A table from before credential_type existed.
+	_, err = db.Exec(`CREATE TABLE credentials (
+		code TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		access_group INTEGER NOT NULL,
+		locked_out BOOLEAN NOT NULL,
+		auto_update BOOLEAN NOT NULL DEFAULT 0,
+		open_mode TEXT NOT NULL
+	);
+	INSERT INTO credentials VALUES ('12345', 'old_pin', 0, 0, 0, 'regular_open');`)
+	if err != nil {
+		t.Fatalf("creating old schema failed: %v", err)
+	}
+
+	am, err := database.NewSQLiteAccessManager(db)
+	if err != nil {
+		t.Fatalf("NewSQLiteAccessManager failed: %v", err)
+	}
+	old, err := am.GetCredential(ctx, "12345")
+	if err != nil {
+		t.Fatalf("GetCredential failed: %v", err)
+	}
+	if old.Type != database.CredentialPIN {
+		t.Errorf("existing credential Type = %q; want pin", old.Type)
+	}
+
+	card := database.Credential{
+		Code:     database.CardCode(12, 34567),
+		Username: "fob",
+		OpenMode: database.RegularOpen,
+		Type:     database.CredentialCard,
+		PIN:      "4321",
+	}
+	if err := am.PutCredential(ctx, card); err != nil {
+		t.Fatalf("PutCredential failed: %v", err)
+	}
+	fetched, err := am.GetCredential(ctx, "12:34567")
+	if err != nil {
+		t.Fatalf("GetCredential failed: %v", err)
+	}
+	if *fetched != card || !fetched.RequiresPIN() {
+		t.Errorf("Fetched card = %+v; want %+v", fetched, card)
+	}
+}
+
+func TestSQLiteDuressCode(t *testing.T) {
+	ctx := context.Background()
+	gm, err := database.NewSqliteGateManager(filepath.Join(t.TempDir(), "gate.sqlite"))
+	if err != nil {
+		t.Fatalf("NewSqliteGateManager failed: %v", err)
+	}
+	defer gm.Close()
+
+	creds := []database.Credential{
+		{Code: "12345", Username: "staff", OpenMode: database.RegularOpen, Type: database.CredentialPIN, DuressCode: "54321"},
+		{Code: "23456", Username: "tenant", OpenMode: database.RegularOpen, Type: database.CredentialPIN},
+		{Code: database.CardCode(12, 34567), Username: "fob", OpenMode: database.RegularOpen,
+			Type: database.CredentialCard, PIN: "4321", DuressCode: "9999"},
+	}
+	if err := gm.PutCredentials(ctx, creds); err != nil {
+		t.Fatalf("PutCredentials failed: %v", err)
+	}
+
+	got, err := gm.GetCredentialByDuressCode(ctx, "54321")
+	if err != nil || *got != creds[0] {
+		t.Errorf("GetCredentialByDuressCode(54321) = %+v, %v; want %+v", got, err, creds[0])
+	}
+	// Neither a card's duress PIN nor an empty code finds anything.
+	for _, code := range []string{"9999", ""} {
+		if got, err := gm.GetCredentialByDuressCode(ctx, code); err == nil && got != nil {
+			t.Errorf("GetCredentialByDuressCode(%q) = %+v; want none", code, got)
+		}
+	}
+	card, err := gm.GetCredential(ctx, creds[2].Code)
+	if err != nil || card.DuressCode != "9999" {
+		t.Errorf("card = %+v, %v; want duress PIN 9999", card, err)
+	}
+}
+
+func TestSQLiteLockoutsSurviveReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "gate.sqlite")
+	gm, err := database.NewSqliteGateManager(path)
+	if err != nil {
+		t.Fatalf("NewSqliteGateManager failed: %v", err)
+	}
+	lockout := database.Lockout{Source: "wiegand", Until: time.Date(2024, 1, 1, 12, 5, 0, 0, time.UTC), Level: 2}
+	if err := gm.PutLockout(ctx, lockout); err != nil {
+		t.Fatalf("PutLockout failed: %v", err)
+	}
+	lockout.Level = 3
+	if err := gm.PutLockout(ctx, lockout); err != nil {
+		t.Fatalf("PutLockout (update) failed: %v", err)
+	}
+	gm.Close()
+
+	gm, err = database.NewSqliteGateManager(path)
+	if err != nil {
+		t.Fatalf("NewSqliteGateManager (reopen) failed: %v", err)
+	}
+	defer gm.Close()
+	lockouts, err := gm.GetLockouts(ctx)
+	if err != nil {
+		t.Fatalf("GetLockouts failed: %v", err)
+	}
+	if len(lockouts) != 1 || lockouts[0] != lockout {
+		t.Fatalf("GetLockouts = %+v; want [%+v]", lockouts, lockout)
+	}
+
+	if err := gm.DeleteLockout(ctx, "wiegand"); err != nil {
+		t.Fatalf("DeleteLockout failed: %v", err)
+	}
+	if lockouts, _ := gm.GetLockouts(ctx); len(lockouts) != 0 {
+		t.Errorf("GetLockouts after delete = %+v; want none", lockouts)
+	}
+}
+
+func TestSQLitePresence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "gate.sqlite")
+	gm, err := database.NewSqliteGateManager(path)
+	if err != nil {
+		t.Fatalf("NewSqliteGateManager failed: %v", err)
+	}
+	defer gm.Close()
+
+	if p, err := gm.GetPresence(ctx, "12345"); err != nil || p != nil {
+		t.Fatalf("GetPresence before any = %+v, %v; want nil", p, err)
+	}
+	presence := database.Presence{Code: "12345", Inside: true, At: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	if err := gm.PutPresence(ctx, presence); err != nil {
+		t.Fatalf("PutPresence failed: %v", err)
+	}
+	presence.Inside, presence.At = false, presence.At.Add(time.Hour)
+	if err := gm.PutPresence(ctx, presence); err != nil {
+		t.Fatalf("PutPresence (update) failed: %v", err)
+	}
+	p, err := gm.GetPresence(ctx, "12345")
+	if err != nil || p == nil || *p != presence {
+		t.Errorf("GetPresence = %+v, %v; want %+v", p, err, presence)
+	}
+}
@@ -6,22 +6,70 @@ import (
 	"time"
 )
 
-func HandleUpdateNotification(access AccessManager, connStr string) func(topic string, message string) {
+// SyncMetrics receives the outcome of each sync. pkg/metrics provides the
+// Prometheus implementation.
+type SyncMetrics interface {
+	SyncCompleted(kind string, duration time.Duration, err error)
+	CredentialCount(n int)
+}
+
+// Sync kinds reported to SyncMetrics.
+const (
+	SyncKindCredentials = "credentials"
+	SyncKindAccessTimes = "access_times"
+)
+
+// HandleUpdateNotification syncs on every credential update notification.
+// metrics and report may be nil; report receives the combined result of
+// each sync.
+func HandleUpdateNotification(access AccessManager, connStr string, metrics SyncMetrics, report func(error)) func(topic string, message string) {
 	return func(topic string, message string) {
 		log.Printf("Received update notification: %s", message)
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		if err := SyncCredentials(ctx, access, connStr); err != nil {
+		err := SyncAll(ctx, access, connStr, metrics)
+		if err != nil {
 			log.Printf("Sync failed: %v. Will retry later.", err)
 		}
-
-		if err := SyncAccessTimes(ctx, access, connStr); err != nil {
-			log.Printf("Access time sync failed: %v. Will retry later.", err)
+		if report != nil {
+			report(err)
 		}
 	}
 }
 
+// SyncAll pulls credentials and access times, returning the first error.
+// Access times are synced even when the credential sync fails. metrics may
+// be nil.
+func SyncAll(ctx context.Context, access AccessManager, connStr string, metrics SyncMetrics) error {
+	start := time.Now()
+	credErr := SyncCredentials(ctx, access, connStr)
+	if metrics != nil {
+		metrics.SyncCompleted(SyncKindCredentials, time.Since(start), credErr)
+		ReportCredentialCount(ctx, access, metrics)
+	}
+
+	start = time.Now()
+	accessErr := SyncAccessTimes(ctx, access, connStr)
+	if metrics != nil {
+		metrics.SyncCompleted(SyncKindAccessTimes, time.Since(start), accessErr)
+	}
+	if accessErr != nil && credErr == nil {
+		return accessErr
+	}
+	return credErr
+}
+
+// ReportCredentialCount sets the credential gauge from the local database.
+func ReportCredentialCount(ctx context.Context, access AccessManager, metrics SyncMetrics) {
+	credentials, err := access.GetCredentials(ctx)
+	if err != nil {
+		log.Printf("Failed to count credentials: %v", err)
+		return
+	}
+	metrics.CredentialCount(len(credentials))
+}
+
 func SyncCredentials(ctx context.Context, access AccessManager, connStr string) error {
 	backend, err := NewPostgresAccessManager(ctx, connStr)
 	if err != nil {
@@ -41,6 +89,7 @@ func SyncCredentials(ctx context.Context, access AccessManager, connStr string)
 	// Store in local database
 	if err := access.PutCredentials(ctx, credentials); err != nil {
 		log.Printf("Failed to sync credentials: %v", err)
+		return err
 	}
 
 	log.Println("Credential sync completed successfully.")
@@ -0,0 +1,138 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"pigate/pkg/database"
+)
+
+// SourceWiegandExit is a second Wiegand keypad, at the exit.
+const SourceWiegandExit = "wiegand_exit"
+
+// AntiPassbackMode is what happens when a credential enters twice without
+// exiting in between.
+type AntiPassbackMode int
+
+const (
+	AntiPassbackOff AntiPassbackMode = iota
+	// AntiPassbackSoft opens the gate and logs the entry with
+	// ReasonAntiPassback.
+	AntiPassbackSoft
+	// AntiPassbackHard denies the entry with ReasonAntiPassback.
+	AntiPassbackHard
+)
+
+func (m AntiPassbackMode) String() string {
+	switch m {
+	case AntiPassbackSoft:
+		return "soft"
+	case AntiPassbackHard:
+		return "hard"
+	default:
+		return "off"
+	}
+}
+
+// ParseAntiPassbackMode parses off, soft or hard. An empty string is off.
+func ParseAntiPassbackMode(s string) (AntiPassbackMode, error) {
+	switch s {
+	case "", "off":
+		return AntiPassbackOff, nil
+	case "soft":
+		return AntiPassbackSoft, nil
+	case "hard":
+		return AntiPassbackHard, nil
+	default:
+		return 0, fmt.Errorf("unknown anti-passback mode %q", s)
+	}
+}
+
+// AntiPassbackConfig enables anti-passback. A credential that opens an entry
+// reader is inside until it opens an exit reader, and cannot enter again
+// until then. Readers not listed in ExitSources are entry readers.
+//
+// Free exits carry no credential and leave it inside; with Reset, a
+// credential last seen longer ago than Reset may enter again regardless.
+type AntiPassbackConfig struct {
+	Mode        AntiPassbackMode
+	ExitSources []string
+	Reset       time.Duration // 0 keeps presence until the credential exits
+}
+
+// Validate reports settings that cannot be enforced.
+func (c AntiPassbackConfig) Validate() error {
+	if c.Mode < AntiPassbackOff || c.Mode > AntiPassbackHard {
+		return fmt.Errorf("unknown anti-passback mode %d", c.Mode)
+	}
+	if c.Reset < 0 {
+		return errors.New("anti-passback reset must not be negative")
+	}
+	if c.Mode != AntiPassbackOff && len(c.ExitSources) == 0 {
+		return errors.New("anti-passback needs an exit reader")
+	}
+	return nil
+}
+
+// exit reports whether source is an exit reader.
+func (c AntiPassbackConfig) exit(source string) bool {
+	for _, s := range c.ExitSources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAntiPassback enforces anti-passback as cfg describes. Presence is kept
+// in the local database, so it survives a restart.
+func (g *GateController) SetAntiPassback(cfg AntiPassbackConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.antiPassback = cfg
+	return nil
+}
+
+// passedBack reports whether code, read at source, is entering while it is
+// still inside. Lookup errors let it through.
+func (g *GateController) passedBack(source, code string, at time.Time) bool {
+	g.mu.Lock()
+	cfg := g.antiPassback
+	g.mu.Unlock()
+	if cfg.Mode == AntiPassbackOff || cfg.exit(source) {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p, err := g.gm.GetPresence(ctx, code)
+	if err != nil {
+		log.Printf("Anti-passback lookup error: %v", err)
+		return false
+	}
+	if p == nil || !p.Inside {
+		return false
+	}
+	return cfg.Reset <= 0 || at.Sub(p.At) < cfg.Reset
+}
+
+// recordPresence notes that code opened the gate at source.
+func (g *GateController) recordPresence(source, code string, at time.Time) {
+	g.mu.Lock()
+	cfg := g.antiPassback
+	g.mu.Unlock()
+	if cfg.Mode == AntiPassbackOff {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	presence := database.Presence{Code: code, Inside: !cfg.exit(source), At: at}
+	if err := g.gm.PutPresence(ctx, presence); err != nil {
+		log.Printf("Failed to save anti-passback presence for %s: %v", code, err)
+	}
+}
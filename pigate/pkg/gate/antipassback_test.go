@@ -0,0 +1,171 @@
+package gate
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"pigate/pkg/database"
+	"pigate/pkg/messenger"
+)
+
+// presenceMap keeps presence in memory.
+type presenceMap struct {
+	mu       sync.Mutex
+	presence map[string]database.Presence
+}
+
+func (m *presenceMap) PutPresence(ctx context.Context, p database.Presence) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.presence == nil {
+		m.presence = make(map[string]database.Presence)
+	}
+	m.presence[p.Code] = p
+	return nil
+}
+
+func (m *presenceMap) GetPresence(ctx context.Context, code string) (*database.Presence, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.presence[code]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+type presenceGateManager struct {
+	leaseGateManager
+	presenceMap
+}
+
+func TestAntiPassback(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, access := newLeaseController(&now)
+	g.gm = &presenceGateManager{}
+	cfg := AntiPassbackConfig{Mode: AntiPassbackHard, ExitSources: []string{SourceWiegandExit}}
+	if err := g.SetAntiPassback(cfg); err != nil {
+		t.Fatalf("SetAntiPassback() error = %v", err)
+	}
+	use := func(source string) messenger.AccessEvent {
+		t.Helper()
+		_ = g.Close()
+		if err := g.OpenFrom(source, "12345", now); err != nil {
+			t.Fatalf("OpenFrom(%s) error = %v", source, err)
+		}
+		return <-access.events
+	}
+
+	if event := use(SourceWiegand); event.Result != messenger.AccessGranted || event.Reason != "" {
+		t.Errorf("first entry: %+v, want granted", event)
+	}
+	if event := use(SourceOSDP); event.Result != messenger.AccessDenied || event.Reason != ReasonAntiPassback {
+		t.Errorf("second entry: %+v, want denied anti_passback", event)
+	}
+	if g.State() != Closed {
+		t.Errorf("State() = %v after a passback, want closed", g.State())
+	}
+
+	// Exit readers are never a violation, and an exit lets the code in again.
+	for i := 0; i < 2; i++ {
+		if event := use(SourceWiegandExit); event.Result != messenger.AccessGranted {
+			t.Errorf("exit %d: %+v, want granted", i+1, event)
+		}
+	}
+	if event := use(SourceWiegand); event.Result != messenger.AccessGranted {
+		t.Errorf("entry after an exit: %+v, want granted", event)
+	}
+
+	// A free exit leaves the code inside until Reset has passed.
+	_ = g.Exit(SourceREX, now)
+	cfg.Reset = time.Hour
+	_ = g.SetAntiPassback(cfg)
+	now = now.Add(59 * time.Minute)
+	if event := use(SourceWiegand); event.Result != messenger.AccessDenied {
+		t.Errorf("entry before the reset: %+v, want denied", event)
+	}
+	now = now.Add(time.Minute)
+	if event := use(SourceWiegand); event.Result != messenger.AccessGranted {
+		t.Errorf("entry after the reset: %+v, want granted", event)
+	}
+
+	// Soft mode opens and flags the re-entry.
+	cfg.Mode = AntiPassbackSoft
+	_ = g.SetAntiPassback(cfg)
+	if event := use(SourceWiegand); event.Result != messenger.AccessGranted || event.Reason != ReasonAntiPassback {
+		t.Errorf("soft mode re-entry: %+v, want granted anti_passback", event)
+	}
+	if g.State() != Open {
+		t.Errorf("State() = %v after a soft mode re-entry, want open", g.State())
+	}
+}
+
+// cardPresenceGateManager enrolls card 12:34567 with PIN 4321 and keeps
+// presence.
+type cardPresenceGateManager struct {
+	cardGateManager
+	presenceMap
+}
+
+func TestCardPINOnlyAtItsReader(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, access := newLeaseController(&now)
+	gm := &cardPresenceGateManager{cardGateManager: cardGateManager{pin: "4321"}}
+	g.gm = gm
+	cfg := AntiPassbackConfig{Mode: AntiPassbackHard, ExitSources: []string{SourceWiegandExit}}
+	if err := g.SetAntiPassback(cfg); err != nil {
+		t.Fatalf("SetAntiPassback() error = %v", err)
+	}
+	card := Card{Format: "H10301", Facility: 12, Number: 34567}
+	lastSource := func() string {
+		t.Helper()
+		logs := &gm.cardGateManager.leaseGateManager
+		logs.mu.Lock()
+		defer logs.mu.Unlock()
+		if len(logs.logs) == 0 {
+			t.Fatal("nothing written to the gate log")
+		}
+		return logs.logs[len(logs.logs)-1].Source
+	}
+
+	// The PIN typed at the exit keypad is not the entry card's PIN.
+	_ = g.OpenCardFrom(SourceWiegand, card, now)
+	_ = g.OpenFrom(SourceWiegandExit, "4321", now)
+	event := <-access.events
+	if event.Code != "4321" || event.Reason != ReasonUnknownCode || lastSource() != SourceWiegandExit {
+		t.Errorf("code at the exit keypad: %+v, want denied unknown_code as a PIN there", event)
+	}
+	if !g.AwaitingPIN() || g.State() != Closed {
+		t.Fatalf("after a code at another reader: AwaitingPIN() = %t, State() = %v, want waiting and closed", g.AwaitingPIN(), g.State())
+	}
+
+	// At the card's own reader it is, and the card is recorded entering.
+	_ = g.OpenFrom(SourceWiegand, "4321", now)
+	event = <-access.events
+	if event.Code != "12:34567" || event.Result != messenger.AccessGranted || lastSource() != SourceWiegand {
+		t.Errorf("PIN at the card's reader: %+v, want granted at wiegand", event)
+	}
+	if p, _ := gm.GetPresence(context.Background(), "12:34567"); p == nil || !p.Inside {
+		t.Errorf("presence = %+v, want inside", p)
+	}
+}
+
+func TestAntiPassbackConfigValidate(t *testing.T) {
+	for _, cfg := range []AntiPassbackConfig{
+		{Mode: AntiPassbackHard},
+		{Mode: AntiPassbackSoft, ExitSources: []string{SourceOSDP}, Reset: -time.Minute},
+		{Mode: AntiPassbackMode(7), ExitSources: []string{SourceOSDP}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate(%+v) = nil, want an error", cfg)
+		}
+	}
+	if err := (AntiPassbackConfig{}).Validate(); err != nil {
+		t.Errorf("Validate() of the zero config = %v", err)
+	}
+	if _, err := ParseAntiPassbackMode("strict"); err == nil {
+		t.Error("ParseAntiPassbackMode(strict) = nil error")
+	}
+}
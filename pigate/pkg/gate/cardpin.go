@@ -0,0 +1,115 @@
+package gate
+
+import (
+	"crypto/subtle"
+	"log"
+	"time"
+
+	"pigate/pkg/database"
+	"pigate/pkg/messenger"
+)
+
+// DefaultCardPINTimeout is how long the controller waits for the PIN after a
+// card that requires one.
+const DefaultCardPINTimeout = 10 * time.Second
+
+// pendingCard is a card that was accepted and is waiting for its PIN.
+type pendingCard struct {
+	source   string // where the card was read
+	cred     *database.Credential
+	deadline time.Time
+	seq      int // tells a stale expiry timer from the current one
+}
+
+// SetCardPINTimeout sets how long a card that requires a PIN waits for it;
+// zero or less keeps DefaultCardPINTimeout.
+func (g *GateController) SetCardPINTimeout(d time.Duration) {
+	if d <= 0 {
+		d = DefaultCardPINTimeout
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cardPINTimeout = d
+}
+
+// AwaitingPIN reports whether a card has been read and its PIN is expected.
+func (g *GateController) AwaitingPIN() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.pending != nil && g.now().Before(g.pending.deadline)
+}
+
+// awaitPIN holds a card accepted at source until its PIN is entered or the
+// timeout passes. A card still waiting is replaced and logged as missing its
+// PIN.
+func (g *GateController) awaitPIN(source string, cred *database.Credential) {
+	g.mu.Lock()
+	previous := g.pending
+	g.pendingSeq++
+	seq := g.pendingSeq
+	timeout := g.cardPINTimeout
+	g.pending = &pendingCard{source: source, cred: cred, deadline: g.now().Add(timeout), seq: seq}
+	g.mu.Unlock()
+
+	if previous != nil {
+		g.denyPending(previous)
+	}
+	log.Printf("Card %s accepted, waiting %v for PIN", cred.Code, timeout)
+	g.afterFunc(timeout, func() {
+		g.mu.Lock()
+		p := g.pending
+		if p == nil || p.seq != seq {
+			g.mu.Unlock()
+			return
+		}
+		g.pending = nil
+		g.mu.Unlock()
+		g.denyPending(p)
+	})
+}
+
+// takePendingCard returns the card waiting for a PIN from source, if any, and
+// clears it. A card read at another reader keeps waiting. A card whose
+// timeout has passed but whose timer has not yet run is logged and not
+// returned.
+func (g *GateController) takePendingCard(source string) *pendingCard {
+	g.mu.Lock()
+	p := g.pending
+	expired := p != nil && !g.now().Before(p.deadline)
+	if p == nil || (!expired && p.source != source) {
+		g.mu.Unlock()
+		return nil
+	}
+	g.pending = nil
+	g.mu.Unlock()
+
+	if expired {
+		g.denyPending(p)
+		return nil
+	}
+	return p
+}
+
+// completeCardPIN grants the pending card if pin, entered at the card's
+// reader, is its PIN or a duress PIN. The PIN itself is never logged;
+// attempts are recorded under the card's code, and a wrong PIN counts as a
+// failed code.
+func (g *GateController) completeCardPIN(p *pendingCard, pin string, currentTime time.Time) error {
+	source := p.source
+	if subtle.ConstantTimeCompare([]byte(pin), []byte(p.cred.PIN)) != 1 {
+		if !g.isDuressPIN(p.cred, pin) {
+			log.Printf("Card %s: wrong PIN", p.cred.Code)
+			g.recordAccess(source, p.cred.Code, database.CredentialCard, p.cred, messenger.AccessDenied, ReasonPINMismatch, currentTime)
+			g.countFailure(source, currentTime)
+			return nil
+		}
+		g.raiseDuress(source, database.CredentialCard, p.cred, currentTime)
+	}
+	return g.grant(source, p.cred.Code, database.CredentialCard, p.cred, currentTime)
+}
+
+// denyPending logs a card that never got its PIN.
+func (g *GateController) denyPending(p *pendingCard) {
+	log.Printf("Card %s: no PIN entered", p.cred.Code)
+	g.recordAccess(p.source, p.cred.Code, database.CredentialCard, p.cred, messenger.AccessDenied, ReasonPINRequired, g.now())
+}
@@ -0,0 +1,149 @@
+package gate
+
+import (
+	"fmt"
+	"strings"
+
+	"pigate/pkg/database"
+)
+
+// maxCardBits bounds the frames a card format may describe.
+const maxCardBits = 64
+
+// BitField is a run of frame bits, counted from 0 at the first bit sent.
+type BitField struct {
+	Start  int
+	Length int
+}
+
+// ParityCheck is a parity bit covering a run of frame bits.
+type ParityCheck struct {
+	Bit  int      // position of the parity bit
+	Odd  bool     // odd parity; even otherwise
+	Over BitField // bits the parity is computed over
+}
+
+// CardFormat describes how a Wiegand card frame of a given length is laid out.
+type CardFormat struct {
+	Name     string
+	Bits     int
+	Facility BitField // zero Length for formats without a facility code
+	Number   BitField
+	Parity   []ParityCheck
+}
+
+// Card is a decoded card or fob scan.
+type Card struct {
+	Format   string
+	Facility uint32
+	Number   uint32
+}
+
+// Code returns the credential code the card is enrolled under.
+func (c Card) Code() string {
+	return database.CardCode(c.Facility, c.Number)
+}
+
+// StandardCardFormats are the formats readers commonly send: HID H10301
+// 26-bit, H10306 34-bit and H10304 37-bit.
+var StandardCardFormats = []CardFormat{
+	{
+		Name:     "H10301",
+		Bits:     26,
+		Facility: BitField{Start: 1, Length: 8},
+		Number:   BitField{Start: 9, Length: 16},
+		Parity: []ParityCheck{
+			{Bit: 0, Over: BitField{Start: 1, Length: 12}},
+			{Bit: 25, Odd: true, Over: BitField{Start: 13, Length: 12}},
+		},
+	},
+	{
+		Name:     "H10306",
+		Bits:     34,
+		Facility: BitField{Start: 1, Length: 16},
+		Number:   BitField{Start: 17, Length: 16},
+		Parity: []ParityCheck{
+			{Bit: 0, Over: BitField{Start: 1, Length: 16}},
+			{Bit: 33, Odd: true, Over: BitField{Start: 17, Length: 16}},
+		},
+	},
+	{
+		Name:     "H10304",
+		Bits:     37,
+		Facility: BitField{Start: 1, Length: 16},
+		Number:   BitField{Start: 17, Length: 19},
+		Parity: []ParityCheck{
+			{Bit: 0, Over: BitField{Start: 1, Length: 18}},
+			{Bit: 36, Odd: true, Over: BitField{Start: 18, Length: 18}},
+		},
+	},
+}
+
+// LookupCardFormat returns the standard format with the given name, ignoring
+// case.
+func LookupCardFormat(name string) (CardFormat, bool) {
+	for _, f := range StandardCardFormats {
+		if strings.EqualFold(f.Name, name) {
+			return f, true
+		}
+	}
+	return CardFormat{}, false
+}
+
+// Validate reports fields or parity bits that fall outside the frame.
+func (f CardFormat) Validate() error {
+	if f.Bits <= 0 || f.Bits > maxCardBits {
+		return fmt.Errorf("card format %s: frame must be 1 to %d bits, got %d", f.Name, maxCardBits, f.Bits)
+	}
+	inFrame := func(b BitField) bool {
+		return b.Start >= 0 && b.Length >= 0 && b.Length <= 32 && b.Start+b.Length <= f.Bits
+	}
+	if !inFrame(f.Facility) {
+		return fmt.Errorf("card format %s: facility code outside the frame", f.Name)
+	}
+	if f.Number.Length == 0 || !inFrame(f.Number) {
+		return fmt.Errorf("card format %s: card number outside the frame", f.Name)
+	}
+	for _, p := range f.Parity {
+		if p.Bit < 0 || p.Bit >= f.Bits || !inFrame(p.Over) {
+			return fmt.Errorf("card format %s: parity bit %d outside the frame", f.Name, p.Bit)
+		}
+	}
+	return nil
+}
+
+// Decode checks the parity of a frame in this format and extracts the
+// facility code and card number.
+func (f CardFormat) Decode(bits []int) (Card, error) {
+	if len(bits) != f.Bits {
+		return Card{}, fmt.Errorf("%s frame must be %d bits, got %d", f.Name, f.Bits, len(bits))
+	}
+	for _, b := range bits {
+		if b != 0 && b != 1 {
+			return Card{}, fmt.Errorf("invalid bit %d in frame", b)
+		}
+	}
+	for _, p := range f.Parity {
+		ones := bits[p.Bit]
+		for _, b := range bits[p.Over.Start : p.Over.Start+p.Over.Length] {
+			ones += b
+		}
+		if (ones%2 == 1) != p.Odd {
+			return Card{}, fmt.Errorf("%s parity bit %d does not match", f.Name, p.Bit)
+		}
+	}
+	return Card{
+		Format:   f.Name,
+		Facility: bitsValue(bits, f.Facility),
+		Number:   bitsValue(bits, f.Number),
+	}, nil
+}
+
+// bitsValue reads field MSB first.
+func bitsValue(bits []int, field BitField) uint32 {
+	var v uint32
+	for _, b := range bits[field.Start : field.Start+field.Length] {
+		v = v<<1 | uint32(b)
+	}
+	return v
+}
@@ -0,0 +1,303 @@
+package gate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pigate/pkg/database"
+	"pigate/pkg/gpio"
+	"pigate/pkg/messenger"
+)
+
+// bitString turns "1001..." into frame bits.
+func bitString(s string) []int {
+	bits := make([]int, len(s))
+	for i, c := range s {
+		bits[i] = int(c - '0')
+	}
+	return bits
+}
+
+func TestCardFormatDecode(t *testing.T) {
+	tests := []struct {
+		format  string
+		bits    string
+		want    Card
+		wantErr bool
+	}{
+		{format: "H10301", bits: "10000110010000111000001111", want: Card{Format: "H10301", Facility: 12, Number: 34567}},
+		{format: "H10301", bits: "00000110010000111000001111", wantErr: true}, // even parity flipped
+		{format: "H10301", bits: "10000110010000111000001110", wantErr: true}, // odd parity flipped
+		{format: "H10301", bits: "1000011001000011100000111", wantErr: true},  // 25 bits
+		{format: "H10306", bits: "0000000111110100011010100001100010", want: Card{Format: "H10306", Facility: 1000, Number: 54321}},
+		{format: "H10304", bits: "0111111111111111111111111111111111111", want: Card{Format: "H10304", Facility: 65535, Number: 524287}},
+	}
+	for _, tt := range tests {
+		format, ok := LookupCardFormat(tt.format)
+		if !ok {
+			t.Fatalf("LookupCardFormat(%q) not found", tt.format)
+		}
+		got, err := format.Decode(bitString(tt.bits))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s.Decode(%s) = %+v, %v, want %+v (error %t)", tt.format, tt.bits, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestStandardCardFormatsValidate(t *testing.T) {
+	for _, f := range StandardCardFormats {
+		if err := f.Validate(); err != nil {
+			t.Errorf("%s: Validate() = %v", f.Name, err)
+		}
+	}
+	bad := CardFormat{Name: "short", Bits: 8, Number: BitField{Start: 4, Length: 8}}
+	if err := bad.Validate(); err == nil {
+		t.Error("Validate() accepted a card number past the end of the frame")
+	}
+
+	cfg := DefaultKeypadConfig()
+	cfg.CardFormats = []CardFormat{{Name: "nibble", Bits: KeypadFrame4, Number: BitField{Length: 4}}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("KeypadConfig.Validate() accepted a card format the length of a key")
+	}
+}
+
+func TestKeypadReaderCardsAndKeys(t *testing.T) {
+	cfg := DefaultKeypadConfig()
+	cfg.CodeLength = 0
+	cfg.CardFormats = StandardCardFormats
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	pins := gpio.NewFake(func() time.Time { return now })
+	reader := NewKeypadReader(pins, cfg)
+	metrics := &parseErrorCounter{}
+	reader.SetMetrics(metrics)
+	cards := make(chan Card, 4)
+	reader.SetCardHandler(func(card Card) { cards <- card })
+	codes := make(chan string, 4)
+	if err := reader.Start(func(code string) { codes <- code }); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(reader.Stop)
+
+	sendBits := func(s string) {
+		t.Helper()
+		for _, bit := range bitString(s) {
+			pin := cfg.D0Pin
+			if bit == 1 {
+				pin = cfg.D1Pin
+			}
+			if err := pins.Pulse(pin); err != nil {
+				t.Fatalf("Pulse(%d) error = %v", pin, err)
+			}
+		}
+		now = now.Add(time.Second) // frames are told apart by the gap after them
+	}
+	wantCard := func(want Card) {
+		t.Helper()
+		select {
+		case got := <-cards:
+			if got != want {
+				t.Errorf("card = %+v, want %+v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no card received, want %+v", want)
+		}
+	}
+
+	sendBits("10000110010000111000001111")
+	sendBits("0100") // 4
+	sendBits("0010") // 2
+	sendBits("1011") // #
+	wantCard(Card{Format: "H10301", Facility: 12, Number: 34567})
+	wantCode(t, codes, "42")
+
+	sendBits("00000110010000111000001111") // bad parity: dropped
+	sendBits("0111111111111111111111111111111111111")
+	wantCard(Card{Format: "H10304", Facility: 65535, Number: 524287})
+	if got := metrics.count(); got != 1 {
+		t.Errorf("parse errors = %d, want 1", got)
+	}
+}
+
+// TestKeypadReaderSplitsQueuedFrames holds the reader in its card handler
+// while further frames arrive, so they are read together long after their
+// edges. They must still be split on the edge times.
+func TestKeypadReaderSplitsQueuedFrames(t *testing.T) {
+	cfg := DefaultKeypadConfig()
+	cfg.CodeLength = 0
+	cfg.CardFormats = StandardCardFormats
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	pins := gpio.NewFake(func() time.Time { return now })
+	reader := NewKeypadReader(pins, cfg)
+	metrics := &parseErrorCounter{}
+	reader.SetMetrics(metrics)
+	cards := make(chan Card, 4)
+	release := make(chan struct{})
+	reader.SetCardHandler(func(card Card) {
+		cards <- card
+		<-release
+	})
+	codes := make(chan string, 4)
+	if err := reader.Start(func(code string) { codes <- code }); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(reader.Stop)
+	sendBits := func(s string) {
+		t.Helper()
+		for _, bit := range bitString(s) {
+			pin := cfg.D0Pin
+			if bit == 1 {
+				pin = cfg.D1Pin
+			}
+			if err := pins.Pulse(pin); err != nil {
+				t.Fatalf("Pulse(%d) error = %v", pin, err)
+			}
+		}
+	}
+	const card = "10000110010000111000001111"
+	want := Card{Format: "H10301", Facility: 12, Number: 34567}
+
+	sendBits(card)
+	if got := <-cards; got != want {
+		t.Fatalf("card = %+v, want %+v", got, want)
+	}
+	// The reader is stuck in the handler; a key press and another card are
+	// queued, each frame a second after the last by its edges.
+	for _, frame := range []string{"0001", "0010", "1011", card} {
+		now = now.Add(time.Second)
+		sendBits(frame)
+	}
+	close(release)
+
+	wantCode(t, codes, "12")
+	select {
+	case got := <-cards:
+		if got != want {
+			t.Errorf("queued card = %+v, want %+v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued card not received")
+	}
+	if got := metrics.count(); got != 0 {
+		t.Errorf("parse errors = %d, want 0", got)
+	}
+}
+
+// cardGateManager enrolls only card 12:34567, with pin if set.
+type cardGateManager struct {
+	leaseGateManager
+	pin string
+}
+
+func (m *cardGateManager) GetCredential(ctx context.Context, code string) (*database.Credential, error) {
+	if code != database.CardCode(12, 34567) {
+		return nil, nil
+	}
+	cred, _ := m.leaseGateManager.GetCredential(ctx, code)
+	cred.Type, cred.PIN = database.CredentialCard, m.pin
+	return cred, nil
+}
+
+func TestOpenCardOnlyMatchesCards(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, access := newLeaseController(&now)
+	g.gm = &cardGateManager{}
+
+	if err := g.Open(database.CardCode(12, 34567), now); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	event := <-access.events
+	if event.Result != messenger.AccessDenied || event.Reason != ReasonUnknownCode || event.CredentialType != "pin" {
+		t.Errorf("card code typed as a PIN: %+v, want denied unknown_code pin", event)
+	}
+
+	if err := g.OpenCard(Card{Format: "H10301", Facility: 12, Number: 34567}, now); err != nil {
+		t.Fatalf("OpenCard() error = %v", err)
+	}
+	event = <-access.events
+	if event.Result != messenger.AccessGranted || event.CredentialType != "card" || event.Code != "12:34567" {
+		t.Errorf("card scan: %+v, want granted card 12:34567", event)
+	}
+	if g.State() != Open {
+		t.Errorf("State() = %v, want open", g.State())
+	}
+}
+
+func TestCardWithPIN(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, access := newLeaseController(&now)
+	g.gm = &cardGateManager{pin: "4321"}
+	var expire func()
+	g.afterFunc = func(d time.Duration, f func()) {
+		if d == DefaultCardPINTimeout {
+			expire = f
+		}
+	}
+	card := Card{Format: "H10301", Facility: 12, Number: 34567}
+	wantEvent := func(result, reason string) {
+		t.Helper()
+		event := <-access.events
+		if event.Result != result || event.Reason != reason || event.Code != "12:34567" || event.CredentialType != "card" {
+			t.Errorf("event = %+v, want %s %q for card 12:34567", event, result, reason)
+		}
+	}
+
+	// Card alone opens nothing and waits; the timeout logs it as denied.
+	if err := g.OpenCard(card, now); err != nil {
+		t.Fatalf("OpenCard() error = %v", err)
+	}
+	if !g.AwaitingPIN() || g.State() != Closed {
+		t.Fatalf("after card: AwaitingPIN() = %t, State() = %v, want waiting and closed", g.AwaitingPIN(), g.State())
+	}
+	now = now.Add(DefaultCardPINTimeout)
+	expire()
+	wantEvent(messenger.AccessDenied, ReasonPINRequired)
+
+	// The wrong PIN is denied under the card's code and ends the attempt.
+	_ = g.OpenCard(card, now)
+	if err := g.Open("1111", now); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	wantEvent(messenger.AccessDenied, ReasonPINMismatch)
+	if g.AwaitingPIN() {
+		t.Error("still waiting for a PIN after a wrong one")
+	}
+
+	// A PIN after the deadline is not matched to the card, even if the
+	// timer has not run yet.
+	_ = g.OpenCard(card, now)
+	now = now.Add(DefaultCardPINTimeout + time.Second)
+	_ = g.Open("4321", now)
+	// Events are published concurrently, so they may arrive in either order.
+	for range 2 {
+		event := <-access.events
+		switch event.Code {
+		case "12:34567":
+			if event.Reason != ReasonPINRequired {
+				t.Errorf("expired card event = %+v, want pin_required", event)
+			}
+		case "4321":
+			if event.Reason != ReasonUnknownCode || event.CredentialType != "pin" {
+				t.Errorf("late PIN event = %+v, want it checked as a PIN", event)
+			}
+		default:
+			t.Errorf("unexpected event %+v", event)
+		}
+	}
+	if g.State() != Closed {
+		t.Fatalf("State() = %v after a late PIN, want closed", g.State())
+	}
+
+	// Card then its PIN opens the gate.
+	_ = g.OpenCard(card, now)
+	if err := g.Open("4321", now); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	wantEvent(messenger.AccessGranted, "")
+	if g.State() != Open {
+		t.Errorf("State() = %v, want open", g.State())
+	}
+}
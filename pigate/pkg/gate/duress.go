@@ -0,0 +1,108 @@
+package gate
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"pigate/pkg/database"
+	"pigate/pkg/messenger"
+)
+
+// DuressNotifier receives a duress event whenever a duress code is entered.
+// Nothing at the gate shows that one was: it opens, and the reader shows the
+// grant, as for the credential's own code.
+type DuressNotifier interface {
+	NotifyDuress(event messenger.DuressEvent) error
+}
+
+func (g *GateController) SetDuressNotifier(notifier DuressNotifier) {
+	g.duressNotifier = notifier
+}
+
+// SetDuressLastDigit makes each PIN with its last digit incremented, 9
+// wrapping to 0, a duress code for its credential; for cards, the same goes
+// for the PIN after the card. This is in addition to any DuressCode enrolled.
+// A code that is enrolled in its own right still opens as itself.
+func (g *GateController) SetDuressLastDigit(enabled bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.duressLastDigit = enabled
+}
+
+// shiftLastDigit returns code with its last digit moved by delta, wrapping
+// within 0-9, or "" when code does not end in a digit.
+func shiftLastDigit(code string, delta int) string {
+	if code == "" {
+		return ""
+	}
+	last := code[len(code)-1]
+	if last < '0' || last > '9' {
+		return ""
+	}
+	digit := (int(last-'0') + delta%10 + 10) % 10
+	return code[:len(code)-1] + string(rune('0'+digit))
+}
+
+// duressCredential returns the PIN credential that code is a duress code
+// for, or nil.
+func (g *GateController) duressCredential(code string) *database.Credential {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cred, err := g.gm.GetCredentialByDuressCode(ctx, code)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Duress code lookup error: %v", err)
+	}
+	if err == nil && cred != nil {
+		return cred
+	}
+
+	g.mu.Lock()
+	lastDigit := g.duressLastDigit
+	g.mu.Unlock()
+	own := shiftLastDigit(code, -1)
+	if !lastDigit || own == "" {
+		return nil
+	}
+	cred, err = g.gm.GetCredential(ctx, own)
+	if err != nil || cred == nil || cred.IsCard() {
+		return nil
+	}
+	return cred
+}
+
+// isDuressPIN reports whether pin is a duress PIN for card cred.
+func (g *GateController) isDuressPIN(cred *database.Credential, pin string) bool {
+	g.mu.Lock()
+	lastDigit := g.duressLastDigit
+	g.mu.Unlock()
+	matches := func(duress string) bool {
+		return duress != "" && subtle.ConstantTimeCompare([]byte(pin), []byte(duress)) == 1
+	}
+	return matches(cred.DuressCode) || (lastDigit && matches(shiftLastDigit(cred.PIN, 1)))
+}
+
+// raiseDuress publishes the duress alarm for cred, entered at source.
+func (g *GateController) raiseDuress(source string, credType database.CredentialType, cred *database.Credential, at time.Time) {
+	log.Printf("Duress code entered for credential %s at %s", cred.Code, source)
+	notifier := g.duressNotifier
+	if notifier == nil {
+		return
+	}
+	event := messenger.DuressEvent{
+		Code:           cred.Code,
+		CredentialType: string(credType),
+		Username:       cred.Username,
+		Source:         source,
+		At:             at,
+	}
+	go func() {
+		if err := notifier.NotifyDuress(event); err != nil {
+			log.Printf("Failed to publish duress event: %v", err)
+		}
+	}()
+}
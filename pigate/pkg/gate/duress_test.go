@@ -0,0 +1,156 @@
+package gate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pigate/pkg/database"
+	"pigate/pkg/messenger"
+)
+
+// duressGateManager serves the credentials in creds, valid all day.
+type duressGateManager struct {
+	leaseGateManager
+	creds []database.Credential
+}
+
+func (m *duressGateManager) GetCredential(ctx context.Context, code string) (*database.Credential, error) {
+	for _, c := range m.creds {
+		if c.Code == code {
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *duressGateManager) GetCredentialByDuressCode(ctx context.Context, code string) (*database.Credential, error) {
+	for _, c := range m.creds {
+		if c.DuressCode == code && !c.IsCard() {
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+type duressRecorder struct {
+	events chan messenger.DuressEvent
+}
+
+func (r *duressRecorder) NotifyDuress(event messenger.DuressEvent) error {
+	r.events <- event
+	return nil
+}
+
+func newDuressController(now *time.Time, creds ...database.Credential) (*GateController, *accessRecorder, *duressRecorder) {
+	g, access := newLeaseController(now)
+	g.gm = &duressGateManager{creds: creds}
+	duress := &duressRecorder{events: make(chan messenger.DuressEvent, 4)}
+	g.SetDuressNotifier(duress)
+	return g, access, duress
+}
+
+func TestDuressCode(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, access, duress := newDuressController(&now,
+		database.Credential{Code: "12345", Username: "staff", OpenMode: database.RegularOpen, DuressCode: "99999"},
+		database.Credential{Code: "12346", Username: "tenant", OpenMode: database.RegularOpen},
+	)
+	enter := func(code string) messenger.AccessEvent {
+		t.Helper()
+		_ = g.Close()
+		if err := g.OpenFrom(SourceOSDP, code, now); err != nil {
+			t.Fatalf("OpenFrom(%s) error = %v", code, err)
+		}
+		return <-access.events
+	}
+
+	// The duress code opens as the credential's own code would, and the
+	// access event does not tell them apart.
+	event := enter("99999")
+	if g.State() != Open || event.Result != messenger.AccessGranted || event.Code != "12345" || event.Username != "staff" {
+		t.Errorf("duress code: state %v, access event %+v; want open, granted as 12345", g.State(), event)
+	}
+	alarm := <-duress.events
+	want := messenger.DuressEvent{Code: "12345", CredentialType: "pin", Username: "staff", Source: SourceOSDP, At: now}
+	if alarm != want {
+		t.Errorf("duress event = %+v, want %+v", alarm, want)
+	}
+
+	// Last-digit variants only count once enabled, and never shadow a code
+	// enrolled in its own right.
+	if event := enter("12347"); event.Result != messenger.AccessDenied || event.Reason != ReasonUnknownCode {
+		t.Errorf("12347 before SetDuressLastDigit: %+v, want denied unknown code", event)
+	}
+	g.SetDuressLastDigit(true)
+	if event := enter("12346"); event.Result != messenger.AccessGranted || event.Username != "tenant" {
+		t.Errorf("12346: %+v, want granted to tenant", event)
+	}
+	if event := enter("12347"); event.Result != messenger.AccessGranted || event.Code != "12346" {
+		t.Errorf("12347: %+v, want granted as 12346", event)
+	}
+	if alarm := <-duress.events; alarm.Code != "12346" {
+		t.Errorf("duress event = %+v, want 12346", alarm)
+	}
+	if len(duress.events) > 0 {
+		t.Errorf("unexpected duress event %+v", <-duress.events)
+	}
+}
+
+func TestDuressCardPIN(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	card := Card{Format: "H10301", Facility: 12, Number: 34567}
+	g, access, duress := newDuressController(&now, database.Credential{
+		Code: card.Code(), Username: "fob", OpenMode: database.RegularOpen,
+		Type: database.CredentialCard, PIN: "4321", DuressCode: "8888",
+	})
+	g.SetDuressLastDigit(true)
+
+	tests := []struct {
+		pin        string
+		wantResult string
+		wantDuress bool
+	}{
+		{pin: "4321", wantResult: messenger.AccessGranted},
+		{pin: "8888", wantResult: messenger.AccessGranted, wantDuress: true},
+		{pin: "4322", wantResult: messenger.AccessGranted, wantDuress: true},
+		{pin: "4320", wantResult: messenger.AccessDenied},
+	}
+	for _, tt := range tests {
+		_ = g.Close()
+		_ = g.OpenCard(card, now)
+		_ = g.Open(tt.pin, now)
+		event := <-access.events
+		if event.Result != tt.wantResult || event.Code != card.Code() {
+			t.Errorf("PIN %s: access event %+v, want %s for the card", tt.pin, event, tt.wantResult)
+		}
+		if tt.wantDuress {
+			if alarm := <-duress.events; alarm.Code != card.Code() || alarm.CredentialType != "card" {
+				t.Errorf("PIN %s: duress event %+v, want the card", tt.pin, alarm)
+			}
+		}
+	}
+	if len(duress.events) > 0 {
+		t.Errorf("unexpected duress event %+v", <-duress.events)
+	}
+}
+
+func TestShiftLastDigit(t *testing.T) {
+	tests := []struct {
+		code  string
+		delta int
+		want  string
+	}{
+		{"12345", 1, "12346"},
+		{"12349", 1, "12340"},
+		{"12340", -1, "12349"},
+		{"12:34567", 1, "12:34568"},
+		{"1234#", 1, ""},
+		{"", 1, ""},
+	}
+	for _, tt := range tests {
+		if got := shiftLastDigit(tt.code, tt.delta); got != tt.want {
+			t.Errorf("shiftLastDigit(%q, %d) = %q, want %q", tt.code, tt.delta, got, tt.want)
+		}
+	}
+}
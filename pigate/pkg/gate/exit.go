@@ -0,0 +1,214 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"pigate/pkg/database"
+	"pigate/pkg/gpio"
+	"pigate/pkg/messenger"
+)
+
+// Sources of free exits, logged in place of a credential's reader and
+// reported to Metrics as command sources.
+const (
+	SourceREX         = "rex"          // request-to-exit button
+	SourceVehicleLoop = "vehicle_loop" // free-exit vehicle loop detector
+)
+
+// ExitConfig describes the request-to-exit button and free-exit vehicle loop,
+// on the relay's chip. Pins of 0 are not wired.
+type ExitConfig struct {
+	REXPin    int
+	LoopPin   int
+	ActiveLow bool
+	Debounce  time.Duration
+}
+
+// DefaultExitConfig is for dry contacts to ground on pulled-up inputs.
+func DefaultExitConfig() ExitConfig {
+	return ExitConfig{ActiveLow: true, Debounce: 50 * time.Millisecond}
+}
+
+// Validate reports inputs that cannot be read.
+func (c ExitConfig) Validate() error {
+	if c.REXPin < 0 || c.LoopPin < 0 {
+		return errors.New("exit input pins must not be negative")
+	}
+	if c.REXPin != 0 && c.REXPin == c.LoopPin {
+		return fmt.Errorf("exit input pin %d is used twice", c.REXPin)
+	}
+	if c.Debounce < 0 {
+		return errors.New("exit input debounce must not be negative")
+	}
+	return nil
+}
+
+// Wired reports whether any input is wired.
+func (c ExitConfig) Wired() bool {
+	return c.REXPin != 0 || c.LoopPin != 0
+}
+
+// ExitInputs reads the request-to-exit button and vehicle loop.
+type ExitInputs struct {
+	driver gpio.Driver
+	cfg    ExitConfig
+
+	mu      sync.Mutex
+	rex     gpio.InputPin
+	loop    gpio.InputPin
+	active  map[string]bool // last state of each input, by source
+	onExit  func(source string)
+	settle  *time.Timer
+	stopped bool
+}
+
+// NewExitInputs prepares the inputs on driver but does not start them.
+func NewExitInputs(driver gpio.Driver, cfg ExitConfig) *ExitInputs {
+	return &ExitInputs{driver: driver, cfg: cfg, active: make(map[string]bool)}
+}
+
+// Start requests the wired inputs and calls onExit with an input's source
+// each time it becomes active and holds for Debounce. An input that is
+// already active at start does not count until it is released.
+func (e *ExitInputs) Start(onExit func(source string)) error {
+	if err := e.cfg.Validate(); err != nil {
+		return err
+	}
+	request := func(name string, pin int) (gpio.InputPin, error) {
+		if pin == 0 {
+			return nil, nil
+		}
+		in, err := e.driver.Input(pin, gpio.BothEdges, e.edge)
+		if err != nil {
+			return nil, fmt.Errorf("request %s line: %w", name, err)
+		}
+		return in, nil
+	}
+	var err error
+	e.mu.Lock()
+	e.onExit = onExit
+	if e.rex, err = request("request-to-exit", e.cfg.REXPin); err == nil {
+		e.loop, err = request("vehicle loop", e.cfg.LoopPin)
+	}
+	e.mu.Unlock()
+	if err != nil {
+		e.Stop()
+		return err
+	}
+
+	log.Printf("Exit inputs started (rex=%d, loop=%d)", e.cfg.REXPin, e.cfg.LoopPin)
+	active := e.read()
+	e.mu.Lock()
+	e.active = active
+	e.mu.Unlock()
+	return nil
+}
+
+// read returns whether each wired input is active. An input that cannot be
+// read keeps its last state. Callers must not hold e.mu.
+func (e *ExitInputs) read() map[string]bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	active := make(map[string]bool)
+	for source, in := range map[string]gpio.InputPin{SourceREX: e.rex, SourceVehicleLoop: e.loop} {
+		if in == nil {
+			continue
+		}
+		high, err := in.Read()
+		if err != nil {
+			log.Printf("Failed to read %s input: %v", source, err)
+			active[source] = e.active[source]
+			continue
+		}
+		active[source] = high != e.cfg.ActiveLow
+	}
+	return active
+}
+
+// edge is called from the driver on every change of an input. Buttons and
+// loop detector relays bounce, so the inputs are read once they have been
+// quiet for Debounce.
+func (e *ExitInputs) edge(gpio.EdgeEvent) {
+	if e.cfg.Debounce <= 0 {
+		e.report()
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped {
+		return
+	}
+	if e.settle == nil {
+		e.settle = time.AfterFunc(e.cfg.Debounce, e.report)
+	} else {
+		e.settle.Reset(e.cfg.Debounce)
+	}
+}
+
+// report passes each input that has become active to onExit.
+func (e *ExitInputs) report() {
+	active := e.read()
+	e.mu.Lock()
+	if e.stopped || e.onExit == nil {
+		e.mu.Unlock()
+		return
+	}
+	var exits []string
+	for _, source := range []string{SourceREX, SourceVehicleLoop} {
+		if active[source] && !e.active[source] {
+			exits = append(exits, source)
+		}
+	}
+	e.active = active
+	onExit := e.onExit
+	e.mu.Unlock()
+	for _, source := range exits {
+		onExit(source)
+	}
+}
+
+// Stop releases the inputs. Closing a line waits for its edge handler, which
+// takes e.mu, so the lines are closed after it is released.
+func (e *ExitInputs) Stop() {
+	e.mu.Lock()
+	e.stopped = true
+	if e.settle != nil {
+		e.settle.Stop()
+	}
+	inputs := []gpio.InputPin{e.rex, e.loop}
+	e.mu.Unlock()
+	for _, in := range inputs {
+		if in != nil {
+			_ = in.Close()
+		}
+	}
+}
+
+// Exit opens the gate for the free-exit input at source, without a
+// credential. Exits are written to the gate log under source with no code.
+// During a lockdown they open the gate unless SetSuppressExitInLockdown is
+// set, and during an update lease they do not.
+func (g *GateController) Exit(source string, at time.Time) error {
+	status := database.StatusGranted
+	switch err := g.openFor(true); {
+	case err == nil:
+		log.Printf("Exit requested at %s", source)
+		g.metrics.Command(source, messenger.CommandOpenMessage)
+	case errors.Is(err, ErrUpdateInProgress), errors.Is(err, ErrLockdown):
+		log.Printf("Not opening the gate for %s: %v", source, err)
+		status = database.StatusDenied
+	default:
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := g.gm.PutGateLog(ctx, database.GateLog{Time: at, Status: status, Source: source}); err != nil {
+		log.Printf("Failed to write gate log: %v", err)
+	}
+	return nil
+}
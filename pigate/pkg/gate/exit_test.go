@@ -0,0 +1,167 @@
+package gate
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"pigate/pkg/database"
+	"pigate/pkg/gpio"
+	"pigate/pkg/messenger"
+)
+
+func TestExitInputs(t *testing.T) {
+	const rexPin, loopPin = 16, 20
+	pins := gpio.NewFake(nil)
+	cfg := ExitConfig{REXPin: rexPin, LoopPin: loopPin, ActiveLow: true}
+	exits := make(chan string, 8)
+	start := func() *ExitInputs {
+		t.Helper()
+		inputs := NewExitInputs(pins, cfg)
+		if err := inputs.Start(func(source string) { exits <- source }); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		return inputs
+	}
+	inputs := start()
+	inject := func(pin int, edge gpio.Edge) {
+		t.Helper()
+		if err := pins.Inject(pin, edge); err != nil {
+			t.Fatalf("Inject(%d) error = %v", pin, err)
+		}
+	}
+
+	inject(rexPin, gpio.FallingEdge)
+	inject(rexPin, gpio.RisingEdge)
+	inject(loopPin, gpio.FallingEdge)
+	inject(loopPin, gpio.FallingEdge) // still occupied
+	inject(rexPin, gpio.FallingEdge)
+	for _, want := range []string{SourceREX, SourceVehicleLoop, SourceREX} {
+		if got := <-exits; got != want {
+			t.Errorf("exit from %s, want %s", got, want)
+		}
+	}
+	if len(exits) > 0 {
+		t.Errorf("unexpected exit from %s", <-exits)
+	}
+
+	// A vehicle already on the loop at start is not an exit. Fake inputs
+	// idle high, so an active-high loop starts occupied.
+	inputs.Stop()
+	cfg.ActiveLow = false
+	inputs = start()
+	defer inputs.Stop()
+	inject(loopPin, gpio.RisingEdge)
+	if len(exits) > 0 {
+		t.Errorf("exit from %s for a vehicle on the loop at start", <-exits)
+	}
+	inject(loopPin, gpio.FallingEdge)
+	inject(loopPin, gpio.RisingEdge)
+	if got := <-exits; got != SourceVehicleLoop {
+		t.Errorf("exit from %s, want vehicle_loop", got)
+	}
+
+	if err := (ExitConfig{REXPin: 16, LoopPin: 16}).Validate(); err == nil {
+		t.Error("Validate() accepted one pin for both inputs")
+	}
+}
+
+func TestExitInputsStopWithEdgeInFlight(t *testing.T) {
+	for _, debounce := range []time.Duration{0, 20 * time.Millisecond} {
+		cfg := ExitConfig{REXPin: 16, LoopPin: 20, ActiveLow: true, Debounce: debounce}
+		inputs := NewExitInputs(closingDriver{gpio.NewFake(nil)}, cfg)
+		if err := inputs.Start(func(string) {}); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		stopsWithin(t, inputs.Stop)
+	}
+}
+
+func TestExitOpensWithoutCredential(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, access := newLeaseController(&now)
+	gm := g.gm.(*leaseGateManager)
+	lastLog := func() database.GateLog {
+		t.Helper()
+		gm.mu.Lock()
+		defer gm.mu.Unlock()
+		if len(gm.logs) == 0 {
+			t.Fatal("nothing written to the gate log")
+		}
+		return gm.logs[len(gm.logs)-1]
+	}
+
+	if err := g.Exit(SourceREX, now); err != nil {
+		t.Fatalf("Exit() error = %v", err)
+	}
+	if g.State() != Open {
+		t.Errorf("State() = %v after an exit, want open", g.State())
+	}
+	if l := lastLog(); l.Source != SourceREX || l.Status != database.StatusGranted || l.Code != "" {
+		t.Errorf("gate log %+v, want granted at rex without a code", l)
+	}
+	if len(access.events) > 0 {
+		t.Errorf("exit published as an access event: %+v", <-access.events)
+	}
+
+	// A lockdown closes the gate and denies credentials, but exits still
+	// open it until they are suppressed.
+	g.SetLockdown(true)
+	if g.State() != Closed {
+		t.Fatalf("State() = %v in lockdown, want closed", g.State())
+	}
+	_ = g.Open("12345", now)
+	if event := <-access.events; event.Result != messenger.AccessDenied || event.Reason != ReasonLockdown {
+		t.Errorf("code in lockdown: %+v, want denied lockdown", event)
+	}
+	_ = g.Exit(SourceVehicleLoop, now)
+	if g.State() != Open {
+		t.Errorf("State() = %v after an exit in lockdown, want open", g.State())
+	}
+	_ = g.Close()
+	g.SetSuppressExitInLockdown(true)
+	_ = g.Exit(SourceVehicleLoop, now)
+	if l := lastLog(); g.State() != Closed || l.Source != SourceVehicleLoop || l.Status != database.StatusDenied {
+		t.Errorf("suppressed exit: state %v, gate log %+v; want closed, denied at vehicle_loop", g.State(), l)
+	}
+
+	g.SetLockdown(false)
+	_ = g.Exit(SourceVehicleLoop, now)
+	if g.State() != Open {
+		t.Errorf("State() = %v after the lockdown ended, want open", g.State())
+	}
+}
+
+// commandRecorder records the sources of Metrics commands.
+type commandRecorder struct {
+	noMetrics
+	mu      sync.Mutex
+	sources []string
+}
+
+func (r *commandRecorder) Command(source, command string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources = append(r.sources, source)
+}
+
+func TestCommandSourceFollowsReader(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, access := newLeaseController(&now)
+	commands := &commandRecorder{}
+	g.SetMetrics(commands)
+
+	for _, source := range []string{SourceWiegand, SourceWiegandExit, SourceOSDP} {
+		_ = g.Close()
+		if err := g.OpenFrom(source, "12345", now); err != nil {
+			t.Fatalf("OpenFrom(%s) error = %v", source, err)
+		}
+		<-access.events
+	}
+	_ = g.Exit(SourceREX, now)
+	want := []string{CommandSourceKeypad, CommandSourceExitKeypad, CommandSourceOSDP, SourceREX}
+	if !slices.Equal(commands.sources, want) {
+		t.Errorf("command sources = %v, want %v", commands.sources, want)
+	}
+}
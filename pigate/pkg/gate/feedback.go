@@ -0,0 +1,323 @@
+package gate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pigate/pkg/gpio"
+)
+
+// Beeps are short and fixed; patterns only choose how many.
+const (
+	beepOn    = 100 * time.Millisecond
+	beepCycle = 200 * time.Millisecond
+	maxBeeps  = 10
+)
+
+// Color is a reader LED color.
+type Color int
+
+const (
+	ColorOff Color = iota
+	ColorRed
+	ColorGreen
+	ColorAmber // red and green together on two-line readers
+)
+
+var colorNames = []string{"off", "red", "green", "amber"}
+
+func (c Color) String() string {
+	if c < 0 || int(c) >= len(colorNames) {
+		return fmt.Sprintf("Color(%d)", int(c))
+	}
+	return colorNames[c]
+}
+
+// Pattern is what a reader's LED and beeper show for one gate event. A
+// pattern with a fixed number of flashes plays over one that repeats, which
+// resumes when it ends.
+type Pattern struct {
+	Color   Color
+	Flashes int           // times the LED flashes; 0 repeats until the next pattern
+	On, Off time.Duration // one flash; with Off of zero the LED stays lit
+	Beeps   int           // short beeps at the start of the pattern
+}
+
+// Feedback holds the pattern shown for each gate event.
+type Feedback struct {
+	Granted    Pattern
+	Denied     Pattern
+	LockedOpen Pattern
+	Idle       Pattern // while the gate is closed
+}
+
+// DefaultFeedback double-blinks green on a grant, shows red with three beeps
+// on a denial and flashes green slowly while the gate is locked open.
+func DefaultFeedback() Feedback {
+	return Feedback{
+		Granted:    Pattern{Color: ColorGreen, Flashes: 2, On: 200 * time.Millisecond, Off: 200 * time.Millisecond},
+		Denied:     Pattern{Color: ColorRed, Flashes: 1, On: 1500 * time.Millisecond, Beeps: 3},
+		LockedOpen: Pattern{Color: ColorGreen, On: time.Second, Off: time.Second},
+	}
+}
+
+// ParsePattern parses color[:flashes[:on_ms[:off_ms[:beeps]]]], e.g.
+// "green:2:200:200" or "red:1:1500:0:3". Omitted fields are zero, except that
+// a lit color with no on time stays lit.
+func ParsePattern(s string) (Pattern, error) {
+	fields := strings.Split(strings.TrimSpace(s), ":")
+	var p Pattern
+	color := strings.ToLower(fields[0])
+	idx := -1
+	for i, name := range colorNames {
+		if name == color {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return p, fmt.Errorf("pattern %q: unknown color %q", s, fields[0])
+	}
+	p.Color = Color(idx)
+	if len(fields) > 5 {
+		return p, fmt.Errorf("pattern %q: too many fields", s)
+	}
+	nums := make([]int, 4)
+	for i, f := range fields[1:] {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("pattern %q: %q is not a count or milliseconds", s, f)
+		}
+		nums[i] = n
+	}
+	p.Flashes = nums[0]
+	p.On = time.Duration(nums[1]) * time.Millisecond
+	p.Off = time.Duration(nums[2]) * time.Millisecond
+	p.Beeps = nums[3]
+	if p.Color != ColorOff && p.On == 0 {
+		p.Off = 0
+	}
+	return p, p.Validate()
+}
+
+// Validate reports patterns a reader cannot show.
+func (p Pattern) Validate() error {
+	switch {
+	case p.Color < ColorOff || p.Color > ColorAmber:
+		return fmt.Errorf("unknown LED color %d", p.Color)
+	case p.Flashes < 0 || p.On < 0 || p.Off < 0:
+		return fmt.Errorf("pattern flashes and times must not be negative")
+	case p.Beeps < 0 || p.Beeps > maxBeeps:
+		return fmt.Errorf("pattern beeps must be 0 to %d, got %d", maxBeeps, p.Beeps)
+	case p.Flashes > 0 && p.On+p.Off == 0 && p.Beeps == 0:
+		return fmt.Errorf("pattern with %d flashes has no on or off time", p.Flashes)
+	}
+	return nil
+}
+
+// repeats reports whether p plays until the next repeating pattern.
+func (p Pattern) repeats() bool {
+	return p.Flashes == 0
+}
+
+// length is how long a pattern with a fixed number of flashes plays.
+func (p Pattern) length() time.Duration {
+	return max(time.Duration(p.Flashes)*(p.On+p.Off), time.Duration(p.Beeps)*beepCycle)
+}
+
+// at returns the LED color and whether the beeper sounds t into p.
+func (p Pattern) at(t time.Duration) (Color, bool) {
+	color := ColorOff
+	if p.Color != ColorOff {
+		switch cycle := p.On + p.Off; {
+		case p.On == 0:
+			// A steady color, for as long as the pattern lasts.
+			if p.repeats() {
+				color = p.Color
+			}
+		case p.repeats() || t < time.Duration(p.Flashes)*cycle:
+			if t%cycle < p.On {
+				color = p.Color
+			}
+		}
+	}
+	beep := t < time.Duration(p.Beeps)*beepCycle && t%beepCycle < beepOn
+	return color, beep
+}
+
+// next returns the first time after t at which at may change, or -1 when p
+// shows the same from t on.
+func (p Pattern) next(t time.Duration) time.Duration {
+	next := time.Duration(-1)
+	consider := func(d time.Duration) {
+		if d > t && (next < 0 || d < next) {
+			next = d
+		}
+	}
+	if cycle := p.On + p.Off; p.Color != ColorOff && p.On > 0 && (p.Off > 0 || !p.repeats()) {
+		if p.repeats() || t < time.Duration(p.Flashes)*cycle {
+			start := t / cycle * cycle
+			consider(start + p.On)
+			consider(start + cycle)
+		}
+	}
+	if t < time.Duration(p.Beeps)*beepCycle {
+		start := t / beepCycle * beepCycle
+		consider(start + beepOn)
+		consider(start + beepCycle)
+	}
+	if !p.repeats() {
+		consider(p.length())
+	}
+	return next
+}
+
+// Indicator shows feedback patterns on a reader. Show must not block.
+type Indicator interface {
+	Show(p Pattern)
+}
+
+// GPIOIndicator shows patterns on a reader's LED and beeper input lines. A
+// reader with a single LED line that turns it green is wired as green only.
+type GPIOIndicator struct {
+	green, red, beeper gpio.OutputPin
+
+	mu        sync.Mutex
+	base      Pattern // the repeating pattern
+	baseStart time.Time
+	top       *Pattern // a pattern with fixed flashes, playing over base
+	topStart  time.Time
+
+	wake   chan struct{}
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+// NewGPIOIndicator drives the given lines; any of them may be nil. Call
+// Start to begin.
+func NewGPIOIndicator(green, red, beeper gpio.OutputPin) *GPIOIndicator {
+	return &GPIOIndicator{
+		green:     green,
+		red:       red,
+		beeper:    beeper,
+		baseStart: time.Now(),
+		wake:      make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start begins showing patterns, starting with all lines inactive.
+func (ind *GPIOIndicator) Start() {
+	go ind.run()
+}
+
+// Stop ends the current pattern and leaves all lines inactive.
+func (ind *GPIOIndicator) Stop() {
+	close(ind.stopCh)
+	<-ind.done
+}
+
+// Show implements Indicator.
+func (ind *GPIOIndicator) Show(p Pattern) {
+	ind.mu.Lock()
+	if p.repeats() {
+		ind.base, ind.baseStart = p, time.Now()
+	} else {
+		ind.top, ind.topStart = &p, time.Now()
+	}
+	ind.mu.Unlock()
+	select {
+	case ind.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (ind *GPIOIndicator) run() {
+	defer close(ind.done)
+	defer ind.set(ColorOff, false)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		wait := ind.step()
+		stopTimer(timer)
+		var fire <-chan time.Time
+		if wait >= 0 {
+			timer.Reset(wait)
+			fire = timer.C
+		}
+		select {
+		case <-ind.stopCh:
+			return
+		case <-ind.wake:
+		case <-fire:
+		}
+	}
+}
+
+// step sets the lines for the current moment of the pattern showing and
+// returns how long until they next change, or -1 if they will not.
+func (ind *GPIOIndicator) step() time.Duration {
+	ind.mu.Lock()
+	now := time.Now()
+	p, t := ind.base, now.Sub(ind.baseStart)
+	if ind.top != nil {
+		if topT := now.Sub(ind.topStart); topT < ind.top.length() {
+			p, t = *ind.top, topT
+		} else {
+			ind.top = nil
+		}
+	}
+	ind.mu.Unlock()
+
+	color, beep := p.at(t)
+	ind.set(color, beep)
+	next := p.next(t)
+	if next < 0 {
+		return -1
+	}
+	return next - t
+}
+
+func (ind *GPIOIndicator) set(color Color, beep bool) {
+	drive(ind.green, color == ColorGreen || color == ColorAmber)
+	drive(ind.red, color == ColorRed || color == ColorAmber)
+	drive(ind.beeper, beep)
+}
+
+func drive(pin gpio.OutputPin, active bool) {
+	switch {
+	case pin == nil:
+	case active:
+		pin.High()
+	default:
+		pin.Low()
+	}
+}
+
+// Show implements Indicator with the reader's LED and buzzer commands.
+func (r *OSDPReader) Show(p Pattern) {
+	led := OSDPLED{OnColor: osdpColors[p.Color], On: p.On, Off: p.Off}
+	if p.On == 0 {
+		led.On = time.Second
+	}
+	if !p.repeats() {
+		led.Duration = time.Duration(p.Flashes) * (p.On + p.Off)
+	}
+	if led.Duration > 0 || p.repeats() {
+		r.SetLED(led)
+	}
+	if p.Beeps > 0 {
+		r.Buzz(OSDPBuzzer{On: beepOn, Off: beepCycle - beepOn, Count: p.Beeps})
+	}
+}
+
+var osdpColors = map[Color]OSDPColor{
+	ColorOff:   OSDPBlack,
+	ColorRed:   OSDPRed,
+	ColorGreen: OSDPGreen,
+	ColorAmber: OSDPAmber,
+}
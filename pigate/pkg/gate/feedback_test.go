@@ -0,0 +1,179 @@
+package gate
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"pigate/pkg/gpio"
+	"pigate/pkg/messenger"
+)
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Pattern
+		wantErr bool
+	}{
+		{in: "green:2:200:200", want: Pattern{Color: ColorGreen, Flashes: 2, On: 200 * time.Millisecond, Off: 200 * time.Millisecond}},
+		{in: "RED:1:1500:0:3", want: Pattern{Color: ColorRed, Flashes: 1, On: 1500 * time.Millisecond, Beeps: 3}},
+		{in: "amber", want: Pattern{Color: ColorAmber}},
+		{in: "off:1:0:0:2", want: Pattern{Flashes: 1, Beeps: 2}},
+		{in: "blue", wantErr: true},
+		{in: "green:2", wantErr: true}, // flashes with no time
+		{in: "red:1:100:0:-1", wantErr: true},
+		{in: "red:1:100:0:1:1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePattern(tt.in)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("ParsePattern(%q) = %+v, %v, want %+v (error %t)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPatternTimeline(t *testing.T) {
+	ms := time.Millisecond
+	denied := DefaultFeedback().Denied
+	steps := []struct {
+		at    time.Duration
+		color Color
+		beep  bool
+		next  time.Duration
+	}{
+		{at: 0, color: ColorRed, beep: true, next: 100 * ms},
+		{at: 150 * ms, color: ColorRed, next: 200 * ms},
+		{at: 500 * ms, color: ColorRed, next: 600 * ms},
+		{at: 600 * ms, color: ColorRed, next: 1500 * ms},
+		{at: 1500 * ms, color: ColorOff, next: -1},
+	}
+	for _, s := range steps {
+		color, beep := denied.at(s.at)
+		if color != s.color || beep != s.beep || denied.next(s.at) != s.next {
+			t.Errorf("denied at %v = %v, beep %t, next %v; want %v, beep %t, next %v",
+				s.at, color, beep, denied.next(s.at), s.color, s.beep, s.next)
+		}
+	}
+
+	locked := DefaultFeedback().LockedOpen
+	if color, _ := locked.at(90 * time.Second); color != ColorGreen {
+		t.Errorf("locked open at 90s = %v, want green", color)
+	}
+	if color, _ := locked.at(91500 * ms); color != ColorOff {
+		t.Errorf("locked open at 91.5s = %v, want off", color)
+	}
+	if next := locked.next(91500 * ms); next != 92*time.Second {
+		t.Errorf("locked open next after 91.5s = %v, want 92s", next)
+	}
+	if next := (Pattern{Color: ColorRed}).next(time.Hour); next != -1 {
+		t.Errorf("steady red next = %v, want -1", next)
+	}
+}
+
+func TestGPIOIndicator(t *testing.T) {
+	const greenPin, redPin, beeperPin = 5, 6, 13
+	pins := gpio.NewFake(nil)
+	output := func(pin int) gpio.OutputPin {
+		p, err := pins.Output(gpio.OutputConfig{Pin: pin, ActiveLow: true})
+		if err != nil {
+			t.Fatalf("Output(%d) error = %v", pin, err)
+		}
+		return p
+	}
+	ind := NewGPIOIndicator(output(greenPin), output(redPin), output(beeperPin))
+	ind.Start()
+	stopped := false
+	t.Cleanup(func() {
+		if !stopped {
+			ind.Stop()
+		}
+	})
+	count := func(pin int) int {
+		n := 0
+		for _, tr := range pins.Transitions() {
+			if tr.Pin == pin {
+				n++
+			}
+		}
+		return n
+	}
+	waitFor := func(what string, ok func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !ok() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s; transitions %+v", what, pins.Transitions())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// A slow flash underneath, then a denial on top of it. Active-low lines
+	// are physically low while active.
+	ind.Show(Pattern{Color: ColorGreen, On: 20 * time.Millisecond, Off: 20 * time.Millisecond})
+	waitFor("green to flash", func() bool { return count(greenPin) >= 4 })
+	ind.Show(Pattern{Color: ColorRed, Flashes: 1, On: 50 * time.Millisecond, Beeps: 2})
+	waitFor("red and two beeps", func() bool { return count(redPin) == 2 && count(beeperPin) == 4 })
+	greenFlashes := count(greenPin)
+	waitFor("green to resume", func() bool { return count(greenPin) >= greenFlashes+2 })
+
+	ind.Stop()
+	stopped = true
+	for _, pin := range []int{greenPin, redPin, beeperPin} {
+		if !pins.Level(pin) {
+			t.Errorf("pin %d left active after Stop", pin)
+		}
+	}
+}
+
+// patternRecorder records the patterns a controller shows.
+type patternRecorder struct {
+	mu    sync.Mutex
+	shown []Pattern
+}
+
+func (r *patternRecorder) Show(p Pattern) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shown = append(r.shown, p)
+}
+
+func (r *patternRecorder) last() Pattern {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.shown[len(r.shown)-1]
+}
+
+func TestGateControllerFeedback(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, access := newLeaseController(&now)
+	g.gm = &cardGateManager{}
+	fb := DefaultFeedback()
+	fb.Idle = Pattern{Color: ColorRed}
+	rec := &patternRecorder{}
+	g.SetFeedback(fb, rec)
+	if got := rec.last(); got != fb.Idle {
+		t.Errorf("after SetFeedback shown %+v, want idle %+v", got, fb.Idle)
+	}
+
+	_ = g.Open("99999", now)
+	<-access.events
+	if got := rec.last(); got != fb.Denied {
+		t.Errorf("after unknown code shown %+v, want denied %+v", got, fb.Denied)
+	}
+	_ = g.OpenCard(Card{Format: "H10301", Facility: 12, Number: 34567}, now)
+	<-access.events
+	if got := rec.last(); got != fb.Granted {
+		t.Errorf("after card shown %+v, want granted %+v", got, fb.Granted)
+	}
+
+	handle := g.CommandHandler()
+	handle("command", messenger.CommandHoldOpenMessage)
+	if got := rec.last(); got != fb.LockedOpen {
+		t.Errorf("after hold open shown %+v, want locked open %+v", got, fb.LockedOpen)
+	}
+	handle("command", messenger.CommandCloseMessage)
+	if got := rec.last(); got != fb.Idle {
+		t.Errorf("after close shown %+v, want idle %+v", got, fb.Idle)
+	}
+}
@@ -2,19 +2,18 @@ package gate
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
 
 	"pigate/pkg/database"
+	"pigate/pkg/gpio"
 	"pigate/pkg/messenger"
 )
 
-type outputPin interface {
-	High()
-	Low()
-}
-
 type GateState int8
 
 const (
@@ -23,32 +22,134 @@ const (
 	LockedOpen
 )
 
+// String returns the status published for the state.
+func (s GateState) String() string {
+	switch s {
+	case Open:
+		return messenger.StatusOpened
+	case LockedOpen:
+		return messenger.StatusLockedOpen
+	default:
+		return messenger.StatusClosed
+	}
+}
+
 type StatusNotifier interface {
 	NotifyGateOpen() error
 	NotifyGateLockedOpen() error
 	NotifyGateClosed() error
 }
 
+// AccessNotifier receives the outcome of every credential presented at the gate.
+type AccessNotifier interface {
+	NotifyAccess(event messenger.AccessEvent) error
+}
+
+// Metrics receives counts of gate activity. pkg/metrics provides the
+// Prometheus implementation.
+type Metrics interface {
+	AccessDecision(result, reason string)
+	Command(source, command string)
+	KeypadParseError()
+	GateState(status string)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) AccessDecision(result, reason string) {}
+func (noMetrics) Command(source, command string)       {}
+func (noMetrics) KeypadParseError()                    {}
+func (noMetrics) GateState(status string)              {}
+
+// Command sources reported to Metrics. Free exits report their own source.
+const (
+	CommandSourceKeypad     = "keypad"
+	CommandSourceExitKeypad = "exit_keypad"
+	CommandSourceOSDP       = "osdp"
+	CommandSourceMQTT       = "mqtt"
+)
+
+// commandSource returns the Metrics command source for a credential read at
+// source.
+func commandSource(source string) string {
+	switch source {
+	case SourceWiegand:
+		return CommandSourceKeypad
+	case SourceWiegandExit:
+		return CommandSourceExitKeypad
+	case SourceOSDP:
+		return CommandSourceOSDP
+	default:
+		return source
+	}
+}
+
+// Denial reasons reported with access events.
+const (
+	ReasonUnknownCode       = "unknown_code"
+	ReasonLockedOut         = "locked_out"
+	ReasonOutsideAccessTime = "outside_access_time"
+	ReasonLookupError       = "lookup_error"
+	ReasonUpdateInProgress  = "update_in_progress"
+	ReasonPINRequired       = "pin_required"  // card read, but its PIN never followed
+	ReasonPINMismatch       = "pin_mismatch"  // card read, then the wrong PIN
+	ReasonThrottled         = "throttled"     // keypad locked out after failed codes
+	ReasonLockdown          = "lockdown"      // facility in lockdown; see SetLockdown
+	ReasonAntiPassback      = "anti_passback" // entered while inside; also on soft-mode grants
+)
+
 type GateController struct {
-	pin              outputPin // GPIO controlling the gate relay
-	ledPin           outputPin // GPIO controlling the status LED
+	relays           [relayCount]gpio.OutputPin // the operator's inputs; see InitRelays
+	relayMade        [relayCount]bool
+	pulseSeq         [relayCount]int
+	relayCfg         RelayConfig
+	ledPin           gpio.OutputPin // GPIO controlling the status LED
 	gm               database.GateManager
 	state            GateState
 	gateOpenDuration int
 	statusNotifier   StatusNotifier
+	accessNotifier   AccessNotifier
+	alertNotifier    AlertNotifier
+	duressNotifier   DuressNotifier
+	metrics          Metrics
+	lease            *UpdateLease // held by the Update Agent; see AcquireUpdateLease
+	now              func() time.Time
+	afterFunc        func(d time.Duration, f func()) // schedules the auto-close
+	cardPINTimeout   time.Duration
+	pending          *pendingCard // card waiting for its PIN; see awaitPIN
+	pendingSeq       int
+	feedback         Feedback
+	indicators       []Indicator // readers showing feedback; see SetFeedback
+	throttle         ThrottleConfig
+	failures         map[string][]time.Time // failed codes per source, within the window
+	lockouts         map[string]database.Lockout
+	duressLastDigit  bool // see SetDuressLastDigit
+	positionNotifier PositionNotifier
+	sensorCfg        SensorConfig // see SetSensors
+	sensing          bool         // set by the first UpdateSensors
+	sensors          SensorState
+	position         Position
+	fault            string    // alert type while position is PositionFault
+	movedAt          time.Time // when the relay last changed
+	moveSeq          int
+	closeWhenClear   bool // a close is waiting for the safety input to clear
+	lockdown         bool // see SetLockdown
+	suppressExit     bool // see SetSuppressExitInLockdown
+	antiPassback     AntiPassbackConfig
 	mu               sync.Mutex
 }
 
-// NewGateController initializes the SPI/GPIO driver and returns a GateController.
-// You only need to call this once in main.
+// NewGateController returns a GateController. It drives no pins until
+// InitPinControl or SetOutputPins is called.
 func NewGateController(gm database.GateManager, gateOpenDuration int) *GateController {
-	if err := openPinDriver(); err != nil {
-		log.Fatalf("failed to open rpio: %v", err)
-	}
 	return &GateController{
 		gm:               gm,
 		state:            Closed,
 		gateOpenDuration: gateOpenDuration,
+		metrics:          noMetrics{},
+		now:              time.Now,
+		afterFunc:        func(d time.Duration, f func()) { time.AfterFunc(d, f) },
+		cardPINTimeout:   DefaultCardPINTimeout,
 	}
 }
 
@@ -56,58 +157,206 @@ func (g *GateController) SetStatusNotifier(notifier StatusNotifier) {
 	g.statusNotifier = notifier
 }
 
-// InitPinControl configures the relay pin and LED pin in one call.
-// relayPinNumber is the BCM pin driving the gate relay;
-// ledPinNumber is the BCM pin driving a status LED.
-func (g *GateController) InitPinControl(relayPinNumber, ledPinNumber int) {
-	g.pin = newOutputPin(relayPinNumber)
-	g.ledPin = newOutputPin(ledPinNumber)
+func (g *GateController) SetAccessNotifier(notifier AccessNotifier) {
+	g.accessNotifier = notifier
+}
+
+// SetMetrics starts reporting to m and records the current gate state.
+func (g *GateController) SetMetrics(m Metrics) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.metrics = m
+	m.GateState(g.state.String())
+}
+
+// SetFeedback shows fb on the given readers' LEDs and beepers, starting with
+// the pattern for the current gate state.
+func (g *GateController) SetFeedback(fb Feedback, indicators ...Indicator) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.feedback = fb
+	g.indicators = indicators
+	if g.state == LockedOpen {
+		g.show(fb.LockedOpen)
+	} else {
+		g.show(fb.Idle)
+	}
+}
+
+// show plays p on every reader. Callers hold g.mu.
+func (g *GateController) show(p Pattern) {
+	for _, ind := range g.indicators {
+		ind.Show(p)
+	}
+}
+
+// InitPinControl configures the relay pin and LED pin on driver in one call.
+// relay is the line driving the gate relay; led is the line driving a status
+// LED.
+func (g *GateController) InitPinControl(driver gpio.Driver, relay, led gpio.OutputConfig) error {
+	relayPin, err := driver.Output(relay)
+	if err != nil {
+		return fmt.Errorf("relay pin: %w", err)
+	}
+	ledPin, err := driver.Output(led)
+	if err != nil {
+		return fmt.Errorf("LED pin: %w", err)
+	}
+	g.SetOutputPins(relayPin, ledPin)
+	return nil
+}
+
+// SetOutputPins drives the relay and LED through the given pins. Both start
+// low.
+func (g *GateController) SetOutputPins(relay, led gpio.OutputPin) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	relay.Low()
+	led.Low()
+	g.relays[relayOpen] = relay
+	g.relayMade[relayOpen] = false
+	g.ledPin = led
 }
 
 // Open triggers either a temporary open or lock-open based on credential.
+// While an update lease is held, valid credentials are logged as denied with
+// ReasonUpdateInProgress and the gate stays closed; the entry is not replayed
+// once the lease ends.
+//
+// While a card read at the keypad is waiting for its PIN, code is taken as
+// that PIN.
 func (g *GateController) Open(code string, currentTime time.Time) error {
-	if !g.ValidateCredential(code, currentTime) {
-		log.Printf("Invalid credential: %s", code)
+	return g.OpenFrom(SourceWiegand, code, currentTime)
+}
+
+// OpenFrom is Open for a code entered at source. Failed codes count towards
+// that source's lockout, during which its codes are ignored. Only a card read
+// at source takes code as its PIN; at other readers code is checked as a PIN
+// credential.
+func (g *GateController) OpenFrom(source, code string, currentTime time.Time) error {
+	if g.throttled(source, code, database.CredentialPIN, currentTime) {
 		return nil
 	}
-	isLockCode, err := g.isLockCode(code)
-	if err != nil {
-		log.Printf("Error checking lock code type: %v", err)
+	if p := g.takePendingCard(source); p != nil {
+		return g.completeCardPIN(p, code, currentTime)
+	}
+	return g.open(source, code, database.CredentialPIN, currentTime)
+}
+
+// OpenCard is Open for a scanned card. Only credentials of type card match.
+// A card with a PIN opens nothing by itself; the controller waits for the PIN
+// to be entered with Open.
+func (g *GateController) OpenCard(card Card, currentTime time.Time) error {
+	return g.OpenCardFrom(SourceWiegand, card, currentTime)
+}
+
+// OpenCardFrom is OpenCard for a card read at source.
+func (g *GateController) OpenCardFrom(source string, card Card, currentTime time.Time) error {
+	if g.throttled(source, card.Code(), database.CredentialCard, currentTime) {
 		return nil
 	}
+	return g.open(source, card.Code(), database.CredentialCard, currentTime)
+}
 
-	if isLockCode {
-		return g.lockOpen()
+func (g *GateController) open(source, code string, credType database.CredentialType, currentTime time.Time) error {
+	cred, reason := g.checkCredential(code, credType, currentTime)
+	if reason == ReasonUnknownCode && credType == database.CredentialPIN {
+		// A duress code goes through the same checks as the credential's own
+		// code, and is logged under it.
+		if duress := g.duressCredential(code); duress != nil {
+			g.raiseDuress(source, credType, duress, currentTime)
+			code = duress.Code
+			cred, reason = g.checkCredential(code, credType, currentTime)
+		}
 	}
-	return g.tempOpen()
+	if reason != "" {
+		log.Printf("Invalid credential: %s (%s)", code, reason)
+		g.recordAccess(source, code, credType, cred, messenger.AccessDenied, reason, currentTime)
+		if reason == ReasonUnknownCode {
+			g.countFailure(source, currentTime)
+		}
+		return nil
+	}
+	if cred.RequiresPIN() {
+		g.awaitPIN(source, cred)
+		return nil
+	}
+	return g.grant(source, code, credType, cred, currentTime)
+}
+
+// grant opens the gate for an accepted credential.
+func (g *GateController) grant(source, code string, credType database.CredentialType, cred *database.Credential, currentTime time.Time) error {
+	var passback string
+	if g.passedBack(source, code, currentTime) {
+		passback = ReasonAntiPassback
+		g.mu.Lock()
+		hard := g.antiPassback.Mode == AntiPassbackHard
+		g.mu.Unlock()
+		if hard {
+			log.Printf("Credential %s denied: already inside", code)
+			g.recordAccess(source, code, credType, cred, messenger.AccessDenied, ReasonAntiPassback, currentTime)
+			return nil
+		}
+		log.Printf("Credential %s entered again without exiting", code)
+	}
+	command, open := messenger.CommandOpenMessage, g.tempOpen
+	if cred.OpenMode == database.LockOpen {
+		command, open = messenger.CommandHoldOpenMessage, g.lockOpen
+	}
+	if err := open(); errors.Is(err, ErrUpdateInProgress) {
+		log.Printf("Credential %s accepted but not opening: update in progress", code)
+		g.recordAccess(source, code, credType, cred, messenger.AccessDenied, ReasonUpdateInProgress, currentTime)
+		return nil
+	} else if errors.Is(err, ErrLockdown) {
+		log.Printf("Credential %s accepted but not opening: lockdown", code)
+		g.recordAccess(source, code, credType, cred, messenger.AccessDenied, ReasonLockdown, currentTime)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	g.recordAccess(source, code, credType, cred, messenger.AccessGranted, passback, currentTime)
+	g.recordPresence(source, code, currentTime)
+	g.metrics.Command(commandSource(source), command)
+	g.clearFailures(source, currentTime)
+	return nil
 }
 
 // tempOpen opens gate for configured duration, unless already open or locked open.
 func (g *GateController) tempOpen() error {
+	return g.openFor(false)
+}
+
+// openFor is tempOpen; exit is set for the free-exit inputs, which still open
+// the gate during a lockdown unless they are suppressed.
+func (g *GateController) openFor(exit bool) error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
 	if g.state == LockedOpen || g.state == Open {
 		return nil
 	}
-	g.state = Open
-	if g.pin != nil {
-		g.pin.High()
+	if g.leaseActive() {
+		return ErrUpdateInProgress
 	}
+	if g.lockdown && (!exit || g.suppressExit) {
+		return ErrLockdown
+	}
+	g.state = Open
+	g.driveOpen()
 	if g.ledPin != nil {
 		g.ledPin.High()
 	}
 	g.notifyGateOpen()
+	g.gateMoved()
 
 	// Schedule auto-close
-	go func() {
-		time.Sleep(time.Duration(g.gateOpenDuration) * time.Second)
+	g.afterFunc(time.Duration(g.gateOpenDuration)*time.Second, func() {
 		g.mu.Lock()
 		defer g.mu.Unlock()
 		if g.state == Open {
 			g.closeLockedOrOpen()
 		}
-	}()
+	})
 	return nil
 }
 
@@ -119,47 +368,55 @@ func (g *GateController) lockOpen() error {
 	if g.state == LockedOpen {
 		return nil
 	}
-	g.state = LockedOpen
-	if g.pin != nil {
-		g.pin.High()
+	if g.leaseActive() {
+		return ErrUpdateInProgress
+	}
+	if g.lockdown {
+		return ErrLockdown
 	}
+	wasOpen := g.state == Open
+	g.state = LockedOpen
+	g.closeWhenClear = false
+	g.driveLockOpen(wasOpen)
 	if g.ledPin != nil {
 		g.ledPin.High()
 	}
 	g.notifyGateLockedOpen()
+	if !wasOpen {
+		g.gateMoved()
+	}
 	return nil
 }
 
-// Close shuts the gate (from open or locked open) and turns off LED.
+// Close shuts the gate (from open or locked open) and turns off LED. While the
+// safety input is tripped, the gate closes once it clears.
 func (g *GateController) Close() error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	if g.state == Open || g.state == LockedOpen {
-		if g.pin != nil {
-			g.pin.Low()
-		}
-		if g.ledPin != nil {
-			g.ledPin.Low()
-		}
-		g.state = Closed
-		g.notifyGateClosed()
+		g.closeLockedOrOpen()
 	}
 	return nil
 }
 
 // internal helper for auto-close
 func (g *GateController) closeLockedOrOpen() {
-	if g.pin != nil {
-		g.pin.Low()
+	if g.obstructed() {
+		log.Println("Safety input tripped; closing the gate once it clears")
+		g.closeWhenClear = true
+		return
 	}
+	g.driveClose()
 	if g.ledPin != nil {
 		g.ledPin.Low()
 	}
 	g.state = Closed
 	g.notifyGateClosed()
+	g.gateMoved()
 }
 
 func (g *GateController) notifyGateOpen() {
+	g.metrics.GateState(messenger.StatusOpened)
 	notifier := g.statusNotifier
 	if notifier == nil {
 		return
@@ -172,6 +429,8 @@ func (g *GateController) notifyGateOpen() {
 }
 
 func (g *GateController) notifyGateLockedOpen() {
+	g.metrics.GateState(messenger.StatusLockedOpen)
+	g.show(g.feedback.LockedOpen)
 	notifier := g.statusNotifier
 	if notifier == nil {
 		return
@@ -184,6 +443,8 @@ func (g *GateController) notifyGateLockedOpen() {
 }
 
 func (g *GateController) notifyGateClosed() {
+	g.metrics.GateState(messenger.StatusClosed)
+	g.show(g.feedback.Idle)
 	notifier := g.statusNotifier
 	if notifier == nil {
 		return
@@ -195,37 +456,85 @@ func (g *GateController) notifyGateClosed() {
 	}()
 }
 
-func (g *GateController) isLockCode(code string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	cred, err := g.gm.GetCredential(ctx, code)
-	if err != nil {
-		return false, err
-	}
-	return cred.OpenMode == database.LockOpen, nil
+// ValidateCredential checks if a PIN is valid and within allowed time.
+func (g *GateController) ValidateCredential(code string, currentTime time.Time) bool {
+	_, reason := g.checkCredential(code, database.CredentialPIN, currentTime)
+	return reason == ""
 }
 
-// ValidateCredential checks if credential is valid and within allowed time.
-func (g *GateController) ValidateCredential(code string, currentTime time.Time) bool {
+// checkCredential looks up code and returns the credential together with the
+// denial reason, or an empty reason when access should be granted. A PIN
+// never matches a card credential, nor a card a PIN.
+func (g *GateController) checkCredential(code string, credType database.CredentialType, currentTime time.Time) (*database.Credential, string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	cred, err := g.gm.GetCredential(ctx, code)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && cred == nil) {
+		return nil, ReasonUnknownCode
+	}
 	if err != nil {
 		log.Printf("Credential validation error: %v", err)
-		return false
+		return nil, ReasonLookupError
+	}
+	if cred.IsCard() != (credType == database.CredentialCard) {
+		return nil, ReasonUnknownCode
 	}
 	if cred.LockedOut {
 		log.Printf("Credential %s is locked out", code)
-		return false
+		return cred, ReasonLockedOut
 	}
 
 	at, err := g.gm.GetAccessTime(ctx, cred.AccessGroup)
 	if err != nil {
 		log.Printf("Error getting access time: %v", err)
-		return false
+		return cred, ReasonLookupError
 	}
-	return isTimeOfDayInRange(currentTime, at.StartTime, at.EndTime)
+	if !isTimeOfDayInRange(currentTime, at.StartTime, at.EndTime) {
+		return cred, ReasonOutsideAccessTime
+	}
+	return cred, ""
+}
+
+// recordAccess writes the attempt at source to the local gate log and
+// publishes it to the Control Plane.
+func (g *GateController) recordAccess(source, code string, credType database.CredentialType, cred *database.Credential, result, reason string, at time.Time) {
+	g.metrics.AccessDecision(result, reason)
+	status := database.StatusGranted
+	g.mu.Lock()
+	if result == messenger.AccessDenied {
+		status = database.StatusDenied
+		g.show(g.feedback.Denied)
+	} else {
+		g.show(g.feedback.Granted)
+	}
+	g.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := g.gm.PutGateLog(ctx, database.GateLog{Code: code, Time: at, Status: status, Source: source}); err != nil {
+		log.Printf("Failed to write gate log: %v", err)
+	}
+
+	notifier := g.accessNotifier
+	if notifier == nil {
+		return
+	}
+	event := messenger.AccessEvent{
+		Code:           code,
+		CredentialType: string(credType),
+		Result:         result,
+		Reason:         reason,
+		At:             at,
+	}
+	if cred != nil {
+		event.Username = cred.Username
+		event.OpenMode = string(cred.OpenMode)
+	}
+	go func() {
+		if err := notifier.NotifyAccess(event); err != nil {
+			log.Printf("Failed to publish access event: %v", err)
+		}
+	}()
 }
 
 // isTimeOfDayInRange ignores date—only compares times, handling overnight spans.
@@ -241,20 +550,36 @@ func isTimeOfDayInRange(current, start, end time.Time) bool {
 	return c.Equal(s) || c.After(s) || c.Before(e)
 }
 
-// CommandHandler returns a function to handle remote commands: open, close, hold open.
+// CommandHandler returns a function to handle remote commands: open, close,
+// hold open, lockdown and end lockdown.
 func (g *GateController) CommandHandler() func(topic, msg string) {
 	return func(topic, msg string) {
 		log.Printf("Received command on topic %s: %s", topic, msg)
 		switch msg {
 		case messenger.CommandOpenMessage:
 			log.Println("Opening the gate...")
-			_ = g.tempOpen()
+			if err := g.tempOpen(); err != nil {
+				log.Printf("Not opening the gate: %v", err)
+				return
+			}
+			g.metrics.Command(CommandSourceMQTT, msg)
 		case messenger.CommandCloseMessage:
 			log.Println("Closing the gate...")
+			g.metrics.Command(CommandSourceMQTT, msg)
 			_ = g.Close()
 		case messenger.CommandHoldOpenMessage:
 			log.Println("Locking the gate open...")
-			_ = g.lockOpen()
+			if err := g.lockOpen(); err != nil {
+				log.Printf("Not locking the gate open: %v", err)
+				return
+			}
+			g.metrics.Command(CommandSourceMQTT, msg)
+		case messenger.CommandLockdownMessage:
+			g.metrics.Command(CommandSourceMQTT, msg)
+			g.SetLockdown(true)
+		case messenger.CommandEndLockdownMessage:
+			g.metrics.Command(CommandSourceMQTT, msg)
+			g.SetLockdown(false)
 		default:
 			log.Printf("Unknown command received: %s", msg)
 		}
@@ -1,75 +1,143 @@
-//go:build linux
-
 package gate
 
 import (
 	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
 	"time"
 
-	gpiocdev "github.com/warthog618/go-gpiocdev"
+	"pigate/pkg/gpio"
 )
 
+// Keypad frame formats: 4 bits per key, or 8 bits with the key in the high
+// nibble and its complement in the low nibble.
 const (
-	pinD0 = 17 // BCM GPIO pin for Wiegand Data0
-	pinD1 = 18 // BCM GPIO pin for Wiegand Data1
+	KeypadFrame4 = 4
+	KeypadFrame8 = 8
+)
 
-	keyFrameTimeout  = 100 * time.Millisecond // max gap between bits of one key
-	codeFrameTimeout = 3 * time.Second        // max gap between keys in a code
-	maxKeysPerCode   = 5                      // 5-key PIN
+// Keys with a meaning of their own rather than being part of the code.
+const (
+	keyClear = "*"
+	keyEnter = "#"
 )
 
-// KeypadReader reads raw Wiegand pulses on D0/D1 and assembles keypad codes.
+// maxCodeLength bounds a code entered without a fixed length, so a stuck or
+// noisy keypad cannot grow one without limit.
+const maxCodeLength = 16
+
+// KeypadConfig describes the keypad wiring and the codes it sends.
+type KeypadConfig struct {
+	D0Pin       int           // BCM GPIO pin for Wiegand Data0
+	D1Pin       int           // BCM GPIO pin for Wiegand Data1
+	FrameBits   int           // KeypadFrame4 or KeypadFrame8
+	CodeLength  int           // submit after this many keys; 0 waits for #
+	KeyTimeout  time.Duration // max gap between bits of one key
+	CodeTimeout time.Duration // max gap between keys in a code
+
+	// CardFormats are the card frames accepted on the same bus. With none,
+	// every FrameBits bits is a key; otherwise a frame ends after KeyTimeout
+	// without bits and is classified by its length.
+	CardFormats []CardFormat
+}
+
+// DefaultKeypadConfig is the wiring the gate controller has always used: D0
+// and D1 on BCM 17 and 18, 4-bit keys and 5-key codes.
+func DefaultKeypadConfig() KeypadConfig {
+	return KeypadConfig{
+		D0Pin:       17,
+		D1Pin:       18,
+		FrameBits:   KeypadFrame4,
+		CodeLength:  5,
+		KeyTimeout:  100 * time.Millisecond,
+		CodeTimeout: 3 * time.Second,
+	}
+}
+
+// Validate reports settings the reader cannot work with.
+func (c KeypadConfig) Validate() error {
+	switch {
+	case c.D0Pin == c.D1Pin:
+		return errors.New("keypad D0 and D1 must be different pins")
+	case c.FrameBits != KeypadFrame4 && c.FrameBits != KeypadFrame8:
+		return fmt.Errorf("keypad frame must be %d or %d bits, got %d", KeypadFrame4, KeypadFrame8, c.FrameBits)
+	case c.CodeLength < 0 || c.CodeLength > maxCodeLength:
+		return fmt.Errorf("keypad code length must be 0 to %d, got %d", maxCodeLength, c.CodeLength)
+	case c.KeyTimeout <= 0 || c.CodeTimeout <= 0:
+		return errors.New("keypad timeouts must be positive")
+	}
+	lengths := map[int]string{c.FrameBits: "keypad frames"}
+	for _, f := range c.CardFormats {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+		if other, ok := lengths[f.Bits]; ok {
+			return fmt.Errorf("card format %s: %d-bit frames are already used by %s", f.Name, f.Bits, other)
+		}
+		lengths[f.Bits] = f.Name
+	}
+	return nil
+}
+
+// KeypadReader reads raw Wiegand pulses on D0/D1 and assembles keypad codes
+// and card scans.
+//
+// Keys are collected until the code is complete: after CodeLength keys, when
+// # is pressed, or, for fixed-length codes only, when no key arrives within
+// CodeTimeout. * clears the keys entered so far.
 type KeypadReader struct {
-	d0, d1 *gpiocdev.Line
+	driver gpio.Driver
+	cfg    KeypadConfig
+	d0, d1 gpio.InputPin
+
+	metrics Metrics
+	onCard  func(Card)
+	bitCh   chan wiegandBit
+	stopCh  chan struct{}
+}
 
-	bitCh  chan int
-	stopCh chan struct{}
+// wiegandBit is one data pulse and when its edge was seen.
+type wiegandBit struct {
+	value int
+	at    time.Time
 }
 
-// NewKeypadReader prepares a reader but does not start it.
-func NewKeypadReader() *KeypadReader {
+// NewKeypadReader prepares a reader on driver but does not start it.
+func NewKeypadReader(driver gpio.Driver, cfg KeypadConfig) *KeypadReader {
 	return &KeypadReader{
-		bitCh:  make(chan int, 64),
-		stopCh: make(chan struct{}),
+		driver:  driver,
+		cfg:     cfg,
+		metrics: noMetrics{},
+		bitCh:   make(chan wiegandBit, 128),
+		stopCh:  make(chan struct{}),
 	}
 }
 
-// Start requests GPIO lines via gpiocdev and installs edge handlers.
-// onCodeReceived is called with the full 5-key code string (e.g. "12345").
-func (k *KeypadReader) Start(onCodeReceived func(code string)) error {
-	// D0 handler = bit 0
-	d0Handler := func(evt gpiocdev.LineEvent) {
-		k.enqueueBit(0)
-	}
+// SetMetrics reports undecodable keypad frames to m. Call it before Start.
+func (k *KeypadReader) SetMetrics(m Metrics) {
+	k.metrics = m
+}
 
-	// D1 handler = bit 1
-	d1Handler := func(evt gpiocdev.LineEvent) {
-		k.enqueueBit(1)
-	}
+// SetCardHandler passes each decoded card scan to onCard. Call it before
+// Start; without it, card frames are logged and dropped.
+func (k *KeypadReader) SetCardHandler(onCard func(Card)) {
+	k.onCard = onCard
+}
 
-	// Request D0 line: input, falling edges, edge handler
-	d0, err := gpiocdev.RequestLine(
-		"gpiochip0",
-		pinD0,
-		gpiocdev.AsInput,
-		gpiocdev.WithEventHandler(d0Handler),
-		gpiocdev.WithFallingEdge,
-	)
+// Start requests the D0/D1 inputs and installs falling-edge handlers.
+// onCodeReceived is called with each complete code (e.g. "12345").
+func (k *KeypadReader) Start(onCodeReceived func(code string)) error {
+	if err := k.cfg.Validate(); err != nil {
+		return err
+	}
+	// D0 pulses low for a 0 bit, D1 for a 1 bit.
+	d0, err := k.driver.Input(k.cfg.D0Pin, gpio.FallingEdge, func(e gpio.EdgeEvent) { k.enqueueBit(0, e.At) })
 	if err != nil {
 		return fmt.Errorf("request D0 line: %w", err)
 	}
-
-	// Request D1 line: input, falling edges, edge handler
-	d1, err := gpiocdev.RequestLine(
-		"gpiochip0",
-		pinD1,
-		gpiocdev.AsInput,
-		gpiocdev.WithEventHandler(d1Handler),
-		gpiocdev.WithFallingEdge,
-	)
+	d1, err := k.driver.Input(k.cfg.D1Pin, gpio.FallingEdge, func(e gpio.EdgeEvent) { k.enqueueBit(1, e.At) })
 	if err != nil {
 		_ = d0.Close()
 		return fmt.Errorf("request D1 line: %w", err)
@@ -78,35 +146,71 @@ func (k *KeypadReader) Start(onCodeReceived func(code string)) error {
 	k.d0 = d0
 	k.d1 = d1
 
-	log.Println("Wiegand: keypad reader started (edge-driven, 4-bit keys, 5-key codes)")
+	length := fmt.Sprintf("%d-key codes", k.cfg.CodeLength)
+	if k.cfg.CodeLength == 0 {
+		length = "#-terminated codes"
+	}
+	log.Printf("Wiegand: keypad reader started (D0=%d, D1=%d, %d-bit keys, %s)",
+		k.cfg.D0Pin, k.cfg.D1Pin, k.cfg.FrameBits, length)
+	for _, f := range k.cfg.CardFormats {
+		log.Printf("Wiegand: accepting %d-bit %s cards", f.Bits, f.Name)
+	}
 
 	go k.run(onCodeReceived)
 	return nil
 }
 
 // enqueueBit is called from the edge handlers; it must not block
-func (k *KeypadReader) enqueueBit(bit int) {
+func (k *KeypadReader) enqueueBit(bit int, at time.Time) {
 	select {
-	case k.bitCh <- bit:
+	case k.bitCh <- wiegandBit{value: bit, at: at}:
 	default:
 		log.Println("Wiegand: bit channel full, dropping bit")
 	}
 }
 
 // run implements:
-// 1) 4-bit key frames with <keyFrameTimeout>ms timeout between bits
-// 2) Up to 5 keys per code, <codeFrameTimeout>s inactivity timeout between keys
+//  1. FrameBits-bit key frames with KeyTimeout between bits; with card
+//     formats, frames end after KeyTimeout without bits and are classified by
+//     length
+//  2. codes of CodeLength keys, or ended by #, with CodeTimeout between keys
 func (k *KeypadReader) run(onCodeReceived func(code string)) {
-	var keyBits []int // bits for current key (expect 4)
-	var keys []string // collected keys for current code (expect up to 5)
+	var frameBits []int // bits for current frame
+	var lastBit time.Time
+
+	cards := len(k.cfg.CardFormats) > 0
+	maxFrame := k.cfg.FrameBits
+	for _, f := range k.cfg.CardFormats {
+		maxFrame = max(maxFrame, f.Bits)
+	}
 
-	keyTimer := time.NewTimer(time.Hour)  // dummy long; we'll stop immediately
-	codeTimer := time.NewTimer(time.Hour) // same
-	if !keyTimer.Stop() {
-		<-keyTimer.C
+	keyTimer := time.NewTimer(time.Hour) // dummy long; we'll stop immediately
+	stopTimer(keyTimer)
+	entry := newCodeEntry(k.cfg.CodeLength, k.cfg.CodeTimeout, k.metrics, onCodeReceived)
+	defer entry.stop()
+
+	handleKey := func(frame []int) {
+		key, err := parseKeypadFrame(frame)
+		if err != nil {
+			log.Printf("Wiegand keypad parse error for bits %v: %v\n", frame, err)
+			k.metrics.KeypadParseError()
+			return
+		}
+		if key == "?" {
+			k.metrics.KeypadParseError()
+		}
+		entry.key(key)
 	}
-	if !codeTimer.Stop() {
-		<-codeTimer.C
+
+	endFrame := func() {
+		frame := frameBits
+		frameBits = nil
+		stopTimer(keyTimer)
+		if len(frame) == k.cfg.FrameBits {
+			handleKey(frame)
+		} else {
+			k.handleCard(frame)
+		}
 	}
 
 	for {
@@ -115,134 +219,194 @@ func (k *KeypadReader) run(onCodeReceived func(code string)) {
 			return
 
 		case bit := <-k.bitCh:
-			// New bit received: extend current key and (re)start key timer
-			keyBits = append(keyBits, bit)
-
-			// reset key timeout
-			if !keyTimer.Stop() {
-				select {
-				case <-keyTimer.C:
-				default:
-				}
+			// Bits can queue up behind a slow handler, so frames are split on
+			// the edge timestamps rather than on when the bits are read.
+			if cards && len(frameBits) > 0 && bit.at.Sub(lastBit) > k.cfg.KeyTimeout {
+				endFrame()
 			}
-			keyTimer.Reset(keyFrameTimeout)
-
-			// if key complete (4 bits), parse it
-			if len(keyBits) == 4 {
-				key, _, err := parseKeypad4(keyBits)
-				if err != nil {
-					log.Printf("Wiegand keypad parse error for bits %v: %v\n", keyBits, err)
-				} else {
-					keys = append(keys, key)
-
-					// reset code timeout (we got a fresh key)
-					if !codeTimer.Stop() {
-						select {
-						case <-codeTimer.C:
-						default:
-						}
-					}
-					codeTimer.Reset(codeFrameTimeout)
-
-					// if we have 5 keys, send immediately
-					if len(keys) == maxKeysPerCode {
-						code := strings.Join(keys, "")
-						onCodeReceived(code)
-						keys = nil
-
-						// stop code timer until next key
-						if !codeTimer.Stop() {
-							select {
-							case <-codeTimer.C:
-							default:
-							}
-						}
-					}
-				}
-
-				// key is done, reset keyBits and keyTimer
-				keyBits = nil
-				if !keyTimer.Stop() {
-					select {
-					case <-keyTimer.C:
-					default:
-					}
-				}
+			frameBits = append(frameBits, bit.value)
+			lastBit = bit.at
+			stopTimer(keyTimer)
+			keyTimer.Reset(k.cfg.KeyTimeout)
+
+			// No format is longer, so the frame is complete.
+			if len(frameBits) == maxFrame {
+				endFrame()
 			}
 
 		case <-keyTimer.C:
-			// Too much gap inside a key -> discard partial key
-			if len(keyBits) > 0 {
-				log.Printf("Wiegand: key timeout, discarding partial bits: %v\n", keyBits)
-				keyBits = nil
+			switch {
+			case len(frameBits) == 0:
+			case cards:
+				endFrame()
+			default:
+				// Too much gap inside a key -> discard partial key
+				log.Printf("Wiegand: key timeout, discarding partial bits: %v\n", frameBits)
+				k.metrics.KeypadParseError()
+				frameBits = nil
 			}
 
-		case <-codeTimer.C:
-			// Too much gap between keys -> send whatever we have (if any)
-			if len(keys) > 0 {
-				code := strings.Join(keys, "")
-				onCodeReceived(code)
-				keys = nil
-			}
+		case <-entry.timer.C:
+			entry.expire()
 		}
 	}
 }
 
-// parseKeypad4 interprets a 4-bit Wiegand keypad frame.
-// Typical mapping:
-//
-//	0x0-0x9 => '0'-'9'
-//	0xA     => '*'
-//	0xB     => '#'
-func parseKeypad4(bits []int) (string, uint8, error) {
-	if len(bits) != 4 {
-		return "", 0, errors.New("expected 4-bit keypad frame")
+// handleCard decodes a frame that is not a key and passes the card on.
+func (k *KeypadReader) handleCard(frame []int) {
+	card, err := decodeCard(k.cfg.CardFormats, frame)
+	if err != nil {
+		log.Printf("Wiegand card parse error: %v", err)
+		k.metrics.KeypadParseError()
+		return
+	}
+	if k.onCard == nil {
+		log.Printf("Wiegand: no card handler, dropping %s card %s", card.Format, card.Code())
+		return
+	}
+	k.onCard(card)
+}
+
+// codeEntry collects keys into codes. A code is complete after length keys,
+// when # is pressed, or, for fixed-length codes only, when no key arrives
+// within timeout. * clears the keys entered so far. Call expire when timer
+// fires; codeEntry is not safe for concurrent use.
+type codeEntry struct {
+	length  int
+	timeout time.Duration
+	metrics Metrics
+	submit  func(code string)
+	keys    []string
+	timer   *time.Timer
+}
+
+func newCodeEntry(length int, timeout time.Duration, metrics Metrics, submit func(code string)) *codeEntry {
+	e := &codeEntry{
+		length:  length,
+		timeout: timeout,
+		metrics: metrics,
+		submit:  submit,
+		timer:   time.NewTimer(time.Hour),
+	}
+	stopTimer(e.timer)
+	return e
+}
+
+// key adds one key: a digit, keyClear or keyEnter.
+func (e *codeEntry) key(key string) {
+	switch key {
+	case keyClear:
+		e.reset()
+	case keyEnter:
+		e.finish()
+	default:
+		e.keys = append(e.keys, key)
+		stopTimer(e.timer)
+		e.timer.Reset(e.timeout)
+		if len(e.keys) == e.length {
+			e.finish()
+		} else if len(e.keys) > maxCodeLength {
+			log.Printf("Keypad: code longer than %d keys, discarding", maxCodeLength)
+			e.metrics.KeypadParseError()
+			e.reset()
+		}
+	}
+}
+
+// expire handles too much gap between keys. Fixed-length codes send whatever
+// we have; a #-terminated code was abandoned.
+func (e *codeEntry) expire() {
+	if e.length > 0 {
+		e.finish()
+	} else if len(e.keys) > 0 {
+		log.Printf("Keypad: code timeout before #, discarding %d keys", len(e.keys))
+		e.reset()
+	}
+}
+
+func (e *codeEntry) finish() {
+	if len(e.keys) > 0 {
+		e.submit(strings.Join(e.keys, ""))
+	}
+	e.reset()
+}
+
+func (e *codeEntry) reset() {
+	e.keys = nil
+	stopTimer(e.timer)
+}
+
+func (e *codeEntry) stop() {
+	e.timer.Stop()
+}
+
+// decodeCard decodes frame with the format of its length.
+func decodeCard(formats []CardFormat, frame []int) (Card, error) {
+	idx := slices.IndexFunc(formats, func(f CardFormat) bool { return f.Bits == len(frame) })
+	if idx < 0 {
+		return Card{}, fmt.Errorf("no card format for %d-bit frame", len(frame))
+	}
+	return formats[idx].Decode(frame)
+}
+
+// stopTimer stops t and drains a pending fire so it can be Reset.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
 	}
+}
 
+// parseKeypadFrame interprets a 4-bit or 8-bit Wiegand keypad frame.
+// 8-bit frames carry the key in the high nibble and its bitwise complement in
+// the low nibble; frames whose nibbles do not match are rejected.
+func parseKeypadFrame(bits []int) (string, error) {
 	var val uint8
 	for _, b := range bits {
 		if b != 0 && b != 1 {
-			return "", 0, fmt.Errorf("invalid bit %d in frame", b)
+			return "", fmt.Errorf("invalid bit %d in frame", b)
 		}
 		val = (val << 1) | uint8(b)
 	}
 
-	var key string
-	switch val {
-	case 0x0:
-		key = "0"
-	case 0x1:
-		key = "1"
-	case 0x2:
-		key = "2"
-	case 0x3:
-		key = "3"
-	case 0x4:
-		key = "4"
-	case 0x5:
-		key = "5"
-	case 0x6:
-		key = "6"
-	case 0x7:
-		key = "7"
-	case 0x8:
-		key = "8"
-	case 0x9:
-		key = "9"
-	case 0xA:
-		key = "*"
-	case 0xB:
-		key = "#"
+	switch len(bits) {
+	case KeypadFrame4:
+		return keypadKey(val), nil
+	case KeypadFrame8:
+		key, check := val>>4, val&0x0F
+		if check != ^key&0x0F {
+			return "", fmt.Errorf("complement mismatch in frame %08b", val)
+		}
+		return keypadKey(key), nil
 	default:
-		key = "?"
+		return "", fmt.Errorf("expected %d or %d-bit keypad frame, got %d bits", KeypadFrame4, KeypadFrame8, len(bits))
 	}
+}
 
-	return key, val, nil
+// keypadKey maps a 4-bit key value.
+// Typical mapping:
+//
+//	0x0-0x9 => '0'-'9'
+//	0xA     => '*'
+//	0xB     => '#'
+func keypadKey(val uint8) string {
+	switch {
+	case val <= 0x9:
+		return string(rune('0' + val))
+	case val == 0xA:
+		return keyClear
+	case val == 0xB:
+		return keyEnter
+	default:
+		return "?"
+	}
 }
 
 // Stop halts edge watching and releases GPIO lines.
 func (k *KeypadReader) Stop() {
-	log.Println("Wiegand: stopping keypad reader (gpiocdev)")
+	log.Println("Wiegand: stopping keypad reader")
 	close(k.stopCh)
 	if k.d0 != nil {
 		_ = k.d0.Close()
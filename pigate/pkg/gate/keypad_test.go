@@ -0,0 +1,168 @@
+package gate
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"pigate/pkg/gpio"
+)
+
+const (
+	keyStar uint8 = 0xA
+	keyHash uint8 = 0xB
+)
+
+// sendFrame pulses D0/D1 for each bit of frame, MSB first.
+func sendFrame(t *testing.T, pins *gpio.Fake, cfg KeypadConfig, frame uint8) {
+	t.Helper()
+	for bit := cfg.FrameBits - 1; bit >= 0; bit-- {
+		pin := cfg.D0Pin
+		if frame>>bit&1 == 1 {
+			pin = cfg.D1Pin
+		}
+		if err := pins.Pulse(pin); err != nil {
+			t.Fatalf("Pulse(%d) error = %v", pin, err)
+		}
+	}
+}
+
+// sendKeys sends each key as one frame in cfg's format.
+func sendKeys(t *testing.T, pins *gpio.Fake, cfg KeypadConfig, keys ...uint8) {
+	t.Helper()
+	for _, key := range keys {
+		frame := key
+		if cfg.FrameBits == KeypadFrame8 {
+			frame = key<<4 | ^key&0x0F
+		}
+		sendFrame(t, pins, cfg, frame)
+	}
+}
+
+type parseErrorCounter struct {
+	noMetrics
+	mu     sync.Mutex
+	errors int
+}
+
+func (m *parseErrorCounter) KeypadParseError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors++
+}
+
+func (m *parseErrorCounter) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errors
+}
+
+func startKeypad(t *testing.T, cfg KeypadConfig) (*gpio.Fake, *parseErrorCounter, chan string) {
+	t.Helper()
+	pins := gpio.NewFake(nil)
+	reader := NewKeypadReader(pins, cfg)
+	metrics := &parseErrorCounter{}
+	reader.SetMetrics(metrics)
+	codes := make(chan string, 4)
+	if err := reader.Start(func(code string) { codes <- code }); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(reader.Stop)
+	return pins, metrics, codes
+}
+
+func wantCode(t *testing.T, codes chan string, want string) {
+	t.Helper()
+	select {
+	case code := <-codes:
+		if code != want {
+			t.Errorf("code = %q, want %q", code, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no code received, want %q", want)
+	}
+}
+
+func TestKeypadReaderDecodesWiegand(t *testing.T) {
+	cfg := DefaultKeypadConfig()
+	pins, _, codes := startKeypad(t, cfg)
+
+	sendKeys(t, pins, cfg, 0x1, 0x2, 0x9, 0x0, 0x5)
+	wantCode(t, codes, "12905")
+}
+
+func TestKeypadReaderClearAndEnter(t *testing.T) {
+	cfg := DefaultKeypadConfig()
+	pins, _, codes := startKeypad(t, cfg)
+
+	// * discards the keys before it; # submits a short code early.
+	sendKeys(t, pins, cfg, 0x9, 0x9, keyStar, 0x4, 0x2, keyHash)
+	wantCode(t, codes, "42")
+}
+
+func TestKeypadReaderVariableLength(t *testing.T) {
+	cfg := DefaultKeypadConfig()
+	cfg.CodeLength = 0
+	pins, _, codes := startKeypad(t, cfg)
+
+	sendKeys(t, pins, cfg, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, keyHash)
+	wantCode(t, codes, "1234567")
+	sendKeys(t, pins, cfg, keyHash) // nothing entered: no code
+	sendKeys(t, pins, cfg, 0x8, keyHash)
+	wantCode(t, codes, "8")
+}
+
+func TestKeypadReaderEightBitFrames(t *testing.T) {
+	cfg := DefaultKeypadConfig()
+	cfg.FrameBits = KeypadFrame8
+	cfg.CodeLength = 0
+	pins, metrics, codes := startKeypad(t, cfg)
+
+	sendKeys(t, pins, cfg, 0x3, 0x1)
+	sendFrame(t, pins, cfg, 0x70) // 7 with a bad complement: dropped
+	sendKeys(t, pins, cfg, 0x4, keyHash)
+	wantCode(t, codes, "314")
+	if got := metrics.count(); got != 1 {
+		t.Errorf("parse errors = %d, want 1", got)
+	}
+}
+
+func TestParseKeypadFrame(t *testing.T) {
+	tests := []struct {
+		bits    []int
+		want    string
+		wantErr bool
+	}{
+		{bits: []int{0, 0, 0, 0}, want: "0"},
+		{bits: []int{0, 1, 1, 1}, want: "7"},
+		{bits: []int{1, 0, 1, 0}, want: "*"},
+		{bits: []int{1, 0, 1, 1}, want: "#"},
+		{bits: []int{1, 1, 1, 1}, want: "?"},
+		{bits: []int{0, 1, 1, 1, 1, 0, 0, 0}, want: "7"},
+		{bits: []int{1, 0, 1, 1, 0, 1, 0, 0}, want: "#"},
+		{bits: []int{0, 1, 1, 1, 0, 1, 1, 1}, wantErr: true},
+		{bits: []int{1, 0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseKeypadFrame(tt.bits)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseKeypadFrame(%v) = %q, %v, want %q (error %t)", tt.bits, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestKeypadConfigValidate(t *testing.T) {
+	if err := DefaultKeypadConfig().Validate(); err != nil {
+		t.Errorf("DefaultKeypadConfig().Validate() = %v", err)
+	}
+	cfg := DefaultKeypadConfig()
+	cfg.FrameBits = 26
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted 26-bit keypad frames")
+	}
+	cfg = DefaultKeypadConfig()
+	cfg.D1Pin = cfg.D0Pin
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted D0 == D1")
+	}
+}
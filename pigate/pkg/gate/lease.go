@@ -0,0 +1,136 @@
+package gate
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// MaxUpdateLease bounds how long the Update Agent can hold the gate closed.
+const MaxUpdateLease = 10 * time.Minute
+
+var (
+	// ErrUpdateInProgress is returned by open transitions while an update
+	// lease is held.
+	ErrUpdateInProgress = errors.New("update in progress")
+	// ErrNotSafeToUpdate is returned when a lease is requested while the
+	// gate is not closed or an operation is in progress.
+	ErrNotSafeToUpdate = errors.New("gate is not in a safe update state")
+	ErrLeaseHeld       = errors.New("another update lease is held")
+	ErrLeaseNotFound   = errors.New("update lease not found or expired")
+)
+
+// UpdateLease keeps the gate closed while the Update Agent replaces the
+// binary. Closing the gate is still allowed during a lease; opening is not.
+type UpdateLease struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// State returns the current gate state.
+func (g *GateController) State() GateState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.state
+}
+
+// SafeToUpdate reports whether the gate is closed with no operation in
+// progress, as AcquireUpdateLease requires.
+func (g *GateController) SafeToUpdate() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.safeToUpdate()
+}
+
+// UpdateLease returns the active lease, if any.
+func (g *GateController) UpdateLease() (UpdateLease, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.leaseActive() {
+		return UpdateLease{}, false
+	}
+	return *g.lease, true
+}
+
+// AcquireUpdateLease puts the gate in a Safe Update State for ttl, capped at
+// MaxUpdateLease. It fails unless the gate is closed, no card is waiting for
+// its PIN, the limit switches, if wired, report the gate closed, and no other
+// lease is held.
+func (g *GateController) AcquireUpdateLease(ttl time.Duration) (UpdateLease, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.safeToUpdate() {
+		return UpdateLease{}, ErrNotSafeToUpdate
+	}
+	if g.leaseActive() {
+		return UpdateLease{}, ErrLeaseHeld
+	}
+	id, err := newLeaseID()
+	if err != nil {
+		return UpdateLease{}, err
+	}
+	g.lease = &UpdateLease{ID: id, ExpiresAt: g.now().Add(clampLease(ttl))}
+	return *g.lease, nil
+}
+
+// RenewUpdateLease extends the lease with id by ttl from now.
+func (g *GateController) RenewUpdateLease(id string, ttl time.Duration) (UpdateLease, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.leaseActive() || g.lease.ID != id {
+		return UpdateLease{}, ErrLeaseNotFound
+	}
+	g.lease.ExpiresAt = g.now().Add(clampLease(ttl))
+	return *g.lease, nil
+}
+
+// ReleaseUpdateLease ends the lease with id early.
+func (g *GateController) ReleaseUpdateLease(id string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.leaseActive() || g.lease.ID != id {
+		return ErrLeaseNotFound
+	}
+	g.lease = nil
+	return nil
+}
+
+// safeToUpdate reports whether no operation is in progress. Callers hold
+// g.mu.
+func (g *GateController) safeToUpdate() bool {
+	if g.state != Closed {
+		return false
+	}
+	if g.pending != nil && g.now().Before(g.pending.deadline) {
+		return false
+	}
+	return !g.sensorCfg.limits() || g.position == PositionClosed
+}
+
+// leaseActive reports whether an unexpired lease is held. Callers hold g.mu.
+func (g *GateController) leaseActive() bool {
+	if g.lease == nil {
+		return false
+	}
+	if !g.now().Before(g.lease.ExpiresAt) {
+		g.lease = nil
+		return false
+	}
+	return true
+}
+
+func clampLease(ttl time.Duration) time.Duration {
+	if ttl <= 0 || ttl > MaxUpdateLease {
+		return MaxUpdateLease
+	}
+	return ttl
+}
+
+func newLeaseID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,196 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"pigate/pkg/database"
+	"pigate/pkg/messenger"
+)
+
+// leaseGateManager serves one regular-open credential valid all day.
+type leaseGateManager struct {
+	database.GateManager
+	mu   sync.Mutex
+	logs []database.GateLog
+}
+
+func (m *leaseGateManager) GetCredential(ctx context.Context, code string) (*database.Credential, error) {
+	return &database.Credential{Code: code, Username: "tenant", OpenMode: database.RegularOpen}, nil
+}
+
+func (m *leaseGateManager) GetCredentialByDuressCode(ctx context.Context, code string) (*database.Credential, error) {
+	return nil, nil
+}
+
+func (m *leaseGateManager) GetAccessTime(ctx context.Context, group int) (*database.AccessTime, error) {
+	return &database.AccessTime{
+		StartTime: time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(0, 1, 1, 23, 59, 59, 0, time.UTC),
+	}, nil
+}
+
+func (m *leaseGateManager) PutGateLog(ctx context.Context, log database.GateLog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logs = append(m.logs, log)
+	return nil
+}
+
+type accessRecorder struct {
+	events chan messenger.AccessEvent
+}
+
+func (r *accessRecorder) NotifyAccess(event messenger.AccessEvent) error {
+	r.events <- event
+	return nil
+}
+
+func newLeaseController(now *time.Time) (*GateController, *accessRecorder) {
+	g := &GateController{
+		gm:               &leaseGateManager{},
+		state:            Closed,
+		gateOpenDuration: 60,
+		metrics:          noMetrics{},
+		now:              func() time.Time { return *now },
+		afterFunc:        func(time.Duration, func()) {},
+		cardPINTimeout:   DefaultCardPINTimeout,
+	}
+	access := &accessRecorder{events: make(chan messenger.AccessEvent, 4)}
+	g.SetAccessNotifier(access)
+	return g, access
+}
+
+func TestUpdateLeaseBlocksKeypadOpen(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, access := newLeaseController(&now)
+
+	lease, err := g.AcquireUpdateLease(time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireUpdateLease() error = %v", err)
+	}
+	if err := g.Open("12345", now); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if g.State() != Closed {
+		t.Fatalf("State() = %v during lease, want closed", g.State())
+	}
+	event := <-access.events
+	if event.Result != messenger.AccessDenied || event.Reason != ReasonUpdateInProgress {
+		t.Errorf("access event = %+v, want denied %s", event, ReasonUpdateInProgress)
+	}
+
+	if err := g.ReleaseUpdateLease(lease.ID); err != nil {
+		t.Fatalf("ReleaseUpdateLease() error = %v", err)
+	}
+	if err := g.Open("12345", now); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if g.State() != Open {
+		t.Errorf("State() = %v after release, want open", g.State())
+	}
+	if event := <-access.events; event.Result != messenger.AccessGranted {
+		t.Errorf("access event after release = %+v, want granted", event)
+	}
+}
+
+func TestUpdateLeaseExpires(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, _ := newLeaseController(&now)
+
+	lease, err := g.AcquireUpdateLease(time.Hour)
+	if err != nil {
+		t.Fatalf("AcquireUpdateLease() error = %v", err)
+	}
+	if got := lease.ExpiresAt.Sub(now); got != MaxUpdateLease {
+		t.Errorf("lease length = %v, want capped at %v", got, MaxUpdateLease)
+	}
+	if err := g.lockOpen(); !errors.Is(err, ErrUpdateInProgress) {
+		t.Errorf("lockOpen() during lease = %v, want ErrUpdateInProgress", err)
+	}
+
+	now = now.Add(MaxUpdateLease)
+	if _, held := g.UpdateLease(); held {
+		t.Error("lease still held after expiry")
+	}
+	if err := g.lockOpen(); err != nil {
+		t.Errorf("lockOpen() after expiry = %v", err)
+	}
+}
+
+func TestUpdateLeaseRequiresClosedGate(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, _ := newLeaseController(&now)
+
+	if err := g.lockOpen(); err != nil {
+		t.Fatalf("lockOpen() error = %v", err)
+	}
+	if _, err := g.AcquireUpdateLease(time.Minute); !errors.Is(err, ErrNotSafeToUpdate) {
+		t.Errorf("AcquireUpdateLease() while open = %v, want ErrNotSafeToUpdate", err)
+	}
+
+	if err := g.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	lease, err := g.AcquireUpdateLease(time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireUpdateLease() error = %v", err)
+	}
+	if _, err := g.AcquireUpdateLease(time.Minute); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("second AcquireUpdateLease() = %v, want ErrLeaseHeld", err)
+	}
+	if _, err := g.RenewUpdateLease("other", time.Minute); !errors.Is(err, ErrLeaseNotFound) {
+		t.Errorf("RenewUpdateLease(other) = %v, want ErrLeaseNotFound", err)
+	}
+	now = now.Add(30 * time.Second)
+	renewed, err := g.RenewUpdateLease(lease.ID, time.Minute)
+	if err != nil || !renewed.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("RenewUpdateLease() = %+v, %v", renewed, err)
+	}
+}
+
+func TestUpdateLeaseWaitsForPendingCard(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, access := newLeaseController(&now)
+	g.gm = &cardGateManager{pin: "4321"}
+
+	_ = g.OpenCard(Card{Format: "H10301", Facility: 12, Number: 34567}, now)
+	if _, err := g.AcquireUpdateLease(time.Minute); !errors.Is(err, ErrNotSafeToUpdate) {
+		t.Errorf("AcquireUpdateLease() while a card waits for its PIN = %v, want ErrNotSafeToUpdate", err)
+	}
+
+	// Once the PIN is due the card no longer blocks the lease.
+	now = now.Add(DefaultCardPINTimeout)
+	if _, err := g.AcquireUpdateLease(time.Minute); err != nil {
+		t.Errorf("AcquireUpdateLease() after the PIN timeout = %v", err)
+	}
+	if len(access.events) > 0 {
+		t.Errorf("unexpected access event %+v", <-access.events)
+	}
+}
+
+func TestUpdateLeaseWaitsForLimitSwitches(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, _, _ := newSensingController(t, &now, SensorConfig{OpenPin: 5, ClosedPin: 6})
+
+	// Commanded closed, but still travelling or not read yet.
+	for _, s := range []*SensorState{nil, {}, {Open: true}} {
+		if s != nil {
+			g.UpdateSensors(*s)
+		}
+		if g.SafeToUpdate() {
+			t.Errorf("SafeToUpdate() at %v = true, want false", g.Position())
+		}
+		if _, err := g.AcquireUpdateLease(time.Minute); !errors.Is(err, ErrNotSafeToUpdate) {
+			t.Errorf("AcquireUpdateLease() at %v = %v, want ErrNotSafeToUpdate", g.Position(), err)
+		}
+	}
+
+	g.UpdateSensors(SensorState{Closed: true})
+	if _, err := g.AcquireUpdateLease(time.Minute); err != nil {
+		t.Errorf("AcquireUpdateLease() with the gate closed = %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package gate
+
+import (
+	"errors"
+	"log"
+)
+
+// ErrLockdown is returned by open transitions while the facility is in
+// lockdown.
+var ErrLockdown = errors.New("facility in lockdown")
+
+// SetLockdown puts the facility in lockdown or ends it. During a lockdown the
+// gate is closed, credentials are denied with ReasonLockdown, and open and
+// hold open commands are refused. The free-exit inputs still open the gate
+// unless SetSuppressExitInLockdown is set. A lockdown is not kept across a
+// restart.
+func (g *GateController) SetLockdown(on bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.lockdown == on {
+		return
+	}
+	g.lockdown = on
+	if !on {
+		log.Println("Lockdown ended")
+		return
+	}
+	log.Println("Lockdown started; closing the gate")
+	if g.state == Open || g.state == LockedOpen {
+		g.closeLockedOrOpen()
+	}
+}
+
+// Lockdown reports whether the facility is in lockdown.
+func (g *GateController) Lockdown() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.lockdown
+}
+
+// SetSuppressExitInLockdown makes the request-to-exit button and vehicle
+// loop open nothing during a lockdown.
+func (g *GateController) SetSuppressExitInLockdown(suppress bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.suppressExit = suppress
+}
@@ -0,0 +1,504 @@
+package gate
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"pigate/pkg/serial"
+)
+
+// osdpOfflineAfter is how many polls in a row may go unanswered before the
+// reader is considered offline and the channel is set up again.
+const osdpOfflineAfter = 3
+
+// OSDPConfig describes an OSDP reader on a serial line and the codes it
+// reports.
+type OSDPConfig struct {
+	Address      int           // reader address, 0 to 126
+	SCBK         []byte        // 16-byte secure channel base key; empty for a plain channel
+	PollInterval time.Duration // time between polls
+	ReplyTimeout time.Duration // max wait for each reply
+	CodeLength   int           // submit after this many keys; 0 waits for #
+	CodeTimeout  time.Duration // max gap between keys in a code
+	CardFormats  []CardFormat  // formats of the card bits the reader reports
+}
+
+// DefaultOSDPConfig polls reader 0 every 200ms without a secure channel and
+// assembles 5-key codes, like DefaultKeypadConfig.
+func DefaultOSDPConfig() OSDPConfig {
+	return OSDPConfig{
+		Address:      0,
+		PollInterval: 200 * time.Millisecond,
+		ReplyTimeout: 200 * time.Millisecond,
+		CodeLength:   5,
+		CodeTimeout:  3 * time.Second,
+		CardFormats:  StandardCardFormats,
+	}
+}
+
+// Validate reports settings the reader cannot work with.
+func (c OSDPConfig) Validate() error {
+	switch {
+	case c.Address < 0 || c.Address > 126:
+		return fmt.Errorf("osdp address must be 0 to 126, got %d", c.Address)
+	case len(c.SCBK) != 0 && len(c.SCBK) != 16:
+		return fmt.Errorf("osdp secure channel key must be 16 bytes, got %d", len(c.SCBK))
+	case c.PollInterval <= 0 || c.ReplyTimeout <= 0 || c.CodeTimeout <= 0:
+		return errors.New("osdp poll interval and timeouts must be positive")
+	case c.CodeLength < 0 || c.CodeLength > maxCodeLength:
+		return fmt.Errorf("osdp code length must be 0 to %d, got %d", maxCodeLength, c.CodeLength)
+	}
+	for _, f := range c.CardFormats {
+		if err := f.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OSDPColor is an OSDP LED color.
+type OSDPColor byte
+
+const (
+	OSDPBlack OSDPColor = iota
+	OSDPRed
+	OSDPGreen
+	OSDPAmber
+	OSDPBlue
+)
+
+// OSDPLED sets a reader LED. With a Duration it is a temporary pattern, after
+// which the LED returns to its permanent state; without one it sets the
+// permanent state. Off of zero shows OnColor steadily.
+type OSDPLED struct {
+	LED               int
+	OnColor, OffColor OSDPColor
+	On, Off           time.Duration // flash timing, in 100ms steps
+	Duration          time.Duration
+}
+
+// OSDPBuzzer sounds the reader's buzzer Count times.
+type OSDPBuzzer struct {
+	On, Off time.Duration // in 100ms steps
+	Count   int
+}
+
+// osdpCommand is a command queued for the next poll.
+type osdpCommand struct {
+	code byte
+	data []byte
+}
+
+// OSDPReader is an OSDP control panel for one reader on a serial line. It
+// polls the reader, passes card reads and keypad codes on the same way
+// KeypadReader does, and sends LED and buzzer commands. With an SCBK it
+// talks to the reader over a secure channel and drops it if the channel
+// cannot be set up.
+type OSDPReader struct {
+	port serial.Port
+	cfg  OSDPConfig
+
+	metrics Metrics
+	onCard  func(Card)
+	cmds    chan osdpCommand
+	packets chan []byte
+	stopCh  chan struct{}
+
+	// Owned by the run goroutine.
+	online   bool
+	seq      byte
+	session  *osdpSession
+	failures int
+}
+
+// NewOSDPReader prepares a reader on port but does not start it. The reader
+// closes port when stopped.
+func NewOSDPReader(port serial.Port, cfg OSDPConfig) *OSDPReader {
+	return &OSDPReader{
+		port:    port,
+		cfg:     cfg,
+		metrics: noMetrics{},
+		cmds:    make(chan osdpCommand, 8),
+		packets: make(chan []byte, 8),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// SetMetrics reports undecodable card reads to m. Call it before Start.
+func (r *OSDPReader) SetMetrics(m Metrics) {
+	r.metrics = m
+}
+
+// SetCardHandler passes each card read to onCard. Call it before Start;
+// without it, card reads are logged and dropped.
+func (r *OSDPReader) SetCardHandler(onCard func(Card)) {
+	r.onCard = onCard
+}
+
+// Start begins polling. onCodeReceived is called with each complete keypad
+// code.
+func (r *OSDPReader) Start(onCodeReceived func(code string)) error {
+	if err := r.cfg.Validate(); err != nil {
+		return err
+	}
+	secure := "plain"
+	if len(r.cfg.SCBK) > 0 {
+		secure = "secure channel"
+	}
+	log.Printf("OSDP: polling reader %d every %v (%s)", r.cfg.Address, r.cfg.PollInterval, secure)
+	go r.readPackets()
+	go r.run(onCodeReceived)
+	return nil
+}
+
+// Stop halts polling and closes the serial port.
+func (r *OSDPReader) Stop() {
+	log.Println("OSDP: stopping reader")
+	close(r.stopCh)
+	_ = r.port.Close()
+}
+
+// SetLED queues an LED command for the reader. Queued commands are sent in
+// place of polls once the reader is online.
+func (r *OSDPReader) SetLED(led OSDPLED) {
+	temp, perm := byte(0), byte(1)
+	timer := osdpTenths(led.Duration)
+	if led.Duration > 0 {
+		temp, perm = 2, 0
+	}
+	on, off := osdpTenths(led.On), osdpTenths(led.Off)
+	if on == 0 {
+		on = 1
+	}
+	r.queue(osdpLED, []byte{
+		0, byte(led.LED),
+		temp, byte(on), byte(off), byte(led.OnColor), byte(led.OffColor), byte(timer), byte(timer >> 8),
+		perm, byte(on), byte(off), byte(led.OnColor), byte(led.OffColor),
+	})
+}
+
+// Buzz queues a buzzer command for the reader.
+func (r *OSDPReader) Buzz(b OSDPBuzzer) {
+	r.queue(osdpBUZ, []byte{0, 2, byte(osdpTenths(b.On)), byte(osdpTenths(b.Off)), byte(b.Count)})
+}
+
+func (r *OSDPReader) queue(code byte, data []byte) {
+	select {
+	case r.cmds <- osdpCommand{code: code, data: data}:
+	default:
+		log.Printf("OSDP: command queue full, dropping command %#x", code)
+	}
+}
+
+// osdpTenths converts d to the 100ms units OSDP timers use.
+func osdpTenths(d time.Duration) int {
+	return min(int(d/(100*time.Millisecond)), 0xFFFF)
+}
+
+// readPackets splits the serial stream into packets until the port is
+// closed.
+func (r *OSDPReader) readPackets() {
+	var buf []byte
+	chunk := make([]byte, 256)
+	for {
+		n, err := r.port.Read(chunk)
+		if err != nil {
+			select {
+			case <-r.stopCh:
+			default:
+				log.Printf("OSDP: serial read failed: %v", err)
+			}
+			return
+		}
+		buf = append(buf, chunk[:n]...)
+		for {
+			var packet []byte
+			packet, buf = nextOSDPPacket(buf)
+			if packet == nil {
+				break
+			}
+			select {
+			case r.packets <- append([]byte(nil), packet...):
+			default:
+				log.Println("OSDP: reply queue full, dropping packet")
+			}
+		}
+	}
+}
+
+// run polls the reader every PollInterval and assembles keypad codes.
+func (r *OSDPReader) run(onCodeReceived func(code string)) {
+	entry := newCodeEntry(r.cfg.CodeLength, r.cfg.CodeTimeout, r.metrics, onCodeReceived)
+	defer entry.stop()
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-entry.timer.C:
+			entry.expire()
+		case <-ticker.C:
+			r.poll(entry)
+		}
+	}
+}
+
+// poll sends one queued command, or a poll, and handles the reply. An
+// offline reader is brought back online first.
+func (r *OSDPReader) poll(entry *codeEntry) {
+	if !r.online {
+		if err := r.connect(entry); err != nil {
+			r.failures++
+			if r.failures == osdpOfflineAfter {
+				log.Printf("OSDP: reader %d not responding: %v", r.cfg.Address, err)
+			}
+			return
+		}
+		r.online, r.failures = true, 0
+		log.Printf("OSDP: reader %d online", r.cfg.Address)
+	}
+
+	cmd := osdpCommand{code: osdpPOLL}
+	select {
+	case cmd = <-r.cmds:
+	default:
+	}
+	reply, err := r.transact(cmd.code, nil, cmd.data)
+	if err != nil {
+		r.failures++
+		if r.failures >= osdpOfflineAfter {
+			log.Printf("OSDP: reader %d offline: %v", r.cfg.Address, err)
+			r.offline()
+		}
+		return
+	}
+	r.failures = 0
+	r.handleReply(reply, entry)
+}
+
+// offline drops the sequence and secure channel, so the next poll starts
+// over.
+func (r *OSDPReader) offline() {
+	r.online, r.seq, r.session = false, 0, nil
+}
+
+// connect resets the reader's sequence and, with an SCBK, sets up the
+// secure channel. A report in reply to the first poll is only trusted on a
+// plain channel.
+func (r *OSDPReader) connect(entry *codeEntry) error {
+	r.offline()
+	reply, err := r.transact(osdpPOLL, nil, nil)
+	if err != nil {
+		return err
+	}
+	if len(r.cfg.SCBK) == 0 {
+		r.handleReply(reply, entry)
+		return nil
+	}
+	if reply.code == osdpRAW || reply.code == osdpKEYPAD {
+		log.Printf("OSDP: dropping report %#x sent outside the secure channel", reply.code)
+	}
+	if err := r.startSecureChannel(); err != nil {
+		r.offline()
+		return fmt.Errorf("secure channel: %w", err)
+	}
+	return nil
+}
+
+// startSecureChannel runs the challenge and cryptogram exchange.
+func (r *OSDPReader) startSecureChannel() error {
+	keyFlag := byte(1)
+	if subtle.ConstantTimeCompare(r.cfg.SCBK, OSDPInstallKey) == 1 {
+		keyFlag = 0
+	}
+	cpRandom := make([]byte, 8)
+	if _, err := rand.Read(cpRandom); err != nil {
+		return err
+	}
+	reply, err := r.transact(osdpCHLNG, []byte{3, osdpSCS11, keyFlag}, cpRandom)
+	if err != nil {
+		return err
+	}
+	if reply.code != osdpCCRYPT || reply.scbType() != osdpSCS12 || len(reply.data) != 32 {
+		return fmt.Errorf("unexpected reply %#x to challenge", reply.code)
+	}
+	pdRandom, pdCryptogram := reply.data[8:16], reply.data[16:32]
+
+	session, err := newOSDPSession(r.cfg.SCBK, cpRandom)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(pdCryptogram, session.cryptogram(cpRandom, pdRandom)) != 1 {
+		return errors.New("reader uses a different key")
+	}
+	cpCryptogram := session.cryptogram(pdRandom, cpRandom)
+	reply, err = r.transact(osdpSCRYPT, []byte{3, osdpSCS13, keyFlag}, cpCryptogram)
+	if err != nil {
+		return err
+	}
+	if reply.code != osdpRMACI || reply.scbType() != osdpSCS14 || len(reply.data) != 16 {
+		return fmt.Errorf("unexpected reply %#x to cryptogram", reply.code)
+	}
+	rmac := session.initialRMAC(cpCryptogram)
+	if subtle.ConstantTimeCompare(reply.data, rmac[:]) != 1 {
+		return errors.New("reader rejected the cryptogram")
+	}
+	session.rMAC = rmac
+	r.session = session
+	return nil
+}
+
+// transact sends one command and waits for its reply. Once the secure
+// channel is up, commands without their own SCB are sent with a MAC and
+// encrypted data, and replies are checked and decrypted.
+func (r *OSDPReader) transact(code byte, scb, data []byte) (osdpPacket, error) {
+	p := osdpPacket{addr: byte(r.cfg.Address), seq: r.seq, scb: scb, code: code, data: data}
+	var mac func([]byte) []byte
+	if r.session != nil && scb == nil {
+		p.scb = []byte{2, osdpSCS15}
+		if len(data) > 0 {
+			p.scb = []byte{2, osdpSCS17}
+			p.data = r.session.encrypt(data, true)
+		}
+		mac = func(signed []byte) []byte { return r.session.mac(signed, true) }
+	}
+
+	// Drop stale replies from earlier, timed out commands.
+	for len(r.packets) > 0 {
+		<-r.packets
+	}
+	if _, err := r.port.Write(p.marshal(mac)); err != nil {
+		return osdpPacket{}, err
+	}
+
+	timeout := time.NewTimer(r.cfg.ReplyTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-r.stopCh:
+			return osdpPacket{}, errors.New("osdp: reader stopped")
+		case <-timeout.C:
+			return osdpPacket{}, fmt.Errorf("no reply to command %#x", code)
+		case b := <-r.packets:
+			reply, err := unmarshalOSDP(b)
+			if err != nil {
+				return osdpPacket{}, err
+			}
+			// Half-duplex adapters echo our own command back.
+			if !reply.reply || reply.addr != p.addr {
+				continue
+			}
+			if reply.seq != p.seq {
+				return osdpPacket{}, fmt.Errorf("reply sequence %d, want %d", reply.seq, p.seq)
+			}
+			if err := r.openReply(&reply); err != nil {
+				r.offline()
+				return osdpPacket{}, err
+			}
+			if reply.code != osdpBUSY {
+				r.seq = r.seq%3 + 1
+			}
+			return reply, nil
+		}
+	}
+}
+
+// openReply checks the MAC of a secure reply and decrypts its data.
+func (r *OSDPReader) openReply(reply *osdpPacket) error {
+	if r.session == nil {
+		return nil
+	}
+	t := reply.scbType()
+	if t != osdpSCS16 && t != osdpSCS18 {
+		if reply.code == osdpNAK {
+			return nil // a reader may NAK without a MAC, e.g. after losing the session
+		}
+		return fmt.Errorf("reply %#x outside the secure channel", reply.code)
+	}
+	if subtle.ConstantTimeCompare(reply.mac, r.session.mac(reply.signed, false)[:osdpMACLen]) != 1 {
+		return errors.New("reply MAC does not match")
+	}
+	if t == osdpSCS18 {
+		data, err := r.session.decrypt(reply.data, false)
+		if err != nil {
+			return err
+		}
+		reply.data = data
+	}
+	return nil
+}
+
+// handleReply acts on card reads, key presses and errors.
+func (r *OSDPReader) handleReply(reply osdpPacket, entry *codeEntry) {
+	switch reply.code {
+	case osdpRAW:
+		if len(reply.data) < 4 {
+			log.Printf("OSDP: short card report")
+			r.metrics.KeypadParseError()
+			return
+		}
+		count := int(reply.data[2]) | int(reply.data[3])<<8
+		bits, err := osdpBits(reply.data[4:], count)
+		if err == nil {
+			var card Card
+			if card, err = decodeCard(r.cfg.CardFormats, bits); err == nil {
+				r.card(card)
+				return
+			}
+		}
+		log.Printf("OSDP card parse error: %v", err)
+		r.metrics.KeypadParseError()
+	case osdpKEYPAD:
+		if len(reply.data) < 2 || len(reply.data) < 2+int(reply.data[1]) {
+			log.Printf("OSDP: short keypad report")
+			r.metrics.KeypadParseError()
+			return
+		}
+		for _, c := range reply.data[2 : 2+int(reply.data[1])] {
+			key, ok := osdpKey(c)
+			if !ok {
+				r.metrics.KeypadParseError()
+				continue
+			}
+			entry.key(key)
+		}
+	case osdpNAK:
+		errCode := byte(0)
+		if len(reply.data) > 0 {
+			errCode = reply.data[0]
+		}
+		log.Printf("OSDP: reader %d rejected a command (error %d)", r.cfg.Address, errCode)
+		if errCode == osdpNAKSeq || errCode == osdpNAKCond {
+			r.offline()
+		}
+	}
+}
+
+func (r *OSDPReader) card(card Card) {
+	if r.onCard == nil {
+		log.Printf("OSDP: no card handler, dropping %s card %s", card.Format, card.Code())
+		return
+	}
+	r.onCard(card)
+}
+
+// osdpKey maps a keypad report byte: digits are ASCII, * is 0x7F and # is
+// 0x0D.
+func osdpKey(c byte) (string, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return string(rune(c)), true
+	case c == 0x7F:
+		return keyClear, true
+	case c == 0x0D:
+		return keyEnter, true
+	default:
+		return "", false
+	}
+}
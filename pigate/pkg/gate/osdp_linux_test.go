@@ -0,0 +1,260 @@
+//go:build linux
+
+package gate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"pigate/pkg/serial"
+)
+
+// simulatedPD is an OSDP peripheral on the controller side of a pty. It acks
+// commands, answers polls with queued reports and, with a key, runs the
+// peripheral half of the secure channel.
+type simulatedPD struct {
+	t    *testing.T
+	port *os.File
+	scbk []byte
+
+	mu       sync.Mutex
+	reports  []osdpPacket
+	commands []osdpPacket // LED and BUZ commands, decrypted
+	secured  int          // commands received inside the secure channel
+
+	session  *osdpSession
+	cpRandom []byte
+	pdRandom []byte
+}
+
+func newSimulatedPD(t *testing.T, scbk []byte) (*simulatedPD, serial.Port) {
+	t.Helper()
+	controller, path, err := serial.OpenPTY()
+	if err != nil {
+		t.Skipf("no pty available: %v", err)
+	}
+	port, err := serial.Open(serial.Config{Device: path, Baud: 115200})
+	if err != nil {
+		controller.Close()
+		t.Fatalf("Open(%s) error = %v", path, err)
+	}
+	pd := &simulatedPD{t: t, port: controller, scbk: scbk}
+	t.Cleanup(func() { controller.Close() })
+	go pd.run()
+	return pd, port
+}
+
+func (pd *simulatedPD) queue(code byte, data []byte) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	pd.reports = append(pd.reports, osdpPacket{code: code, data: data})
+}
+
+func (pd *simulatedPD) queueCard(bits string) {
+	n := len(bits)
+	data := []byte{0, 1, byte(n), byte(n >> 8)}
+	data = append(data, make([]byte, (n+7)/8)...)
+	for i, c := range bits {
+		if c == '1' {
+			data[4+i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	pd.queue(osdpRAW, data)
+}
+
+func (pd *simulatedPD) queueKeys(keys string) {
+	pd.queue(osdpKEYPAD, append([]byte{0, byte(len(keys))}, keys...))
+}
+
+func (pd *simulatedPD) securedCommands() int {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	return pd.secured
+}
+
+func (pd *simulatedPD) receivedCommands() []osdpPacket {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	return append([]osdpPacket(nil), pd.commands...)
+}
+
+func (pd *simulatedPD) run() {
+	var buf []byte
+	chunk := make([]byte, 256)
+	for {
+		n, err := pd.port.Read(chunk)
+		if err != nil {
+			return
+		}
+		buf = append(buf, chunk[:n]...)
+		for {
+			var b []byte
+			if b, buf = nextOSDPPacket(buf); b == nil {
+				break
+			}
+			p, err := unmarshalOSDP(b)
+			if err != nil || p.reply {
+				continue
+			}
+			if reply, ok := pd.handle(p); ok {
+				if _, err := pd.port.Write(reply); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// handle builds the reply to one command.
+func (pd *simulatedPD) handle(p osdpPacket) ([]byte, bool) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	reply := osdpPacket{addr: p.addr, reply: true, seq: p.seq, code: osdpACK}
+	switch p.scbType() {
+	case osdpSCS11:
+		pd.cpRandom = p.data
+		pd.pdRandom = make([]byte, 8)
+		_, _ = rand.Read(pd.pdRandom)
+		pd.session, _ = newOSDPSession(pd.scbk, pd.cpRandom)
+		reply.scb = []byte{3, osdpSCS12, p.scb[2]}
+		reply.code = osdpCCRYPT
+		reply.data = append(append(make([]byte, 8), pd.pdRandom...), pd.session.cryptogram(pd.cpRandom, pd.pdRandom)...)
+		return reply.marshal(nil), true
+	case osdpSCS13:
+		if pd.session == nil || !bytes.Equal(p.data, pd.session.cryptogram(pd.pdRandom, pd.cpRandom)) {
+			pd.session = nil
+			reply.code, reply.data = osdpNAK, []byte{osdpNAKCond}
+			return reply.marshal(nil), true
+		}
+		pd.session.rMAC = pd.session.initialRMAC(p.data)
+		reply.scb = []byte{3, osdpSCS14, 1}
+		reply.code, reply.data = osdpRMACI, pd.session.rMAC[:]
+		return reply.marshal(nil), true
+	case osdpSCS15, osdpSCS17:
+		if pd.session == nil || !bytes.Equal(p.mac, pd.session.mac(p.signed, true)[:osdpMACLen]) {
+			pd.t.Errorf("simulated PD: command %#x with a bad MAC", p.code)
+			return nil, false
+		}
+		if p.scbType() == osdpSCS17 {
+			data, err := pd.session.decrypt(p.data, true)
+			if err != nil {
+				pd.t.Errorf("simulated PD: %v", err)
+				return nil, false
+			}
+			p.data = data
+		}
+		pd.secured++
+	}
+
+	switch p.code {
+	case osdpPOLL:
+		// A keyed reader holds its reports until the secure channel is up.
+		if len(pd.reports) > 0 && (pd.scbk == nil || pd.session != nil && p.scb != nil) {
+			reply.code, reply.data = pd.reports[0].code, pd.reports[0].data
+			pd.reports = pd.reports[1:]
+		}
+	case osdpLED, osdpBUZ:
+		pd.commands = append(pd.commands, p)
+	}
+
+	if pd.session == nil || p.scb == nil {
+		return reply.marshal(nil), true
+	}
+	reply.scb = []byte{2, osdpSCS16}
+	if len(reply.data) > 0 {
+		reply.scb = []byte{2, osdpSCS18}
+		reply.data = pd.session.encrypt(reply.data, false)
+	}
+	session := pd.session
+	return reply.marshal(func(signed []byte) []byte { return session.mac(signed, false) }), true
+}
+
+func startOSDP(t *testing.T, port serial.Port, scbk []byte) (*OSDPReader, chan Card, chan string) {
+	t.Helper()
+	cfg := DefaultOSDPConfig()
+	cfg.SCBK = scbk
+	cfg.PollInterval = 10 * time.Millisecond
+	cfg.ReplyTimeout = 200 * time.Millisecond
+	cfg.CodeLength = 4
+	reader := NewOSDPReader(port, cfg)
+	cards := make(chan Card, 4)
+	codes := make(chan string, 4)
+	reader.SetCardHandler(func(c Card) { cards <- c })
+	if err := reader.Start(func(code string) { codes <- code }); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(reader.Stop)
+	return reader, cards, codes
+}
+
+func wantCard(t *testing.T, cards chan Card, want Card) {
+	t.Helper()
+	select {
+	case card := <-cards:
+		if card != want {
+			t.Errorf("card = %+v, want %+v", card, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no card read, want %+v", want)
+	}
+}
+
+func testOSDPReader(t *testing.T, scbk []byte) {
+	pd, port := newSimulatedPD(t, scbk)
+	reader, cards, codes := startOSDP(t, port, scbk)
+
+	pd.queueCard("10000110010000111000001111")
+	pd.queueKeys("12\x7f5678")
+	wantCard(t, cards, Card{Format: "H10301", Facility: 12, Number: 34567})
+	wantCode(t, codes, "5678")
+
+	reader.SetLED(OSDPLED{OnColor: OSDPGreen, Duration: 3 * time.Second})
+	reader.Buzz(OSDPBuzzer{On: 200 * time.Millisecond, Off: 100 * time.Millisecond, Count: 2})
+	wantLED := []byte{0, 0, 2, 1, 0, byte(OSDPGreen), byte(OSDPBlack), 30, 0, 0, 1, 0, byte(OSDPGreen), byte(OSDPBlack)}
+	wantBuzz := []byte{0, 2, 2, 1, 2}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(pd.receivedCommands()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := pd.receivedCommands()
+	if len(got) != 2 {
+		t.Fatalf("peripheral received %d LED/buzzer commands, want 2", len(got))
+	}
+	if got[0].code != osdpLED || !bytes.Equal(got[0].data, wantLED) {
+		t.Errorf("LED command = %#x %x, want %x", got[0].code, got[0].data, wantLED)
+	}
+	if got[1].code != osdpBUZ || !bytes.Equal(got[1].data, wantBuzz) {
+		t.Errorf("buzzer command = %#x %x, want %x", got[1].code, got[1].data, wantBuzz)
+	}
+}
+
+func TestOSDPReaderPlain(t *testing.T) {
+	testOSDPReader(t, nil)
+}
+
+func TestOSDPReaderSecureChannel(t *testing.T) {
+	scbk := make([]byte, 16)
+	_, _ = rand.Read(scbk)
+	testOSDPReader(t, scbk)
+}
+
+func TestOSDPReaderWrongKey(t *testing.T) {
+	pd, port := newSimulatedPD(t, OSDPInstallKey)
+	scbk := bytes.Repeat([]byte{0x42}, 16)
+	_, cards, _ := startOSDP(t, port, scbk)
+
+	pd.queueCard("10000110010000111000001111")
+	select {
+	case card := <-cards:
+		t.Fatalf("card %+v read from a reader with a different key", card)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if n := pd.securedCommands(); n != 0 {
+		t.Errorf("peripheral accepted %d secure commands with a different key", n)
+	}
+}
@@ -0,0 +1,205 @@
+package gate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// OSDP framing. A packet is
+//
+//	SOM ADDR LEN_LSB LEN_MSB CTRL [SCB] CODE [DATA] [MAC(4)] CRC_LSB CRC_MSB
+//
+// where LEN counts the whole packet. Replies set the high bit of ADDR.
+const (
+	osdpSOM       = 0x53
+	osdpReplyBit  = 0x80
+	osdpMaxPacket = 1440
+
+	osdpCtrlSeq = 0x03 // sequence number, 0 to 3
+	osdpCtrlCRC = 0x04 // CRC-16 rather than an 8-bit checksum
+	osdpCtrlSCB = 0x08 // security control block present
+
+	osdpMACLen = 4
+)
+
+// Commands sent by the control panel.
+const (
+	osdpPOLL   = 0x60
+	osdpID     = 0x61
+	osdpLED    = 0x69
+	osdpBUZ    = 0x6A
+	osdpCHLNG  = 0x76
+	osdpSCRYPT = 0x77
+)
+
+// Replies sent by the peripheral.
+const (
+	osdpACK     = 0x40
+	osdpNAK     = 0x41
+	osdpPDID    = 0x45
+	osdpLSTATR  = 0x48
+	osdpRAW     = 0x50
+	osdpKEYPAD  = 0x53
+	osdpCCRYPT  = 0x76
+	osdpRMACI   = 0x78
+	osdpBUSY    = 0x79
+	osdpNAKSeq  = 0x04 // NAK error: unexpected sequence number
+	osdpNAKCond = 0x06 // NAK error: secure channel condition not met
+)
+
+// Security control block types.
+const (
+	osdpSCS11 = 0x11 // CHLNG
+	osdpSCS12 = 0x12 // CCRYPT
+	osdpSCS13 = 0x13 // SCRYPT
+	osdpSCS14 = 0x14 // RMAC_I
+	osdpSCS15 = 0x15 // command, MAC only
+	osdpSCS16 = 0x16 // reply, MAC only
+	osdpSCS17 = 0x17 // command, MAC and encrypted data
+	osdpSCS18 = 0x18 // reply, MAC and encrypted data
+)
+
+// osdpPacket is a decoded OSDP packet.
+type osdpPacket struct {
+	addr  byte // without the reply bit
+	reply bool
+	seq   byte
+	scb   []byte // security control block: length, type, data
+	code  byte
+	data  []byte
+	mac   []byte // first bytes of the MAC, when the SCB type carries one
+
+	signed []byte // bytes the MAC covers
+}
+
+// scbType returns the security control block type, or 0 without one.
+func (p osdpPacket) scbType() byte {
+	if len(p.scb) < 2 {
+		return 0
+	}
+	return p.scb[1]
+}
+
+// osdpSCBHasMAC reports whether packets with this SCB type end in a MAC.
+func osdpSCBHasMAC(t byte) bool {
+	return t >= osdpSCS15 && t <= osdpSCS18
+}
+
+// marshal encodes p. mac, if not nil, computes the MAC over the packet up to
+// that point; the length field already counts the MAC and CRC.
+func (p osdpPacket) marshal(mac func(signed []byte) []byte) []byte {
+	ctrl := p.seq&osdpCtrlSeq | osdpCtrlCRC
+	if p.scb != nil {
+		ctrl |= osdpCtrlSCB
+	}
+	addr := p.addr
+	if p.reply {
+		addr |= osdpReplyBit
+	}
+	n := 5 + len(p.scb) + 1 + len(p.data) + 2
+	if mac != nil {
+		n += osdpMACLen
+	}
+	b := make([]byte, 0, n)
+	b = append(b, osdpSOM, addr, byte(n), byte(n>>8), ctrl)
+	b = append(b, p.scb...)
+	b = append(b, p.code)
+	b = append(b, p.data...)
+	if mac != nil {
+		b = append(b, mac(b)[:osdpMACLen]...)
+	}
+	crc := osdpCRC(b)
+	return append(b, byte(crc), byte(crc>>8))
+}
+
+// unmarshalOSDP decodes one complete packet whose CRC has been checked.
+func unmarshalOSDP(b []byte) (osdpPacket, error) {
+	ctrl := b[4]
+	if ctrl&osdpCtrlCRC == 0 {
+		return osdpPacket{}, errors.New("osdp: checksum packets are not supported")
+	}
+	p := osdpPacket{
+		addr:  b[1] &^ osdpReplyBit,
+		reply: b[1]&osdpReplyBit != 0,
+		seq:   ctrl & osdpCtrlSeq,
+	}
+	i, end := 5, len(b)-2
+	if ctrl&osdpCtrlSCB != 0 {
+		if i >= end || b[i] < 2 || i+int(b[i]) >= end {
+			return osdpPacket{}, errors.New("osdp: bad security control block")
+		}
+		p.scb = b[i : i+int(b[i])]
+		i += int(b[i])
+	}
+	if osdpSCBHasMAC(p.scbType()) {
+		if end-osdpMACLen <= i {
+			return osdpPacket{}, errors.New("osdp: packet too short for its MAC")
+		}
+		end -= osdpMACLen
+		p.mac = b[end : end+osdpMACLen]
+		p.signed = b[:end]
+	}
+	if i >= end {
+		return osdpPacket{}, errors.New("osdp: packet has no command or reply code")
+	}
+	p.code = b[i]
+	p.data = b[i+1 : end]
+	return p, nil
+}
+
+// nextOSDPPacket finds the first complete packet with a valid CRC in buf. It
+// returns the packet bytes and the rest of buf; packet is nil until one is
+// complete. Bytes before a start of message, and packets with a bad length
+// or CRC, are skipped.
+func nextOSDPPacket(buf []byte) (packet, rest []byte) {
+	for {
+		for len(buf) > 0 && buf[0] != osdpSOM {
+			buf = buf[1:]
+		}
+		if len(buf) < 4 {
+			return nil, buf
+		}
+		n := int(buf[2]) | int(buf[3])<<8
+		if n < 8 || n > osdpMaxPacket {
+			buf = buf[1:]
+			continue
+		}
+		if len(buf) < n {
+			return nil, buf
+		}
+		crc := osdpCRC(buf[:n-2])
+		if buf[n-2] != byte(crc) || buf[n-1] != byte(crc>>8) {
+			buf = buf[1:]
+			continue
+		}
+		return buf[:n], buf[n:]
+	}
+}
+
+// osdpCRC is CRC-16/AUG-CCITT: polynomial 0x1021, initial value 0x1D0F.
+func osdpCRC(b []byte) uint16 {
+	crc := uint16(0x1D0F)
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// osdpBits unpacks count bits, MSB first, from a RAW card report.
+func osdpBits(data []byte, count int) ([]int, error) {
+	if count <= 0 || (count+7)/8 > len(data) {
+		return nil, fmt.Errorf("osdp: %d bits do not fit %d bytes", count, len(data))
+	}
+	bits := make([]int, count)
+	for i := range bits {
+		bits[i] = int(data[i/8]>>(7-i%8)) & 1
+	}
+	return bits, nil
+}
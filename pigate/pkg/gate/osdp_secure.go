@@ -0,0 +1,146 @@
+package gate
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+)
+
+// OSDPInstallKey is SCBK-D, the default secure channel base key readers
+// accept in install mode.
+var OSDPInstallKey = []byte{
+	0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37,
+	0x38, 0x39, 0x3A, 0x3B, 0x3C, 0x3D, 0x3E, 0x3F,
+}
+
+// osdpSession holds the keys and MAC chain of an OSDP secure channel
+// session. The same code runs on both ends: command is true for packets from
+// the control panel and false for replies from the peripheral.
+type osdpSession struct {
+	enc, mac1, mac2 cipher.Block
+	cMAC, rMAC      [16]byte // last command and reply MACs
+}
+
+// newOSDPSession derives the session keys from the base key and the control
+// panel's random challenge.
+func newOSDPSession(scbk, cpRandom []byte) (*osdpSession, error) {
+	base, err := aes.NewCipher(scbk)
+	if err != nil {
+		return nil, err
+	}
+	if len(cpRandom) != 8 {
+		return nil, errors.New("osdp: challenge must be 8 bytes")
+	}
+	derive := func(b0, b1 byte) (cipher.Block, error) {
+		var k [16]byte
+		k[0], k[1] = b0, b1
+		copy(k[2:8], cpRandom)
+		base.Encrypt(k[:], k[:])
+		return aes.NewCipher(k[:])
+	}
+	s := &osdpSession{}
+	if s.enc, err = derive(0x01, 0x82); err != nil {
+		return nil, err
+	}
+	if s.mac1, err = derive(0x01, 0x01); err != nil {
+		return nil, err
+	}
+	if s.mac2, err = derive(0x01, 0x02); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// cryptogram encrypts a||b with the session encryption key. The peripheral
+// proves its key with cryptogram(cpRandom, pdRandom), the control panel with
+// cryptogram(pdRandom, cpRandom).
+func (s *osdpSession) cryptogram(a, b []byte) []byte {
+	out := make([]byte, 16)
+	copy(out, a)
+	copy(out[8:], b)
+	s.enc.Encrypt(out, out)
+	return out
+}
+
+// initialRMAC is the MAC chain's starting value, derived from the control
+// panel's cryptogram.
+func (s *osdpSession) initialRMAC(cpCryptogram []byte) [16]byte {
+	var r [16]byte
+	s.mac1.Encrypt(r[:], cpCryptogram)
+	s.mac2.Encrypt(r[:], r[:])
+	return r
+}
+
+// mac computes the MAC of signed and advances the chain for its direction.
+// It is an AES CBC-MAC seeded with the other direction's last MAC, using
+// S-MAC1 for all blocks but the last.
+func (s *osdpSession) mac(signed []byte, command bool) []byte {
+	iv := s.cMAC
+	if command {
+		iv = s.rMAC
+	}
+	buf := append([]byte(nil), signed...)
+	if len(buf)%16 != 0 {
+		buf = osdpPad(buf)
+	}
+	if len(buf) > 16 {
+		head := buf[:len(buf)-16]
+		cipher.NewCBCEncrypter(s.mac1, iv[:]).CryptBlocks(head, head)
+		copy(iv[:], head[len(head)-16:])
+	}
+	last := buf[len(buf)-16:]
+	cipher.NewCBCEncrypter(s.mac2, iv[:]).CryptBlocks(last, last)
+	if command {
+		copy(s.cMAC[:], last)
+	} else {
+		copy(s.rMAC[:], last)
+	}
+	return last
+}
+
+// encrypt pads and encrypts packet data. The IV is the complement of the
+// other direction's last MAC.
+func (s *osdpSession) encrypt(data []byte, command bool) []byte {
+	buf := osdpPad(append([]byte(nil), data...))
+	iv := s.dataIV(command)
+	cipher.NewCBCEncrypter(s.enc, iv[:]).CryptBlocks(buf, buf)
+	return buf
+}
+
+// decrypt reverses encrypt.
+func (s *osdpSession) decrypt(data []byte, command bool) ([]byte, error) {
+	if len(data) == 0 || len(data)%16 != 0 {
+		return nil, errors.New("osdp: encrypted data is not whole blocks")
+	}
+	buf := append([]byte(nil), data...)
+	iv := s.dataIV(command)
+	cipher.NewCBCDecrypter(s.enc, iv[:]).CryptBlocks(buf, buf)
+	i := len(buf) - 1
+	for i > 0 && buf[i] == 0 {
+		i--
+	}
+	if buf[i] != 0x80 {
+		return nil, errors.New("osdp: bad padding in encrypted data")
+	}
+	return buf[:i], nil
+}
+
+func (s *osdpSession) dataIV(command bool) [16]byte {
+	iv := s.cMAC
+	if command {
+		iv = s.rMAC
+	}
+	for i := range iv {
+		iv[i] = ^iv[i]
+	}
+	return iv
+}
+
+// osdpPad appends 0x80 and zeros up to a whole AES block.
+func osdpPad(b []byte) []byte {
+	b = append(b, 0x80)
+	for len(b)%16 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
@@ -0,0 +1,98 @@
+package gate
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOSDPCRC(t *testing.T) {
+	if got := osdpCRC([]byte("123456789")); got != 0xE5CC {
+		t.Errorf("osdpCRC(123456789) = %#04x, want 0xe5cc", got)
+	}
+}
+
+func TestOSDPPacketRoundTrip(t *testing.T) {
+	sent := osdpPacket{addr: 5, reply: true, seq: 2, code: osdpKEYPAD, data: []byte{0, 2, '1', 0x0D}}
+	b := sent.marshal(nil)
+
+	// Line noise before the packet and a partial packet after it.
+	stream := append([]byte{0xFF, 0x00}, b...)
+	stream = append(stream, b[:4]...)
+	packet, rest := nextOSDPPacket(stream)
+	if !bytes.Equal(packet, b) {
+		t.Fatalf("nextOSDPPacket() packet = %x, want %x", packet, b)
+	}
+	if !bytes.Equal(rest, b[:4]) {
+		t.Errorf("nextOSDPPacket() rest = %x, want %x", rest, b[:4])
+	}
+	got, err := unmarshalOSDP(packet)
+	if err != nil {
+		t.Fatalf("unmarshalOSDP() error = %v", err)
+	}
+	if got.addr != 5 || !got.reply || got.seq != 2 || got.code != osdpKEYPAD || !bytes.Equal(got.data, sent.data) {
+		t.Errorf("unmarshalOSDP() = %+v, want %+v", got, sent)
+	}
+
+	corrupt := append([]byte(nil), b...)
+	corrupt[len(corrupt)-3] ^= 1
+	if packet, _ := nextOSDPPacket(corrupt); packet != nil {
+		t.Errorf("nextOSDPPacket() accepted a packet with a bad CRC")
+	}
+}
+
+func TestOSDPSessionBothEnds(t *testing.T) {
+	cpRandom := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	pdRandom := []byte{8, 7, 6, 5, 4, 3, 2, 1}
+	cp, err := newOSDPSession(OSDPInstallKey, cpRandom)
+	if err != nil {
+		t.Fatalf("newOSDPSession() error = %v", err)
+	}
+	pd, _ := newOSDPSession(OSDPInstallKey, cpRandom)
+	if !bytes.Equal(cp.cryptogram(cpRandom, pdRandom), pd.cryptogram(cpRandom, pdRandom)) {
+		t.Fatal("cryptograms differ for the same key")
+	}
+	rmac := cp.initialRMAC(cp.cryptogram(pdRandom, cpRandom))
+	cp.rMAC, pd.rMAC = rmac, rmac
+
+	for _, data := range [][]byte{{0x01}, bytes.Repeat([]byte{0x00}, 16), bytes.Repeat([]byte{0xAB}, 33)} {
+		command := osdpPacket{seq: 1, scb: []byte{2, osdpSCS17}, code: osdpLED, data: cp.encrypt(data, true)}
+		b := command.marshal(func(signed []byte) []byte { return cp.mac(signed, true) })
+		got, err := unmarshalOSDP(b)
+		if err != nil {
+			t.Fatalf("unmarshalOSDP() error = %v", err)
+		}
+		if !bytes.Equal(got.mac, pd.mac(got.signed, true)[:osdpMACLen]) {
+			t.Fatal("peripheral computed a different command MAC")
+		}
+		plain, err := pd.decrypt(got.data, true)
+		if err != nil || !bytes.Equal(plain, data) {
+			t.Fatalf("decrypt() = %x, %v, want %x", plain, err, data)
+		}
+
+		reply := osdpPacket{reply: true, seq: 1, scb: []byte{2, osdpSCS18}, code: osdpRAW, data: pd.encrypt(data, false)}
+		b = reply.marshal(func(signed []byte) []byte { return pd.mac(signed, false) })
+		got, _ = unmarshalOSDP(b)
+		if !bytes.Equal(got.mac, cp.mac(got.signed, false)[:osdpMACLen]) {
+			t.Fatal("control panel computed a different reply MAC")
+		}
+		if plain, err := cp.decrypt(got.data, false); err != nil || !bytes.Equal(plain, data) {
+			t.Fatalf("decrypt() = %x, %v, want %x", plain, err, data)
+		}
+	}
+}
+
+func TestOSDPConfigValidate(t *testing.T) {
+	if err := DefaultOSDPConfig().Validate(); err != nil {
+		t.Fatalf("DefaultOSDPConfig().Validate() = %v", err)
+	}
+	cfg := DefaultOSDPConfig()
+	cfg.Address = 127
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted the broadcast address")
+	}
+	cfg = DefaultOSDPConfig()
+	cfg.SCBK = []byte{1, 2, 3}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted a 3-byte key")
+	}
+}
@@ -0,0 +1,93 @@
+package gate
+
+import (
+	"testing"
+	"time"
+
+	"pigate/pkg/gpio"
+)
+
+const (
+	testRelayPin = 22
+	testLEDPin   = 27
+)
+
+// newPinController returns a controller on a recording fake whose auto-close
+// runs only when the returned function is called.
+func newPinController(t *testing.T, now *time.Time) (*GateController, *gpio.Fake, func() time.Duration) {
+	t.Helper()
+	g, _ := newLeaseController(now)
+	pins := gpio.NewFake(func() time.Time { return *now })
+	if err := g.InitPinControl(pins, gpio.OutputConfig{Pin: testRelayPin}, gpio.OutputConfig{Pin: testLEDPin}); err != nil {
+		t.Fatalf("InitPinControl() error = %v", err)
+	}
+
+	var scheduled func()
+	var delay time.Duration
+	g.afterFunc = func(d time.Duration, f func()) {
+		delay, scheduled = d, f
+	}
+	fire := func() time.Duration {
+		if scheduled == nil {
+			t.Fatal("no auto-close scheduled")
+		}
+		*now = now.Add(delay)
+		f := scheduled
+		scheduled = nil
+		f()
+		return delay
+	}
+	return g, pins, fire
+}
+
+func TestRelayPulseForOpenDuration(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, pins, fire := newPinController(t, &now)
+	start := now
+
+	if err := g.Open("12345", now); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if delay := fire(); delay != 60*time.Second {
+		t.Errorf("auto-close after %v, want 60s", delay)
+	}
+
+	want := []gpio.Transition{
+		{Pin: testRelayPin, High: true, At: start},
+		{Pin: testLEDPin, High: true, At: start},
+		{Pin: testRelayPin, High: false, At: start.Add(60 * time.Second)},
+		{Pin: testLEDPin, High: false, At: start.Add(60 * time.Second)},
+	}
+	got := pins.Transitions()
+	if len(got) != len(want) {
+		t.Fatalf("transitions = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("transition %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if g.State() != Closed {
+		t.Errorf("State() = %v after auto-close, want closed", g.State())
+	}
+}
+
+func TestAutoCloseSkipsLockedOpen(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, pins, fire := newPinController(t, &now)
+
+	if err := g.tempOpen(); err != nil {
+		t.Fatalf("tempOpen() error = %v", err)
+	}
+	if err := g.lockOpen(); err != nil {
+		t.Fatalf("lockOpen() error = %v", err)
+	}
+	fire()
+
+	if g.State() != LockedOpen {
+		t.Errorf("State() = %v, want locked open", g.State())
+	}
+	if !pins.Level(testRelayPin) {
+		t.Error("relay released while locked open")
+	}
+}
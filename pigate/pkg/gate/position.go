@@ -0,0 +1,429 @@
+package gate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"pigate/pkg/gpio"
+	"pigate/pkg/messenger"
+)
+
+// Position is where the gate is, as its limit switches report it. The gate
+// state says what the relay was told to do; without limit switches nothing
+// says whether the gate followed.
+type Position int8
+
+const (
+	PositionUnknown Position = iota // no limit switches, or not read yet
+	PositionClosed
+	PositionOpening
+	PositionOpen
+	PositionClosing
+	PositionFault
+)
+
+// String returns the position published for p.
+func (p Position) String() string {
+	switch p {
+	case PositionClosed:
+		return messenger.PositionClosed
+	case PositionOpening:
+		return messenger.PositionOpening
+	case PositionOpen:
+		return messenger.PositionOpen
+	case PositionClosing:
+		return messenger.PositionClosing
+	case PositionFault:
+		return messenger.PositionFault
+	default:
+		return "unknown"
+	}
+}
+
+// PositionNotifier receives the gate position each time it changes.
+type PositionNotifier interface {
+	NotifyGatePosition(position string) error
+}
+
+func (g *GateController) SetPositionNotifier(notifier PositionNotifier) {
+	g.positionNotifier = notifier
+}
+
+// SensorConfig describes the gate's limit switches and safety input. Each is
+// optional; pin 0 means not wired.
+//
+// With an open limit switch, the gate is open once it is made; without one,
+// TravelTimeout after it was commanded open. The closed limit switch works the
+// same way for closed.
+type SensorConfig struct {
+	OpenPin       int           // BCM pin of the open limit switch
+	ClosedPin     int           // BCM pin of the closed limit switch
+	SafetyPin     int           // BCM pin of the safety loop or photo-eye
+	ActiveLow     bool          // inputs read low when made or tripped
+	TravelTimeout time.Duration // longest a full open or close may take
+	Debounce      time.Duration // how long an input must settle before it is read
+}
+
+// DefaultSensorConfig has no inputs wired. Wired ones are dry contacts to
+// ground on pulled-up inputs.
+func DefaultSensorConfig() SensorConfig {
+	return SensorConfig{
+		ActiveLow:     true,
+		TravelTimeout: 30 * time.Second,
+		Debounce:      50 * time.Millisecond,
+	}
+}
+
+// Validate reports settings the sensors cannot work with.
+func (c SensorConfig) Validate() error {
+	pins := make(map[int]bool)
+	for _, pin := range []int{c.OpenPin, c.ClosedPin, c.SafetyPin} {
+		if pin < 0 {
+			return fmt.Errorf("gate sensor pin %d is not a pin", pin)
+		}
+		if pin != 0 && pins[pin] {
+			return fmt.Errorf("gate sensor pin %d is used twice", pin)
+		}
+		pins[pin] = true
+	}
+	if c.Wired() && c.TravelTimeout <= 0 {
+		return errors.New("gate travel timeout must be positive")
+	}
+	if c.Debounce < 0 {
+		return errors.New("gate sensor debounce must not be negative")
+	}
+	return nil
+}
+
+// Wired reports whether any input is wired.
+func (c SensorConfig) Wired() bool {
+	return c.limits() || c.SafetyPin != 0
+}
+
+func (c SensorConfig) limits() bool {
+	return c.OpenPin != 0 || c.ClosedPin != 0
+}
+
+// SensorState is one reading of the gate's inputs. Inputs that are not wired
+// read false.
+type SensorState struct {
+	Open       bool // open limit switch made
+	Closed     bool // closed limit switch made
+	Obstructed bool // safety loop or photo-eye tripped
+}
+
+// GateSensors reads the limit switches and safety input.
+type GateSensors struct {
+	driver gpio.Driver
+	cfg    SensorConfig
+
+	mu       sync.Mutex
+	open     gpio.InputPin
+	closed   gpio.InputPin
+	safety   gpio.InputPin
+	last     SensorState
+	onChange func(SensorState)
+	settle   *time.Timer
+	stopped  bool
+}
+
+// NewGateSensors prepares the sensors on driver but does not start them.
+func NewGateSensors(driver gpio.Driver, cfg SensorConfig) *GateSensors {
+	return &GateSensors{driver: driver, cfg: cfg}
+}
+
+// Start requests the wired inputs and calls onChange with their state, then
+// again each time it changes and has held for Debounce.
+func (s *GateSensors) Start(onChange func(SensorState)) error {
+	if err := s.cfg.Validate(); err != nil {
+		return err
+	}
+	request := func(name string, pin int) (gpio.InputPin, error) {
+		if pin == 0 {
+			return nil, nil
+		}
+		in, err := s.driver.Input(pin, gpio.BothEdges, s.edge)
+		if err != nil {
+			return nil, fmt.Errorf("request %s line: %w", name, err)
+		}
+		return in, nil
+	}
+	var err error
+	s.mu.Lock()
+	s.onChange = onChange
+	if s.open, err = request("open limit", s.cfg.OpenPin); err == nil {
+		if s.closed, err = request("closed limit", s.cfg.ClosedPin); err == nil {
+			s.safety, err = request("safety", s.cfg.SafetyPin)
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		s.Stop()
+		return err
+	}
+
+	log.Printf("Gate sensors started (open=%d, closed=%d, safety=%d)", s.cfg.OpenPin, s.cfg.ClosedPin, s.cfg.SafetyPin)
+	state := s.Read()
+	s.mu.Lock()
+	s.last = state
+	s.mu.Unlock()
+	onChange(state)
+	return nil
+}
+
+// Read returns the current state of the inputs. An input that cannot be read
+// keeps its last state.
+func (s *GateSensors) Read() SensorState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.last
+	read := func(name string, in gpio.InputPin, active *bool) {
+		if in == nil {
+			return
+		}
+		high, err := in.Read()
+		if err != nil {
+			log.Printf("Failed to read gate %s input: %v", name, err)
+			return
+		}
+		*active = high != s.cfg.ActiveLow
+	}
+	read("open limit", s.open, &state.Open)
+	read("closed limit", s.closed, &state.Closed)
+	read("safety", s.safety, &state.Obstructed)
+	return state
+}
+
+// edge is called from the driver on every change of an input. Switches
+// bounce, so the inputs are read once they have been quiet for Debounce.
+func (s *GateSensors) edge(gpio.EdgeEvent) {
+	if s.cfg.Debounce <= 0 {
+		s.report()
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	if s.settle == nil {
+		s.settle = time.AfterFunc(s.cfg.Debounce, s.report)
+	} else {
+		s.settle.Reset(s.cfg.Debounce)
+	}
+}
+
+// report passes a new reading to onChange if it differs from the last one.
+func (s *GateSensors) report() {
+	state := s.Read()
+	s.mu.Lock()
+	if s.stopped || s.onChange == nil || state == s.last {
+		s.mu.Unlock()
+		return
+	}
+	s.last = state
+	onChange := s.onChange
+	s.mu.Unlock()
+	onChange(state)
+}
+
+// Stop releases the inputs. Closing a line waits for its edge handler, which
+// takes s.mu, so the lines are closed after it is released.
+func (s *GateSensors) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	if s.settle != nil {
+		s.settle.Stop()
+	}
+	inputs := []gpio.InputPin{s.open, s.closed, s.safety}
+	s.mu.Unlock()
+	for _, in := range inputs {
+		if in != nil {
+			_ = in.Close()
+		}
+	}
+}
+
+// SetSensors makes the controller follow the gate through the inputs cfg
+// describes. Pass their readings to UpdateSensors.
+func (g *GateController) SetSensors(cfg SensorConfig) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sensorCfg = cfg
+}
+
+// Position returns where the gate is, or PositionUnknown without limit
+// switches.
+func (g *GateController) Position() Position {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.position
+}
+
+// UpdateSensors takes a new reading of the gate's inputs. While the safety
+// input is tripped the gate does not close: a gate on its way closed opens
+// again, and a close waits until the input clears.
+func (g *GateController) UpdateSensors(s SensorState) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.sensorCfg.Wired() {
+		return
+	}
+	was := g.sensors
+	g.sensors = s
+	now := g.now()
+	switch {
+	case !g.sensing:
+		// The first reading: the gate has had no time to reach the state
+		// the relay is in, so give it the travel timeout from now.
+		g.sensing = true
+		g.gateMoved()
+	case s.Obstructed && !was.Obstructed && g.closing(now):
+		log.Println("Safety input tripped while the gate was closing; opening it again")
+		g.state = Open
+		g.driveReopen()
+		if g.ledPin != nil {
+			g.ledPin.High()
+		}
+		g.notifyGateOpen()
+		g.gateMoved()
+		g.closeWhenClear = true
+	case !s.Obstructed && was.Obstructed && g.closeWhenClear:
+		log.Println("Safety input cleared; closing the gate")
+		g.closeWhenClear = false
+		if g.state != Closed {
+			g.closeLockedOrOpen()
+		} else {
+			g.updatePosition(now)
+		}
+	default:
+		g.updatePosition(now)
+	}
+}
+
+// obstructed reports whether the safety input is tripped. Callers hold g.mu.
+func (g *GateController) obstructed() bool {
+	return g.sensing && g.sensors.Obstructed
+}
+
+// closing reports whether the gate is on its way closed. Without limit
+// switches, it is for the travel timeout after the relay drops. Callers hold
+// g.mu.
+func (g *GateController) closing(now time.Time) bool {
+	if g.state != Closed {
+		return false
+	}
+	if g.sensorCfg.limits() {
+		return g.position == PositionClosing || g.fault == messenger.AlertGateFailedToClose
+	}
+	return now.Sub(g.movedAt) < g.sensorCfg.TravelTimeout
+}
+
+// gateMoved notes that the relay has just changed, and checks the position
+// again once the gate should have finished moving. Callers hold g.mu.
+func (g *GateController) gateMoved() {
+	if !g.sensing {
+		return
+	}
+	now := g.now()
+	g.movedAt = now
+	g.moveSeq++
+	seq := g.moveSeq
+	g.afterFunc(g.sensorCfg.TravelTimeout, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.moveSeq == seq {
+			g.updatePosition(g.now())
+		}
+	})
+	g.updatePosition(now)
+}
+
+// updatePosition works out the position from the limit switches and the
+// relay, and publishes it and any new fault. Callers hold g.mu.
+func (g *GateController) updatePosition(now time.Time) {
+	cfg, s := g.sensorCfg, g.sensors
+	if !g.sensing || !cfg.limits() {
+		return
+	}
+	commandedOpen := g.state != Closed
+	travelled := now.Sub(g.movedAt) >= cfg.TravelTimeout
+
+	// A gate still on the switch it is leaving is moving until the travel
+	// timeout says it is stuck.
+	var pos Position
+	switch {
+	case s.Closed && !s.Open:
+		pos = PositionClosed
+		if commandedOpen && !travelled {
+			pos = PositionOpening
+		}
+	case s.Open && !s.Closed:
+		pos = PositionOpen
+		if !commandedOpen && !travelled {
+			pos = PositionClosing
+		}
+	case commandedOpen && (cfg.OpenPin != 0 || !travelled):
+		pos = PositionOpening
+	case commandedOpen:
+		pos = PositionOpen
+	case cfg.ClosedPin != 0 || !travelled:
+		pos = PositionClosing
+	default:
+		pos = PositionClosed
+	}
+
+	var fault string
+	switch {
+	case s.Open && s.Closed:
+		fault = messenger.AlertGateSensorFault
+	case !commandedOpen && pos != PositionClosed && (g.position == PositionClosed || g.fault == messenger.AlertGateForcedOpen):
+		fault = messenger.AlertGateForcedOpen
+	case !commandedOpen && pos != PositionClosed && travelled:
+		fault = messenger.AlertGateFailedToClose
+	case commandedOpen && pos != PositionOpen && travelled:
+		fault = messenger.AlertGateFailedToOpen
+	}
+	if fault != "" {
+		pos = PositionFault
+	}
+	if pos == g.position && fault == g.fault {
+		return
+	}
+	newFault := fault != "" && fault != g.fault
+	g.position, g.fault = pos, fault
+	log.Printf("Gate position: %s", pos)
+	g.notifyPosition(pos)
+	if newFault {
+		log.Printf("Gate fault: %s", fault)
+		g.notifyFault(fault, now)
+	}
+}
+
+func (g *GateController) notifyPosition(pos Position) {
+	notifier := g.positionNotifier
+	if notifier == nil {
+		return
+	}
+	go func() {
+		if err := notifier.NotifyGatePosition(pos.String()); err != nil {
+			log.Printf("Failed to publish gate position: %v", err)
+		}
+	}()
+}
+
+func (g *GateController) notifyFault(fault string, at time.Time) {
+	notifier := g.alertNotifier
+	if notifier == nil {
+		return
+	}
+	event := messenger.AlertEvent{Type: fault, At: at}
+	go func() {
+		if err := notifier.NotifyAlert(event); err != nil {
+			log.Printf("Failed to publish gate fault alert: %v", err)
+		}
+	}()
+}
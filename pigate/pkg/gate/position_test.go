@@ -0,0 +1,308 @@
+package gate
+
+import (
+	"testing"
+	"time"
+
+	"pigate/pkg/gpio"
+	"pigate/pkg/messenger"
+)
+
+// timerRecorder keeps what a controller schedules so tests can fire it.
+type timerRecorder struct {
+	scheduled []scheduledFunc
+}
+
+type scheduledFunc struct {
+	d time.Duration
+	f func()
+}
+
+func (r *timerRecorder) afterFunc(d time.Duration, f func()) {
+	r.scheduled = append(r.scheduled, scheduledFunc{d: d, f: f})
+}
+
+// fire runs the last function scheduled after d.
+func (r *timerRecorder) fire(t *testing.T, d time.Duration) {
+	t.Helper()
+	for i := len(r.scheduled) - 1; i >= 0; i-- {
+		if r.scheduled[i].d == d {
+			r.scheduled[i].f()
+			return
+		}
+	}
+	t.Fatalf("nothing scheduled after %v", d)
+}
+
+const travelTimeout = 10 * time.Second
+
+func newSensingController(t *testing.T, now *time.Time, cfg SensorConfig) (*GateController, *timerRecorder, *alertRecorder) {
+	t.Helper()
+	g, _ := newLeaseController(now)
+	timers := &timerRecorder{}
+	g.afterFunc = timers.afterFunc
+	alerts := &alertRecorder{events: make(chan messenger.AlertEvent, 4)}
+	g.SetAlertNotifier(alerts)
+	cfg.TravelTimeout = travelTimeout
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	g.SetSensors(cfg)
+	return g, timers, alerts
+}
+
+func TestGatePositionFollowsLimitSwitches(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, _, _ := newSensingController(t, &now, SensorConfig{OpenPin: 5, ClosedPin: 6})
+	steps := []struct {
+		name string
+		do   func()
+		want Position
+	}{
+		{"first reading", func() { g.UpdateSensors(SensorState{Closed: true}) }, PositionClosed},
+		{"open", func() { _ = g.Open("12345", now) }, PositionOpening},
+		{"left closed", func() { g.UpdateSensors(SensorState{}) }, PositionOpening},
+		{"reached open", func() { g.UpdateSensors(SensorState{Open: true}) }, PositionOpen},
+		{"close", func() { _ = g.Close() }, PositionClosing},
+		{"left open", func() { g.UpdateSensors(SensorState{}) }, PositionClosing},
+		{"reached closed", func() { g.UpdateSensors(SensorState{Closed: true}) }, PositionClosed},
+	}
+	for _, s := range steps {
+		s.do()
+		if got := g.Position(); got != s.want {
+			t.Fatalf("after %s: Position() = %v, want %v", s.name, got, s.want)
+		}
+	}
+}
+
+func TestGatePositionFaults(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, timers, alerts := newSensingController(t, &now, SensorConfig{OpenPin: 5, ClosedPin: 6})
+	expectFault := func(what, alert string) {
+		t.Helper()
+		if got := g.Position(); got != PositionFault {
+			t.Fatalf("%s: Position() = %v, want fault", what, got)
+		}
+		if event := <-alerts.events; event.Type != alert || !event.At.Equal(now) || event.Until != nil {
+			t.Errorf("%s: alert %+v, want %s at %v", what, event, alert, now)
+		}
+	}
+	g.UpdateSensors(SensorState{Closed: true})
+
+	// Commanded closed, but the open switch is still made after the travel
+	// timeout.
+	_ = g.Open("12345", now)
+	g.UpdateSensors(SensorState{Open: true})
+	_ = g.Close()
+	now = now.Add(travelTimeout)
+	timers.fire(t, travelTimeout)
+	expectFault("stuck open", messenger.AlertGateFailedToClose)
+	g.UpdateSensors(SensorState{Closed: true})
+	if got := g.Position(); got != PositionClosed {
+		t.Fatalf("Position() = %v once closed, want closed", got)
+	}
+
+	// Leaving closed while commanded closed is forced open, and stays a
+	// fault until the gate is closed again.
+	g.UpdateSensors(SensorState{})
+	expectFault("forced", messenger.AlertGateForcedOpen)
+	g.UpdateSensors(SensorState{Open: true})
+	if got := g.Position(); got != PositionFault {
+		t.Errorf("Position() = %v when forced fully open, want fault", got)
+	}
+	g.UpdateSensors(SensorState{Closed: true})
+	if got := g.Position(); got != PositionClosed {
+		t.Fatalf("Position() = %v once closed, want closed", got)
+	}
+
+	// Commanded open, but still on the closed switch after the timeout.
+	_ = g.Open("12345", now)
+	now = now.Add(travelTimeout)
+	timers.fire(t, travelTimeout)
+	expectFault("stuck closed", messenger.AlertGateFailedToOpen)
+
+	g.UpdateSensors(SensorState{Open: true, Closed: true})
+	expectFault("both switches", messenger.AlertGateSensorFault)
+}
+
+func TestGatePositionWithClosedSwitchOnly(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, timers, _ := newSensingController(t, &now, SensorConfig{ClosedPin: 6})
+	g.UpdateSensors(SensorState{Closed: true})
+	_ = g.Open("12345", now)
+	g.UpdateSensors(SensorState{})
+	if got := g.Position(); got != PositionOpening {
+		t.Fatalf("Position() = %v after leaving closed, want opening", got)
+	}
+	// With no open switch, the gate counts as open after the travel timeout.
+	now = now.Add(travelTimeout)
+	timers.fire(t, travelTimeout)
+	if got := g.Position(); got != PositionOpen {
+		t.Errorf("Position() = %v after the travel timeout, want open", got)
+	}
+}
+
+func TestSafetyInputStopsGateClosing(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, timers, _ := newSensingController(t, &now, SensorConfig{ClosedPin: 6, SafetyPin: 7})
+	autoClose := time.Duration(g.gateOpenDuration) * time.Second
+	g.UpdateSensors(SensorState{Closed: true})
+
+	// The auto-close waits while a vehicle is in the loop.
+	_ = g.Open("12345", now)
+	g.UpdateSensors(SensorState{Obstructed: true})
+	timers.fire(t, autoClose)
+	if g.State() != Open {
+		t.Fatalf("State() = %v after auto-close while obstructed, want open", g.State())
+	}
+	_ = g.Close()
+	if g.State() != Open {
+		t.Fatalf("State() = %v after Close while obstructed, want open", g.State())
+	}
+	g.UpdateSensors(SensorState{})
+	if g.State() != Closed || g.Position() != PositionClosing {
+		t.Fatalf("after the loop cleared: state %v, position %v; want closed, closing", g.State(), g.Position())
+	}
+
+	// Tripping it while closing opens the gate again until it clears.
+	g.UpdateSensors(SensorState{Obstructed: true})
+	if g.State() != Open {
+		t.Fatalf("State() = %v after tripping while closing, want open", g.State())
+	}
+	g.UpdateSensors(SensorState{})
+	g.UpdateSensors(SensorState{Closed: true})
+	if g.State() != Closed || g.Position() != PositionClosed {
+		t.Errorf("after closing: state %v, position %v; want closed", g.State(), g.Position())
+	}
+
+	// Once closed, the safety input does not open the gate.
+	g.UpdateSensors(SensorState{Closed: true, Obstructed: true})
+	if g.State() != Closed {
+		t.Errorf("State() = %v after tripping while closed, want closed", g.State())
+	}
+}
+
+func TestGateSensors(t *testing.T) {
+	const openPin, closedPin, safetyPin = 5, 6, 13
+	pins := gpio.NewFake(nil)
+	cfg := SensorConfig{OpenPin: openPin, ClosedPin: closedPin, SafetyPin: safetyPin, ActiveLow: true, TravelTimeout: time.Second}
+	sensors := NewGateSensors(pins, cfg)
+	states := make(chan SensorState, 8)
+	if err := sensors.Start(func(s SensorState) { states <- s }); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer sensors.Stop()
+
+	if got := <-states; got != (SensorState{}) {
+		t.Errorf("initial state = %+v, want nothing made", got)
+	}
+	inject := func(pin int, edge gpio.Edge) {
+		t.Helper()
+		if err := pins.Inject(pin, edge); err != nil {
+			t.Fatalf("Inject(%d) error = %v", pin, err)
+		}
+	}
+	inject(closedPin, gpio.FallingEdge)
+	inject(safetyPin, gpio.FallingEdge)
+	inject(safetyPin, gpio.RisingEdge)
+	inject(closedPin, gpio.FallingEdge) // no change
+	want := []SensorState{{Closed: true}, {Closed: true, Obstructed: true}, {Closed: true}}
+	for _, w := range want {
+		if got := <-states; got != w {
+			t.Errorf("state = %+v, want %+v", got, w)
+		}
+	}
+	if len(states) > 0 {
+		t.Errorf("unexpected state %+v", <-states)
+	}
+
+	if err := (SensorConfig{OpenPin: 5, ClosedPin: 5, TravelTimeout: time.Second}).Validate(); err == nil {
+		t.Error("Validate() accepted one pin for both limit switches")
+	}
+}
+
+func TestGateSensorsDebounce(t *testing.T) {
+	const openPin = 5
+	pins := gpio.NewFake(nil)
+	cfg := SensorConfig{OpenPin: openPin, ActiveLow: true, TravelTimeout: time.Second, Debounce: 20 * time.Millisecond}
+	sensors := NewGateSensors(pins, cfg)
+	states := make(chan SensorState, 8)
+	if err := sensors.Start(func(s SensorState) { states <- s }); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer sensors.Stop()
+	<-states
+
+	// A bouncing switch is reported once, after it settles.
+	for _, edge := range []gpio.Edge{gpio.FallingEdge, gpio.RisingEdge, gpio.FallingEdge} {
+		_ = pins.Inject(openPin, edge)
+	}
+	select {
+	case got := <-states:
+		if got != (SensorState{Open: true}) {
+			t.Errorf("state = %+v, want open made", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the settled state")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(states) > 0 {
+		t.Errorf("unexpected state %+v", <-states)
+	}
+}
+
+// closingDriver delivers one last edge while an input is closed, and waits
+// for its handler, as a gpiocdev line does when its watcher is mid-event.
+type closingDriver struct {
+	*gpio.Fake
+}
+
+type closingInput struct {
+	gpio.InputPin
+	last func()
+}
+
+func (d closingDriver) Input(pin int, edges gpio.Edge, handler func(gpio.EdgeEvent)) (gpio.InputPin, error) {
+	in, err := d.Fake.Input(pin, edges, handler)
+	if err != nil {
+		return nil, err
+	}
+	last := func() { handler(gpio.EdgeEvent{Pin: pin, Edge: gpio.FallingEdge, At: time.Now()}) }
+	return &closingInput{InputPin: in, last: last}, nil
+}
+
+func (i *closingInput) Close() error {
+	done := make(chan struct{})
+	go func() {
+		i.last()
+		close(done)
+	}()
+	<-done
+	return i.InputPin.Close()
+}
+
+// stopsWithin fails t unless stop returns within a second.
+func stopsWithin(t *testing.T, stop func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop() did not return with an edge in flight")
+	}
+}
+
+func TestGateSensorsStopWithEdgeInFlight(t *testing.T) {
+	for _, debounce := range []time.Duration{0, 20 * time.Millisecond} {
+		cfg := SensorConfig{OpenPin: 5, SafetyPin: 13, ActiveLow: true, TravelTimeout: time.Second, Debounce: debounce}
+		sensors := NewGateSensors(closingDriver{gpio.NewFake(nil)}, cfg)
+		if err := sensors.Start(func(SensorState) {}); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		stopsWithin(t, sensors.Stop)
+	}
+}
@@ -0,0 +1,215 @@
+package gate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"pigate/pkg/gpio"
+)
+
+// RelayMode is how the relays drive the gate operator.
+type RelayMode int
+
+const (
+	// RelayHold keeps the open relay made for as long as the gate is open,
+	// for operators that close when their open input is released.
+	RelayHold RelayMode = iota
+	// RelayPulse makes the open relay for a moment, for operators that
+	// take a momentary open input and run their own close timer.
+	RelayPulse
+	// RelayThreeButton pulses separate open, close and stop relays, for
+	// operators with a three-button station.
+	RelayThreeButton
+)
+
+func (m RelayMode) String() string {
+	switch m {
+	case RelayPulse:
+		return "pulse"
+	case RelayThreeButton:
+		return "three_button"
+	default:
+		return "hold"
+	}
+}
+
+// ParseRelayMode parses hold, pulse or three_button. An empty string is
+// hold.
+func ParseRelayMode(s string) (RelayMode, error) {
+	switch s {
+	case "", "hold":
+		return RelayHold, nil
+	case "pulse":
+		return RelayPulse, nil
+	case "three_button":
+		return RelayThreeButton, nil
+	default:
+		return 0, fmt.Errorf("unknown relay mode %q", s)
+	}
+}
+
+// DefaultPulseWidth is how long a pulsed relay stays made.
+const DefaultPulseWidth = 500 * time.Millisecond
+
+// RelayConfig describes how the gate operator is driven. The open relay is
+// the one given to InitPinControl; Close, Stop and HoldOpen are further
+// relays on the operator's inputs, with pin 0 when not wired.
+//
+// While the gate is locked open, the HoldOpen relay is made. Without one, the
+// open relay is held instead, which most operators take as hold open.
+type RelayConfig struct {
+	Mode       RelayMode
+	PulseWidth time.Duration
+	Close      gpio.OutputConfig // used in RelayThreeButton mode
+	Stop       gpio.OutputConfig // pulsed before reopening in RelayThreeButton mode
+	HoldOpen   gpio.OutputConfig
+}
+
+// Validate reports settings that cannot drive the operator.
+func (c RelayConfig) Validate() error {
+	if c.Mode < RelayHold || c.Mode > RelayThreeButton {
+		return fmt.Errorf("unknown relay mode %d", c.Mode)
+	}
+	if c.Mode != RelayHold && c.PulseWidth <= 0 {
+		return errors.New("relay pulse width must be positive")
+	}
+	if c.Mode == RelayThreeButton && c.Close.Pin == 0 {
+		return errors.New("three-button relay mode needs a close relay")
+	}
+	pins := make(map[int]bool)
+	for _, out := range []gpio.OutputConfig{c.Close, c.Stop, c.HoldOpen} {
+		if out.Pin < 0 {
+			return fmt.Errorf("relay pin %d is not a pin", out.Pin)
+		}
+		if out.Pin != 0 && pins[out.Pin] {
+			return fmt.Errorf("relay pin %d is used twice", out.Pin)
+		}
+		pins[out.Pin] = true
+	}
+	return nil
+}
+
+// Relays on the operator's inputs, indexing GateController.relays.
+const (
+	relayOpen = iota
+	relayClose
+	relayStop
+	relayHoldOpen
+	relayCount
+)
+
+var relayNames = [relayCount]string{"open", "close", "stop", "hold open"}
+
+// InitRelays drives the gate operator as cfg describes, requesting its wired
+// relays on driver. Until it is called, the open relay is held while the gate
+// is open.
+func (g *GateController) InitRelays(driver gpio.Driver, cfg RelayConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	outs := [relayCount]gpio.OutputConfig{relayClose: cfg.Close, relayStop: cfg.Stop, relayHoldOpen: cfg.HoldOpen}
+	var pins [relayCount]gpio.OutputPin
+	for r := relayClose; r < relayCount; r++ {
+		if outs[r].Pin == 0 {
+			continue
+		}
+		pin, err := driver.Output(outs[r])
+		if err != nil {
+			return fmt.Errorf("%s relay pin: %w", relayNames[r], err)
+		}
+		pin.Low()
+		pins[r] = pin
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.relayCfg = cfg
+	for r := relayClose; r < relayCount; r++ {
+		g.relays[r] = pins[r]
+		g.relayMade[r] = false
+	}
+	return nil
+}
+
+// setRelay makes or releases relay r, cancelling any pulse on it. Callers
+// hold g.mu.
+func (g *GateController) setRelay(r int, made bool) {
+	g.pulseSeq[r]++
+	pin := g.relays[r]
+	if pin == nil || g.relayMade[r] == made {
+		return
+	}
+	g.relayMade[r] = made
+	if made {
+		pin.High()
+	} else {
+		pin.Low()
+	}
+}
+
+// pulseRelay makes relay r for the pulse width and then, unless the pulse
+// was cut short, releases it and calls then if set. Callers hold g.mu.
+func (g *GateController) pulseRelay(r int, then func()) {
+	g.setRelay(r, true)
+	seq := g.pulseSeq[r]
+	g.afterFunc(g.relayCfg.PulseWidth, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.pulseSeq[r] != seq {
+			return
+		}
+		g.setRelay(r, false)
+		if then != nil {
+			then()
+		}
+	})
+}
+
+// driveOpen starts the gate opening. Callers hold g.mu.
+func (g *GateController) driveOpen() {
+	if g.relayCfg.Mode == RelayHold {
+		g.setRelay(relayOpen, true)
+	} else {
+		g.pulseRelay(relayOpen, nil)
+	}
+}
+
+// driveLockOpen keeps the gate open until driveClose; wasOpen is set when
+// the gate was already opening. Callers hold g.mu.
+func (g *GateController) driveLockOpen(wasOpen bool) {
+	if g.relays[relayHoldOpen] == nil || g.relayCfg.Mode == RelayHold {
+		g.setRelay(relayOpen, true)
+	} else if !wasOpen {
+		g.pulseRelay(relayOpen, nil)
+	}
+	g.setRelay(relayHoldOpen, true)
+}
+
+// driveClose releases whatever holds the gate open and, in three-button
+// mode, starts it closing. In pulse mode, the operator closes the gate on
+// its own timer. Callers hold g.mu.
+func (g *GateController) driveClose() {
+	g.setRelay(relayHoldOpen, false)
+	g.setRelay(relayOpen, false)
+	if g.relayCfg.Mode == RelayThreeButton {
+		g.pulseRelay(relayClose, nil)
+	}
+}
+
+// driveReopen opens a gate that is closing. Three-button operators ignore
+// open while stop is made, so the open pulse follows the stop pulse, unless
+// the gate is closed or held open before then. Callers hold g.mu.
+func (g *GateController) driveReopen() {
+	if g.relayCfg.Mode != RelayThreeButton {
+		g.driveOpen()
+		return
+	}
+	g.setRelay(relayClose, false)
+	openSeq := g.pulseSeq[relayOpen]
+	g.pulseRelay(relayStop, func() {
+		if g.pulseSeq[relayOpen] == openSeq {
+			g.driveOpen()
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package gate
+
+import (
+	"testing"
+	"time"
+
+	"pigate/pkg/gpio"
+)
+
+const (
+	testClosePin    = 23
+	testStopPin     = 24
+	testHoldOpenPin = 25
+	testPulseWidth  = 500 * time.Millisecond
+)
+
+// newRelayController returns a controller driving the operator as cfg
+// describes on a recording fake. Only relay transitions are recorded.
+func newRelayController(t *testing.T, now *time.Time, cfg RelayConfig) (*GateController, *gpio.Fake, *timerRecorder) {
+	t.Helper()
+	g, _ := newLeaseController(now)
+	timers := &timerRecorder{}
+	g.afterFunc = timers.afterFunc
+	pins := gpio.NewFake(func() time.Time { return *now })
+	if err := g.InitPinControl(pins, gpio.OutputConfig{Pin: testRelayPin}, gpio.OutputConfig{Pin: testLEDPin}); err != nil {
+		t.Fatalf("InitPinControl() error = %v", err)
+	}
+	cfg.PulseWidth = testPulseWidth
+	if err := g.InitRelays(pins, cfg); err != nil {
+		t.Fatalf("InitRelays() error = %v", err)
+	}
+	pins.Reset()
+	return g, pins, timers
+}
+
+// relayTransitions returns the transitions on pins other than the LED, and
+// forgets them.
+func relayTransitions(pins *gpio.Fake) []gpio.Transition {
+	var out []gpio.Transition
+	for _, tr := range pins.Transitions() {
+		if tr.Pin != testLEDPin {
+			out = append(out, tr)
+		}
+	}
+	pins.Reset()
+	return out
+}
+
+func expectTransitions(t *testing.T, what string, got []gpio.Transition, want ...gpio.Transition) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: transitions = %+v, want %+v", what, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s: transition %d = %+v, want %+v", what, i, got[i], want[i])
+		}
+	}
+}
+
+func TestRelayPulseMode(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, pins, timers := newRelayController(t, &now, RelayConfig{Mode: RelayPulse, HoldOpen: gpio.OutputConfig{Pin: testHoldOpenPin}})
+	autoClose := time.Duration(g.gateOpenDuration) * time.Second
+
+	// Open is a momentary contact; the operator closes on its own timer, so
+	// the auto-close only updates the state.
+	_ = g.Open("12345", now)
+	start := now
+	now = now.Add(testPulseWidth)
+	timers.fire(t, testPulseWidth)
+	now = start.Add(autoClose)
+	timers.fire(t, autoClose)
+	expectTransitions(t, "open", relayTransitions(pins),
+		gpio.Transition{Pin: testRelayPin, High: true, At: start},
+		gpio.Transition{Pin: testRelayPin, High: false, At: start.Add(testPulseWidth)},
+	)
+	if g.State() != Closed {
+		t.Errorf("State() = %v after the auto-close, want closed", g.State())
+	}
+
+	// Lock open pulses open and holds the operator's hold-open input.
+	_ = g.lockOpen()
+	now = now.Add(testPulseWidth)
+	timers.fire(t, testPulseWidth)
+	_ = g.Close()
+	expectTransitions(t, "lock open", relayTransitions(pins),
+		gpio.Transition{Pin: testRelayPin, High: true, At: start.Add(autoClose)},
+		gpio.Transition{Pin: testHoldOpenPin, High: true, At: start.Add(autoClose)},
+		gpio.Transition{Pin: testRelayPin, High: false, At: now},
+		gpio.Transition{Pin: testHoldOpenPin, High: false, At: now},
+	)
+}
+
+func TestRelayPulseModeHoldsOpenRelayWithoutHoldOpen(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, pins, timers := newRelayController(t, &now, RelayConfig{Mode: RelayPulse})
+
+	// A pulse still running when the gate is locked open no longer ends it.
+	_ = g.tempOpen()
+	_ = g.lockOpen()
+	timers.fire(t, testPulseWidth)
+	if !pins.Level(testRelayPin) {
+		t.Fatal("open relay released while locked open")
+	}
+	_ = g.Close()
+	if pins.Level(testRelayPin) {
+		t.Error("open relay still made after Close")
+	}
+}
+
+func TestRelayThreeButtonMode(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g, pins, timers := newRelayController(t, &now, RelayConfig{
+		Mode:  RelayThreeButton,
+		Close: gpio.OutputConfig{Pin: testClosePin},
+		Stop:  gpio.OutputConfig{Pin: testStopPin},
+	})
+	g.SetSensors(SensorConfig{SafetyPin: 7, TravelTimeout: time.Minute})
+	g.UpdateSensors(SensorState{})
+
+	_ = g.Open("12345", now)
+	timers.fire(t, testPulseWidth)
+	_ = g.Close()
+	timers.fire(t, testPulseWidth)
+	expectTransitions(t, "open and close", relayTransitions(pins),
+		gpio.Transition{Pin: testRelayPin, High: true, At: now},
+		gpio.Transition{Pin: testRelayPin, High: false, At: now},
+		gpio.Transition{Pin: testClosePin, High: true, At: now},
+		gpio.Transition{Pin: testClosePin, High: false, At: now},
+	)
+
+	// Reopening on the safety input stops the operator, and only pulses
+	// open once stop is released.
+	g.UpdateSensors(SensorState{Obstructed: true})
+	expectTransitions(t, "stop", relayTransitions(pins),
+		gpio.Transition{Pin: testStopPin, High: true, At: now},
+	)
+	timers.fire(t, testPulseWidth)
+	timers.fire(t, testPulseWidth)
+	expectTransitions(t, "reopen", relayTransitions(pins),
+		gpio.Transition{Pin: testStopPin, High: false, At: now},
+		gpio.Transition{Pin: testRelayPin, High: true, At: now},
+		gpio.Transition{Pin: testRelayPin, High: false, At: now},
+	)
+	if g.State() != Open {
+		t.Errorf("State() = %v after tripping while closing, want open", g.State())
+	}
+
+	// The safety input clearing during the stop pulse closes the gate, and
+	// cancels the open pulse.
+	g.UpdateSensors(SensorState{})
+	timers.fire(t, testPulseWidth)
+	relayTransitions(pins)
+	pending := len(timers.scheduled)
+	g.UpdateSensors(SensorState{Obstructed: true})
+	g.UpdateSensors(SensorState{})
+	for _, s := range timers.scheduled[pending:] {
+		if s.d == testPulseWidth {
+			s.f()
+		}
+	}
+	for _, tr := range relayTransitions(pins) {
+		if tr.Pin == testRelayPin && tr.High {
+			t.Errorf("open relay made after the reopen was closed: %+v", tr)
+		}
+	}
+}
+
+func TestRelayConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  RelayConfig
+		ok   bool
+	}{
+		{"hold", RelayConfig{}, true},
+		{"pulse", RelayConfig{Mode: RelayPulse, PulseWidth: time.Second}, true},
+		{"pulse without width", RelayConfig{Mode: RelayPulse}, false},
+		{"three-button without close", RelayConfig{Mode: RelayThreeButton, PulseWidth: time.Second}, false},
+		{"pin used twice", RelayConfig{Close: gpio.OutputConfig{Pin: 5}, HoldOpen: gpio.OutputConfig{Pin: 5}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() error = %v", tt.name, err)
+		}
+	}
+	for _, s := range []string{"", "hold", "pulse", "three_button"} {
+		if _, err := ParseRelayMode(s); err != nil {
+			t.Errorf("ParseRelayMode(%q) error = %v", s, err)
+		}
+	}
+	if _, err := ParseRelayMode("toggle"); err == nil {
+		t.Error("ParseRelayMode accepted toggle")
+	}
+}
@@ -0,0 +1,194 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"pigate/pkg/database"
+	"pigate/pkg/messenger"
+)
+
+// Sources of keypad codes and cards. Failed codes are counted, and lockouts
+// kept, per source.
+const (
+	SourceWiegand = "wiegand"
+	SourceOSDP    = "osdp"
+)
+
+// ThrottleConfig limits how fast codes can be guessed at one keypad or
+// reader. After MaxFailures failed codes within Window, the source is
+// ignored for Lockout; each lockout in a row doubles it, up to MaxLockout.
+type ThrottleConfig struct {
+	MaxFailures int // 0 disables throttling
+	Window      time.Duration
+	Lockout     time.Duration
+	MaxLockout  time.Duration
+}
+
+// DefaultThrottleConfig locks a keypad out for 30 seconds after 5 failed
+// codes in a minute, and for up to an hour when guessing goes on.
+func DefaultThrottleConfig() ThrottleConfig {
+	return ThrottleConfig{
+		MaxFailures: 5,
+		Window:      time.Minute,
+		Lockout:     30 * time.Second,
+		MaxLockout:  time.Hour,
+	}
+}
+
+// Validate reports settings that cannot lock anything out.
+func (c ThrottleConfig) Validate() error {
+	if c.MaxFailures < 0 {
+		return errors.New("throttle max failures must not be negative")
+	}
+	if c.MaxFailures > 0 && (c.Window <= 0 || c.Lockout <= 0 || c.MaxLockout < c.Lockout) {
+		return errors.New("throttle window and lockout must be positive, and max lockout at least the lockout")
+	}
+	return nil
+}
+
+// backoff returns the lockout for the level-th lockout in a row.
+func (c ThrottleConfig) backoff(level int) time.Duration {
+	d := c.Lockout
+	for i := 1; i < level && d < c.MaxLockout; i++ {
+		d *= 2
+	}
+	return min(d, c.MaxLockout)
+}
+
+// AlertNotifier receives events that need an operator's attention.
+type AlertNotifier interface {
+	NotifyAlert(event messenger.AlertEvent) error
+}
+
+func (g *GateController) SetAlertNotifier(notifier AlertNotifier) {
+	g.alertNotifier = notifier
+}
+
+// SetThrottle enables throttling with cfg and restores the lockouts saved
+// before the last restart.
+func (g *GateController) SetThrottle(cfg ThrottleConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	lockouts := make(map[string]database.Lockout)
+	if cfg.MaxFailures > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		saved, err := g.gm.GetLockouts(ctx)
+		if err != nil {
+			return err
+		}
+		for _, l := range saved {
+			lockouts[l.Source] = l
+			if g.now().Before(l.Until) {
+				log.Printf("Keypad %s locked out until %s", l.Source, l.Until.Local().Format(time.RFC3339))
+			}
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.throttle = cfg
+	g.failures = make(map[string][]time.Time)
+	g.lockouts = lockouts
+	return nil
+}
+
+// LockedOut reports whether source is ignoring codes at t.
+func (g *GateController) LockedOut(source string, t time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	l, ok := g.lockouts[source]
+	return ok && t.Before(l.Until)
+}
+
+// throttled logs and drops a code or card from a source that is locked out.
+func (g *GateController) throttled(source, code string, credType database.CredentialType, t time.Time) bool {
+	if !g.LockedOut(source, t) {
+		return false
+	}
+	log.Printf("Ignoring credential %s: %s is locked out", code, source)
+	g.recordAccess(source, code, credType, nil, messenger.AccessDenied, ReasonThrottled, t)
+	return true
+}
+
+// countFailure adds a failed code at source to its window, and locks the
+// source out once the window holds MaxFailures.
+func (g *GateController) countFailure(source string, t time.Time) {
+	g.mu.Lock()
+	cfg := g.throttle
+	if cfg.MaxFailures <= 0 {
+		g.mu.Unlock()
+		return
+	}
+	window := g.failures[source][:0]
+	for _, at := range g.failures[source] {
+		if t.Sub(at) < cfg.Window {
+			window = append(window, at)
+		}
+	}
+	window = append(window, t)
+	if len(window) < cfg.MaxFailures {
+		g.failures[source] = window
+		g.mu.Unlock()
+		return
+	}
+	delete(g.failures, source)
+
+	// Lockouts in a row back off; one long after the last starts over.
+	level := 1
+	if prev, ok := g.lockouts[source]; ok && t.Sub(prev.Until) < cfg.MaxLockout {
+		level = prev.Level + 1
+	}
+	lockout := database.Lockout{Source: source, Until: t.Add(cfg.backoff(level)), Level: level}
+	g.lockouts[source] = lockout
+	notifier := g.alertNotifier
+	g.mu.Unlock()
+
+	log.Printf("Keypad %s locked out for %v after %d failed codes", source, lockout.Until.Sub(t), len(window))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := g.gm.PutLockout(ctx, lockout); err != nil {
+		log.Printf("Failed to save keypad lockout: %v", err)
+	}
+	if notifier == nil {
+		return
+	}
+	event := messenger.AlertEvent{
+		Type:     messenger.AlertKeypadLockout,
+		Source:   source,
+		Failures: len(window),
+		Until:    &lockout.Until,
+		At:       t,
+	}
+	go func() {
+		if err := notifier.NotifyAlert(event); err != nil {
+			log.Printf("Failed to publish keypad lockout alert: %v", err)
+		}
+	}()
+}
+
+// clearFailures forgets the failures at source after a grant, and resets its
+// back-off once the last lockout has ended.
+func (g *GateController) clearFailures(source string, t time.Time) {
+	g.mu.Lock()
+	delete(g.failures, source)
+	l, ok := g.lockouts[source]
+	expired := ok && !t.Before(l.Until)
+	if expired {
+		delete(g.lockouts, source)
+	}
+	g.mu.Unlock()
+
+	if !expired {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := g.gm.DeleteLockout(ctx, source); err != nil {
+		log.Printf("Failed to clear keypad lockout: %v", err)
+	}
+}
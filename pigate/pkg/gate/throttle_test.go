@@ -0,0 +1,165 @@
+package gate
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"pigate/pkg/database"
+	"pigate/pkg/messenger"
+)
+
+// lockoutGateManager is cardGateManager with lockouts kept in memory, so a
+// second controller can load what the first saved.
+type lockoutGateManager struct {
+	cardGateManager
+	mu    sync.Mutex
+	saved map[string]database.Lockout
+}
+
+func (m *lockoutGateManager) PutLockout(ctx context.Context, l database.Lockout) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saved[l.Source] = l
+	return nil
+}
+
+func (m *lockoutGateManager) GetLockouts(ctx context.Context) ([]database.Lockout, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []database.Lockout
+	for _, l := range m.saved {
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+func (m *lockoutGateManager) DeleteLockout(ctx context.Context, source string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.saved, source)
+	return nil
+}
+
+func (m *lockoutGateManager) lockout(source string) (database.Lockout, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.saved[source]
+	return l, ok
+}
+
+type alertRecorder struct {
+	events chan messenger.AlertEvent
+}
+
+func (r *alertRecorder) NotifyAlert(event messenger.AlertEvent) error {
+	r.events <- event
+	return nil
+}
+
+func newThrottledController(t *testing.T, now *time.Time, gm *lockoutGateManager) (*GateController, *accessRecorder, *alertRecorder) {
+	t.Helper()
+	g, _ := newLeaseController(now)
+	g.gm = gm
+	access := &accessRecorder{events: make(chan messenger.AccessEvent, 64)}
+	g.SetAccessNotifier(access)
+	alerts := &alertRecorder{events: make(chan messenger.AlertEvent, 4)}
+	g.SetAlertNotifier(alerts)
+	cfg := ThrottleConfig{MaxFailures: 3, Window: time.Minute, Lockout: 30 * time.Second, MaxLockout: 2 * time.Minute}
+	if err := g.SetThrottle(cfg); err != nil {
+		t.Fatalf("SetThrottle() error = %v", err)
+	}
+	return g, access, alerts
+}
+
+func TestThrottleLocksOutAfterFailures(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	gm := &lockoutGateManager{saved: make(map[string]database.Lockout)}
+	g, access, alerts := newThrottledController(t, &now, gm)
+	card := Card{Format: "H10301", Facility: 12, Number: 34567}
+	guess := func(source string, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if err := g.OpenFrom(source, "11111", now); err != nil {
+				t.Fatalf("OpenFrom() error = %v", err)
+			}
+			now = now.Add(time.Second)
+		}
+	}
+
+	// Failures outside the window do not add up.
+	guess(SourceWiegand, 2)
+	now = now.Add(time.Minute)
+	guess(SourceWiegand, 2)
+	if g.LockedOut(SourceWiegand, now) {
+		t.Fatal("locked out after failures spread over more than the window")
+	}
+
+	guess(SourceWiegand, 1)
+	if !g.LockedOut(SourceWiegand, now) {
+		t.Fatal("not locked out after 3 failures within the window")
+	}
+	alert := <-alerts.events
+	if alert.Type != messenger.AlertKeypadLockout || alert.Source != SourceWiegand || alert.Failures != 3 {
+		t.Errorf("alert = %+v, want keypad_lockout at wiegand after 3 failures", alert)
+	}
+	saved, ok := gm.lockout(SourceWiegand)
+	if !ok || saved.Level != 1 || alert.Until == nil || !saved.Until.Equal(*alert.Until) || saved.Until.Sub(alert.At) != 30*time.Second {
+		t.Errorf("saved lockout = %+v (%t), want level 1 for 30s until %v", saved, ok, alert.Until)
+	}
+
+	// A valid card is ignored during the lockout; other readers still work.
+	if g.LockedOut(SourceOSDP, now) {
+		t.Error("OSDP reader locked out by Wiegand failures")
+	}
+	// Access events are published asynchronously; wait for all five guesses.
+	for i := 0; i < 5; i++ {
+		<-access.events
+	}
+	_ = g.OpenCardFrom(SourceWiegand, card, now)
+	if event := <-access.events; event.Result != messenger.AccessDenied || event.Reason != ReasonThrottled {
+		t.Errorf("card during lockout: %+v, want denied throttled", event)
+	}
+	if g.State() != Closed {
+		t.Fatalf("State() = %v during lockout, want closed", g.State())
+	}
+
+	// The next lockout in a row lasts twice as long, and survives a restart.
+	now = saved.Until
+	guess(SourceWiegand, 3)
+	alert = <-alerts.events
+	saved, _ = gm.lockout(SourceWiegand)
+	if saved.Level != 2 || saved.Until.Sub(alert.At) != 60*time.Second {
+		t.Errorf("second lockout = %+v, want level 2 for 60s", saved)
+	}
+	restarted, _, _ := newThrottledController(t, &now, gm)
+	if !restarted.LockedOut(SourceWiegand, now) {
+		t.Fatal("lockout lost across a restart")
+	}
+
+	// Once it ends, a grant resets the back-off.
+	now = saved.Until
+	if err := restarted.OpenCardFrom(SourceWiegand, card, now); err != nil {
+		t.Fatalf("OpenCardFrom() error = %v", err)
+	}
+	if restarted.State() != Open {
+		t.Errorf("State() = %v after the lockout, want open", restarted.State())
+	}
+	if l, ok := gm.lockout(SourceWiegand); ok {
+		t.Errorf("lockout %+v still saved after a grant", l)
+	}
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	cfg := ThrottleConfig{MaxFailures: 5, Window: time.Minute, Lockout: 30 * time.Second, MaxLockout: 3 * time.Minute}
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute}
+	for i, w := range want {
+		if got := cfg.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+	if err := (ThrottleConfig{MaxFailures: 3, Window: time.Minute, Lockout: time.Hour, MaxLockout: time.Minute}).Validate(); err == nil {
+		t.Error("Validate() accepted a max lockout shorter than the lockout")
+	}
+}
@@ -0,0 +1,193 @@
+package gpio
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("sim", func(Options) (Driver, error) { return NewFake(nil), nil })
+}
+
+// Transition is one change of an output's physical level recorded by Fake.
+type Transition struct {
+	Pin  int
+	High bool
+	At   time.Time
+}
+
+// Fake is an in-memory Driver. It records every output transition with the
+// time from its clock and lets tests and the simulator inject input edges.
+// It is registered as the "sim" driver.
+type Fake struct {
+	mu          sync.Mutex
+	now         func() time.Time
+	levels      map[int]bool // physical levels
+	outputs     map[int]*fakeOutput
+	inputs      map[int]*fakeInput
+	transitions []Transition
+	watchers    []func(Transition)
+}
+
+type fakeInput struct {
+	fake    *Fake
+	pin     int
+	edges   Edge
+	handler func(EdgeEvent)
+}
+
+type fakeOutput struct {
+	fake      *Fake
+	pin       int
+	activeLow bool
+}
+
+// NewFake returns a Fake using now for timestamps, or time.Now if now is nil.
+func NewFake(now func() time.Time) *Fake {
+	if now == nil {
+		now = time.Now
+	}
+	return &Fake{
+		now:     now,
+		levels:  make(map[int]bool),
+		outputs: make(map[int]*fakeOutput),
+		inputs:  make(map[int]*fakeInput),
+	}
+}
+
+// Output implements Driver. The pin starts inactive without recording a
+// transition.
+func (f *Fake) Output(cfg OutputConfig) (OutputPin, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.inputs[cfg.Pin]; ok {
+		return nil, fmt.Errorf("gpio: pin %d is already an input", cfg.Pin)
+	}
+	out := &fakeOutput{fake: f, pin: cfg.Pin, activeLow: cfg.ActiveLow}
+	f.outputs[cfg.Pin] = out
+	f.levels[cfg.Pin] = cfg.ActiveLow
+	return out, nil
+}
+
+// Input implements Driver. Inputs idle high, as Wiegand data lines do.
+func (f *Fake) Input(pin int, edges Edge, handler func(EdgeEvent)) (InputPin, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.inputs[pin]; ok {
+		return nil, fmt.Errorf("gpio: pin %d is already requested", pin)
+	}
+	in := &fakeInput{fake: f, pin: pin, edges: edges, handler: handler}
+	f.inputs[pin] = in
+	f.levels[pin] = true
+	return in, nil
+}
+
+// Close implements Driver, recording outputs returning to inactive.
+func (f *Fake) Close() error {
+	f.mu.Lock()
+	outputs := make([]*fakeOutput, 0, len(f.outputs))
+	for _, out := range f.outputs {
+		outputs = append(outputs, out)
+	}
+	f.outputs = make(map[int]*fakeOutput)
+	f.mu.Unlock()
+
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].pin < outputs[j].pin })
+	for _, out := range outputs {
+		out.Low()
+	}
+	return nil
+}
+
+// Watch calls fn for every output transition after it is recorded.
+func (f *Fake) Watch(fn func(Transition)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.watchers = append(f.watchers, fn)
+}
+
+// Transitions returns the output transitions recorded so far, oldest first.
+func (f *Fake) Transitions() []Transition {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Transition(nil), f.transitions...)
+}
+
+// Reset forgets the recorded transitions.
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.transitions = nil
+}
+
+// Level reports whether pin is physically high.
+func (f *Fake) Level(pin int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.levels[pin]
+}
+
+// Inject sets input pin to the level the edge leads to and, if the pin was
+// requested for that edge, calls its handler synchronously.
+func (f *Fake) Inject(pin int, edge Edge) error {
+	f.mu.Lock()
+	in, ok := f.inputs[pin]
+	if !ok {
+		f.mu.Unlock()
+		return fmt.Errorf("gpio: pin %d is not an input", pin)
+	}
+	f.levels[pin] = edge == RisingEdge
+	event := EdgeEvent{Pin: pin, Edge: edge, At: f.now()}
+	handler := in.handler
+	deliver := handler != nil && in.edges&edge != 0
+	f.mu.Unlock()
+
+	if deliver {
+		handler(event)
+	}
+	return nil
+}
+
+// Pulse injects a falling edge followed by a rising edge, the active-low pulse
+// a Wiegand reader sends for each bit.
+func (f *Fake) Pulse(pin int) error {
+	if err := f.Inject(pin, FallingEdge); err != nil {
+		return err
+	}
+	return f.Inject(pin, RisingEdge)
+}
+
+func (f *Fake) set(pin int, high bool) {
+	f.mu.Lock()
+	if f.levels[pin] == high {
+		f.mu.Unlock()
+		return
+	}
+	f.levels[pin] = high
+	t := Transition{Pin: pin, High: high, At: f.now()}
+	f.transitions = append(f.transitions, t)
+	watchers := append(([]func(Transition))(nil), f.watchers...)
+	f.mu.Unlock()
+
+	for _, fn := range watchers {
+		fn(t)
+	}
+}
+
+func (p *fakeOutput) High() { p.fake.set(p.pin, !p.activeLow) }
+func (p *fakeOutput) Low()  { p.fake.set(p.pin, p.activeLow) }
+
+func (in *fakeInput) Read() (bool, error) {
+	return in.fake.Level(in.pin), nil
+}
+
+func (in *fakeInput) Close() error {
+	in.fake.mu.Lock()
+	defer in.fake.mu.Unlock()
+	if in.fake.inputs[in.pin] == in {
+		delete(in.fake.inputs, in.pin)
+	}
+	return nil
+}
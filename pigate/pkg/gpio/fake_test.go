@@ -0,0 +1,115 @@
+package gpio_test
+
+import (
+	"testing"
+	"time"
+
+	"pigate/pkg/gpio"
+)
+
+func TestOpenSimDriver(t *testing.T) {
+	driver, err := gpio.Open("sim", gpio.Options{})
+	if err != nil {
+		t.Fatalf("Open(sim) error = %v", err)
+	}
+	if _, ok := driver.(*gpio.Fake); !ok {
+		t.Errorf("Open(sim) = %T, want *gpio.Fake", driver)
+	}
+	if _, err := gpio.Open("missing", gpio.Options{}); err == nil {
+		t.Error("Open(missing) succeeded, want error")
+	}
+}
+
+func TestFakeRecordsTransitions(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	fake := gpio.NewFake(func() time.Time { return now })
+	var watched []gpio.Transition
+	fake.Watch(func(tr gpio.Transition) { watched = append(watched, tr) })
+
+	relay, err := fake.Output(gpio.OutputConfig{Pin: 22})
+	if err != nil {
+		t.Fatalf("Output() error = %v", err)
+	}
+	relay.Low() // already low: not a transition
+	relay.High()
+	now = now.Add(500 * time.Millisecond)
+	relay.High()
+	relay.Low()
+
+	want := []gpio.Transition{
+		{Pin: 22, High: true, At: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{Pin: 22, High: false, At: time.Date(2024, 1, 1, 12, 0, 0, 500e6, time.UTC)},
+	}
+	got := fake.Transitions()
+	if len(got) != len(want) {
+		t.Fatalf("Transitions() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Transitions()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if len(watched) != len(want) {
+		t.Errorf("Watch saw %d transitions, want %d", len(watched), len(want))
+	}
+	if fake.Level(22) {
+		t.Error("Level(22) = high, want low")
+	}
+}
+
+func TestFakeInjectsEdges(t *testing.T) {
+	fake := gpio.NewFake(nil)
+	var edges []gpio.Edge
+	in, err := fake.Input(17, gpio.FallingEdge, func(e gpio.EdgeEvent) { edges = append(edges, e.Edge) })
+	if err != nil {
+		t.Fatalf("Input() error = %v", err)
+	}
+	if high, _ := in.Read(); !high {
+		t.Error("input does not idle high")
+	}
+
+	if err := fake.Pulse(17); err != nil {
+		t.Fatalf("Pulse() error = %v", err)
+	}
+	if err := fake.Inject(17, gpio.FallingEdge); err != nil {
+		t.Fatalf("Inject() error = %v", err)
+	}
+	if len(edges) != 2 || edges[0] != gpio.FallingEdge || edges[1] != gpio.FallingEdge {
+		t.Errorf("handler saw %v, want two falling edges", edges)
+	}
+	if high, _ := in.Read(); high {
+		t.Error("input high after falling edge")
+	}
+
+	if err := in.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := fake.Inject(17, gpio.RisingEdge); err == nil {
+		t.Error("Inject() on closed input succeeded, want error")
+	}
+}
+
+func TestFakeActiveLowAndClose(t *testing.T) {
+	fake := gpio.NewFake(nil)
+	relay, err := fake.Output(gpio.OutputConfig{Pin: 22, ActiveLow: true})
+	if err != nil {
+		t.Fatalf("Output() error = %v", err)
+	}
+	if !fake.Level(22) {
+		t.Fatal("inactive active-low output is not high")
+	}
+
+	relay.High()
+	if fake.Level(22) {
+		t.Error("active active-low output is not low")
+	}
+	if err := fake.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !fake.Level(22) {
+		t.Error("Close() did not return the output to its inactive level")
+	}
+	if got := fake.Transitions(); len(got) != 2 || got[1].High != true {
+		t.Errorf("transitions = %+v, want low then high", got)
+	}
+}
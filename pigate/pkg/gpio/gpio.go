@@ -0,0 +1,159 @@
+// Package gpio abstracts the GPIO pins the gate controller drives and reads.
+//
+// Drivers register themselves by name: "rpio" and "gpiocdev" on Linux, and
+// "sim", an in-memory Fake, everywhere. Pins are numbered by BCM GPIO number.
+package gpio
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutputPin drives one GPIO output, such as the gate relay or status LED.
+// High makes the output active and Low inactive; for an active-low output the
+// physical levels are inverted.
+type OutputPin interface {
+	High()
+	Low()
+}
+
+// Drive is the electrical drive mode of an output.
+type Drive int
+
+const (
+	DrivePushPull Drive = iota
+	DriveOpenDrain
+	DriveOpenSource
+)
+
+func (d Drive) String() string {
+	switch d {
+	case DriveOpenDrain:
+		return "open_drain"
+	case DriveOpenSource:
+		return "open_source"
+	default:
+		return "push_pull"
+	}
+}
+
+// ParseDrive parses push_pull, open_drain or open_source. An empty string is
+// push-pull.
+func ParseDrive(s string) (Drive, error) {
+	switch s {
+	case "", "push_pull":
+		return DrivePushPull, nil
+	case "open_drain":
+		return DriveOpenDrain, nil
+	case "open_source":
+		return DriveOpenSource, nil
+	default:
+		return 0, fmt.Errorf("gpio: unknown drive mode %q", s)
+	}
+}
+
+// OutputConfig describes one output line.
+type OutputConfig struct {
+	Pin       int
+	ActiveLow bool
+	Drive     Drive
+}
+
+// Options configure a driver when it is opened.
+type Options struct {
+	// Chip is the GPIO character device, e.g. gpiochip0. Drivers that do not
+	// use the character device ignore it.
+	Chip string
+}
+
+// InputPin is a requested GPIO input. Edges are delivered to the handler
+// given to Driver.Input until the pin is closed.
+type InputPin interface {
+	// Read returns true when the line is high.
+	Read() (bool, error)
+	Close() error
+}
+
+// Edge selects which transitions of an input are reported.
+type Edge int
+
+const (
+	RisingEdge Edge = 1 << iota
+	FallingEdge
+
+	BothEdges = RisingEdge | FallingEdge
+)
+
+func (e Edge) String() string {
+	switch e {
+	case RisingEdge:
+		return "rising"
+	case FallingEdge:
+		return "falling"
+	case BothEdges:
+		return "both"
+	default:
+		return fmt.Sprintf("Edge(%d)", int(e))
+	}
+}
+
+// EdgeEvent is one transition seen on an input.
+type EdgeEvent struct {
+	Pin  int
+	Edge Edge // RisingEdge or FallingEdge
+	At   time.Time
+}
+
+// Driver opens pins on one GPIO implementation.
+type Driver interface {
+	// Output configures an output, driven inactive.
+	Output(cfg OutputConfig) (OutputPin, error)
+	// Input configures pin as an input and calls handler for each edge
+	// selected by edges. handler runs on the driver's goroutine and must not
+	// block.
+	Input(pin int, edges Edge, handler func(EdgeEvent)) (InputPin, error)
+	// Close drives every output to its inactive level and releases the
+	// driver's pins. Pins should not be used afterwards.
+	Close() error
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]func(Options) (Driver, error))
+)
+
+// Register makes a driver available to Open under name. It panics if name is
+// already registered, like database/sql.
+func Register(name string, open func(Options) (Driver, error)) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic("gpio: Register called twice for driver " + name)
+	}
+	registry[name] = open
+}
+
+// Open opens the driver registered under name.
+func Open(name string, opts Options) (Driver, error) {
+	registryMu.Lock()
+	open, ok := registry[name]
+	registryMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("gpio: unknown driver %q (available: %v)", name, Drivers())
+	}
+	return open(opts)
+}
+
+// Drivers returns the registered driver names, sorted.
+func Drivers() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
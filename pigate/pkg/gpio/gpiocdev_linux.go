@@ -0,0 +1,189 @@
+//go:build linux
+
+package gpio
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	gpiocdev "github.com/warthog618/go-gpiocdev"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultChip = "gpiochip0"
+	consumer    = "pigate"
+)
+
+func init() {
+	Register("gpiocdev", openGpiocdev)
+}
+
+// gpiocdevDriver uses the Linux GPIO character device. Edges are timestamped
+// by the kernel, so it is the driver to use for Wiegand inputs, and active-low
+// and drive mode are applied by the kernel rather than in software.
+type gpiocdevDriver struct {
+	chip string
+
+	mu      sync.Mutex
+	outputs []*gpiocdev.Line
+	inputs  []*gpiocdev.Line
+}
+
+type gpiocdevOutput struct {
+	line *gpiocdev.Line
+}
+
+type gpiocdevInput struct {
+	driver *gpiocdevDriver
+	line   *gpiocdev.Line
+}
+
+func openGpiocdev(opts Options) (Driver, error) {
+	chip := opts.Chip
+	if chip == "" {
+		chip = defaultChip
+	}
+	return &gpiocdevDriver{chip: chip}, nil
+}
+
+func (d *gpiocdevDriver) Output(cfg OutputConfig) (OutputPin, error) {
+	options := []gpiocdev.LineReqOption{
+		gpiocdev.WithConsumer(consumer),
+		gpiocdev.AsOutput(0), // inactive; the kernel applies active-low
+	}
+	if cfg.ActiveLow {
+		options = append(options, gpiocdev.AsActiveLow)
+	}
+	switch cfg.Drive {
+	case DriveOpenDrain:
+		options = append(options, gpiocdev.AsOpenDrain)
+	case DriveOpenSource:
+		options = append(options, gpiocdev.AsOpenSource)
+	default:
+		options = append(options, gpiocdev.AsPushPull)
+	}
+	line, err := gpiocdev.RequestLine(d.chip, cfg.Pin, options...)
+	if err != nil {
+		return nil, fmt.Errorf("request output line %s:%d: %w", d.chip, cfg.Pin, err)
+	}
+	d.mu.Lock()
+	d.outputs = append(d.outputs, line)
+	d.mu.Unlock()
+	return &gpiocdevOutput{line: line}, nil
+}
+
+func (d *gpiocdevDriver) Input(pin int, edges Edge, handler func(EdgeEvent)) (InputPin, error) {
+	options := []gpiocdev.LineReqOption{gpiocdev.WithConsumer(consumer), gpiocdev.AsInput}
+	if handler != nil && edges != 0 {
+		switch edges {
+		case RisingEdge:
+			options = append(options, gpiocdev.WithRisingEdge)
+		case FallingEdge:
+			options = append(options, gpiocdev.WithFallingEdge)
+		default:
+			options = append(options, gpiocdev.WithBothEdges)
+		}
+		clock, err := newEdgeClock()
+		if err != nil {
+			return nil, fmt.Errorf("read monotonic clock: %w", err)
+		}
+		options = append(options, gpiocdev.WithEventHandler(func(evt gpiocdev.LineEvent) {
+			edge := RisingEdge
+			if evt.Type == gpiocdev.LineEventFallingEdge {
+				edge = FallingEdge
+			}
+			handler(EdgeEvent{Pin: evt.Offset, Edge: edge, At: clock.at(evt.Timestamp)})
+		}))
+	}
+	line, err := gpiocdev.RequestLine(d.chip, pin, options...)
+	if err != nil {
+		return nil, fmt.Errorf("request input line %s:%d: %w", d.chip, pin, err)
+	}
+	d.mu.Lock()
+	d.inputs = append(d.inputs, line)
+	d.mu.Unlock()
+	return &gpiocdevInput{driver: d, line: line}, nil
+}
+
+// edgeClock converts kernel edge timestamps, which are CLOCK_MONOTONIC from
+// Linux 5.7, to times. The offset between the two clocks is taken once, so
+// the times keep Go's monotonic reading and an edge that waited behind the
+// handler is still stamped with when it happened.
+type edgeClock struct {
+	base time.Time
+	mono time.Duration // CLOCK_MONOTONIC at base
+}
+
+func newEdgeClock() (edgeClock, error) {
+	var ts unix.Timespec
+	base := time.Now()
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return edgeClock{}, err
+	}
+	return edgeClock{base: base, mono: time.Duration(ts.Nano())}, nil
+}
+
+// at returns the time of an edge stamped ts. Kernels before 5.7 stamp edges
+// with CLOCK_REALTIME, which is decades past any monotonic reading.
+func (c edgeClock) at(ts time.Duration) time.Time {
+	if ts-c.mono > 10*365*24*time.Hour {
+		return time.Unix(0, int64(ts))
+	}
+	return c.base.Add(ts - c.mono)
+}
+
+// release closes an input requested from d.
+func (d *gpiocdevDriver) release(line *gpiocdev.Line) error {
+	d.mu.Lock()
+	for i, l := range d.inputs {
+		if l == line {
+			d.inputs = append(d.inputs[:i], d.inputs[i+1:]...)
+			break
+		}
+	}
+	d.mu.Unlock()
+	return line.Close()
+}
+
+// Close sets outputs inactive before releasing them. The kernel leaves a
+// released line at its last value, so this is what keeps the relay off after
+// a clean shutdown.
+func (d *gpiocdevDriver) Close() error {
+	d.mu.Lock()
+	outputs, inputs := d.outputs, d.inputs
+	d.outputs, d.inputs = nil, nil
+	d.mu.Unlock()
+
+	var errs []error
+	for _, line := range outputs {
+		if err := line.SetValue(0); err != nil {
+			errs = append(errs, fmt.Errorf("set line %d inactive: %w", line.Offset(), err))
+		}
+	}
+	for _, line := range append(outputs, inputs...) {
+		if err := line.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("release line %d: %w", line.Offset(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *gpiocdevOutput) High() {
+	_ = p.line.SetValue(1)
+}
+
+func (p *gpiocdevOutput) Low() {
+	_ = p.line.SetValue(0)
+}
+
+func (p *gpiocdevInput) Read() (bool, error) {
+	v, err := p.line.Value()
+	return v == 1, err
+}
+
+func (p *gpiocdevInput) Close() error {
+	return p.driver.release(p.line)
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package gpio
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestEdgeClock(t *testing.T) {
+	clock, err := newEdgeClock()
+	if err != nil {
+		t.Fatalf("newEdgeClock() error = %v", err)
+	}
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		t.Fatalf("ClockGettime() error = %v", err)
+	}
+	now := time.Now()
+
+	// An edge delivered 50ms late keeps the time it happened.
+	at := clock.at(time.Duration(ts.Nano()) - 50*time.Millisecond)
+	if late := now.Sub(at); late < 50*time.Millisecond || late > 100*time.Millisecond {
+		t.Errorf("edge stamped %v before now, want 50ms", late)
+	}
+	if next := clock.at(time.Duration(ts.Nano())); next.Sub(at) != 50*time.Millisecond {
+		t.Errorf("edges %v apart, want 50ms", next.Sub(at))
+	}
+
+	// Older kernels stamp edges with the wall clock.
+	wall := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if got := clock.at(time.Duration(wall.UnixNano())); !got.Equal(wall) {
+		t.Errorf("realtime edge at %v, want %v", got, wall)
+	}
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+package gpio
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	rpio "github.com/stianeikeland/go-rpio/v4"
+)
+
+// rpioPollInterval is how often rpio inputs are checked for edges. The
+// Broadcom edge detector latches a single edge between checks, so rpio is
+// fine for switches and loops but too slow for Wiegand; use gpiocdev there.
+const rpioPollInterval = time.Millisecond
+
+func init() {
+	Register("rpio", func(Options) (Driver, error) { return openRpio() })
+}
+
+// rpioDriver drives the Broadcom GPIO registers through /dev/gpiomem. It
+// only works on Pi models go-rpio knows, and supports push-pull outputs only;
+// gpiocdev is the default.
+type rpioDriver struct {
+	mu      sync.Mutex
+	outputs []*rpioOutput
+}
+
+type rpioOutput struct {
+	pin       rpio.Pin
+	activeLow bool
+}
+
+type rpioInput struct {
+	pin  rpio.Pin
+	stop chan struct{}
+	once sync.Once
+}
+
+func openRpio() (Driver, error) {
+	if err := rpio.Open(); err != nil {
+		return nil, err
+	}
+	return &rpioDriver{}, nil
+}
+
+func (d *rpioDriver) Output(cfg OutputConfig) (OutputPin, error) {
+	if cfg.Drive != DrivePushPull {
+		return nil, errors.New("rpio supports push-pull outputs only")
+	}
+	out := &rpioOutput{pin: rpio.Pin(cfg.Pin), activeLow: cfg.ActiveLow}
+	out.pin.Output()
+	out.Low()
+	d.mu.Lock()
+	d.outputs = append(d.outputs, out)
+	d.mu.Unlock()
+	return out, nil
+}
+
+func (d *rpioDriver) Input(pin int, edges Edge, handler func(EdgeEvent)) (InputPin, error) {
+	p := rpio.Pin(pin)
+	p.Input()
+	in := &rpioInput{pin: p, stop: make(chan struct{})}
+	if handler != nil && edges != 0 {
+		detect := rpio.AnyEdge
+		switch edges {
+		case RisingEdge:
+			detect = rpio.RiseEdge
+		case FallingEdge:
+			detect = rpio.FallEdge
+		}
+		p.Detect(detect)
+		go in.poll(edges, handler)
+	}
+	return in, nil
+}
+
+func (d *rpioDriver) Close() error {
+	d.mu.Lock()
+	outputs := d.outputs
+	d.outputs = nil
+	d.mu.Unlock()
+	for _, out := range outputs {
+		out.Low()
+	}
+	return rpio.Close()
+}
+
+func (p *rpioOutput) High() {
+	p.set(true)
+}
+
+func (p *rpioOutput) Low() {
+	p.set(false)
+}
+
+func (p *rpioOutput) set(active bool) {
+	if active != p.activeLow {
+		p.pin.High()
+	} else {
+		p.pin.Low()
+	}
+}
+
+// poll reports latched edges. The edge direction is inferred from the level
+// read afterwards.
+func (in *rpioInput) poll(edges Edge, handler func(EdgeEvent)) {
+	ticker := time.NewTicker(rpioPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-in.stop:
+			return
+		case <-ticker.C:
+			if !in.pin.EdgeDetected() {
+				continue
+			}
+			edge := FallingEdge
+			if in.pin.Read() == rpio.High {
+				edge = RisingEdge
+			}
+			if edges&edge != 0 {
+				handler(EdgeEvent{Pin: int(in.pin), Edge: edge, At: time.Now()})
+			}
+		}
+	}
+}
+
+func (in *rpioInput) Read() (bool, error) {
+	return in.pin.Read() == rpio.High, nil
+}
+
+func (in *rpioInput) Close() error {
+	in.once.Do(func() {
+		close(in.stop)
+		in.pin.Detect(rpio.NoEdge)
+	})
+	return nil
+}
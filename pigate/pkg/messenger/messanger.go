@@ -1,9 +1,11 @@
 package messenger
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -13,9 +15,23 @@ type MQTTClient struct {
 	client        mqtt.Client
 	locationID    string
 	subscriptions map[string]mqtt.MessageHandler // store callbacks for re-connecting after network loss
-	mu            sync.Mutex                     // For accessing subscriptions map
+	mu            *sync.Mutex                    // For accessing subscriptions map
+	presence      bool                           // publish online/offline on the presence topic
+	metrics       Metrics
 }
 
+// Metrics receives counts of MQTT connection problems. pkg/metrics provides
+// the Prometheus implementation.
+type Metrics interface {
+	Reconnected()
+	PublishFailed(topic string)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) Reconnected()               {}
+func (noMetrics) PublishFailed(topic string) {}
+
 // Make sure the MQTTClient satisfies the MQTTClientInterface
 var _ MQTTClientInterface = (*MQTTClient)(nil)
 
@@ -24,6 +40,18 @@ func NewMQTTClient(broker string, clientID string, locationID string) *MQTTClien
 }
 
 func NewMQTTClientWithCredentials(broker string, clientID string, locationID string, username string, password string) *MQTTClient {
+	return newMQTTClient(broker, clientID, locationID, username, password, false)
+}
+
+// NewDeviceMQTTClient returns a client for the Device at locationID. It
+// registers a retained "offline" last-will on the presence topic and
+// publishes "online" each time the connection is (re-)established, so the
+// Control Plane learns when the Device drops off the network.
+func NewDeviceMQTTClient(broker string, clientID string, locationID string, username string, password string) *MQTTClient {
+	return newMQTTClient(broker, clientID, locationID, username, password, true)
+}
+
+func newMQTTClient(broker, clientID, locationID, username, password string, presence bool) *MQTTClient {
 	opts := mqtt.NewClientOptions().
 		AddBroker(broker).
 		SetClientID(clientID).
@@ -39,16 +67,33 @@ func NewMQTTClientWithCredentials(broker string, clientID string, locationID str
 		opts.SetPassword(password)
 	}
 
+	presenceTopic := fmt.Sprintf(TopicPigatePresence, locationID)
+	if presence {
+		opts.SetWill(presenceTopic, PresenceOffline, 1, true)
+	}
+
 	r := &MQTTClient{
-		client:        mqtt.NewClient(opts),
 		locationID:    locationID,
 		subscriptions: make(map[string]mqtt.MessageHandler),
+		mu:            &sync.Mutex{},
+		presence:      presence,
+		metrics:       noMetrics{},
 	}
 
+	// Handlers must be set before mqtt.NewClient, which copies the options.
 	// Handle successful connection
+	var connects atomic.Int64
 	opts.OnConnect = func(c mqtt.Client) {
 		log.Println("MQTT Connected!")
+		if connects.Add(1) > 1 {
+			r.metrics.Reconnected()
+		}
 		r.resubscribeAll() // 🔹 Restore previous subscriptions
+		if presence {
+			if err := r.publish(presenceTopic, true, PresenceOnline); err != nil {
+				log.Printf("Failed to publish presence: %v", err)
+			}
+		}
 	}
 
 	// Handle lost connection
@@ -56,15 +101,47 @@ func NewMQTTClientWithCredentials(broker string, clientID string, locationID str
 		log.Printf("MQTT Connection lost: %v. Retrying...", err)
 	}
 
+	r.client = mqtt.NewClient(opts)
 	return r
 }
 
+// ForLocation returns a client addressing the topics of another location. It
+// shares this client's broker connection and subscriptions, so one Control
+// Plane process can follow many locations.
+func (r *MQTTClient) ForLocation(locationID string) *MQTTClient {
+	return &MQTTClient{
+		client:        r.client,
+		locationID:    locationID,
+		subscriptions: r.subscriptions,
+		mu:            r.mu,
+		metrics:       r.metrics,
+	}
+}
+
+// SetMetrics reports reconnects and failed publishes to m. Call it before
+// Connect and before ForLocation.
+func (r *MQTTClient) SetMetrics(m Metrics) {
+	r.metrics = m
+}
+
+// LocationID returns the location whose topics this client addresses.
+func (r *MQTTClient) LocationID() string {
+	return r.locationID
+}
+
 func (r *MQTTClient) Connect() error {
 	token := r.client.Connect()
 	return waitForToken("connect to MQTT broker", token)
 }
 
 func (r *MQTTClient) Disconnect() {
+	// A clean disconnect does not trigger the last-will, so announce it.
+	if r.presence && r.client.IsConnectionOpen() {
+		topic := fmt.Sprintf(TopicPigatePresence, r.locationID)
+		if err := r.publish(topic, true, PresenceOffline); err != nil {
+			log.Printf("Failed to publish presence: %v", err)
+		}
+	}
 	r.client.Disconnect(250)
 }
 
@@ -77,6 +154,13 @@ const (
 	TopicPigateStatus      = "%s/pigate/status"      // e.g. "location123/pigate/status"
 	TopicPigateCommand     = "%s/pigate/command"     // e.g. "location123/pigate/command"
 	TopicCredentialsStatus = "%s/credentials/status" // e.g. "location123/credentials/status"
+	TopicPigateAccess      = "%s/pigate/access"      // e.g. "location123/pigate/access"
+	TopicPigatePresence    = "%s/pigate/presence"    // e.g. "location123/pigate/presence"
+	TopicCredentialsSync   = "%s/credentials/sync"   // e.g. "location123/credentials/sync"
+	TopicPigateHeartbeat   = "%s/pigate/heartbeat"   // e.g. "location123/pigate/heartbeat"
+	TopicPigateAlert       = "%s/pigate/alert"       // e.g. "location123/pigate/alert"
+	TopicPigateDuress      = "%s/pigate/duress"      // e.g. "location123/pigate/duress"
+	TopicPigatePosition    = "%s/pigate/position"    // e.g. "location123/pigate/position"
 )
 
 // Command messages (payloads) for `locationID/pigate/command`
@@ -84,6 +168,12 @@ const (
 	CommandOpenMessage     = "open"
 	CommandHoldOpenMessage = "hold_open"
 	CommandCloseMessage    = "close"
+
+	// A lockdown keeps the gate closed to credentials and open commands until
+	// it ends; free-exit inputs open it unless the gate controller suppresses
+	// them.
+	CommandLockdownMessage    = "lockdown"
+	CommandEndLockdownMessage = "end_lockdown"
 )
 
 // Status messages (payloads) for `locationID/pigate/status`
@@ -93,11 +183,93 @@ const (
 	StatusClosed     = "closed"
 )
 
+// Position messages (payloads) for `locationID/pigate/position`, published by
+// Devices with gate limit switches. Status says what the relay was told to do;
+// position says where the gate is.
+const (
+	PositionOpening = "opening"
+	PositionOpen    = "open"
+	PositionClosing = "closing"
+	PositionClosed  = "closed"
+	PositionFault   = "fault" // see the gate_* alert for which fault
+)
+
 // Status messages (payloads) for `locationID/credentials/status`
 const (
 	UpdateAvailable = "update_available"
 )
 
+// Sync results (payloads) for `locationID/credentials/sync`
+const (
+	SyncSucceeded = "synced"
+	SyncFailed    = "sync_failed"
+)
+
+// Presence messages (payloads) for `locationID/pigate/presence`
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+)
+
+// Access results for `locationID/pigate/access`
+const (
+	AccessGranted = "granted"
+	AccessDenied  = "denied"
+)
+
+// AccessEvent is the JSON payload published on `locationID/pigate/access`
+// for every credential presented at the gate.
+type AccessEvent struct {
+	Code           string    `json:"code"`
+	CredentialType string    `json:"credential_type,omitempty"` // "pin" or "card"
+	Username       string    `json:"username,omitempty"`
+	Result         string    `json:"result"`
+	Reason         string    `json:"reason,omitempty"`
+	OpenMode       string    `json:"open_mode,omitempty"`
+	At             time.Time `json:"at"`
+}
+
+// Alert types for `locationID/pigate/alert`
+const (
+	AlertKeypadLockout     = "keypad_lockout"
+	AlertGateForcedOpen    = "gate_forced_open"     // left closed without being commanded open
+	AlertGateFailedToOpen  = "gate_failed_to_open"  // not open within the travel timeout
+	AlertGateFailedToClose = "gate_failed_to_close" // not closed within the travel timeout
+	AlertGateSensorFault   = "gate_sensor_fault"    // both limit switches made at once
+)
+
+// AlertEvent is the JSON payload a Device publishes on `locationID/pigate/alert`
+// when something at the gate needs an operator's attention.
+type AlertEvent struct {
+	Type     string     `json:"type"`
+	Source   string     `json:"source,omitempty"`   // keypad or reader, for keypad_lockout
+	Failures int        `json:"failures,omitempty"` // failed codes that started the lockout
+	Until    *time.Time `json:"until,omitempty"`    // end of the lockout
+	At       time.Time  `json:"at"`
+}
+
+// DuressEvent is the JSON payload a Device publishes on `locationID/pigate/duress`
+// when a duress code is entered. The gate opens as usual, and the access event
+// published for it looks like any other.
+type DuressEvent struct {
+	Code           string    `json:"code"` // the credential's own code, never the duress code
+	CredentialType string    `json:"credential_type"`
+	Username       string    `json:"username"`
+	Source         string    `json:"source"` // keypad or reader the code was entered at
+	At             time.Time `json:"at"`
+}
+
+// Heartbeat is the JSON payload a Device publishes periodically on
+// `locationID/pigate/heartbeat` to report the software it is running.
+type Heartbeat struct {
+	DeviceID  string    `json:"device_id"`
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit,omitempty"`
+	BuildTime string    `json:"build_time,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	At        time.Time `json:"at"`
+}
+
 func (r *MQTTClient) NotifyNewCredentials() error {
 	topic := fmt.Sprintf(TopicCredentialsStatus, r.locationID)
 	if err := r.publish(topic, true, UpdateAvailable); err != nil {
@@ -107,6 +279,21 @@ func (r *MQTTClient) NotifyNewCredentials() error {
 	return nil
 }
 
+// NotifyCredentialSync reports the outcome of a Device pulling credentials
+// from the Control Plane Store.
+func (r *MQTTClient) NotifyCredentialSync(syncErr error) error {
+	topic := fmt.Sprintf(TopicCredentialsSync, r.locationID)
+	payload := SyncSucceeded
+	if syncErr != nil {
+		payload = SyncFailed
+	}
+	if err := r.publish(topic, true, payload); err != nil {
+		log.Printf("Failed to publish credential sync result: %v", err)
+		return err
+	}
+	return nil
+}
+
 func (r *MQTTClient) CommandOpen() error {
 	topic := fmt.Sprintf(TopicPigateCommand, r.locationID)
 	if err := r.publish(topic, false, CommandOpenMessage); err != nil {
@@ -134,6 +321,24 @@ func (r *MQTTClient) CommandClose() error {
 	return nil
 }
 
+func (r *MQTTClient) CommandLockdown() error {
+	topic := fmt.Sprintf(TopicPigateCommand, r.locationID)
+	if err := r.publish(topic, false, CommandLockdownMessage); err != nil {
+		log.Printf("Failed to publish 'lockdown' message: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *MQTTClient) CommandEndLockdown() error {
+	topic := fmt.Sprintf(TopicPigateCommand, r.locationID)
+	if err := r.publish(topic, false, CommandEndLockdownMessage); err != nil {
+		log.Printf("Failed to publish 'end_lockdown' message: %v", err)
+		return err
+	}
+	return nil
+}
+
 func (r *MQTTClient) NotifyGateOpen() error {
 	topic := fmt.Sprintf(TopicPigateStatus, r.locationID)
 	if err := r.publish(topic, true, StatusOpened); err != nil {
@@ -161,9 +366,81 @@ func (r *MQTTClient) NotifyGateClosed() error {
 	return nil
 }
 
+// NotifyGatePosition publishes a retained gate position, so a status server
+// that starts later still learns where the gate is.
+func (r *MQTTClient) NotifyGatePosition(position string) error {
+	topic := fmt.Sprintf(TopicPigatePosition, r.locationID)
+	if err := r.publish(topic, true, position); err != nil {
+		log.Printf("Failed to publish '%s' position: %v", position, err)
+		return err
+	}
+	return nil
+}
+
+func (r *MQTTClient) NotifyAccess(event AccessEvent) error {
+	topic := fmt.Sprintf(TopicPigateAccess, r.locationID)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encode access event: %w", err)
+	}
+	if err := r.publish(topic, false, string(payload)); err != nil {
+		log.Printf("Failed to publish access event: %v", err)
+		return err
+	}
+	return nil
+}
+
+// NotifyAlert publishes an alert event. Alerts are not retained; a status
+// server that is down misses them, as it does access events.
+func (r *MQTTClient) NotifyAlert(event AlertEvent) error {
+	topic := fmt.Sprintf(TopicPigateAlert, r.locationID)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encode alert event: %w", err)
+	}
+	if err := r.publish(topic, false, string(payload)); err != nil {
+		log.Printf("Failed to publish alert event: %v", err)
+		return err
+	}
+	return nil
+}
+
+// NotifyDuress publishes a duress event. Like alerts, it is not retained.
+func (r *MQTTClient) NotifyDuress(event DuressEvent) error {
+	topic := fmt.Sprintf(TopicPigateDuress, r.locationID)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encode duress event: %w", err)
+	}
+	if err := r.publish(topic, false, string(payload)); err != nil {
+		log.Printf("Failed to publish duress event: %v", err)
+		return err
+	}
+	return nil
+}
+
+// NotifyHeartbeat publishes a retained heartbeat, so a status server that
+// starts later still learns the Device's version.
+func (r *MQTTClient) NotifyHeartbeat(heartbeat Heartbeat) error {
+	topic := fmt.Sprintf(TopicPigateHeartbeat, r.locationID)
+	payload, err := json.Marshal(heartbeat)
+	if err != nil {
+		return fmt.Errorf("encode heartbeat: %w", err)
+	}
+	if err := r.publish(topic, true, string(payload)); err != nil {
+		log.Printf("Failed to publish heartbeat: %v", err)
+		return err
+	}
+	return nil
+}
+
 func (r *MQTTClient) publish(topic string, retained bool, payload string) error {
 	token := r.client.Publish(topic, 1, retained, payload)
-	return waitForToken(fmt.Sprintf("publish to topic %s", topic), token)
+	if err := waitForToken(fmt.Sprintf("publish to topic %s", topic), token); err != nil {
+		r.metrics.PublishFailed(topic)
+		return err
+	}
+	return nil
 }
 
 func waitForToken(action string, token mqtt.Token) error {
@@ -233,6 +510,146 @@ func (r *MQTTClient) SubscribeCredentialStatus(callback func(topic string, statu
 	return nil
 }
 
+func (r *MQTTClient) SubscribePigateAccess(callback func(topic string, payload string)) error {
+	topic := fmt.Sprintf(TopicPigateAccess, r.locationID)
+
+	r.mu.Lock()
+	r.subscriptions[topic] = func(client mqtt.Client, msg mqtt.Message) {
+		callback(msg.Topic(), string(msg.Payload()))
+	}
+	r.mu.Unlock()
+
+	token := r.client.Subscribe(topic, 1, r.subscriptions[topic])
+
+	if err := waitForToken(fmt.Sprintf("subscribe to topic %s", topic), token); err != nil {
+		log.Printf("Failed to subscribe to topic '%s': %v", topic, err)
+		return err
+	}
+
+	log.Printf("Subscribed to '%s' for gate access events", topic)
+	return nil
+}
+
+func (r *MQTTClient) SubscribePigateAlert(callback func(topic string, payload string)) error {
+	topic := fmt.Sprintf(TopicPigateAlert, r.locationID)
+
+	r.mu.Lock()
+	r.subscriptions[topic] = func(client mqtt.Client, msg mqtt.Message) {
+		callback(msg.Topic(), string(msg.Payload()))
+	}
+	r.mu.Unlock()
+
+	token := r.client.Subscribe(topic, 1, r.subscriptions[topic])
+
+	if err := waitForToken(fmt.Sprintf("subscribe to topic %s", topic), token); err != nil {
+		log.Printf("Failed to subscribe to topic '%s': %v", topic, err)
+		return err
+	}
+
+	log.Printf("Subscribed to '%s' for gate alerts", topic)
+	return nil
+}
+
+func (r *MQTTClient) SubscribePigatePosition(callback func(topic string, position string)) error {
+	topic := fmt.Sprintf(TopicPigatePosition, r.locationID)
+
+	r.mu.Lock()
+	r.subscriptions[topic] = func(client mqtt.Client, msg mqtt.Message) {
+		callback(msg.Topic(), string(msg.Payload()))
+	}
+	r.mu.Unlock()
+
+	token := r.client.Subscribe(topic, 1, r.subscriptions[topic])
+
+	if err := waitForToken(fmt.Sprintf("subscribe to topic %s", topic), token); err != nil {
+		log.Printf("Failed to subscribe to topic '%s': %v", topic, err)
+		return err
+	}
+
+	log.Printf("Subscribed to '%s' for gate position", topic)
+	return nil
+}
+
+func (r *MQTTClient) SubscribePigateDuress(callback func(topic string, payload string)) error {
+	topic := fmt.Sprintf(TopicPigateDuress, r.locationID)
+
+	r.mu.Lock()
+	r.subscriptions[topic] = func(client mqtt.Client, msg mqtt.Message) {
+		callback(msg.Topic(), string(msg.Payload()))
+	}
+	r.mu.Unlock()
+
+	token := r.client.Subscribe(topic, 1, r.subscriptions[topic])
+
+	if err := waitForToken(fmt.Sprintf("subscribe to topic %s", topic), token); err != nil {
+		log.Printf("Failed to subscribe to topic '%s': %v", topic, err)
+		return err
+	}
+
+	log.Printf("Subscribed to '%s' for duress events", topic)
+	return nil
+}
+
+func (r *MQTTClient) SubscribePigatePresence(callback func(topic string, presence string)) error {
+	topic := fmt.Sprintf(TopicPigatePresence, r.locationID)
+
+	r.mu.Lock()
+	r.subscriptions[topic] = func(client mqtt.Client, msg mqtt.Message) {
+		callback(msg.Topic(), string(msg.Payload()))
+	}
+	r.mu.Unlock()
+
+	token := r.client.Subscribe(topic, 1, r.subscriptions[topic])
+
+	if err := waitForToken(fmt.Sprintf("subscribe to topic %s", topic), token); err != nil {
+		log.Printf("Failed to subscribe to topic '%s': %v", topic, err)
+		return err
+	}
+
+	log.Printf("Subscribed to '%s' for device presence", topic)
+	return nil
+}
+
+func (r *MQTTClient) SubscribeCredentialSync(callback func(topic string, result string)) error {
+	topic := fmt.Sprintf(TopicCredentialsSync, r.locationID)
+
+	r.mu.Lock()
+	r.subscriptions[topic] = func(client mqtt.Client, msg mqtt.Message) {
+		callback(msg.Topic(), string(msg.Payload()))
+	}
+	r.mu.Unlock()
+
+	token := r.client.Subscribe(topic, 1, r.subscriptions[topic])
+
+	if err := waitForToken(fmt.Sprintf("subscribe to topic %s", topic), token); err != nil {
+		log.Printf("Failed to subscribe to topic '%s': %v", topic, err)
+		return err
+	}
+
+	log.Printf("Subscribed to '%s' for credential sync results", topic)
+	return nil
+}
+
+func (r *MQTTClient) SubscribePigateHeartbeat(callback func(topic string, payload string)) error {
+	topic := fmt.Sprintf(TopicPigateHeartbeat, r.locationID)
+
+	r.mu.Lock()
+	r.subscriptions[topic] = func(client mqtt.Client, msg mqtt.Message) {
+		callback(msg.Topic(), string(msg.Payload()))
+	}
+	r.mu.Unlock()
+
+	token := r.client.Subscribe(topic, 1, r.subscriptions[topic])
+
+	if err := waitForToken(fmt.Sprintf("subscribe to topic %s", topic), token); err != nil {
+		log.Printf("Failed to subscribe to topic '%s': %v", topic, err)
+		return err
+	}
+
+	log.Printf("Subscribed to '%s' for device heartbeats", topic)
+	return nil
+}
+
 func (r *MQTTClient) resubscribeAll() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -7,11 +7,26 @@ type MQTTClientInterface interface {
 	CommandOpen() error
 	CommandLockOpen() error
 	CommandClose() error
+	CommandLockdown() error
+	CommandEndLockdown() error
 	NotifyGateOpen() error
 	NotifyGateLockedOpen() error
 	NotifyGateClosed() error
+	NotifyGatePosition(position string) error
+	NotifyAccess(event AccessEvent) error
+	NotifyAlert(event AlertEvent) error
+	NotifyDuress(event DuressEvent) error
+	NotifyCredentialSync(syncErr error) error
+	NotifyHeartbeat(heartbeat Heartbeat) error
 	IsConnected() bool
 	SubscribePigateCommand(callback func(topic string, command string)) error
 	SubscribePigateStatus(callback func(topic string, command string)) error
+	SubscribePigatePosition(callback func(topic string, position string)) error
 	SubscribeCredentialStatus(callback func(topic string, command string)) error
+	SubscribePigateAccess(callback func(topic string, payload string)) error
+	SubscribePigateAlert(callback func(topic string, payload string)) error
+	SubscribePigateDuress(callback func(topic string, payload string)) error
+	SubscribePigatePresence(callback func(topic string, presence string)) error
+	SubscribeCredentialSync(callback func(topic string, result string)) error
+	SubscribePigateHeartbeat(callback func(topic string, payload string)) error
 }
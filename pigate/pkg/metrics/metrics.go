@@ -0,0 +1,210 @@
+// Package metrics is a small Prometheus-compatible metrics registry. Each
+// process creates its own Registry and hands the recorders built from it to
+// the packages it instruments; nothing is registered globally.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type kind string
+
+const (
+	kindCounter kind = "counter"
+	kindGauge   kind = "gauge"
+	kindSummary kind = "summary"
+)
+
+// Registry holds metric families and renders them in the Prometheus text
+// exposition format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+type family struct {
+	name       string
+	help       string
+	kind       kind
+	labelNames []string
+	fn         func() float64 // set for gauges computed at scrape time
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64 // counter or gauge value; summary sum
+	count       uint64  // summary observations
+}
+
+func (r *Registry) register(name, help string, k kind, labelNames []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.families[name]; dup {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       k,
+		labelNames: labelNames,
+		series:     make(map[string]*series),
+	}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(labelValues []string, update func(s *series)) {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s takes %d labels, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	update(s)
+}
+
+// Counter is a monotonically increasing value, optionally split by labels.
+type Counter struct{ f *family }
+
+func (r *Registry) Counter(name, help string, labelNames ...string) *Counter {
+	return &Counter{f: r.register(name, help, kindCounter, labelNames)}
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.f.with(labelValues, func(s *series) { s.value += v })
+}
+
+// Gauge is a value that can go up and down, optionally split by labels.
+type Gauge struct{ f *family }
+
+func (r *Registry) Gauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{f: r.register(name, help, kindGauge, labelNames)}
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.with(labelValues, func(s *series) { s.value = v })
+}
+
+// GaugeFunc registers an unlabelled gauge whose value is read from fn on
+// every scrape.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, help, kindGauge, nil).fn = fn
+}
+
+// Summary tracks the count and sum of observations, such as durations in
+// seconds. No quantiles are computed.
+type Summary struct{ f *family }
+
+func (r *Registry) Summary(name, help string, labelNames ...string) *Summary {
+	return &Summary{f: r.register(name, help, kindSummary, labelNames)}
+}
+
+func (s *Summary) Observe(v float64, labelValues ...string) {
+	s.f.with(labelValues, func(s *series) {
+		s.value += v
+		s.count++
+	})
+}
+
+// WriteTo writes every family, sorted by name, in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var b strings.Builder
+	for _, f := range families {
+		f.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (f *family) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.kind)
+
+	if f.fn != nil {
+		fmt.Fprintf(b, "%s %s\n", f.name, formatValue(f.fn()))
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		labels := formatLabels(f.labelNames, s.labelValues)
+		if f.kind == kindSummary {
+			fmt.Fprintf(b, "%s_sum%s %s\n", f.name, labels, formatValue(s.value))
+			fmt.Fprintf(b, "%s_count%s %d\n", f.name, labels, s.count)
+			continue
+		}
+		fmt.Fprintf(b, "%s%s %s\n", f.name, labels, formatValue(s.value))
+	}
+}
+
+// Handler serves the registry for Prometheus to scrape.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := r.WriteTo(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	help = strings.ReplaceAll(help, `\`, `\\`)
+	return strings.ReplaceAll(help, "\n", `\n`)
+}
@@ -0,0 +1,125 @@
+package metrics_test
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"pigate/pkg/metrics"
+)
+
+func render(t *testing.T, r *metrics.Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	return b.String()
+}
+
+func TestRegistryTextFormat(t *testing.T) {
+	r := metrics.NewRegistry()
+	access := r.Counter("test_access_total", "Access attempts.", "result", "reason")
+	access.Inc("denied", "unknown_code")
+	access.Inc("denied", "unknown_code")
+	access.Inc("granted", "")
+	r.Gauge("test_credentials", "Credentials.").Set(42)
+	r.Summary("test_sync_seconds", "Sync time.", "kind").Observe(1.5, "credentials")
+	r.GaugeFunc("test_up", "Up.", func() float64 { return 1 })
+
+	want := `# HELP test_access_total Access attempts.
+# TYPE test_access_total counter
+test_access_total{result="denied",reason="unknown_code"} 2
+test_access_total{result="granted",reason=""} 1
+# HELP test_credentials Credentials.
+# TYPE test_credentials gauge
+test_credentials 42
+# HELP test_sync_seconds Sync time.
+# TYPE test_sync_seconds summary
+test_sync_seconds_sum{kind="credentials"} 1.5
+test_sync_seconds_count{kind="credentials"} 1
+# HELP test_up Up.
+# TYPE test_up gauge
+test_up 1
+`
+	if got := render(t, r); got != want {
+		t.Errorf("WriteTo() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLabelValuesAreEscaped(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.Counter("test_total", "Test.", "topic").Inc("a\"b\\c\nd")
+
+	if got := render(t, r); !strings.Contains(got, `test_total{topic="a\"b\\c\nd"} 1`) {
+		t.Errorf("label not escaped:\n%s", got)
+	}
+}
+
+func TestGateStateIsOneHot(t *testing.T) {
+	r := metrics.NewRegistry()
+	m := metrics.NewGateMetrics(r)
+	m.GateState("closed")
+	m.GateState("opened")
+
+	got := render(t, r)
+	for _, line := range []string{
+		`pigate_gate_state{state="closed"} 0`,
+		`pigate_gate_state{state="opened"} 1`,
+	} {
+		if !strings.Contains(got, line) {
+			t.Errorf("missing %q in:\n%s", line, got)
+		}
+	}
+}
+
+func TestSyncMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	m := metrics.NewSyncMetrics(r)
+	m.SyncCompleted("credentials", 2*time.Second, nil)
+	m.SyncCompleted("credentials", time.Second, errors.New("timeout"))
+	m.CredentialCount(7)
+
+	got := render(t, r)
+	for _, line := range []string{
+		`pigate_sync_total{kind="credentials",result="success"} 1`,
+		`pigate_sync_total{kind="credentials",result="failure"} 1`,
+		`pigate_sync_duration_seconds_sum{kind="credentials"} 3`,
+		`pigate_sync_duration_seconds_count{kind="credentials"} 2`,
+		`pigate_credentials 7`,
+	} {
+		if !strings.Contains(got, line) {
+			t.Errorf("missing %q in:\n%s", line, got)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.NewMessengerMetrics(r).Reconnected()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "pigate_mqtt_reconnects_total 1") {
+		t.Errorf("body missing reconnect count:\n%s", body)
+	}
+}
+
+func TestDuplicateRegistrationPanics(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.Counter("test_total", "Test.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering test_total twice should panic")
+		}
+	}()
+	r.Counter("test_total", "Test.")
+}
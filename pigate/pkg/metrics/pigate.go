@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// GateMetrics records gate decisions and state. It satisfies gate.Metrics.
+type GateMetrics struct {
+	access       *Counter
+	commands     *Counter
+	keypadErrors *Counter
+	state        *Gauge
+
+	mu        sync.Mutex
+	lastState string
+}
+
+func NewGateMetrics(r *Registry) *GateMetrics {
+	return &GateMetrics{
+		access:       r.Counter("pigate_access_total", "Credentials presented at the gate, by result and denial reason.", "result", "reason"),
+		commands:     r.Counter("pigate_commands_total", "Gate commands carried out, by source and command.", "source", "command"),
+		keypadErrors: r.Counter("pigate_keypad_parse_errors_total", "Keypad frames that could not be decoded."),
+		state:        r.Gauge("pigate_gate_state", "1 for the current gate state, 0 for the others.", "state"),
+	}
+}
+
+func (m *GateMetrics) AccessDecision(result, reason string) {
+	m.access.Inc(result, reason)
+}
+
+func (m *GateMetrics) Command(source, command string) {
+	m.commands.Inc(source, command)
+}
+
+func (m *GateMetrics) KeypadParseError() {
+	m.keypadErrors.Inc()
+}
+
+func (m *GateMetrics) GateState(state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lastState != "" && m.lastState != state {
+		m.state.Set(0, m.lastState)
+	}
+	m.state.Set(1, state)
+	m.lastState = state
+}
+
+// MessengerMetrics records MQTT connection health. It satisfies
+// messenger.Metrics.
+type MessengerMetrics struct {
+	reconnects      *Counter
+	publishFailures *Counter
+}
+
+func NewMessengerMetrics(r *Registry) *MessengerMetrics {
+	return &MessengerMetrics{
+		reconnects:      r.Counter("pigate_mqtt_reconnects_total", "MQTT connections re-established after the first."),
+		publishFailures: r.Counter("pigate_mqtt_publish_failures_total", "MQTT publishes that failed or timed out, by topic.", "topic"),
+	}
+}
+
+func (m *MessengerMetrics) Reconnected() {
+	m.reconnects.Inc()
+}
+
+func (m *MessengerMetrics) PublishFailed(topic string) {
+	m.publishFailures.Inc(topic)
+}
+
+// SyncMetrics records credential syncs from the Control Plane Store. It
+// satisfies database.SyncMetrics.
+type SyncMetrics struct {
+	syncs       *Counter
+	duration    *Summary
+	credentials *Gauge
+}
+
+func NewSyncMetrics(r *Registry) *SyncMetrics {
+	return &SyncMetrics{
+		syncs:       r.Counter("pigate_sync_total", "Syncs from the Control Plane Store, by kind and result.", "kind", "result"),
+		duration:    r.Summary("pigate_sync_duration_seconds", "Time spent syncing from the Control Plane Store, by kind.", "kind"),
+		credentials: r.Gauge("pigate_credentials", "Credentials in the local database."),
+	}
+}
+
+func (m *SyncMetrics) SyncCompleted(kind string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.syncs.Inc(kind, result)
+	m.duration.Observe(duration.Seconds(), kind)
+}
+
+func (m *SyncMetrics) CredentialCount(n int) {
+	m.credentials.Set(float64(n))
+}
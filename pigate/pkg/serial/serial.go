@@ -0,0 +1,39 @@
+// Package serial opens the RS-485 serial lines card readers are attached to.
+//
+// Ports are opened raw, 8N1, with no flow control. On Linux, OpenPTY creates
+// a pseudo-terminal pair so a simulated peripheral can stand in for a reader.
+package serial
+
+import (
+	"fmt"
+	"io"
+)
+
+// DefaultBaud is the OSDP default line speed.
+const DefaultBaud = 9600
+
+// Port is an open serial line. Close unblocks a pending Read.
+type Port interface {
+	io.ReadWriteCloser
+}
+
+// Config describes the line to open.
+type Config struct {
+	Device string // e.g. /dev/ttyUSB0
+	Baud   int    // DefaultBaud if zero
+}
+
+// supportedBauds are the line speeds Open accepts.
+var supportedBauds = []int{9600, 19200, 38400, 57600, 115200, 230400}
+
+func (c Config) baud() (int, error) {
+	if c.Baud == 0 {
+		return DefaultBaud, nil
+	}
+	for _, b := range supportedBauds {
+		if b == c.Baud {
+			return b, nil
+		}
+	}
+	return 0, fmt.Errorf("serial: unsupported baud rate %d", c.Baud)
+}
@@ -0,0 +1,98 @@
+//go:build linux
+
+package serial
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var baudFlags = map[int]uint32{
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+}
+
+// Open opens cfg.Device in raw 8N1 mode.
+func Open(cfg Config) (Port, error) {
+	baud, err := cfg.baud()
+	if err != nil {
+		return nil, err
+	}
+	// O_NONBLOCK lets the runtime poll the descriptor, so Close interrupts
+	// a blocked Read.
+	f, err := os.OpenFile(cfg.Device, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := makeRaw(f, baudFlags[baud]); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("serial: configure %s: %w", cfg.Device, err)
+	}
+	return f, nil
+}
+
+// makeRaw switches off line editing, echo and translation, and sets the
+// line speed.
+func makeRaw(f *os.File, speed uint32) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var termErr error
+	err = conn.Control(func(fd uintptr) {
+		t, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+		if err != nil {
+			termErr = err
+			return
+		}
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB | unix.CSTOPB | unix.CRTSCTS | unix.CBAUD
+		t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL | speed
+		t.Ispeed, t.Ospeed = speed, speed
+		t.Cc[unix.VMIN], t.Cc[unix.VTIME] = 1, 0
+		termErr = unix.IoctlSetTermios(int(fd), unix.TCSETS, t)
+	})
+	if err != nil {
+		return err
+	}
+	return termErr
+}
+
+// OpenPTY creates a pseudo-terminal pair. The returned file is the
+// controller side, for a simulated peripheral; open path with Open for the
+// other end.
+func OpenPTY() (*os.File, string, error) {
+	f, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	conn, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, "", err
+	}
+	var n int
+	var ptyErr error
+	err = conn.Control(func(fd uintptr) {
+		if ptyErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); ptyErr != nil {
+			return
+		}
+		n, ptyErr = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+	})
+	if err == nil {
+		err = ptyErr
+	}
+	if err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("serial: set up pty: %w", err)
+	}
+	return f, fmt.Sprintf("/dev/pts/%d", n), nil
+}
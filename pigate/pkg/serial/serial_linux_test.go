@@ -0,0 +1,49 @@
+package serial_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"pigate/pkg/serial"
+)
+
+func TestPTYRoundTrip(t *testing.T) {
+	controller, path, err := serial.OpenPTY()
+	if err != nil {
+		t.Skipf("no pty available: %v", err)
+	}
+	defer controller.Close()
+	port, err := serial.Open(serial.Config{Device: path, Baud: 115200})
+	if err != nil {
+		t.Fatalf("Open(%s) error = %v", path, err)
+	}
+	defer port.Close()
+
+	// Raw mode: bytes that a terminal would translate or swallow pass as-is.
+	msg := []byte{0x53, 0x0D, 0x0A, 0x03, 0x7F, 0x11}
+	if _, err := port.Write(msg); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(controller, got); err != nil {
+		t.Fatalf("read from controller: %v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("controller read %x, want %x", got, msg)
+	}
+
+	if _, err := controller.Write(msg); err != nil {
+		t.Fatalf("controller Write() error = %v", err)
+	}
+	if _, err := io.ReadFull(port, got); err != nil {
+		t.Fatalf("read from port: %v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("port read %x, want %x", got, msg)
+	}
+
+	if _, err := serial.Open(serial.Config{Device: path, Baud: 1234}); err == nil {
+		t.Error("Open() accepted an unsupported baud rate")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package serial
+
+import "errors"
+
+// Open is only implemented on Linux.
+func Open(cfg Config) (Port, error) {
+	return nil, errors.New("serial: ports are only supported on Linux")
+}
@@ -0,0 +1,239 @@
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"os/exec"
+	"time"
+
+	"pigate/pkg/database"
+)
+
+// VersionStore is the Control Plane side of the Update Agent.
+// database.DeviceRegistry implements it.
+type VersionStore interface {
+	RegisterDevice(ctx context.Context, deviceID, locationID string) (*database.Device, error)
+	ReportVersion(ctx context.Context, deviceID, currentVersion, updateStatus, updateError string) error
+	ReportFailedVersion(ctx context.Context, deviceID, currentVersion, failedVersion, reason string) error
+}
+
+// Restarter restarts the updated service.
+type Restarter interface {
+	Restart(ctx context.Context) error
+}
+
+// SystemdRestarter restarts a systemd unit with systemctl.
+type SystemdRestarter struct {
+	Unit string
+}
+
+func (s SystemdRestarter) Restart(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, "systemctl", "restart", s.Unit).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl restart %s: %w: %s", s.Unit, err, out)
+	}
+	return nil
+}
+
+// Agent applies Device-pulled Updates to one binary.
+type Agent struct {
+	DeviceID   string
+	LocationID string
+	Store      VersionStore
+	Feed       *Feed
+	AssetName  string // release asset holding the binary, e.g. gatecontroller-linux-arm64
+	PublicKey  ed25519.PublicKey
+	BinaryPath string
+	Restarter  Restarter
+
+	// EnterSafeState puts the Device in a Safe Update State, or reports why
+	// it cannot. The returned func ends the Safe Update State early; it is
+	// only called when the update is abandoned, since the restart ends it
+	// anyway. A nil EnterSafeState always allows updates.
+	EnterSafeState func(ctx context.Context) (release func(), err error)
+
+	// CheckHealth reports whether the restarted service passed its startup
+	// checks. It is polled every HealthPollInterval until it returns nil or
+	// HealthWindow elapses, after which the previous binary is restored. A
+	// nil CheckHealth keeps every update.
+	CheckHealth        func(ctx context.Context) error
+	HealthWindow       time.Duration
+	HealthPollInterval time.Duration
+}
+
+// Defaults for Agent.HealthWindow and Agent.HealthPollInterval.
+const (
+	DefaultHealthWindow       = 2 * time.Minute
+	DefaultHealthPollInterval = 5 * time.Second
+)
+
+// Check compares the Current Version with the Desired Version and installs
+// the Desired Version when they differ. Progress is reported to Store.
+func (a *Agent) Check(ctx context.Context) error {
+	current, err := ReadVersion(a.BinaryPath)
+	if err != nil {
+		return fmt.Errorf("read current version: %w", err)
+	}
+	device, err := a.Store.RegisterDevice(ctx, a.DeviceID, a.LocationID)
+	if err != nil {
+		return fmt.Errorf("read desired version: %w", err)
+	}
+	desired := device.DesiredVersion
+	if desired == "" || desired == current {
+		return a.report(ctx, current, database.UpdateStatusIdle, "")
+	}
+	if desired == device.FailedVersion {
+		// Keep the rollback visible until the Desired Version changes.
+		return a.report(ctx, current, device.UpdateStatus, device.UpdateError)
+	}
+
+	// Download and verify before entering the Safe Update State, so the gate
+	// is only held closed for the swap and restart.
+	log.Printf("Updating from %q to %q", current, desired)
+	if err := a.report(ctx, current, database.UpdateStatusDownloading, ""); err != nil {
+		log.Printf("Failed to report update progress: %v", err)
+	}
+	staged, err := a.download(ctx, desired)
+	if err != nil {
+		a.reportFailure(ctx, current, err)
+		return err
+	}
+
+	release := func() {}
+	if a.EnterSafeState != nil {
+		if release, err = a.EnterSafeState(ctx); err != nil {
+			staged.Discard()
+			log.Printf("Update to %s deferred: %v", desired, err)
+			return a.report(ctx, current, database.UpdateStatusWaiting, err.Error())
+		}
+	}
+	if err := staged.Commit(); err != nil {
+		release()
+		a.reportFailure(ctx, current, err)
+		return err
+	}
+	if err := a.Restarter.Restart(ctx); err != nil {
+		release()
+		a.reportFailure(ctx, desired, err)
+		return err
+	}
+	if err := a.waitHealthy(ctx); err != nil {
+		return a.rollback(ctx, current, desired, err)
+	}
+	log.Printf("Updated to %s", desired)
+	return a.report(ctx, desired, database.UpdateStatusInstalled, "")
+}
+
+// waitHealthy polls CheckHealth until it passes or HealthWindow elapses.
+func (a *Agent) waitHealthy(ctx context.Context) error {
+	if a.CheckHealth == nil {
+		return nil
+	}
+	window, interval := a.HealthWindow, a.HealthPollInterval
+	if window <= 0 {
+		window = DefaultHealthWindow
+	}
+	if interval <= 0 {
+		interval = DefaultHealthPollInterval
+	}
+	ctx, cancel := context.WithTimeout(ctx, window)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := a.CheckHealth(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("health check did not pass within %s: %w", window, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// rollback restores the previous binary after failed health checks and
+// records failed so it is not installed again.
+func (a *Agent) rollback(ctx context.Context, previous, failed string, healthErr error) error {
+	log.Printf("Update to %s failed health checks, rolling back to %s: %v", failed, previous, healthErr)
+	if err := Rollback(a.BinaryPath); err != nil {
+		err = fmt.Errorf("%v; rollback failed: %w", healthErr, err)
+		a.reportFailure(ctx, failed, err)
+		return err
+	}
+	if err := a.Restarter.Restart(ctx); err != nil {
+		err = fmt.Errorf("%v; restart after rollback failed: %w", healthErr, err)
+		a.reportFailure(ctx, previous, err)
+		return err
+	}
+	if err := a.Store.ReportFailedVersion(ctx, a.DeviceID, previous, failed, healthErr.Error()); err != nil {
+		log.Printf("Failed to report rolled back version: %v", err)
+	}
+	return fmt.Errorf("rolled back %s: %w", failed, healthErr)
+}
+
+// download fetches and verifies the release artifact and stages it next to
+// the binary.
+func (a *Agent) download(ctx context.Context, version string) (*Staged, error) {
+	release, err := a.Feed.Release(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+	binary, ok := release.Asset(a.AssetName)
+	if !ok {
+		return nil, fmt.Errorf("release %s has no asset %s", version, a.AssetName)
+	}
+	sumsAsset, ok := release.Asset(ChecksumsAsset)
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s", version, ChecksumsAsset)
+	}
+	sigAsset, ok := release.Asset(SignatureAsset)
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s", version, SignatureAsset)
+	}
+
+	sums, err := a.Feed.fetch(ctx, sumsAsset, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := a.Feed.fetch(ctx, sigAsset, 4<<10)
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifySignature(a.PublicKey, sums, sig); err != nil {
+		return nil, fmt.Errorf("release %s: %w", version, err)
+	}
+	checksums, err := ParseChecksums(sums)
+	if err != nil {
+		return nil, fmt.Errorf("release %s: %w", version, err)
+	}
+	want, ok := checksums[a.AssetName]
+	if !ok {
+		return nil, fmt.Errorf("release %s: %s lists no checksum for %s", version, ChecksumsAsset, a.AssetName)
+	}
+
+	body, err := a.Feed.Open(ctx, binary)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return Stage(a.BinaryPath, version, body, want)
+}
+
+func (a *Agent) report(ctx context.Context, current, status, updateErr string) error {
+	if err := a.Store.ReportVersion(ctx, a.DeviceID, current, status, updateErr); err != nil {
+		return fmt.Errorf("report version: %w", err)
+	}
+	return nil
+}
+
+func (a *Agent) reportFailure(ctx context.Context, current string, updateErr error) {
+	log.Printf("Update failed: %v", updateErr)
+	if err := a.report(ctx, current, database.UpdateStatusFailed, updateErr.Error()); err != nil {
+		log.Printf("Failed to report update failure: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Package update implements the Update Agent: it reads the Desired Version
+// for a Device, downloads the matching artifact from the Release Source,
+// verifies it and swaps it into place.
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Release is the part of a GitHub release the Update Agent uses.
+type Release struct {
+	TagName string  `json:"tag_name"`
+	Assets  []Asset `json:"assets"`
+}
+
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// Asset returns the release asset called name.
+func (r *Release) Asset(name string) (Asset, bool) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset, true
+		}
+	}
+	return Asset{}, false
+}
+
+// Feed reads releases from a GitHub-Releases-style HTTP API. BaseURL is the
+// repository API root, for example https://api.github.com/repos/owner/pigate;
+// releases are fetched from BaseURL/releases/tags/<version>.
+type Feed struct {
+	BaseURL string
+	Token   string // optional bearer token for private repositories
+	Client  *http.Client
+}
+
+func NewFeed(baseURL, token string) *Feed {
+	return &Feed{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		Client:  &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// Release returns the release tagged version.
+func (f *Feed) Release(ctx context.Context, version string) (*Release, error) {
+	body, err := f.get(ctx, f.BaseURL+"/releases/tags/"+url.PathEscape(version), "application/vnd.github+json")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var release Release
+	if err := json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&release); err != nil {
+		return nil, fmt.Errorf("decode release %s: %w", version, err)
+	}
+	return &release, nil
+}
+
+// Open starts downloading an asset. The caller closes the returned body.
+func (f *Feed) Open(ctx context.Context, asset Asset) (io.ReadCloser, error) {
+	return f.get(ctx, asset.URL, "application/octet-stream")
+}
+
+// fetch downloads a small asset such as the checksum file.
+func (f *Feed) fetch(ctx context.Context, asset Asset, limit int64) ([]byte, error) {
+	body, err := f.Open(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", asset.Name, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("download %s: larger than %d bytes", asset.Name, limit)
+	}
+	return data, nil
+}
+
+func (f *Feed) get(ctx context.Context, rawURL, accept string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if f.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.Token)
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,169 @@
+package update
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxBinarySize bounds a downloaded artifact.
+const maxBinarySize = 256 << 20
+
+// PreviousPath is where Install keeps the binary it replaced.
+func PreviousPath(binaryPath string) string {
+	return binaryPath + ".previous"
+}
+
+// VersionPath records the version of the binary at binaryPath.
+func VersionPath(binaryPath string) string {
+	return binaryPath + ".version"
+}
+
+// ReadVersion returns the recorded version of the binary at binaryPath, or
+// an empty string if none has been recorded.
+func ReadVersion(binaryPath string) (string, error) {
+	data, err := os.ReadFile(VersionPath(binaryPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Staged is a verified binary written next to the one it will replace.
+type Staged struct {
+	binaryPath string
+	version    string
+	tmpPath    string
+}
+
+// Stage writes src next to binaryPath and checks its SHA-256 against
+// wantSHA256. Nothing is replaced until Commit.
+func Stage(binaryPath, version string, src io.Reader, wantSHA256 string) (*Staged, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(binaryPath), "."+filepath.Base(binaryPath)+".new-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temporary binary: %w", err)
+	}
+	staged := &Staged{binaryPath: binaryPath, version: version, tmpPath: tmp.Name()}
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(src, maxBinarySize+1))
+	if err == nil && n > maxBinarySize {
+		err = fmt.Errorf("artifact larger than %d bytes", maxBinarySize)
+	}
+	if err == nil {
+		err = tmp.Chmod(0o755)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		staged.Discard()
+		return nil, fmt.Errorf("write new binary: %w", err)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != strings.ToLower(wantSHA256) {
+		staged.Discard()
+		return nil, fmt.Errorf("checksum mismatch: got %s, want %s", got, wantSHA256)
+	}
+	return staged, nil
+}
+
+// Commit renames the staged binary over the current one, so the binary is
+// either fully old or fully new. The replaced binary and its version are
+// kept at PreviousPath for rollback.
+func (s *Staged) Commit() error {
+	if err := keepPrevious(s.binaryPath); err != nil {
+		return fmt.Errorf("keep previous binary: %w", err)
+	}
+	if err := os.Rename(s.tmpPath, s.binaryPath); err != nil {
+		return fmt.Errorf("replace binary: %w", err)
+	}
+	if err := writeFileAtomic(VersionPath(s.binaryPath), []byte(s.version+"\n")); err != nil {
+		return fmt.Errorf("record version: %w", err)
+	}
+	return syncDir(filepath.Dir(s.binaryPath))
+}
+
+// Discard removes a staged binary that will not be committed.
+func (s *Staged) Discard() {
+	_ = os.Remove(s.tmpPath)
+}
+
+// Rollback restores the binary and version kept at PreviousPath by the last
+// Commit. The restored binary is renamed into place, so there is no previous
+// binary afterwards.
+func Rollback(binaryPath string) error {
+	previous := PreviousPath(binaryPath)
+	if _, err := os.Stat(previous); err != nil {
+		return fmt.Errorf("no previous binary to restore: %w", err)
+	}
+	if err := os.Rename(previous, binaryPath); err != nil {
+		return fmt.Errorf("restore binary: %w", err)
+	}
+	err := os.Rename(VersionPath(previous), VersionPath(binaryPath))
+	if errors.Is(err, os.ErrNotExist) {
+		// The previous binary predates version files.
+		err = os.Remove(VersionPath(binaryPath))
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("restore version: %w", err)
+	}
+	return syncDir(filepath.Dir(binaryPath))
+}
+
+// keepPrevious hard-links the current binary and its version file to their
+// .previous names. Linking leaves binaryPath in place until the final rename.
+func keepPrevious(binaryPath string) error {
+	links := []struct{ current, previous string }{
+		{binaryPath, PreviousPath(binaryPath)},
+		{VersionPath(binaryPath), VersionPath(PreviousPath(binaryPath))},
+	}
+	for _, link := range links {
+		if err := os.Remove(link.previous); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := os.Link(link.current, link.previous); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".new-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
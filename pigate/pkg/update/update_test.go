@@ -0,0 +1,344 @@
+package update_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pigate/pkg/database"
+	"pigate/pkg/update"
+)
+
+const assetName = "gatecontroller-linux-arm64"
+
+// releaseFeed is a local stand-in for the GitHub Releases API.
+type releaseFeed struct {
+	server   *httptest.Server
+	key      ed25519.PrivateKey
+	releases map[string]map[string][]byte // tag -> asset name -> content
+}
+
+func newReleaseFeed(t *testing.T) *releaseFeed {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	f := &releaseFeed{key: key, releases: make(map[string]map[string][]byte)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// publish adds a signed release containing binary.
+func (f *releaseFeed) publish(tag string, binary []byte) {
+	sum := sha256.Sum256(binary)
+	sums := []byte(fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), assetName))
+	f.releases[tag] = map[string][]byte{
+		assetName:             binary,
+		update.ChecksumsAsset: sums,
+		update.SignatureAsset: []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(f.key, sums))),
+	}
+}
+
+func (f *releaseFeed) publicKey() ed25519.PublicKey {
+	return f.key.Public().(ed25519.PublicKey)
+}
+
+func (f *releaseFeed) serve(w http.ResponseWriter, r *http.Request) {
+	if tag, ok := strings.CutPrefix(r.URL.Path, "/releases/tags/"); ok {
+		assets, ok := f.releases[tag]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		release := update.Release{TagName: tag}
+		for name := range assets {
+			release.Assets = append(release.Assets, update.Asset{
+				Name: name,
+				URL:  fmt.Sprintf("%s/download/%s/%s", f.server.URL, tag, name),
+			})
+		}
+		json.NewEncoder(w).Encode(release)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/download/"), "/")
+	if len(parts) == 2 {
+		if content, ok := f.releases[parts[0]][parts[1]]; ok {
+			w.Write(content)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+type fakeStore struct {
+	desired string
+	failed  string
+	reports []string // "current/status"
+	lastErr string
+}
+
+func (s *fakeStore) RegisterDevice(ctx context.Context, deviceID, locationID string) (*database.Device, error) {
+	return &database.Device{DeviceID: deviceID, DesiredVersion: s.desired, FailedVersion: s.failed}, nil
+}
+
+func (s *fakeStore) ReportVersion(ctx context.Context, deviceID, current, status, updateErr string) error {
+	s.reports = append(s.reports, current+"/"+status)
+	s.lastErr = updateErr
+	return nil
+}
+
+func (s *fakeStore) ReportFailedVersion(ctx context.Context, deviceID, current, failed, reason string) error {
+	s.failed = failed
+	s.reports = append(s.reports, current+"/"+database.UpdateStatusRolledBack)
+	s.lastErr = reason
+	return nil
+}
+
+type fakeRestarter struct{ restarts int }
+
+func (r *fakeRestarter) Restart(ctx context.Context) error {
+	r.restarts++
+	return nil
+}
+
+func newAgent(t *testing.T, feed *releaseFeed, store *fakeStore) (*update.Agent, *fakeRestarter) {
+	t.Helper()
+	binaryPath := filepath.Join(t.TempDir(), "gatecontroller")
+	if err := os.WriteFile(binaryPath, []byte("old binary"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(update.VersionPath(binaryPath), []byte("v1.0.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	restarter := &fakeRestarter{}
+	return &update.Agent{
+		DeviceID:   "pi-1",
+		LocationID: "loc",
+		Store:      store,
+		Feed:       update.NewFeed(feed.server.URL, ""),
+		AssetName:  assetName,
+		PublicKey:  feed.publicKey(),
+		BinaryPath: binaryPath,
+		Restarter:  restarter,
+	}, restarter
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestAgentInstallsDesiredVersion(t *testing.T) {
+	feed := newReleaseFeed(t)
+	feed.publish("v1.1.0", []byte("new binary"))
+	store := &fakeStore{desired: "v1.1.0"}
+	agent, restarter := newAgent(t, feed, store)
+
+	if err := agent.Check(context.Background()); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	if got := readFile(t, agent.BinaryPath); got != "new binary" {
+		t.Errorf("binary = %q, want new binary", got)
+	}
+	if got := readFile(t, update.PreviousPath(agent.BinaryPath)); got != "old binary" {
+		t.Errorf("previous binary = %q, want old binary", got)
+	}
+	if got, _ := update.ReadVersion(agent.BinaryPath); got != "v1.1.0" {
+		t.Errorf("version = %q, want v1.1.0", got)
+	}
+	if got, _ := update.ReadVersion(update.PreviousPath(agent.BinaryPath)); got != "v1.0.0" {
+		t.Errorf("previous version = %q, want v1.0.0", got)
+	}
+	if restarter.restarts != 1 {
+		t.Errorf("restarts = %d, want 1", restarter.restarts)
+	}
+	want := []string{"v1.0.0/" + database.UpdateStatusDownloading, "v1.1.0/" + database.UpdateStatusInstalled}
+	if strings.Join(store.reports, ",") != strings.Join(want, ",") {
+		t.Errorf("reports = %v, want %v", store.reports, want)
+	}
+}
+
+func TestAgentIdleWhenCurrent(t *testing.T) {
+	feed := newReleaseFeed(t)
+	store := &fakeStore{desired: "v1.0.0"}
+	agent, restarter := newAgent(t, feed, store)
+
+	if err := agent.Check(context.Background()); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if restarter.restarts != 0 || len(store.reports) != 1 || store.reports[0] != "v1.0.0/"+database.UpdateStatusIdle {
+		t.Errorf("restarts = %d, reports = %v; want idle report only", restarter.restarts, store.reports)
+	}
+}
+
+func TestAgentWaitsForSafeState(t *testing.T) {
+	feed := newReleaseFeed(t)
+	feed.publish("v1.1.0", []byte("new binary"))
+	store := &fakeStore{desired: "v1.1.0"}
+	agent, restarter := newAgent(t, feed, store)
+	agent.EnterSafeState = func(ctx context.Context) (func(), error) { return nil, errors.New("gate is opened") }
+
+	if err := agent.Check(context.Background()); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got := readFile(t, agent.BinaryPath); got != "old binary" || restarter.restarts != 0 {
+		t.Errorf("binary replaced while gate open")
+	}
+	if last := store.reports[len(store.reports)-1]; last != "v1.0.0/"+database.UpdateStatusWaiting || store.lastErr != "gate is opened" {
+		t.Errorf("reports = %v (%q), want waiting", store.reports, store.lastErr)
+	}
+	assertNothingStaged(t, agent.BinaryPath)
+}
+
+func TestAgentRejectsBadSignature(t *testing.T) {
+	feed := newReleaseFeed(t)
+	feed.publish("v1.1.0", []byte("new binary"))
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	sums := feed.releases["v1.1.0"][update.ChecksumsAsset]
+	feed.releases["v1.1.0"][update.SignatureAsset] = ed25519.Sign(otherKey, sums)
+
+	store := &fakeStore{desired: "v1.1.0"}
+	agent, restarter := newAgent(t, feed, store)
+
+	if err := agent.Check(context.Background()); err == nil {
+		t.Fatal("Check() succeeded with a signature from the wrong key")
+	}
+	if got := readFile(t, agent.BinaryPath); got != "old binary" || restarter.restarts != 0 {
+		t.Errorf("binary replaced despite bad signature")
+	}
+	if last := store.reports[len(store.reports)-1]; last != "v1.0.0/"+database.UpdateStatusFailed {
+		t.Errorf("last report = %s, want failed", last)
+	}
+}
+
+func TestAgentRejectsChecksumMismatch(t *testing.T) {
+	feed := newReleaseFeed(t)
+	feed.publish("v1.1.0", []byte("new binary"))
+	feed.releases["v1.1.0"][assetName] = []byte("tampered binary")
+
+	store := &fakeStore{desired: "v1.1.0"}
+	agent, _ := newAgent(t, feed, store)
+
+	err := agent.Check(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("Check() error = %v, want checksum mismatch", err)
+	}
+	if got := readFile(t, agent.BinaryPath); got != "old binary" {
+		t.Errorf("binary = %q, want old binary", got)
+	}
+	assertNothingStaged(t, agent.BinaryPath)
+}
+
+func TestAgentRollsBackUnhealthyUpdate(t *testing.T) {
+	feed := newReleaseFeed(t)
+	feed.publish("v1.1.0", []byte("broken binary"))
+	store := &fakeStore{desired: "v1.1.0"}
+	agent, restarter := newAgent(t, feed, store)
+	agent.HealthWindow = 50 * time.Millisecond
+	agent.HealthPollInterval = 10 * time.Millisecond
+	checks := 0
+	agent.CheckHealth = func(ctx context.Context) error {
+		checks++
+		return errors.New("mqtt: pending")
+	}
+
+	err := agent.Check(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "mqtt: pending") {
+		t.Fatalf("Check() error = %v, want health failure", err)
+	}
+	if checks < 2 {
+		t.Errorf("health checked %d times, want polling", checks)
+	}
+	if got := readFile(t, agent.BinaryPath); got != "old binary" {
+		t.Errorf("binary = %q, want old binary restored", got)
+	}
+	if got, _ := update.ReadVersion(agent.BinaryPath); got != "v1.0.0" {
+		t.Errorf("version = %q, want v1.0.0", got)
+	}
+	if restarter.restarts != 2 {
+		t.Errorf("restarts = %d, want 2 (update and rollback)", restarter.restarts)
+	}
+	if store.failed != "v1.1.0" {
+		t.Errorf("failed version = %q, want v1.1.0", store.failed)
+	}
+	if last := store.reports[len(store.reports)-1]; last != "v1.0.0/"+database.UpdateStatusRolledBack {
+		t.Errorf("last report = %s, want rolled back", last)
+	}
+
+	// The failed version is not retried.
+	if err := agent.Check(context.Background()); err != nil {
+		t.Fatalf("second Check() error = %v", err)
+	}
+	if restarter.restarts != 2 || readFile(t, agent.BinaryPath) != "old binary" {
+		t.Error("failed version was installed again")
+	}
+}
+
+func TestAgentKeepsUpdateOnceHealthy(t *testing.T) {
+	feed := newReleaseFeed(t)
+	feed.publish("v1.1.0", []byte("new binary"))
+	store := &fakeStore{desired: "v1.1.0"}
+	agent, restarter := newAgent(t, feed, store)
+	agent.HealthPollInterval = time.Millisecond
+	checks := 0
+	agent.CheckHealth = func(ctx context.Context) error {
+		if checks++; checks < 3 {
+			return errors.New("control socket not ready")
+		}
+		return nil
+	}
+
+	if err := agent.Check(context.Background()); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got := readFile(t, agent.BinaryPath); got != "new binary" || restarter.restarts != 1 {
+		t.Errorf("binary = %q after %d restarts, want new binary kept", got, restarter.restarts)
+	}
+	if last := store.reports[len(store.reports)-1]; last != "v1.1.0/"+database.UpdateStatusInstalled {
+		t.Errorf("last report = %s, want installed", last)
+	}
+}
+
+func assertNothingStaged(t *testing.T, binaryPath string) {
+	t.Helper()
+	entries, _ := os.ReadDir(filepath.Dir(binaryPath))
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".new-") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestParseChecksums(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	sums, err := update.ParseChecksums([]byte(digest + "  a\n" + digest + " *b\n\n"))
+	if err != nil {
+		t.Fatalf("ParseChecksums() error = %v", err)
+	}
+	if sums["a"] != digest || sums["b"] != digest {
+		t.Errorf("ParseChecksums() = %v", sums)
+	}
+	if _, err := update.ParseChecksums([]byte("nothex  a\n")); err == nil {
+		t.Error("ParseChecksums() accepted a malformed digest")
+	}
+}
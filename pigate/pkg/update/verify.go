@@ -0,0 +1,69 @@
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Every release carries a sha256sum-style checksum file and an Ed25519
+// signature of that file, so one signature covers all artifacts.
+const (
+	ChecksumsAsset = "checksums.txt"
+	SignatureAsset = "checksums.txt.sig"
+)
+
+// ParsePublicKey decodes a base64 Ed25519 public key.
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("decode public key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key is %d bytes, want %d", len(key), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// VerifySignature checks sig over data. The signature may be raw or base64.
+func VerifySignature(key ed25519.PublicKey, data, sig []byte) error {
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil {
+			return fmt.Errorf("decode signature: %w", err)
+		}
+		sig = decoded
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return errors.New("signature does not match release public key")
+	}
+	return nil
+}
+
+// ParseChecksums reads `sha256sum` output into a map of file name to hex
+// digest.
+func ParseChecksums(data []byte) (map[string]string, error) {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed checksum line %q", line)
+		}
+		digest := strings.ToLower(fields[0])
+		if _, err := hex.DecodeString(digest); err != nil || len(digest) != 64 {
+			return nil, fmt.Errorf("malformed sha256 digest %q", fields[0])
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = digest
+	}
+	return sums, scanner.Err()
+}
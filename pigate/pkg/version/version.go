@@ -0,0 +1,67 @@
+// Package version holds the build metadata embedded in every PiGate binary.
+// Release builds set it with -ldflags, for example:
+//
+//	go build -ldflags "-X pigate/pkg/version.Version=v1.2.0 \
+//	    -X pigate/pkg/version.Commit=$(git rev-parse --short HEAD) \
+//	    -X pigate/pkg/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/gatecontroller
+package version
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Set with -ldflags "-X pigate/pkg/version.<Name>=<value>".
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Info is the build metadata of the running binary.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+}
+
+// Get returns the build metadata. A commit or build time missing from
+// -ldflags falls back to the VCS stamp the go tool records in local builds.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildTime: BuildTime}
+	if info.Commit != "" && info.BuildTime != "" {
+		return info
+	}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" && len(setting.Value) >= 7 {
+				info.Commit = setting.Value[:7]
+			}
+		case "vcs.time":
+			if info.BuildTime == "" {
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// String formats the metadata for -version output and logs, e.g.
+// "v1.2.0 (commit 1a2b3c4, built 2026-05-01T12:00:00Z)".
+func (i Info) String() string {
+	s := i.Version
+	switch {
+	case i.Commit != "" && i.BuildTime != "":
+		s += fmt.Sprintf(" (commit %s, built %s)", i.Commit, i.BuildTime)
+	case i.Commit != "":
+		s += fmt.Sprintf(" (commit %s)", i.Commit)
+	case i.BuildTime != "":
+		s += fmt.Sprintf(" (built %s)", i.BuildTime)
+	}
+	return s
+}
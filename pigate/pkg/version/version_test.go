@@ -0,0 +1,28 @@
+package version
+
+import "testing"
+
+func TestInfoString(t *testing.T) {
+	tests := []struct {
+		info Info
+		want string
+	}{
+		{Info{Version: "dev"}, "dev"},
+		{Info{Version: "v1.2.0", Commit: "1a2b3c4"}, "v1.2.0 (commit 1a2b3c4)"},
+		{Info{Version: "v1.2.0", Commit: "1a2b3c4", BuildTime: "2026-05-01T12:00:00Z"}, "v1.2.0 (commit 1a2b3c4, built 2026-05-01T12:00:00Z)"},
+	}
+	for _, tt := range tests {
+		if got := tt.info.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.info, got, tt.want)
+		}
+	}
+}
+
+func TestGetPrefersLinkerValues(t *testing.T) {
+	defer func(v, c, b string) { Version, Commit, BuildTime = v, c, b }(Version, Commit, BuildTime)
+	Version, Commit, BuildTime = "v1.2.0", "1a2b3c4", "2026-05-01T12:00:00Z"
+
+	if got := Get(); got != (Info{Version: "v1.2.0", Commit: "1a2b3c4", BuildTime: "2026-05-01T12:00:00Z"}) {
+		t.Errorf("Get() = %+v", got)
+	}
+}